@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/DAddYE/igo/to_go"
+)
+
+var cacheDir = flag.String("cache", "", "keep the converted files in this directory, by content, and reuse them while the source, the flags and igo are the same")
+
+// A cacheEntry is the conversion of a source kept in the -cache directory.
+type cacheEntry struct {
+	Output    []byte
+	Positions to_go.Positions // of an iGo source, nil for Go
+}
+
+// cacheKey returns the name of the entry of the -cache directory for src,
+// read from filename and converted into m: a hash of them, of the value
+// of every flag, of the rewrite rules file, if any, and of the size and
+// time of the igo executable, so that changing any of them invalidates
+// the entries.
+func cacheKey(m Mode, filename string, src []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d %q\n", m, filename)
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(h, "-%s=%q\n", f.Name, f.Value)
+	})
+	if *rewriteRules != "" {
+		rules, _ := ioutil.ReadFile(*rewriteRules)
+		h.Write(rules)
+	}
+	if exe, err := os.Executable(); err == nil {
+		if fi, err := os.Stat(exe); err == nil {
+			fmt.Fprintf(h, "%q %d %d\n", exe, fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachePath returns the path of the entry key in the -cache directory.
+func cachePath(key string) string {
+	return filepath.Join(*cacheDir, key[:2], key)
+}
+
+// cacheGet returns the entry key of the -cache directory, or nil if there
+// is none or it cannot be read.
+func cacheGet(key string) *cacheEntry {
+	data, err := ioutil.ReadFile(cachePath(key))
+	if err != nil {
+		return nil
+	}
+	var e cacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// cachePut stores e as the entry key of the -cache directory. Nothing is
+// stored with -n or -summary, which write nothing.
+func cachePut(key string, e *cacheEntry) error {
+	if *dryRun || *summary {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return err
+	}
+	path := cachePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFile(path, buf.Bytes(), 0644)
+}
+
+// igoTranslateCached is like igoTranslate, but reuses the conversion kept
+// in the -cache directory, if any, without parsing src. On a hit, the
+// rewrite rules are not applied, hence not counted by -v either.
+func igoTranslateCached(filename string, src []byte) ([]byte, *to_go.Positions, error) {
+	if *cacheDir == "" {
+		return igoTranslate(filename, src, rewrites, nil)
+	}
+	key := cacheKey(GO, filename, src)
+	if e := cacheGet(key); e != nil {
+		return e.Output, &e.Positions, nil
+	}
+	res, pos, err := igoTranslate(filename, src, rewrites, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	e := &cacheEntry{Output: res}
+	if pos != nil {
+		e.Positions = *pos
+	}
+	if err := cachePut(key, e); err != nil {
+		return nil, nil, err
+	}
+	return res, pos, nil
+}
+
+// goTranslateCached is like goTranslate, but reuses the conversion kept
+// in the -cache directory, if any, without parsing src.
+func goTranslateCached(filename string, src []byte) ([]byte, error) {
+	if *cacheDir == "" {
+		return goTranslate(filename, src, nil)
+	}
+	key := cacheKey(IGO, filename, src)
+	if e := cacheGet(key); e != nil {
+		return e.Output, nil
+	}
+	res, err := goTranslate(filename, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := cachePut(key, &cacheEntry{Output: res}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
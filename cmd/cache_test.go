@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var cacheTests = []struct {
+	m        Mode
+	src, out string
+}{
+	{GO, "package p\n\nfunc f() int: return 1\n", "package p\n\nfunc f() int { return 1 }\n"},
+	{IGO, "package p\n\nfunc f() int { return 1 }\n", "package p\n\nfunc f() int: return 1\n"},
+}
+
+// setCacheDir sets -cache.
+func setCacheDir(dir string) {
+	*cacheDir = dir
+}
+
+// setTabWidth sets -tabwidth.
+func setTabWidth(n int) {
+	*tabWidth = n
+}
+
+// translateCached converts src into m with the -cache directory.
+func translateCached(m Mode, src []byte) ([]byte, error) {
+	if m == GO {
+		out, _, err := igoTranslateCached("f", src)
+		return out, err
+	}
+	return goTranslateCached("f", src)
+}
+
+// TestCache checks that a second conversion of an unchanged source is a
+// hit of the -cache directory giving the same output, that a hit does not
+// convert the source again, and that changing a flag misses.
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setCacheDir(*cacheDir)
+	defer setTabWidth(*tabWidth)
+	setCacheDir(dir)
+	igoInitMode()
+
+	for _, test := range cacheTests {
+		src := []byte(test.src)
+		key := cacheKey(test.m, "f", src)
+		if cacheGet(key) != nil {
+			t.Errorf("%q: hit before the first conversion", test.src)
+		}
+		first, err := translateCached(test.m, src)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if string(first) != test.out {
+			t.Errorf("%q: got %q; want %q", test.src, first, test.out)
+		}
+		if cacheGet(key) == nil {
+			t.Errorf("%q: no entry after the first conversion", test.src)
+		}
+		second, err := translateCached(test.m, src)
+		if err != nil || !bytes.Equal(second, first) {
+			t.Errorf("%q: second conversion got %q, %v; want %q", test.src, second, err, first)
+		}
+
+		// a hit is the entry, whatever it is
+		if err := cachePut(key, &cacheEntry{Output: []byte("cached")}); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := translateCached(test.m, src); err != nil || string(out) != "cached" {
+			t.Errorf("%q: got %q, %v; want the entry", test.src, out, err)
+		}
+
+		setTabWidth(*tabWidth + 1)
+		if cacheKey(test.m, "f", src) == key {
+			t.Errorf("%q: same key with another -tabwidth", test.src)
+		}
+		setTabWidth(*tabWidth - 1)
+	}
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	goparser "go/parser"
+	goscanner "go/scanner"
+	gotoken "go/token"
+	"io/ioutil"
+	"os"
+
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/scanner"
+	"github.com/DAddYE/igo/token"
+)
+
+// A Diagnostic is a problem found in a source, e.g. by CheckSource.
+type Diagnostic struct {
+	Pos token.Position // position of the problem; Filename is the one given for the source
+	Msg string
+}
+
+func (d Diagnostic) String() string {
+	if d.Pos.IsValid() {
+		return d.Pos.String() + ": " + d.Msg
+	}
+	return d.Msg
+}
+
+// CheckSource parses src, which was read from filename, as a source in
+// the language opposite to m and returns its syntax errors, sorted by
+// position. Nothing is printed, not even comments are parsed. As the Go
+// compiler does, the parser reports one error per line and gives up after
+// the eleventh.
+// The result is empty if src is correct.
+func CheckSource(m Mode, src []byte, filename string) []Diagnostic {
+	src = stripBOM(src)
+
+	var err error
+	if m == GO {
+		_, err = parser.ParseFile(token.NewFileSet(), filename, src, 0)
+	} else {
+		_, err = goparser.ParseFile(gotoken.NewFileSet(), filename, src, 0)
+	}
+	return diagnostics(err)
+}
+
+// diagnostics returns the errors in err, a scanner.ErrorList of either
+// language or any other error, as diagnostics.
+func diagnostics(err error) []Diagnostic {
+	var diags []Diagnostic
+	switch list := err.(type) {
+	case nil:
+	case scanner.ErrorList:
+		for _, e := range list {
+			diags = append(diags, Diagnostic{e.Pos, e.Msg})
+		}
+	case goscanner.ErrorList:
+		for _, e := range list {
+			diags = append(diags, Diagnostic{token.Position(e.Pos), e.Msg})
+		}
+	default:
+		diags = append(diags, Diagnostic{Msg: err.Error()})
+	}
+	return diags
+}
+
+// badUTF8 is the message of the scanners for invalid UTF-8.
+const badUTF8 = "illegal UTF-8 encoding"
+
+// ignoreBadUTF8 returns err, a scanner.ErrorList of either language, without
+// its invalid UTF-8 errors if -allow-invalid-utf8 is set. The result is nil
+// if no other error remains: the scanners keep the invalid bytes as they
+// are, so the tree is complete anyway.
+func ignoreBadUTF8(err error) error {
+	if !*allowUTF8 {
+		return err
+	}
+	switch list := err.(type) {
+	case scanner.ErrorList:
+		var rest scanner.ErrorList
+		for _, e := range list {
+			if e.Msg != badUTF8 {
+				rest = append(rest, e)
+			}
+		}
+		if len(rest) == 0 {
+			return nil
+		}
+		return rest
+	case goscanner.ErrorList:
+		var rest goscanner.ErrorList
+		for _, e := range list {
+			if e.Msg != badUTF8 {
+				rest = append(rest, e)
+			}
+		}
+		if len(rest) == 0 {
+			return nil
+		}
+		return rest
+	}
+	return err
+}
+
+// FormatError formats the errors in err, met while converting src, the
+// content of filename, as "file:line:col: message" lines, the format of
+// GCC understood by most editors. A position without line, only known
+// by its byte offset, is resolved in src. If caret is set, each line is
+// followed by the offending line of src and a caret under the column.
+func FormatError(filename string, src []byte, err error, caret bool) string {
+	var buf bytes.Buffer
+	for _, d := range diagnostics(err) {
+		if d.Pos.Filename == "" {
+			d.Pos.Filename = filename
+		}
+		formatDiagnostic(&buf, src, d, caret)
+	}
+	return buf.String()
+}
+
+// formatDiagnostic writes d, found in src, to buf as FormatError does.
+func formatDiagnostic(buf *bytes.Buffer, src []byte, d Diagnostic, caret bool) {
+	pos := d.Pos
+	if pos.Line == 0 && pos.Offset > 0 && pos.Offset <= len(src) {
+		pos.Line = 1 + bytes.Count(src[:pos.Offset], []byte{'\n'})
+		pos.Column = pos.Offset - bytes.LastIndexByte(src[:pos.Offset], '\n')
+	}
+	if !pos.IsValid() {
+		if pos.Filename != "" {
+			buf.WriteString(pos.Filename + ": ")
+		}
+		buf.WriteString(d.Msg + "\n")
+		return
+	}
+	fmt.Fprintf(buf, "%s: %s\n", pos, d.Msg)
+	if caret {
+		writeCaret(buf, src, pos)
+	}
+}
+
+// printErrorCarets prints err to standard error as FormatError does with
+// caret set, reading the sources of the errors again.
+func printErrorCarets(err error) {
+	var buf bytes.Buffer
+	sources := make(map[string][]byte)
+	for _, d := range diagnostics(err) {
+		src, ok := sources[d.Pos.Filename]
+		if !ok && d.Pos.Filename != "" {
+			src, _ = ioutil.ReadFile(d.Pos.Filename)
+			src = stripBOM(src) // positions are relative to the source without it
+			sources[d.Pos.Filename] = src
+		}
+		formatDiagnostic(&buf, src, d, true)
+	}
+	os.Stderr.Write(buf.Bytes())
+}
+
+// A jsonError is a line printed by -errorformat json.
+type jsonError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Col     int    `json:"col"`
+	Message string `json:"message"`
+}
+
+// printErrorsJSON prints the errors in err to standard error as JSON
+// objects, one per line. An error without position, e.g. a file that
+// cannot be read, has no line nor column.
+func printErrorsJSON(err error) {
+	enc := json.NewEncoder(os.Stderr) // Encode ends each object with a newline
+	for _, d := range diagnostics(err) {
+		if pe, ok := err.(*os.PathError); ok {
+			d.Pos.Filename, d.Msg = pe.Path, pe.Op+": "+pe.Err.Error()
+		}
+		enc.Encode(jsonError{d.Pos.Filename, d.Pos.Line, d.Pos.Column, d.Msg})
+	}
+}
+
+// writeCaret writes the line of src at pos and, below it, a caret under
+// pos.Column. The caret is indented with the same tabs as the line, so
+// that it stays in place however wide tabs are shown.
+func writeCaret(buf *bytes.Buffer, src []byte, pos token.Position) {
+	lines := bytes.SplitAfter(src, []byte{'\n'})
+	if pos.Line > len(lines) {
+		return
+	}
+	line := bytes.TrimRight(lines[pos.Line-1], "\r\n")
+	buf.Write(line)
+	buf.WriteByte('\n')
+	for i := 0; i < pos.Column-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			buf.WriteByte('\t')
+		} else if line[i]&0xC0 != 0x80 { // one blank per character
+			buf.WriteByte(' ')
+		}
+	}
+	buf.WriteString("^\n")
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/scanner"
+	"github.com/DAddYE/igo/token"
+)
+
+// diags are the diagnostics of src, as strings.
+var checkSourceTests = []struct {
+	m     Mode
+	src   string
+	diags []string
+}{
+	{GO, "package p\n\nfunc f()\n\treturn\n", nil},
+	{IGO, "package p\n\nfunc f() {\n\treturn\n}\n", nil},
+	{GO, "\ufeffpackage p\n", nil},
+	{IGO, "package p\n\nvar = 1\n\nvar b = 2\n\nvar = 3\n",
+		[]string{"f:3:5: expected 'IDENT', found '='", "f:7:5: expected 'IDENT', found '='"}},
+	{GO, "package p\n\nvar a = )\nvar b = 2\nvar c = ]\n",
+		[]string{"f:3:9: expected operand, found ')'", "f:5:9: expected operand, found ']'"}},
+	{GO, "", []string{"f:1:1: expected 'package', found 'EOF'"}},
+}
+
+func TestCheckSource(t *testing.T) {
+	for _, test := range checkSourceTests {
+		var diags []string
+		for _, d := range CheckSource(test.m, []byte(test.src), "f") {
+			diags = append(diags, d.String())
+		}
+		if fmt.Sprint(diags) != fmt.Sprint(test.diags) {
+			t.Errorf("%q:\ngot  %q\nwant %q", test.src, diags, test.diags)
+		}
+	}
+}
+
+func TestCheckSourceLimit(t *testing.T) {
+	src := "package p\n" + strings.Repeat("var = 1\n", 20)
+	if n := len(CheckSource(GO, []byte(src), "f")); n != 11 {
+		t.Errorf("got %d diagnostics; want 11", n)
+	}
+}
+
+var writeCaretTests = []struct {
+	src       string
+	line, col int
+	out       string
+}{
+	{"var = 1\n", 1, 5, "var = 1\n    ^\n"},
+	{"var = 1\n", 1, 1, "var = 1\n^\n"},
+	// the tabs are kept, the other characters are blanks, one per rune
+	{"\t\tx = )\n", 1, 7, "\t\tx = )\n\t\t    ^\n"},
+	{"\tx\t= )\n", 1, 6, "\tx\t= )\n\t \t  ^\n"},
+	{"s := \"é€𝄞\" )\n", 1, 18, "s := \"é€𝄞\" )\n           ^\n"},
+	{"a\r\nb )\r\n", 2, 3, "b )\n  ^\n"},
+	// at the end of the file, after the last line or at its end
+	{"a\nb", 2, 2, "b\n ^\n"},
+	{"a\nb\n", 3, 1, "\n^\n"},
+	{"a\n", 3, 1, ""},
+}
+
+func TestWriteCaret(t *testing.T) {
+	for _, test := range writeCaretTests {
+		var buf bytes.Buffer
+		writeCaret(&buf, []byte(test.src), token.Position{Line: test.line, Column: test.col})
+		if buf.String() != test.out {
+			t.Errorf("%q at %d:%d: got %q; want %q", test.src, test.line, test.col, buf.String(), test.out)
+		}
+	}
+}
+
+var formatErrorTests = []struct {
+	src   string
+	caret bool
+	out   string
+}{
+	{"package p\n\nvar = 1\n", false, "f.igo:3:5: expected 'IDENT', found '='\n"},
+	{"package p\n\nvar = 1\n", true, "f.igo:3:5: expected 'IDENT', found '='\nvar = 1\n    ^\n"},
+	{"package p\n\nfunc f()\n\tx := \"é\" )\n", true,
+		"f.igo:4:12: expected statement, found ')'\n\tx := \"é\" )\n\t         ^\n"},
+	{"package p\n\nvar a = (", true,
+		"f.igo:3:10: expected operand, found 'EOF'\nvar a = (\n         ^\n"},
+	// EOF past the end of the last line, the caret at its end
+	{"package p\n\nfunc f(\n", true,
+		"f.igo:3:9: expected type, found 'EOF'\nfunc f(\n       ^\n"},
+}
+
+func TestFormatError(t *testing.T) {
+	for _, test := range formatErrorTests {
+		_, err := parser.ParseFile(token.NewFileSet(), "f.igo", test.src, 0)
+		if err == nil {
+			t.Fatalf("%q: no error", test.src)
+		}
+		if list, ok := err.(scanner.ErrorList); ok {
+			err = list[:1] // the first only
+		}
+		if got := FormatError("f.igo", []byte(test.src), err, test.caret); got != test.out {
+			t.Errorf("%q:\ngot\n%s\nwant\n%s", test.src, got, test.out)
+		}
+	}
+
+	// positions only known by their offset are resolved
+	err := scanner.ErrorList{{Pos: token.Position{Offset: 14}, Msg: "bad"}}
+	src := "package p\n\n\tvé\n"
+	if got, want := FormatError("f.igo", []byte(src), err, true), "f.igo:3:4: bad\n\tvé\n\t  ^\n"; got != want {
+		t.Errorf("offset 14 of %q:\ngot\n%s\nwant\n%s", src, got, want)
+	}
+	// other errors have no position
+	if got, want := FormatError("f.igo", nil, fmt.Errorf("bad"), true), "f.igo: bad\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+// setErrFormat sets -errorformat.
+func setErrFormat(format string) {
+	*errFormat = format
+}
+
+var errorsJSONTests = []struct {
+	srcs []string // of the files converted, f0.igo, f1.igo...
+	code int
+	errs []jsonError // Filename relative to the directory
+}{
+	{[]string{"package p\n", "package p\n\nvar a = 1\n"}, 0, nil},
+	{[]string{"package p\n\nvar a = )\n", "package p\n", "package p\n\nvar b = )\n"}, 2, []jsonError{
+		{"f0.igo", 3, 9, "expected operand, found ')'"},
+		{"f2.igo", 3, 9, "expected operand, found ')'"},
+	}},
+}
+
+// TestPrintErrorsJSON checks that with -errorformat json the errors are
+// printed as JSON, nothing when there is none, and that the exit code
+// still tells whether the conversion failed.
+func TestPrintErrorsJSON(t *testing.T) {
+	defer setDestDir(*DestDir)
+	defer setExitCode(exitCode)
+	defer setErrFormat(*errFormat)
+	setDestDir("")
+	setErrFormat("json")
+	for _, test := range errorsJSONTests {
+		dir, err := ioutil.TempDir("", "igo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for i, src := range test.srcs {
+			if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d.igo", i)), []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		setExitCode(0)
+		stderr := captureOutput(t, &os.Stderr)
+		code := To(GO, []string{dir})
+		out := stderr.read()
+		if code != test.code {
+			t.Errorf("%q: exit code %d; want %d", test.srcs, code, test.code)
+		}
+		var errs []jsonError
+		dec := json.NewDecoder(strings.NewReader(out))
+		for dec.More() {
+			var e jsonError
+			if err := dec.Decode(&e); err != nil {
+				t.Fatalf("%q: %v in %q", test.srcs, err, out)
+			}
+			e.File, _ = filepath.Rel(dir, e.File)
+			errs = append(errs, e)
+		}
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%q: got %+v; want %+v", test.srcs, errs, test.errs)
+		}
+	}
+
+	// an error without position has no line nor column
+	stderr := captureOutput(t, &os.Stderr)
+	printErrorsJSON(&os.PathError{Op: "open", Path: "f.igo", Err: os.ErrNotExist})
+	if got, want := stderr.read(), `{"file":"f.igo","line":0,"col":0,"message":"open: file does not exist"}`+"\n"; got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/token"
+)
+
+var DocMode = flag.Bool("doc", false, "with compile, write to standard output or to -o a Go file made of the documentation of a package and of its exported declarations, as comments")
+
+// Doc writes to the -o file, or to standard output, a Go source made of
+// the package clause of the iGo files at paths, or in the directories at
+// paths, tests left out, and of their documentation as comments: that of
+// the package clause first, from every file having some, then that of
+// each exported declaration, introduced by its kind and name, e.g.
+// "func Open" or "method File.Close", in the order of the files. The
+// declarations without documentation are left out. The files must belong
+// to the same package.
+func Doc(paths []string) int {
+	flag.Parse()
+
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "-doc requires the files or directories of a package")
+		exitCode = 2
+		return exitCode
+	}
+
+	if err := igoInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
+	}
+	res, err := igoDoc(paths)
+	if err == nil {
+		if *mergeOut == "" {
+			_, err = os.Stdout.Write(res)
+		} else {
+			err = writeFile(*mergeOut, res, 0644)
+		}
+	}
+	if err != nil {
+		igoReport(err)
+	}
+	return exitCode
+}
+
+// igoDoc returns the Go source described by Doc for paths.
+func igoDoc(paths []string) ([]byte, error) {
+	filenames, err := docFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	if len(filenames) == 0 {
+		return nil, fmt.Errorf("%s: no iGo files", strings.Join(paths, ", "))
+	}
+
+	var (
+		pkg, first string
+		pkgDocs    []string
+		declDocs   []string
+	)
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkUTF8(filename, src); err != nil {
+			return nil, err
+		}
+		file, err := parser.ParseFile(igoFileSet, filename, stripBOM(src), igoParserMode|parser.ParseComments)
+		if err = ignoreBadUTF8(err); err != nil {
+			return nil, err
+		}
+
+		switch name := file.Name.Name; {
+		case pkg == "":
+			pkg, first = name, filename
+		case name != pkg:
+			return nil, fmt.Errorf("%s: package %s, not %s as %s", filename, name, pkg, first)
+		}
+		if text := file.Doc.Text(); text != "" {
+			pkgDocs = append(pkgDocs, text)
+		}
+		declDocs = append(declDocs, exportedDocs(file)...)
+	}
+
+	var buf bytes.Buffer
+	writeDocComment(&buf, strings.Join(pkgDocs, "\n"))
+	fmt.Fprintf(&buf, "package %s\n", pkg)
+	for _, text := range declDocs {
+		buf.WriteByte('\n')
+		writeDocComment(&buf, text)
+	}
+	return buf.Bytes(), nil
+}
+
+// docFiles returns the iGo files at paths, and those of the directories
+// at paths, but for tests, in order.
+func docFiles(paths []string) ([]string, error) {
+	var filenames []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			filenames = append(filenames, path)
+			continue
+		}
+		list, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range list {
+			if igoFile(f) && !strings.HasSuffix(f.Name(), "_test"+*igoExt) {
+				filenames = append(filenames, filepath.Join(path, f.Name()))
+			}
+		}
+	}
+	return filenames, nil
+}
+
+// exportedDocs returns the documentation of the exported declarations of
+// file, each preceded by a line with the kind and the name of the
+// declaration and a blank one. A group of constants, variables or types
+// documented as a whole is introduced by the exported names of its specs
+// without documentation of their own, and comes before these.
+func exportedDocs(file *ast.File) []string {
+	var docs []string
+	add := func(kind string, names []string, doc *ast.CommentGroup) {
+		if text := doc.Text(); text != "" && len(names) > 0 {
+			docs = append(docs, kind+" "+strings.Join(names, ", ")+"\n\n"+text)
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !d.Name.IsExported() {
+				continue
+			}
+			switch recv := igoRecvName(d); {
+			case recv == "":
+				add("func", []string{d.Name.Name}, d.Doc)
+			case ast.IsExported(recv):
+				add("method", []string{recv + "." + d.Name.Name}, d.Doc)
+			}
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			var all []string // the exported names of the specs without documentation
+			var specs []*ast.CommentGroup
+			var specNames [][]string
+			for _, spec := range d.Specs {
+				var names []string
+				var doc *ast.CommentGroup
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Name.IsExported() {
+						names = []string{s.Name.Name}
+					}
+					doc = s.Doc
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						if name.IsExported() {
+							names = append(names, name.Name)
+						}
+					}
+					doc = s.Doc
+				}
+				if doc == nil {
+					all = append(all, names...)
+					continue
+				}
+				specs = append(specs, doc)
+				specNames = append(specNames, names)
+			}
+			add(d.Tok.String(), all, d.Doc)
+			for i, doc := range specs {
+				add(d.Tok.String(), specNames[i], doc)
+			}
+		}
+	}
+	return docs
+}
+
+// writeDocComment writes text, if any, to buf as // comments.
+func writeDocComment(buf *bytes.Buffer, text string) {
+	if text == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if line == "" || line[0] == '\t' {
+			buf.WriteString("//" + line + "\n")
+		} else {
+			buf.WriteString("// " + line + "\n")
+		}
+	}
+}
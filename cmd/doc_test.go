@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// docSources are the files of a package documented by igoDoc.
+var docSources = map[string]string{
+	"a.igo": "# Package p does things.\npackage p\n\n# Open opens.\nfunc Open():\n\n# open is not exported.\nfunc open():\n\nfunc Undocumented():\n",
+	"b.igo": "# It does them well.\n#\n#\tp.Open()\npackage p\n\n# File is a file.\ntype File int\n\n# Close closes.\nfunc *File.Close():\n\n# Values.\nconst\n\tA = 1\n\t# B is alone.\n\tB = 2\n\tc = 3\n",
+	"c.igo": "package p\n\n# T is a type.\ntype T int\n\n# G is generic.\ntype G[K any] int\n\n# Get gets.\nfunc *G[K].Get():\n",
+	// left out
+	"b_test.igo": "# Package p is tested.\npackage p\n\n# TestOpen tests.\nfunc TestOpen():\n",
+	"d.go":       "// Package p in Go.\npackage p\n",
+}
+
+const docOut = `// Package p does things.
+//
+// It does them well.
+//
+//	p.Open()
+package p
+
+// func Open
+//
+// Open opens.
+
+// type File
+//
+// File is a file.
+
+// method File.Close
+//
+// Close closes.
+
+// const A
+//
+// Values.
+
+// const B
+//
+// B is alone.
+
+// type T
+//
+// T is a type.
+
+// type G
+//
+// G is generic.
+
+// method G.Get
+//
+// Get gets.
+`
+
+func TestIgoDoc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	igoInitMode()
+	for name, src := range docSources {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := igoDoc([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != docOut {
+		t.Errorf("got\n%s\nwant\n%s", out, docOut)
+	}
+
+	// files are taken in the order given
+	out, err = igoDoc([]string{filepath.Join(dir, "c.igo"), filepath.Join(dir, "a.igo")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "// Package p does things.\npackage p\n\n// type T\n//\n// T is a type.\n\n// type G\n//\n// G is generic.\n\n// method G.Get\n//\n// Get gets.\n\n// func Open\n//\n// Open opens.\n"
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	// the files must belong to the same package
+	other := filepath.Join(dir, "e.igo")
+	if err := ioutil.WriteFile(other, []byte("package q\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := igoDoc([]string{dir}); err == nil || !strings.Contains(err.Error(), "package q, not p") {
+		t.Errorf("got error %v; want one about package q", err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.Mkdir(empty, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := igoDoc([]string{empty}); err == nil || !strings.Contains(err.Error(), "no iGo files") {
+		t.Errorf("got error %v; want no iGo files", err)
+	}
+}
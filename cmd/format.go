@@ -0,0 +1,325 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	goast "go/ast"
+	gofmt "go/format"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/token"
+)
+
+var ByteRange = flag.String("range", "", "with fmt, format only the declarations of a single file overlapping this byte range, start:end, and keep the rest of it as it is")
+
+// formatSource parses src, which was read from filename, as a source file
+// written in the language m and prints it back in the same language,
+// applying rules to iGo sources.
+func formatSource(m Mode, filename string, src []byte, rules []*rewrite) ([]byte, error) {
+	if m == GO {
+		return gofmt.Source(src)
+	}
+
+	// There is no iGo printer for iGo trees: go through Go and back.
+	res, _, err := igoTranslate(filename, src, rules, nil)
+	if err != nil {
+		return nil, err
+	}
+	return goTranslate(filename, res, nil)
+}
+
+// FormatInPlace formats the file at path, written in the language m, and
+// writes it back only if its content changed. The file is replaced
+// atomically and keeps its permissions. The rewrite rules given via -r
+// and -rewrite-file apply to iGo sources; an invalid one is returned as
+// the error.
+func FormatInPlace(m Mode, path string) (changed bool, err error) {
+	igoInitMode()
+	goInitParserMode()
+	goInitPrinterMode()
+
+	rules, err := initRewrite()
+	if err != nil {
+		return false, err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := formatSource(m, path, src, rules)
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(src, res) {
+		return false, nil
+	}
+	return true, writeFile(path, res, fi.Mode().Perm())
+}
+
+// Format formats in place, as FormatInPlace does, the iGo and Go sources
+// found in paths, files given explicitly included, each in the language of
+// its extension. In verbose mode, the names of the files changed are
+// printed.
+func Format(paths []string) int {
+	flag.Parse()
+
+	// invalid rewrite rules end the command, not each file
+	if err := igoInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
+	}
+
+	if len(paths) == 0 {
+		paths = append(paths, ".")
+	}
+
+	for _, path := range paths {
+		path = trimDots(path)
+		switch dir, err := os.Stat(path); {
+		case err != nil:
+			goReport(err)
+		case dir.IsDir():
+			filepath.Walk(path, formatVisitFile)
+		default:
+			formatFile(path, dir)
+		}
+	}
+
+	return exitCode
+}
+
+func formatVisitFile(path string, f os.FileInfo, err error) error {
+	if err == nil && ignored(path, f) {
+		return skip(f)
+	}
+	if err != nil {
+		goReport(err)
+	} else {
+		formatFile(path, f)
+	}
+	return nil
+}
+
+// formatFile formats the file at path, described by f, as iGo or Go
+// according to its extension. Other files are left alone.
+func formatFile(path string, f os.FileInfo) {
+	m, report := GO, goReport
+	switch {
+	case igoFile(f):
+		m, report = IGO, igoReport
+	case !goFile(f):
+		return
+	}
+	changed, err := FormatInPlace(m, path)
+	if err != nil {
+		report(err)
+	} else if changed && *verbose {
+		fmt.Fprintln(os.Stderr, path)
+	}
+}
+
+// A declSpan is the byte range of a top-level declaration in a source,
+// its doc comment included and its trailing blanks excluded.
+type declSpan struct {
+	start, end int
+}
+
+// declSpans returns the offset of the end of the package name in src,
+// read from filename and written in the language m, and the spans of its
+// top-level declarations, in order.
+func declSpans(m Mode, filename string, src []byte) (clause int, spans []declSpan, err error) {
+	add := func(start, end int) {
+		for end > start && strings.IndexByte(" \t\r\n", src[end-1]) >= 0 {
+			end--
+		}
+		spans = append(spans, declSpan{start, end})
+	}
+
+	if m == GO {
+		fset := gotoken.NewFileSet()
+		file, err := goparser.ParseFile(fset, filename, src, goParserMode|goparser.ParseComments)
+		if err != nil {
+			return 0, nil, err
+		}
+		for _, d := range file.Decls {
+			start := d.Pos()
+			if doc := goDeclDoc(d); doc != nil {
+				start = doc.Pos()
+			}
+			add(fset.Position(start).Offset, fset.Position(d.End()).Offset)
+		}
+		return fset.Position(file.Name.End()).Offset, spans, nil
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, igoParserMode|parser.ParseComments)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, d := range file.Decls {
+		start := d.Pos()
+		if doc := igoDeclDoc(d); doc != nil {
+			start = doc.Pos()
+		}
+		add(fset.Position(start).Offset, fset.Position(d.End()).Offset)
+	}
+	return fset.Position(file.Name.End()).Offset, spans, nil
+}
+
+// goDeclDoc returns the doc comment of d, if any.
+func goDeclDoc(d goast.Decl) *goast.CommentGroup {
+	switch d := d.(type) {
+	case *goast.FuncDecl:
+		return d.Doc
+	case *goast.GenDecl:
+		return d.Doc
+	}
+	return nil
+}
+
+// igoDeclDoc is like goDeclDoc, for iGo sources.
+func igoDeclDoc(d ast.Decl) *ast.CommentGroup {
+	switch d := d.(type) {
+	case *ast.FuncDecl:
+		return d.Doc
+	case *ast.GenDecl:
+		return d.Doc
+	}
+	return nil
+}
+
+// parseByteRange returns the offsets of the -range value s, start:end.
+func parseByteRange(s string) (start, end int, err error) {
+	i := strings.IndexByte(s, ':')
+	if i >= 0 {
+		start, err = strconv.Atoi(s[:i])
+		if err == nil {
+			end, err = strconv.Atoi(s[i+1:])
+		}
+	}
+	if i < 0 || err != nil || start < 0 || end < start {
+		return 0, 0, fmt.Errorf("invalid -range %q, want start:end", s)
+	}
+	return start, end, nil
+}
+
+// formatRange returns src, read from filename and written in the language
+// m, with the declarations overlapping the byte range [start, end)
+// formatted, or those containing start if the range is empty. They are
+// formatted after the package clause, on their own lines so that errors
+// are reported at their positions, then spliced back in place of their
+// source, from the first to the last one: the rest of src is kept byte
+// for byte.
+func formatRange(m Mode, filename string, src []byte, start, end int) ([]byte, error) {
+	if end > len(src) {
+		return nil, fmt.Errorf("%s: -range %d:%d beyond the %d bytes of the file", filename, start, end, len(src))
+	}
+	clause, spans, err := declSpans(m, filename, src)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := -1, -1
+	for _, d := range spans {
+		if d.start < end && start < d.end || start == end && d.start <= start && start <= d.end {
+			if from < 0 {
+				from = d.start
+			}
+			to = d.end
+		}
+	}
+	if from < 0 {
+		return nil, fmt.Errorf("%s: no declaration in -range %d:%d", filename, start, end)
+	}
+
+	part := make([]byte, 0, clause+to-from+len(src[clause:from]))
+	part = append(part, src[:clause]...)
+	part = append(part, bytes.Repeat([]byte{'\n'}, bytes.Count(src[clause:from], []byte{'\n'}))...)
+	part = append(part, src[from:to]...)
+	res, err := formatSource(m, filename, part, rewrites)
+	if err != nil {
+		return nil, err
+	}
+	clause, _, err = declSpans(m, filename, res)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(src)+len(res))
+	out = append(out, src[:from]...)
+	out = append(out, bytes.TrimSpace(res[clause:])...)
+	return append(out, src[to:]...), nil
+}
+
+// FormatRange formats the single file in paths, written in the language
+// of its extension, as Format does, but for the declarations overlapping
+// the -range only, see formatRange.
+func FormatRange(paths []string) int {
+	flag.Parse()
+
+	if len(paths) != 1 {
+		fmt.Fprintln(os.Stderr, "-range requires exactly one file")
+		exitCode = 2
+		return exitCode
+	}
+	path := paths[0]
+
+	m, report := GO, goReport
+	if strings.HasSuffix(path, *igoExt) {
+		m, report = IGO, igoReport
+	}
+	if err := igoInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
+	}
+	goInitParserMode()
+	goInitPrinterMode()
+
+	start, end, err := parseByteRange(*ByteRange)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		report(err)
+		return exitCode
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		report(err)
+		return exitCode
+	}
+	res, err := formatRange(m, path, src, start, end)
+	if err == nil && !bytes.Equal(src, res) {
+		err = writeFile(path, res, fi.Mode().Perm())
+		if err == nil && *verbose {
+			fmt.Fprintln(os.Stderr, path)
+		}
+	}
+	if err != nil {
+		report(err)
+	}
+	return exitCode
+}
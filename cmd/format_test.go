@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var formatInPlaceTests = []struct {
+	m       Mode
+	name    string
+	src     string
+	changed bool
+	out     string // the content after, src if empty
+	err     string // substring of the error, if any
+}{
+	{IGO, "a.igo", "package p\nvar  a = 1\nfunc f(x int) int\n    return x+1\n", true,
+		"package p\n\nvar a = 1\n\nfunc f(x int) int: return x + 1\n", ""},
+	{IGO, "a.igo", "package p\n\nvar a = 1\n\nfunc f(x int) int: return x + 1\n", false, "", ""},
+	{GO, "a.go", "package p\nvar  a = 1\nfunc f(x int) int {\n return x+1 }\n", true,
+		"package p\n\nvar a = 1\n\nfunc f(x int) int {\n\treturn x + 1\n}\n", ""},
+	{GO, "a.go", "package p\n\nvar a = 1\n", false, "", ""},
+	{IGO, "a.igo", "package p\nvar = 1\n", false, "", "expected"},
+	{GO, "a.go", "package p\nvar = 1\n", false, "", "expected"},
+}
+
+func TestFormatInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, test := range formatInPlaceTests {
+		path := filepath.Join(dir, test.name)
+		if err := ioutil.WriteFile(path, []byte(test.src), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		changed, err := FormatInPlace(test.m, path)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got error %v; want %q", test.src, err, test.err)
+			}
+		} else if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+		}
+		if changed != test.changed {
+			t.Errorf("%q: changed %v; want %v", test.src, changed, test.changed)
+		}
+
+		want := test.out
+		if want == "" {
+			want = test.src
+		}
+		if got, err := ioutil.ReadFile(path); err != nil || string(got) != want {
+			t.Errorf("%q: content\n%s\nwant\n%s", test.src, got, want)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != 0600 {
+			t.Errorf("%q: permissions %v; want 0600", test.src, fi.Mode().Perm())
+		}
+		if written := !fi.ModTime().Equal(old); written != test.changed {
+			t.Errorf("%q: written %v; want %v", test.src, written, test.changed)
+		}
+	}
+}
+
+// formatTree is a tree of sources formatted by Format.
+var formatTree = []struct {
+	name, src string
+	changed   bool
+}{
+	{"a.igo", "package p\nvar  a = 1\n", true},
+	{"b.go", "package p\nvar  b = 1\n", true},
+	{"c.txt", "var  c = 1\n", false},
+	{"d/e.igo", "package d\nvar = 1\n", false},
+	{"d/f.go", "package d\n\nvar f = 1\n", false},
+	{"d/.g.igo", "package d\nvar  g = 1\n", false}, // hidden
+	{"d/h/i.igo", "package h\nvar  i = 1\n", true},
+}
+
+// TestFormat checks that each path is visited once, its files formatted
+// in the language of their extension.
+func TestFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, file := range formatTree {
+		path := filepath.Join(dir, filepath.FromSlash(file.name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(file.src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer setExitCode(exitCode)
+	defer setVerbose(*verbose)
+	setVerbose(true)
+	stderr := captureOutput(t, &os.Stderr)
+	code := Format([]string{dir, filepath.Join(dir, "b.go"), filepath.Join(dir, "missing.go")})
+	out := stderr.read()
+	if code != 2 {
+		t.Errorf("exit code %d; want 2", code)
+	}
+
+	// b.go, given twice, is formatted by the walk, then unchanged
+	for _, name := range []string{"a.igo", "b.go", "d/h/i.igo"} {
+		if n := strings.Count(out, filepath.Join(dir, filepath.FromSlash(name))+"\n"); n != 1 {
+			t.Errorf("%s printed %d times; want 1:\n%s", name, n, out)
+		}
+	}
+	for _, name := range []string{"d/e.igo:2:5", "missing.go"} {
+		if n := strings.Count(out, filepath.Join(dir, filepath.FromSlash(name))+":"); n != 1 {
+			t.Errorf("error of %s printed %d times; want 1:\n%s", name, n, out)
+		}
+	}
+	for _, file := range formatTree {
+		got, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file.name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed := string(got) != file.src; changed != file.changed {
+			t.Errorf("%s: got %q, changed %v; want %v", file.name, got, changed, file.changed)
+		}
+	}
+}
+
+// setVerbose sets -v.
+func setVerbose(v bool) {
+	*verbose = v
+}
+
+// TestFormatInPlaceRewriteFlag checks that the rules given via -r apply
+// to iGo sources, and that an invalid one is returned as an error.
+func TestFormatInPlaceRewriteFlag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setRewriteRule(*rewriteRule)
+
+	path := filepath.Join(dir, "a.igo")
+	src := []byte("package p\n\nvar a = foo(1)\n")
+	if err := ioutil.WriteFile(path, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setRewriteRule("foo(x)")
+	if changed, err := FormatInPlace(IGO, path); changed || err == nil {
+		t.Errorf("invalid -r: got %v, %v; want an error", changed, err)
+	}
+
+	setRewriteRule("foo(x) -> bar(x)")
+	if changed, err := FormatInPlace(IGO, path); !changed || err != nil {
+		t.Errorf("got %v, %v; want true, nil", changed, err)
+	}
+	if got, _ := ioutil.ReadFile(path); !strings.Contains(string(got), "bar(1)") {
+		t.Errorf("rule not applied:\n%s", got)
+	}
+}
+
+var parseByteRangeTests = []struct {
+	s          string
+	start, end int
+	ok         bool
+}{
+	{"1:5", 1, 5, true},
+	{"2:2", 2, 2, true},
+	{"5:1", 0, 0, false},
+	{"-1:2", 0, 0, false},
+	{"a:b", 0, 0, false},
+	{"3", 0, 0, false},
+	{"", 0, 0, false},
+}
+
+func TestParseByteRange(t *testing.T) {
+	for _, test := range parseByteRangeTests {
+		start, end, err := parseByteRange(test.s)
+		if (err == nil) != test.ok || start != test.start || end != test.end {
+			t.Errorf("%q: got %d, %d, %v; want %d, %d, ok %v", test.s, start, end, err, test.start, test.end, test.ok)
+		}
+	}
+}
+
+var formatRangeTests = []struct {
+	m        Mode
+	src, sel string // the range is that of sel in src, 0:0 if empty
+	out      string
+	err      string // substring of the error, if any
+}{
+	{GO, "package p\nvar  a = 1\n\nfunc f(x int) int {\n return x+1 }\n\nvar  b = 2\n", "x+1",
+		"package p\nvar  a = 1\n\nfunc f(x int) int {\n\treturn x + 1\n}\n\nvar  b = 2\n", ""},
+	{GO, "package p\nvar  a = 1\n\nfunc f(x int) int {\n return x+1 }\n\nvar  b = 2\n", "1\n\nfunc",
+		"package p\nvar a = 1\n\nfunc f(x int) int {\n\treturn x + 1\n}\n\nvar  b = 2\n", ""},
+	// the doc comment goes with its declaration
+	{GO, "package p\n\n// F  does.\nfunc F() {  }\nvar  b = 2\n", "F  does",
+		"package p\n\n// F  does.\nfunc F() {}\nvar  b = 2\n", ""},
+	{GO, "package p\n\nvar  a = 1\n", "", "", "no declaration"},
+	{GO, "package p\n\nvar = 1\n", "= 1", "", "expected"},
+	{IGO, "package p\nvar  a = 1\n\nfunc f(x int) int\n    return x+1\n\nvar  b = 2\n", "x+1",
+		"package p\nvar  a = 1\n\nfunc f(x int) int: return x + 1\n\nvar  b = 2\n", ""},
+	{IGO, "package p\n\n# F  does.\nfunc F():\nvar  b = 2\n", "b",
+		"package p\n\n# F  does.\nfunc F():\nvar b = 2\n", ""},
+}
+
+func TestFormatRange(t *testing.T) {
+	igoInitMode()
+	goInitParserMode()
+	goInitPrinterMode()
+	for _, test := range formatRangeTests {
+		start, end := 0, 0
+		if test.sel != "" {
+			start = strings.Index(test.src, test.sel)
+			end = start + len(test.sel)
+		}
+		out, err := formatRange(test.m, "f", []byte(test.src), start, end)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q, %d:%d: got error %v; want %q", test.src, start, end, err, test.err)
+			}
+			continue
+		}
+		if err != nil || string(out) != test.out {
+			t.Errorf("%q, %d:%d: got %q, %v; want %q", test.src, start, end, out, err, test.out)
+		}
+	}
+
+	// an empty range formats the declaration containing it
+	src := "package p\nvar  a = 1\nvar  b = 2\n"
+	i := strings.Index(src, "b")
+	if out, err := formatRange(GO, "f", []byte(src), i, i); err != nil || string(out) != "package p\nvar  a = 1\nvar b = 2\n" {
+		t.Errorf("%q, %d:%d: got %q, %v", src, i, i, out, err)
+	}
+
+	if _, err := formatRange(GO, "f", []byte("package p\n"), 0, 20); err == nil || !strings.Contains(err.Error(), "beyond") {
+		t.Errorf("got error %v; want one about the range beyond the file", err)
+	}
+}
@@ -24,7 +24,15 @@ var (
 )
 
 func goReport(err error) {
-	scanner.PrintError(os.Stderr, err)
+	counts.failed++
+	switch {
+	case *errFormat == "json":
+		printErrorsJSON(err)
+	case *showCarets:
+		printErrorCarets(err)
+	default:
+		scanner.PrintError(os.Stderr, err)
+	}
 	exitCode = 2
 }
 
@@ -37,14 +45,26 @@ func goInitParserMode() {
 }
 
 func goInitPrinterMode() {
+	// alignment is always done with spaces, -spaces only changes the
+	// indentation
 	goPrinterMode = printer.UseSpaces
-	if *tabIndent {
+	if *tabIndent && !*useSpaces {
 		goPrinterMode |= printer.TabIndent
 	}
+	if *wsOnly {
+		goPrinterMode |= printer.SourceLines
+	}
 }
 
 func goProcessFile(filename string, in io.Reader, out io.Writer) error {
-	dest := strings.TrimSuffix(filename, ".go") + ".igo"
+	counts.files++
+	dest, err := outPath(filename, destName(filename, *goExt, *igoExt))
+	if err != nil {
+		return err
+	}
+	if *outDir == "" && *DestDir != "" {
+		dest = filepath.Join(*DestDir, dest)
+	}
 
 	f, err := os.Open(filename)
 	if err != nil {
@@ -57,46 +77,127 @@ func goProcessFile(filename string, in io.Reader, out io.Writer) error {
 		return err
 	}
 
-	file, adjust, err := goParse(goFileSet, filename, src)
+	res, err := goTranslateCached(filename, src)
 	if err != nil {
 		return err
 	}
 
-	ast.SortImports(goFileSet, file)
+	// the iGo is written anyway, as by igoProcessFile
+	cerr := checkOutput(IGO, dest, res)
 
-	var buf bytes.Buffer
-	err = (&printer.Config{Mode: goPrinterMode, Tabwidth: *tabWidth}).Fprint(&buf, goFileSet, file)
+	if *summary {
+		counts.add(dest, src, res)
+		return cerr
+	}
+
+	if err := createDir(dest); err != nil {
+		return err
+	}
+
+	if ok, err := confirmWrite(dest, res); !ok {
+		return err
+	}
+
+	err = writeFile(dest, res, 0644)
 	if err != nil {
 		return err
 	}
+
+	return cerr
+}
+
+// goTranslate converts src, which was read from filename, from Go to iGo,
+// recording the transformations applied in applied, if not nil.
+func goTranslate(filename string, src []byte, applied *transforms) ([]byte, error) {
+	if *allowEmpty && isBlank(src) {
+		return []byte{}, nil
+	}
+	file, adjust, err := goParse(goFileSet, filename, src)
+	if err != nil {
+		return nil, err
+	}
+	return goPrint(goFileSet, file, src, adjust, applied)
+}
+
+// goPrint prints file, parsed from src by goParse together with adjust,
+// as iGo.
+func goPrint(fset *token.FileSet, file *ast.File, src []byte, adjust func(orig, src []byte) []byte, applied *transforms) ([]byte, error) {
+	hasBOM := bytes.HasPrefix(src, utf8BOM)
+	src = stripBOM(src)
+
+	imports := goImports(file)
+	ast.SortImports(fset, file)
+	if !equalStrings(imports, goImports(file)) {
+		applied.add("sorted imports")
+	}
+
+	var buf bytes.Buffer
+	// fragments are adjusted after printing, which expects no mark
+	cfg := printer.Config{Mode: goPrinterMode, Tabwidth: *tabWidth, PreserveBOM: hasBOM && *keepBOM && adjust == nil}
+	cfg.PreserveBlankLines, cfg.MaxBlankLines = *blankLines > 0, *blankLines
+	err := cfg.Fprint(&buf, fset, file)
+	if err != nil {
+		return nil, err
+	}
 	res := buf.Bytes()
 	if adjust != nil {
 		res = adjust(src, res)
 	}
+	return res, nil
+}
 
-	if *DestDir != "" {
-		dest = filepath.Join(*DestDir, dest)
-		createDir(dest)
+// goImports returns the paths of the imports of file, in order.
+func goImports(file *ast.File) (paths []string) {
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			for _, spec := range d.Specs {
+				paths = append(paths, spec.(*ast.ImportSpec).Path.Value)
+			}
+		}
 	}
+	return
+}
 
-	err = ioutil.WriteFile(dest, res, 0644)
+// goCheckFile parses filename reporting any syntax error. With -max-col,
+// filename is converted and a line of the iGo too wide is reported too.
+func goCheckFile(filename string) error {
+	src, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-
+	if *allowEmpty && isBlank(src) {
+		return nil
+	}
+	if *maxCol > 0 {
+		res, err := goTranslate(filename, src, nil)
+		if err != nil {
+			return err
+		}
+		if errs := checkMaxCol(destName(filename, *goExt, *igoExt), res); errs != nil {
+			return errs
+		}
+		return nil
+	}
+	_, _, err = goParse(goFileSet, filename, src)
 	return err
 }
 
 func goFile(f os.FileInfo) bool {
 	// ignore non-Go files
 	name := f.Name()
-	return !f.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".go")
+	return !f.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, *goExt)
 }
 
 func goVisitFile(path string, f os.FileInfo, err error) error {
+	if err == nil && ignored(path, f) {
+		return skip(f)
+	}
 	if err == nil && goFile(f) {
 		err = goProcessFile(path, nil, os.Stdout)
 	}
+	if err == errQuit {
+		return err // stops the walk
+	}
 	if err != nil {
 		goReport(err)
 	}
@@ -110,7 +211,7 @@ func goWalkPath(path string) {
 	case dir.IsDir():
 		filepath.Walk(path, goVisitFile)
 	default:
-		if err := goProcessFile(path, nil, os.Stdout); err != nil {
+		if err := goProcessFile(path, nil, os.Stdout); err != nil && err != errQuit {
 			goReport(err)
 		}
 	}
@@ -119,15 +220,31 @@ func goWalkPath(path string) {
 // parse parses src, which was read from filename,
 // as a Go source file or statement list.
 func goParse(fset *token.FileSet, filename string, src []byte) (*ast.File, func(orig, src []byte) []byte, error) {
-	// Try as whole source file.
-	file, err := parser.ParseFile(fset, filename, src, goParserMode)
+	if err := checkUTF8(filename, src); err != nil {
+		return nil, nil, err
+	}
+
+	// A byte order mark is only permitted at the very beginning:
+	// remove it, or it would end up after the package clause below.
+	src = stripBOM(src)
+
+	// Try as whole source file. The missing package clause of an empty
+	// source is reported alone, not with the errors on the same line
+	// following from it, which would sort before it.
+	mode := goParserMode
+	if isBlank(src) {
+		mode &^= parser.AllErrors
+	}
+	file, err := parser.ParseFile(fset, filename, src, mode)
+	err = ignoreBadUTF8(err)
 	if err == nil {
 		return file, nil, nil
 	}
 	// If the error is that the source file didn't begin with a
 	// package line and this is standard input, fall through to
 	// try as a source fragment.  Stop and return on any other error.
-	if !strings.Contains(err.Error(), "expected 'package'") {
+	// An empty source is no fragment either.
+	if !strings.Contains(err.Error(), "expected 'package'") || isBlank(src) {
 		return nil, nil, err
 	}
 
@@ -137,6 +254,7 @@ func goParse(fset *token.FileSet, filename string, src []byte) (*ast.File, func(
 	// in psrc match the ones in src.
 	psrc := append([]byte("package p;"), src...)
 	file, err = parser.ParseFile(fset, filename, psrc, goParserMode)
+	err = ignoreBadUTF8(err)
 	if err == nil {
 		adjust := func(orig, src []byte) []byte {
 			// Remove the package clause.
@@ -160,6 +278,7 @@ func goParse(fset *token.FileSet, filename string, src []byte) (*ast.File, func(
 	// in fsrc match the ones in src.
 	fsrc := append(append([]byte("package p; func _() {"), src...), '}')
 	file, err = parser.ParseFile(fset, filename, fsrc, goParserMode)
+	err = ignoreBadUTF8(err)
 	if err == nil {
 		adjust := func(orig, src []byte) []byte {
 			// Remove the wrapping.
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/from_go"
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/to_go"
+)
+
+var FuncName = flag.String("func", "", "convert only the function Name, or the method Recv.Name, of a single file to standard output")
+
+// Func converts the function called name, declared in the file at path,
+// from the language opposite to m into m and writes it, along with its
+// comments, to standard output. name is either the name of a function or
+// Recv.Name for the method Name of the type Recv. It is an error if no
+// declaration or more than one matches.
+func Func(m Mode, name string, paths []string) int {
+	flag.Parse()
+
+	if len(paths) != 1 {
+		fmt.Fprintln(os.Stderr, "-func requires exactly one file")
+		exitCode = 2
+		return exitCode
+	}
+
+	if m == IGO {
+		goInitParserMode()
+		goInitPrinterMode()
+		if err := goFunc(paths[0], name, os.Stdout); err != nil {
+			goReport(err)
+		}
+	} else if err := igoInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+	} else if err := igoFunc(paths[0], name, os.Stdout); err != nil {
+		igoReport(err)
+	}
+	return exitCode
+}
+
+func igoFunc(filename, name string, out io.Writer) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := checkUTF8(filename, src); err != nil {
+		return err
+	}
+	file, err := parser.ParseFile(igoFileSet, filename, stripBOM(src), igoParserMode)
+	err = ignoreBadUTF8(err)
+	if err != nil {
+		return err
+	}
+	file = rewriteFile(igoFileSet, file, rewrites, nil)
+
+	var found []*ast.FuncDecl
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.FuncDecl); ok && funcMatches(name, igoRecvName(d), d.Name.Name) {
+			found = append(found, d)
+		}
+	}
+	if err := funcFound(filename, name, len(found)); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	cfg := &to_go.Config{Mode: igoPrinterMode, Tabwidth: *tabWidth}
+	cfg.PreserveBlankLines, cfg.MaxBlankLines = *blankLines > 0, *blankLines
+	if _, err := cfg.Fprint(&buf, igoFileSet, &to_go.CommentedNode{Node: found[0], Comments: file.Comments}); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = out.Write(buf.Bytes())
+	return err
+}
+
+func goFunc(filename, name string, out io.Writer) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := checkUTF8(filename, src); err != nil {
+		return err
+	}
+	file, err := goparser.ParseFile(goFileSet, filename, stripBOM(src), goParserMode)
+	err = ignoreBadUTF8(err)
+	if err != nil {
+		return err
+	}
+
+	var found []*goast.FuncDecl
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*goast.FuncDecl); ok && funcMatches(name, goRecvName(d), d.Name.Name) {
+			found = append(found, d)
+		}
+	}
+	if err := funcFound(filename, name, len(found)); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	cfg := &from_go.Config{Mode: goPrinterMode, Tabwidth: *tabWidth}
+	cfg.PreserveBlankLines, cfg.MaxBlankLines = *blankLines > 0, *blankLines
+	if err := cfg.Fprint(&buf, goFileSet, &from_go.CommentedNode{Node: found[0], Comments: file.Comments}); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = out.Write(buf.Bytes())
+	return err
+}
+
+// funcMatches reports whether name, as given to -func, is the function
+// fname or, if recv is not empty, the method fname of the type recv.
+func funcMatches(name, recv, fname string) bool {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return recv != "" && strings.TrimPrefix(name[:i], "*") == recv && name[i+1:] == fname
+	}
+	return recv == "" && name == fname
+}
+
+// funcFound returns the error for n declarations of filename matching
+// name, if n is not exactly one.
+func funcFound(filename, name string, n int) error {
+	switch n {
+	case 0:
+		return fmt.Errorf("%s: no function %s", filename, name)
+	case 1:
+		return nil
+	}
+	return fmt.Errorf("%s: %d functions match %s", filename, n, name)
+}
+
+// igoRecvName returns the name of the receiver type of d, without any
+// '*' or type parameters, or "" if d is a function.
+func igoRecvName(d *ast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return ""
+	}
+	typ := d.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch x := typ.(type) {
+	case *ast.IndexExpr:
+		typ = x.X
+	case *ast.IndexListExpr:
+		typ = x.X
+	}
+	if id, ok := typ.(*ast.Ident); ok {
+		return id.Name
+	}
+	return "_"
+}
+
+// goRecvName is like igoRecvName, for Go sources.
+func goRecvName(d *goast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return ""
+	}
+	typ := d.Recv.List[0].Type
+	if star, ok := typ.(*goast.StarExpr); ok {
+		typ = star.X
+	}
+	switch x := typ.(type) {
+	case *goast.IndexExpr:
+		typ = x.X
+	case *goast.IndexListExpr:
+		typ = x.X
+	}
+	if id, ok := typ.(*goast.Ident); ok {
+		return id.Name
+	}
+	return "_"
+}
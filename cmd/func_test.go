@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var funcMatchesTests = []struct {
+	name, recv, fname string
+	want              bool
+}{
+	{"F", "", "F", true},
+	{"F", "T", "F", false},
+	{"T.F", "T", "F", true},
+	{"*T.F", "T", "F", true},
+	{"T.F", "", "F", false},
+	{"U.F", "T", "F", false},
+}
+
+func TestFuncMatches(t *testing.T) {
+	for _, test := range funcMatchesTests {
+		if got := funcMatches(test.name, test.recv, test.fname); got != test.want {
+			t.Errorf("funcMatches(%q, %q, %q) = %v; want %v", test.name, test.recv, test.fname, got, test.want)
+		}
+	}
+}
+
+// funcSources are converted by -func, by extension.
+var funcSources = map[string]string{
+	".igo": "package p\n\ntype T[K comparable] struct\n\tk K\n\n# F returns 1.\nfunc F() int: return 1\n\nfunc *T[K].M() K: return self.k\n\nfunc init():\n\nfunc init():\n",
+	".go":  "package p\n\ntype P[K, V any] struct{}\n\n// F returns 1.\nfunc F() int { return 1 }\n\nfunc (p P[K, V]) M() {}\n\nfunc init() {}\n\nfunc init() {}\n",
+}
+
+var funcTests = []struct {
+	ext  string // of the source
+	name string
+	out  string
+	err  string
+}{
+	{".igo", "F", "// F returns 1.\nfunc F() int { return 1 }\n", ""},
+	{".igo", "T.M", "func (self *T[K]) M() K { return self.k }\n", ""},
+	{".igo", "*T.M", "func (self *T[K]) M() K { return self.k }\n", ""},
+	{".igo", "M", "", "no function M"},
+	{".igo", "init", "", "2 functions match init"},
+	{".go", "F", "# F returns 1.\nfunc F() int: return 1\n", ""},
+	{".go", "P.M", "func P[K, V].M():\n", ""},
+	{".go", "P.F", "", "no function P.F"},
+	{".go", "init", "", "2 functions match init"},
+}
+
+func TestFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	igoInitMode()
+	goInitParserMode()
+	goInitPrinterMode()
+
+	for _, test := range funcTests {
+		filename := filepath.Join(dir, "f"+test.ext)
+		if err := ioutil.WriteFile(filename, []byte(funcSources[test.ext]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if test.ext == ".igo" {
+			err = igoFunc(filename, test.name, &out)
+		} else {
+			err = goFunc(filename, test.name, &out)
+		}
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s %s: got error %v; want %s", test.ext, test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: %v", test.ext, test.name, err)
+			continue
+		}
+		if out.String() != test.out {
+			t.Errorf("%s %s: got %q; want %q", test.ext, test.name, out.String(), test.out)
+		}
+	}
+}
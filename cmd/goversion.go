@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"strconv"
+	"strings"
+
+	"github.com/DAddYE/igo/scanner"
+	"github.com/DAddYE/igo/token"
+)
+
+var goVersion = flag.String("go", "", "report the uses of Go features newer than this version, e.g. 1.17, in the Go code written by compile")
+
+// goMinor returns the minor number of the Go version v, e.g. 17 for 1.17
+// or go1.17.3.
+func goMinor(v string) (int, error) {
+	s := strings.TrimPrefix(v, "go")
+	if !strings.HasPrefix(s, "1.") {
+		return 0, fmt.Errorf("invalid -go version %q, want e.g. 1.17", v)
+	}
+	s = s[len("1."):]
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s = s[:i]
+	}
+	minor, err := strconv.Atoi(s)
+	if err != nil || minor < 0 {
+		return 0, fmt.Errorf("invalid -go version %q, want e.g. 1.17", v)
+	}
+	return minor, nil
+}
+
+// checkGoVersion returns the uses, in the Go source src written to
+// filename, of features newer than -go, if set, at their positions in src.
+// Declarations of the file named as a predeclared identifier, e.g. a func
+// min, are told apart, those of the package are not. Without the types,
+// only a range over an integer literal is reported, not over a variable.
+func checkGoVersion(filename string, src []byte) error {
+	if *goVersion == "" {
+		return nil
+	}
+	target, err := goMinor(*goVersion)
+	if err != nil {
+		return err
+	}
+
+	fset := gotoken.NewFileSet()
+	file, err := goparser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return err
+	}
+
+	var errs scanner.ErrorList
+	report := func(n goast.Node, minor int, feature string) {
+		if minor <= target {
+			return
+		}
+		errs.Add(token.Position(fset.Position(n.Pos())), fmt.Sprintf("%s requires go1.%d or later (-go %s)", feature, minor, *goVersion))
+	}
+
+	names := make(map[*goast.Ident]bool) // of fields, not predeclared
+	goast.Inspect(file, func(n goast.Node) bool {
+		switch x := n.(type) {
+		case *goast.SelectorExpr:
+			names[x.Sel] = true
+		case *goast.Field:
+			for _, id := range x.Names {
+				names[id] = true
+			}
+		case *goast.KeyValueExpr:
+			if id, ok := x.Key.(*goast.Ident); ok {
+				names[id] = true
+			}
+		case *goast.FuncType:
+			if x.TypeParams != nil {
+				report(x.TypeParams, 18, "a type parameter list")
+			}
+		case *goast.TypeSpec:
+			if x.TypeParams != nil {
+				report(x.TypeParams, 18, "a type parameter list")
+			}
+			if x.Assign.IsValid() {
+				report(x, 9, "a type alias")
+			}
+		case *goast.IndexListExpr:
+			report(x, 18, "an instantiation with several type arguments")
+		case *goast.UnaryExpr:
+			if x.Op == gotoken.TILDE {
+				report(x, 18, "an approximation element (~T)")
+			}
+		case *goast.Ident:
+			// predeclared identifiers, unless declared in the file
+			if x.Obj == nil && !names[x] && (x.Name == "any" || x.Name == "comparable") {
+				report(x, 18, "the predeclared "+x.Name)
+			}
+		case *goast.CallExpr:
+			if id, ok := x.Fun.(*goast.Ident); ok && id.Obj == nil {
+				switch id.Name {
+				case "min", "max", "clear":
+					report(x, 21, "the builtin "+id.Name)
+				}
+			}
+		case *goast.RangeStmt:
+			if lit, ok := x.X.(*goast.BasicLit); ok && lit.Kind == gotoken.INT {
+				report(x, 22, "range over an integer")
+			}
+		case *goast.BasicLit:
+			if x.Kind == gotoken.INT || x.Kind == gotoken.FLOAT || x.Kind == gotoken.IMAG {
+				lit := strings.ToLower(x.Value)
+				if strings.HasPrefix(lit, "0b") || strings.HasPrefix(lit, "0o") || strings.Contains(lit, "_") {
+					report(x, 13, "a 0b or 0o prefix or a digit separator")
+				}
+			}
+		}
+		return true
+	})
+
+	if len(errs) == 0 {
+		return nil
+	}
+	errs.Sort()
+	return errs
+}
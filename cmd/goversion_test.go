@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/DAddYE/igo/scanner"
+)
+
+var goMinorTests = []struct {
+	v     string
+	minor int
+	ok    bool
+}{
+	{"1.17", 17, true},
+	{"go1.17", 17, true},
+	{"go1.17.3", 17, true},
+	{"1.0", 0, true},
+	{"1", 0, false},
+	{"2.0", 0, false},
+	{"1.x", 0, false},
+	{"1.-1", 0, false},
+	{"", 0, false},
+}
+
+func TestGoMinor(t *testing.T) {
+	for _, test := range goMinorTests {
+		minor, err := goMinor(test.v)
+		if ok := err == nil; ok != test.ok || minor != test.minor {
+			t.Errorf("goMinor(%q) = %d, %v; want %d, ok %v", test.v, minor, err, test.minor, test.ok)
+		}
+	}
+}
+
+// setGoVersion sets -go.
+func setGoVersion(v string) {
+	*goVersion = v
+}
+
+var goVersionTests = []struct {
+	version string
+	src     string
+	errs    []string
+}{
+	{"1.17", "package p\n\nvar x any\n",
+		[]string{"3:7: the predeclared any requires go1.18 or later (-go 1.17)"}},
+	{"1.18", "package p\n\nvar x any\n", nil},
+	// fields, selectors, keys and declarations are not the predeclared any
+	{"1.17", "package p\n\ntype T struct{ any int }\n\nfunc (t T) f() int { return t.any + T{any: 1}.any }\n", nil},
+	{"1.17", "package p\n\ntype any int\n\nvar x any\n", nil},
+	{"1.17", "package p\n\nfunc F[T comparable](x T) {}\n",
+		[]string{"3:7: a type parameter list requires go1.18 or later (-go 1.17)",
+			"3:10: the predeclared comparable requires go1.18 or later (-go 1.17)"}},
+	{"1.17", "package p\n\ntype I interface{ ~int }\n\nvar m M[int, string]\n",
+		[]string{"3:19: an approximation element (~T) requires go1.18 or later (-go 1.17)",
+			"5:7: an instantiation with several type arguments requires go1.18 or later (-go 1.17)"}},
+	{"1.8", "package p\n\ntype A = int\n",
+		[]string{"3:6: a type alias requires go1.9 or later (-go 1.8)"}},
+	{"1.9", "package p\n\ntype A = int\n", nil},
+	{"1.20", "package p\n\nvar x = min(1, 2)\n",
+		[]string{"3:9: the builtin min requires go1.21 or later (-go 1.20)"}},
+	{"1.20", "package p\n\nfunc min(a, b int) int { return a }\n\nvar x = min(1, 2) + t.max(1)\n", nil},
+	{"1.12", "package p\n\nvar x = 1_000 + 0b1 + 0o7 + 0x1F + 017\n",
+		[]string{"3:9: a 0b or 0o prefix or a digit separator requires go1.13 or later (-go 1.12)",
+			"3:17: a 0b or 0o prefix or a digit separator requires go1.13 or later (-go 1.12)",
+			"3:23: a 0b or 0o prefix or a digit separator requires go1.13 or later (-go 1.12)"}},
+	{"1.21", "package p\n\nfunc f() {\n\tfor range 10 {\n\t}\n}\n",
+		[]string{"4:2: range over an integer requires go1.22 or later (-go 1.21)"}},
+	// only literals are told to be integers
+	{"1.21", "package p\n\nfunc f(n int) {\n\tfor range n {\n\t}\n}\n", nil},
+	{"", "package p\n\nvar x any\n", nil},
+}
+
+func TestCheckGoVersion(t *testing.T) {
+	defer setGoVersion(*goVersion)
+	for _, test := range goVersionTests {
+		setGoVersion(test.version)
+		err := checkGoVersion("", []byte(test.src))
+		var errs []string
+		if list, ok := err.(scanner.ErrorList); ok {
+			for _, e := range list {
+				errs = append(errs, e.Error())
+			}
+		} else if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if len(errs) != len(test.errs) {
+			t.Errorf("-go %s %q: got %q; want %q", test.version, test.src, errs, test.errs)
+			continue
+		}
+		for i := range errs {
+			if errs[i] != test.errs[i] {
+				t.Errorf("-go %s %q: got %q; want %q", test.version, test.src, errs[i], test.errs[i])
+			}
+		}
+	}
+}
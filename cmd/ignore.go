@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// An .igoignore file lists, one per line, gitignore-style patterns of paths
+// to skip while walking directories. Blank lines and lines starting with '#'
+// are ignored. In a pattern '*' matches any sequence of characters but '/',
+// '?' any single character but '/' and '**' any sequence of characters,
+// '/' included. A leading '!' negates the pattern, re-including paths
+// excluded before, and a trailing '/' restricts the pattern to directories.
+// Patterns containing a '/' other than a trailing one are relative to the
+// directory of the .igoignore file; the others match the name of a path at
+// any depth below it.
+//
+// The .igoignore files are discovered walking up from the directory of each
+// visited path. All of them apply: they are consulted from the outermost to
+// the innermost, and every file in order, so that the last matching pattern
+// decides, i.e. the patterns of the innermost files take precedence.
+const ignoreFileName = ".igoignore"
+
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+var ignoreFiles = make(map[string][]ignorePattern) // cache of .igoignore patterns by directory
+
+// ignored reports whether path, described by f, is excluded by the
+// .igoignore files found in its directory or any of its parents.
+func ignored(path string, f os.FileInfo) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	// collect the directories from the outermost one
+	var dirs []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+
+	ignore := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		patterns := loadIgnoreFile(dirs[i])
+		if len(patterns) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(dirs[i], path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, p := range patterns {
+			if (!p.dirOnly || f.IsDir()) && p.re.MatchString(rel) {
+				ignore = !p.negate
+			}
+		}
+	}
+	return ignore
+}
+
+// skip returns the value a filepath.WalkFunc must return to skip f.
+func skip(f os.FileInfo) error {
+	if f.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+// loadIgnoreFile returns the patterns of the .igoignore file in dir, if any.
+func loadIgnoreFile(dir string) []ignorePattern {
+	if patterns, found := ignoreFiles[dir]; found {
+		return patterns
+	}
+
+	var patterns []ignorePattern
+	if f, err := os.Open(filepath.Join(dir, ignoreFileName)); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			patterns = append(patterns, compileIgnorePattern(line))
+		}
+		f.Close()
+	}
+
+	ignoreFiles[dir] = patterns
+	return patterns
+}
+
+// compileIgnorePattern translates a .igoignore pattern into a regular
+// expression matching slash-separated paths relative to its directory.
+func compileIgnorePattern(line string) (p ignorePattern) {
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var re bytes.Buffer
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case strings.HasPrefix(line[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(line[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	p.re = regexp.MustCompile(re.String())
+	return
+}
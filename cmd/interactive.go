@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var interactive = flag.Bool("interactive", false, "show the changes to each file and ask before writing it")
+
+// prompt asks the user before each write; it is nil unless -interactive
+// is set.
+var prompt *prompter
+
+// errQuit is returned by prompter.confirm when the user chose to quit.
+var errQuit = errors.New("quit")
+
+// A prompter shows the changes about to be made to a file on out and
+// reads from in whether to write it.
+type prompter struct {
+	in   *bufio.Reader
+	out  io.Writer
+	all  bool // write all remaining files without asking
+	quit bool // write no more files
+}
+
+// initInteractive sets up prompt if -interactive is set. Standard input
+// must be a terminal, there would be nobody to answer otherwise.
+func initInteractive() error {
+	prompt = nil
+	if !*interactive {
+		return nil
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return errors.New("-interactive requires standard input to be a terminal")
+	}
+	prompt = &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+	return nil
+}
+
+// confirm shows diff, the changes to filename, and asks whether to write
+// it until it gets one of y (yes), n (no), a (yes to all the remaining
+// files) or q (quit, also at the end of the input).
+func (p *prompter) confirm(filename string, diff []byte) (bool, error) {
+	if p.all {
+		return true, nil
+	}
+	p.out.Write(diff)
+	for {
+		fmt.Fprintf(p.out, "write %s? [y/n/a/q] ", filename)
+		line, err := p.in.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y":
+			return true, nil
+		case "n":
+			return false, nil
+		case "a":
+			p.all = true
+			return true, nil
+		case "q":
+			p.quit = true
+			return false, errQuit
+		}
+		if err != nil {
+			fmt.Fprintln(p.out)
+			p.quit = true
+			return false, errQuit
+		}
+	}
+}
+
+// confirmWrite reports whether res should be written to filename. Without
+// -interactive, or with -n where writeFile only reports what it would do,
+// it always should; otherwise files that would not change are skipped and
+// the user is asked for the others. Once the user chose to quit, it
+// returns errQuit for every file.
+func confirmWrite(filename string, res []byte) (bool, error) {
+	if prompt == nil || *dryRun {
+		return true, nil
+	}
+	if prompt.quit {
+		return false, errQuit
+	}
+
+	orig, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if bytes.Equal(orig, res) {
+		return false, nil
+	}
+
+	var d []byte
+	if !prompt.all {
+		if d, err = diff(filename, orig, res); err != nil {
+			return false, err
+		}
+	}
+
+	return prompt.confirm(filename, d)
+}
+
+// quitting reports whether the user chose to quit at a prompt, the
+// command then stops without an error.
+func quitting() bool {
+	return prompt != nil && prompt.quit
+}
+
+// diff returns the changes from orig to res, the old and new content of
+// filename, as a unified diff.
+func diff(filename string, orig, res []byte) (data []byte, err error) {
+	f1, err := writeTempFile("igo", orig)
+	if err != nil {
+		return
+	}
+	defer os.Remove(f1)
+
+	f2, err := writeTempFile("igo", res)
+	if err != nil {
+		return
+	}
+	defer os.Remove(f2)
+
+	data, err = exec.Command("diff", "-u", "-L", filename+".orig", "-L", filename, f1, f2).CombinedOutput()
+	if len(data) > 0 {
+		// diff exits with a non-zero status when the files don't match.
+		// Ignore that failure as long as we get output.
+		err = nil
+	}
+	return
+}
+
+func writeTempFile(prefix string, data []byte) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
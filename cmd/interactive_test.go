@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var confirmTests = []struct {
+	in   string
+	ok   bool
+	err  error
+	all  bool
+	asks int // number of prompts printed
+}{
+	{"y\n", true, nil, false, 1},
+	{"Y\n", true, nil, false, 1},
+	{"n\n", false, nil, false, 1},
+	{"a\n", true, nil, true, 1},
+	{"q\n", false, errQuit, false, 1},
+	{"", false, errQuit, false, 1},
+	{"x\n\ny\n", true, nil, false, 3},
+	{"maybe\nn", false, nil, false, 2},
+	{"y", true, nil, false, 1}, // no newline at the end of the input
+}
+
+func TestConfirm(t *testing.T) {
+	for _, test := range confirmTests {
+		var out bytes.Buffer
+		p := &prompter{in: bufio.NewReader(strings.NewReader(test.in)), out: &out}
+		ok, err := p.confirm("f.go", []byte("diff\n"))
+		if ok != test.ok || err != test.err || p.all != test.all {
+			t.Errorf("%q: got %v, %v, all %v; want %v, %v, all %v", test.in, ok, err, p.all, test.ok, test.err, test.all)
+		}
+		if n := strings.Count(out.String(), "write f.go? [y/n/a/q] "); n != test.asks {
+			t.Errorf("%q: asked %d times; want %d", test.in, n, test.asks)
+		}
+		if !strings.HasPrefix(out.String(), "diff\n") {
+			t.Errorf("%q: diff not shown first: %q", test.in, out.String())
+		}
+	}
+}
+
+func TestConfirmAll(t *testing.T) {
+	var out bytes.Buffer
+	p := &prompter{in: bufio.NewReader(strings.NewReader("")), out: &out, all: true}
+	if ok, err := p.confirm("f.go", []byte("diff\n")); !ok || err != nil {
+		t.Errorf("got %v, %v; want true, nil", ok, err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("printed %q after a; want nothing", out.String())
+	}
+}
+
+var confirmWriteTests = []struct {
+	dryRun bool
+	res    string
+	in     string
+	ok     bool
+	asked  bool
+}{
+	{false, "package p\n", "y\n", false, false}, // unchanged, skipped
+	{false, "package q\n", "y\n", true, true},
+	{false, "package q\n", "n\n", false, true},
+	{true, "package q\n", "n\n", true, false}, // -n asks nothing, writeFile only reports
+	{true, "package p\n", "n\n", true, false},
+}
+
+// setConfirmState sets the state confirmWrite depends on.
+func setConfirmState(p *prompter, n bool) {
+	prompt, *dryRun = p, n
+}
+
+func TestConfirmWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "f.go")
+	if err := ioutil.WriteFile(filename, []byte("package p\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setConfirmState(prompt, *dryRun)
+	for _, test := range confirmWriteTests {
+		var out bytes.Buffer
+		setConfirmState(&prompter{in: bufio.NewReader(strings.NewReader(test.in)), out: &out}, test.dryRun)
+		ok, err := confirmWrite(filename, []byte(test.res))
+		if err != nil {
+			t.Fatalf("dryRun %v, %q: %v", test.dryRun, test.res, err)
+		}
+		if ok != test.ok {
+			t.Errorf("dryRun %v, %q, input %q: got %v; want %v", test.dryRun, test.res, test.in, ok, test.ok)
+		}
+		if asked := out.Len() > 0; asked != test.asked {
+			t.Errorf("dryRun %v, %q: asked %v; want %v", test.dryRun, test.res, asked, test.asked)
+		}
+	}
+}
+
+func TestConfirmWriteQuit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.igo", "b.igo"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("package p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := igoInit(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setConfirmState(prompt, *dryRun)
+	var out bytes.Buffer
+	setConfirmState(&prompter{in: bufio.NewReader(strings.NewReader("q\n")), out: &out}, false)
+	code := exitCode
+	igoWalkPath(dir)
+	if !quitting() {
+		t.Error("not quitting after q")
+	}
+	if exitCode != code {
+		t.Errorf("exit code %d after q; want %d", exitCode, code)
+	}
+	if n := strings.Count(out.String(), "write "); n != 1 {
+		t.Errorf("asked %d times; want 1, the walk stopping at q", n)
+	}
+	for _, name := range []string{"a.go", "b.go"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s written after q", name)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	goast "go/ast"
+	gotoken "go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/token"
+)
+
+var JSONMode = flag.Bool("json", false, "print the syntax tree of each source as a line of JSON instead of converting it, with report the counts as a JSON object")
+
+// JSON parses the files found in paths, as To would do, and writes the
+// syntax tree of each one to standard output as a line of JSON, instead
+// of converting it. The trees of iGo sources are written for GO, the
+// trees of Go ones for IGO.
+//
+// Each node is an object whose "Node" member is the name of its type in
+// the ast package (e.g., "FuncDecl"), and "Pos" and "End" its extent.
+// The other members are the fields of the node, in the order of the ast
+// package, with the same names: nodes, lists of nodes, strings, numbers,
+// booleans, tokens (written as in the source, e.g., "+=") and positions.
+// A position is an object with the "Offset", "Line" and "Column" of a
+// file, the File node giving its "Filename". Fields without value (nil
+// nodes, empty lists and unknown positions) are left out, and so are
+// the scopes and objects computed by the parser.
+func JSON(m Mode, paths []string) int {
+	flag.Parse()
+
+	if m == IGO {
+		goInitParserMode()
+	} else if err := igoInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
+	}
+
+	if len(paths) == 0 {
+		paths = append(paths, ".")
+	}
+
+	for _, path := range paths {
+		path = trimDots(path)
+		var err error
+		if m == IGO {
+			err = checkPath(path, goFile, goJSON)
+		} else {
+			err = checkPath(path, igoFile, igoJSON)
+		}
+		if err != nil {
+			if m == IGO {
+				goReport(err)
+			} else {
+				igoReport(err)
+			}
+			break
+		}
+	}
+
+	return exitCode
+}
+
+func igoJSON(filename string) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	file, _, err := igoParse(igoFileSet, filename, src)
+	if err != nil {
+		return err
+	}
+	resolve := func(pos int64) token.Position {
+		return igoFileSet.Position(token.Pos(pos))
+	}
+	e := jsonEncoder{
+		node:    reflect.TypeOf((*ast.Node)(nil)).Elem(),
+		pos:     reflect.TypeOf(token.NoPos),
+		tok:     reflect.TypeOf(token.ILLEGAL),
+		resolve: resolve,
+	}
+	return e.write(file)
+}
+
+func goJSON(filename string) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	file, _, err := goParse(goFileSet, filename, src)
+	if err != nil {
+		return err
+	}
+	resolve := func(pos int64) token.Position {
+		return token.Position(goFileSet.Position(gotoken.Pos(pos)))
+	}
+	e := jsonEncoder{
+		node:    reflect.TypeOf((*goast.Node)(nil)).Elem(),
+		pos:     reflect.TypeOf(gotoken.NoPos),
+		tok:     reflect.TypeOf(gotoken.ILLEGAL),
+		resolve: resolve,
+	}
+	return e.write(file)
+}
+
+// A jsonEncoder writes syntax trees as JSON, as JSON describes. The
+// trees are walked by reflection, so that the same encoder serves for
+// both languages given the types of their nodes, positions and tokens.
+type jsonEncoder struct {
+	buf     bytes.Buffer
+	node    reflect.Type // the Node interface
+	pos     reflect.Type // the Pos type
+	tok     reflect.Type // the Token type
+	resolve func(pos int64) token.Position
+}
+
+// write writes the tree of file to standard output.
+func (e *jsonEncoder) write(file interface{}) error {
+	e.value(reflect.ValueOf(file))
+	e.buf.WriteByte('\n')
+	_, err := os.Stdout.Write(e.buf.Bytes())
+	return err
+}
+
+// omitted reports whether the field f, of value v, is not written.
+func (e *jsonEncoder) omitted(f reflect.StructField, v reflect.Value) bool {
+	switch f.Name {
+	case "Scope", "Obj", "Imports", "Unresolved":
+		return true // computed by the parser
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice:
+		return v.Len() == 0
+	}
+	return v.Type() == e.pos && v.Int() == 0
+}
+
+// value writes v.
+func (e *jsonEncoder) value(v reflect.Value) {
+	switch {
+	case v.Kind() == reflect.Interface:
+		e.value(v.Elem())
+	case v.Type() == e.pos:
+		e.position(v.Int())
+	case v.Type() == e.tok:
+		e.literal(v.Interface().(fmt.Stringer).String())
+	case v.Kind() == reflect.Slice:
+		e.buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				e.buf.WriteByte(',')
+			}
+			e.value(v.Index(i))
+		}
+		e.buf.WriteByte(']')
+	case v.Type().Implements(e.node):
+		e.nodeValue(v)
+	default:
+		e.literal(v.Interface())
+	}
+}
+
+// nodeValue writes the node v.
+func (e *jsonEncoder) nodeValue(v reflect.Value) {
+	s := reflect.Indirect(v)
+	e.buf.WriteString(`{"Node":`)
+	e.literal(s.Type().Name())
+	if s.Type().Name() == "File" {
+		e.buf.WriteString(`,"Filename":`)
+		e.literal(filepath.ToSlash(e.resolve(v.MethodByName("Pos").Call(nil)[0].Int()).Filename))
+	}
+	for _, name := range []string{"Pos", "End"} {
+		e.buf.WriteString(`,"` + name + `":`)
+		e.position(v.MethodByName(name).Call(nil)[0].Int())
+	}
+	for i := 0; i < s.NumField(); i++ {
+		f, fv := s.Type().Field(i), s.Field(i)
+		if e.omitted(f, fv) {
+			continue
+		}
+		e.buf.WriteString(`,"` + f.Name + `":`)
+		e.value(fv)
+	}
+	e.buf.WriteByte('}')
+}
+
+// position writes the position pos.
+func (e *jsonEncoder) position(pos int64) {
+	p := e.resolve(pos)
+	e.buf.WriteString(`{"Offset":`)
+	e.literal(p.Offset)
+	e.buf.WriteString(`,"Line":`)
+	e.literal(p.Line)
+	e.buf.WriteString(`,"Column":`)
+	e.literal(p.Column)
+	e.buf.WriteByte('}')
+}
+
+// literal writes x, a string, number or boolean.
+func (e *jsonEncoder) literal(x interface{}) {
+	b, _ := json.Marshal(x)
+	e.buf.Write(b)
+}
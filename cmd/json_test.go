@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the tests")
+
+// TestJSON compares the tree printed for testdata/tree.igo, with comments,
+// positions and nil fields left out, with testdata/tree.json. Run the test
+// with -update to write the file again.
+func TestJSON(t *testing.T) {
+	const filename, golden = "testdata/tree.igo", "testdata/tree.json"
+	if err := igoInit(); err != nil {
+		t.Fatal(err)
+	}
+	stdout := captureOutput(t, &os.Stdout)
+	err := igoJSON(filename)
+	out := stdout.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(out), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/DAddYE/igo/scanner"
+	"github.com/DAddYE/igo/token"
+)
+
+var maxCol = flag.Int("max-col", 0, "report the longest line of each converted file wider than this many columns, tabs counting up to -tabwidth, also with -check")
+
+// longestLine returns the number and the width of the widest line of src,
+// and the column of its first character past max, if the width is over
+// max. Characters count one column in the width, tabs up to the next
+// multiple of tabwidth: those indenting a line count tabwidth each. The
+// column is a byte offset, as in all the positions of errors, which
+// editors expect, not a width: it is smaller after tabs or multibyte
+// runes.
+func longestLine(src []byte, tabwidth, max int) (line, width, col int) {
+	for i, text := range bytes.Split(src, []byte{'\n'}) {
+		w, past := 0, 0
+		for j := 0; j < len(text); {
+			r, size := utf8.DecodeRune(text[j:])
+			switch {
+			case r == '\t' && tabwidth > 0:
+				w += tabwidth - w%tabwidth
+			case r != '\r':
+				w++
+			}
+			if w > max && past == 0 {
+				past = j + 1
+			}
+			j += size
+		}
+		if w > width {
+			line, width, col = i+1, w, past
+		}
+	}
+	return
+}
+
+// checkMaxCol returns the widest line of src, the output written to
+// filename, as an error if it is wider than -max-col, if set.
+func checkMaxCol(filename string, src []byte) scanner.ErrorList {
+	if *maxCol <= 0 {
+		return nil
+	}
+	line, width, col := longestLine(src, *tabWidth, *maxCol)
+	if width <= *maxCol {
+		return nil
+	}
+	var errs scanner.ErrorList
+	errs.Add(token.Position{Filename: filename, Line: line, Column: col}, fmt.Sprintf("line of %d columns, wider than -max-col %d", width, *maxCol))
+	return errs
+}
+
+// checkOutput returns the problems of src, the output in the language m
+// written to filename, reported by -go and -max-col, at their positions
+// in src.
+func checkOutput(m Mode, filename string, src []byte) error {
+	var errs scanner.ErrorList
+	if m == GO {
+		switch err := checkGoVersion(filename, src).(type) {
+		case nil:
+		case scanner.ErrorList:
+			errs = append(errs, err...)
+		default:
+			return err
+		}
+	}
+	errs = append(errs, checkMaxCol(filename, src)...)
+
+	if len(errs) == 0 {
+		return nil
+	}
+	errs.Sort()
+	return errs
+}
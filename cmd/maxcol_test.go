@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var longestLineTests = []struct {
+	src              string
+	tabwidth, max    int
+	line, width, col int
+}{
+	{"abc\nabcdef\nab\n", 8, 4, 2, 6, 5},
+	{"abc\nabcdef\nab\n", 8, 6, 2, 6, 0}, // not over
+	// tabs indent tabwidth columns each, in one byte
+	{"\t\tx = 1\n", 8, 10, 1, 21, 2},
+	{"\t\tx = 1\n", 4, 10, 1, 13, 5},
+	{"\tx\ty\n", 8, 8, 1, 17, 2}, // to the next multiple
+	{"\tx\ty\n", 0, 8, 1, 4, 0},  // tabs count one column with no tabwidth
+	// runes count one column, in up to four bytes
+	{"é€𝄞é€𝄞\n", 8, 4, 1, 6, 12},
+	{"a\r\nbc\r\n", 8, 1, 2, 2, 2},
+}
+
+func TestLongestLine(t *testing.T) {
+	for _, test := range longestLineTests {
+		line, width, col := longestLine([]byte(test.src), test.tabwidth, test.max)
+		if line != test.line || width != test.width || col != test.col {
+			t.Errorf("%q, tabwidth %d, max %d: got %d, %d, %d; want %d, %d, %d",
+				test.src, test.tabwidth, test.max, line, width, col, test.line, test.width, test.col)
+		}
+	}
+}
+
+// setMaxCol sets -max-col.
+func setMaxCol(n int) {
+	*maxCol = n
+}
+
+// TestCheckMaxCol checks that -check reports the widest line of the Go
+// output, at its position in the output.
+func TestCheckMaxCol(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "f.igo")
+	src := "package p\n\nfunc f()\n\tif true\n\t\ts := \"é€\"\n\t\t_ = s\n"
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := igoInit(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setMaxCol(*maxCol)
+	defer setTabWidth(*tabWidth)
+	setTabWidth(8)
+	setMaxCol(20)
+	err = igoCheckFile(filename)
+	want := filepath.Join(dir, "f.go") + ":5:7: line of 25 columns, wider than -max-col 20"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v; want %s", err, want)
+	}
+
+	setMaxCol(25)
+	if err := igoCheckFile(filename); err != nil {
+		t.Errorf("-max-col 25: %v", err)
+	}
+	if _, err := os.Stat(strings.TrimSuffix(filename, ".igo") + ".go"); !os.IsNotExist(err) {
+		t.Error("f.go written by -check")
+	}
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	goast "go/ast"
+	gofmt "go/format"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	MergeMode = flag.Bool("merge", false, "convert the files given into a single one, written to standard output or to -o")
+	mergeOut  = flag.String("o", "", "with -merge or -doc, the file to write instead of standard output")
+)
+
+// Merge converts the files at paths, which must belong to the same
+// package, from the language opposite to m into a single source in m,
+// written to the -o file or to standard output. The result keeps the
+// package clause of the first file, with its documentation, the imports
+// of all the files, without duplicates, and the declarations of all the
+// files, in order.
+func Merge(m Mode, paths []string) int {
+	flag.Parse()
+
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "-merge requires the files to merge")
+		exitCode = 2
+		return exitCode
+	}
+
+	var (
+		res    []byte
+		err    error
+		report = igoReport
+	)
+	if m == IGO {
+		goInitParserMode()
+		goInitPrinterMode()
+		res, err = goMerge(paths)
+		report = goReport
+	} else {
+		if err := igoInit(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 2
+			return exitCode
+		}
+		res, err = igoMerge(paths)
+	}
+	if err == nil {
+		if *mergeOut == "" {
+			_, err = os.Stdout.Write(res)
+		} else {
+			err = writeFile(*mergeOut, res, 0644)
+		}
+	}
+	if err != nil {
+		report(err)
+	}
+	return exitCode
+}
+
+// igoMerge converts the iGo files at paths to Go one by one and merges
+// the results.
+func igoMerge(paths []string) ([]byte, error) {
+	srcs := make([][]byte, len(paths))
+	for i, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if srcs[i], _, err = igoTranslate(path, src, rewrites, nil); err != nil {
+			return nil, err
+		}
+	}
+	return mergeGo(paths, srcs)
+}
+
+// goMerge merges the Go files at paths and converts the result to iGo.
+func goMerge(paths []string) ([]byte, error) {
+	srcs := make([][]byte, len(paths))
+	for i, path := range paths {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		srcs[i] = stripBOM(src)
+	}
+	res, err := mergeGo(paths, srcs)
+	if err != nil {
+		return nil, err
+	}
+	return goTranslate(paths[0], res, nil)
+}
+
+// mergeGo merges the Go sources srcs, read from filenames, as Merge
+// describes. The sources are merged as text, then formatted as gofmt
+// would, which also sorts the imports.
+func mergeGo(filenames []string, srcs [][]byte) ([]byte, error) {
+	var (
+		pkg     string
+		header  []byte
+		imports [][]byte
+		bodies  [][]byte
+		seen    = make(map[string]bool)
+	)
+	fset := gotoken.NewFileSet()
+	for i, src := range srcs {
+		file, err := goparser.ParseFile(fset, filenames[i], src, goparser.ImportsOnly|goparser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		offset := func(pos gotoken.Pos) int { return fset.Position(pos).Offset }
+
+		if i == 0 {
+			pkg = file.Name.Name
+			header = src[:offset(file.Name.End())]
+		} else if file.Name.Name != pkg {
+			return nil, fmt.Errorf("%s: package %s, but %s is package %s", filenames[i], file.Name.Name, filenames[0], pkg)
+		}
+
+		start := offset(file.Name.End())
+		for _, decl := range file.Decls {
+			for _, spec := range decl.(*goast.GenDecl).Specs {
+				imp := spec.(*goast.ImportSpec)
+				from, to := offset(imp.Pos()), offset(imp.End())
+				if imp.Doc != nil {
+					from = offset(imp.Doc.Pos())
+				}
+				if imp.Comment != nil {
+					to = offset(imp.Comment.End())
+				}
+				key := importName(imp) + " " + imp.Path.Value
+				if !seen[key] {
+					seen[key] = true
+					imports = append(imports, src[from:to])
+				}
+				if to > start {
+					start = to
+				}
+			}
+			if end := offset(decl.End()); end > start {
+				start = end
+			}
+		}
+		bodies = append(bodies, src[start:])
+	}
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	if len(imports) > 0 {
+		buf.WriteString("\n\nimport (\n")
+		for _, imp := range imports {
+			buf.Write(imp)
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(")\n")
+	}
+	for _, body := range bodies {
+		buf.WriteByte('\n')
+		buf.Write(body)
+	}
+	return gofmt.Source(buf.Bytes())
+}
+
+// importName returns the name imp declares in the file: its explicit name
+// or else the one assumed from its path, as goimports does, e.g. yaml for
+// gopkg.in/yaml.v2 and pkg for example.com/go-pkg/v3.
+func importName(imp *goast.ImportSpec) string {
+	if imp.Name != nil {
+		return imp.Name.Name
+	}
+	p, err := strconv.Unquote(imp.Path.Value)
+	if err != nil {
+		return imp.Path.Value
+	}
+	base := path.Base(p)
+	if strings.HasPrefix(base, "v") && isDigits(base[1:]) && path.Dir(p) != "." {
+		base = path.Base(path.Dir(p))
+	}
+	base = strings.TrimPrefix(base, "go-")
+	if i := strings.IndexFunc(base, notIdentifier); i >= 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// isDigits reports whether s is a non-empty string of decimal digits.
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// notIdentifier reports whether r cannot be part of a Go identifier.
+func notIdentifier(r rune) bool {
+	return !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+}
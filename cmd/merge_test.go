@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	goparser "go/parser"
+	gotoken "go/token"
+	"strings"
+	"testing"
+)
+
+var importNameTests = []struct {
+	spec string
+	want string
+}{
+	{`"fmt"`, "fmt"},
+	{`"go/ast"`, "ast"},
+	{`goast "go/ast"`, "goast"},
+	{`. "strings"`, "."},
+	{`_ "embed"`, "_"},
+	{`"gopkg.in/yaml.v2"`, "yaml"},
+	{`"example.com/go-pkg/v3"`, "pkg"},
+	{`"v2"`, "v2"}, // a major version alone is the name
+}
+
+func TestImportName(t *testing.T) {
+	for _, test := range importNameTests {
+		src := "package p\n\nimport " + test.spec + "\n"
+		file, err := goparser.ParseFile(gotoken.NewFileSet(), "f.go", src, goparser.ImportsOnly)
+		if err != nil {
+			t.Fatalf("%s: %v", test.spec, err)
+		}
+		if got := importName(file.Imports[0]); got != test.want {
+			t.Errorf("%s: got %s; want %s", test.spec, got, test.want)
+		}
+	}
+}
+
+var mergeGoTests = []struct {
+	srcs []string
+	out  string
+	err  string // substring of the error, if any
+}{
+	// an import with the name it would have anyway is the same import
+	{[]string{
+		"package p\n\nimport \"fmt\"\n\nvar a = fmt.Sprint(1)\n",
+		"package p\n\nimport fmt \"fmt\"\n\nvar b = fmt.Sprint(2)\n",
+	}, "package p\n\nimport (\n\t\"fmt\"\n)\n\nvar a = fmt.Sprint(1)\n\nvar b = fmt.Sprint(2)\n", ""},
+	// under another name, it is another one
+	{[]string{
+		"package p\n\nimport \"fmt\"\n\nvar a = fmt.Sprint(1)\n",
+		"package p\n\nimport f \"fmt\"\n\nvar b = f.Sprint(2)\n",
+	}, "package p\n\nimport (\n\t\"fmt\"\n\tf \"fmt\"\n)\n\nvar a = fmt.Sprint(1)\n\nvar b = f.Sprint(2)\n", ""},
+	{[]string{
+		"package p\n\nimport yaml \"gopkg.in/yaml.v2\"\n\nvar a = yaml.Marshal\n",
+		"package p\n\nimport \"gopkg.in/yaml.v2\"\n\nvar b = yaml.Marshal\n",
+	}, "package p\n\nimport (\n\tyaml \"gopkg.in/yaml.v2\"\n)\n\nvar a = yaml.Marshal\n\nvar b = yaml.Marshal\n", ""},
+	// the comments are kept: the package documentation of the first file,
+	// those of the first occurrence of each import and of the bodies
+	{[]string{
+		"// Package p does.\npackage p\n\nimport (\n\t// for a\n\t\"fmt\" // fmt\n)\n\n// a is one.\nvar a = fmt.Sprint(1) // one\n",
+		"// Package p, again.\npackage p\n\nimport \"fmt\" // dropped\n\n// b is two.\nvar b = fmt.Sprint(2)\n",
+	}, "// Package p does.\npackage p\n\nimport (\n\t// for a\n\t\"fmt\" // fmt\n)\n\n// a is one.\nvar a = fmt.Sprint(1) // one\n\n// b is two.\nvar b = fmt.Sprint(2)\n", ""},
+	{[]string{"package p\n", "package q\n"}, "", "b.go: package q, but a.go is package p"},
+}
+
+func TestMergeGo(t *testing.T) {
+	for _, test := range mergeGoTests {
+		filenames := []string{"a.go", "b.go", "c.go"}[:len(test.srcs)]
+		srcs := make([][]byte, len(test.srcs))
+		for i, src := range test.srcs {
+			srcs[i] = []byte(src)
+		}
+		out, err := mergeGo(filenames, srcs)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got error %v; want %q", test.srcs, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.srcs, err)
+		} else if string(out) != test.out {
+			t.Errorf("%q:\ngot\n%s\nwant\n%s", test.srcs, out, test.out)
+		}
+	}
+}
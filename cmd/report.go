@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/DAddYE/igo/scanner"
+)
+
+// reportCounts are the numbers of sources of a language found by Report,
+// of those that convert cleanly and of those that fail.
+type reportCounts struct {
+	Files  int `json:"files"`
+	Clean  int `json:"clean"`
+	Failed int `json:"failed"`
+}
+
+// add counts a source, failing with err if not nil.
+func (c *reportCounts) add(err error) {
+	c.Files++
+	if err != nil {
+		c.Failed++
+		return
+	}
+	c.Clean++
+}
+
+// report is what Report prints, as a table or, with -json, as a JSON
+// object.
+type report struct {
+	Igo   reportCounts `json:"igo"`
+	Go    reportCounts `json:"go"`
+	Total reportCounts `json:"total"`
+}
+
+// Report checks the iGo and the Go files found in paths, as Check does
+// for each language, and prints to standard output how many there are of
+// each, how many convert cleanly and how many fail, as a table or, with
+// -json, as a JSON object. The errors of the failing files are printed to
+// standard error. Unlike Check, it goes on after an error, and the exit
+// code is non-zero only if a path cannot be walked.
+func Report(paths []string) int {
+	flag.Parse()
+
+	if err := igoInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
+	}
+	goInitParserMode()
+	goInitPrinterMode() // for -max-col
+
+	if len(paths) == 0 {
+		paths = append(paths, ".")
+	}
+
+	var r report
+	for _, path := range paths {
+		path = trimDots(path)
+		if err := reportPath(&r, path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 2
+			return exitCode
+		}
+	}
+	r.Total = reportCounts{
+		Files:  r.Igo.Files + r.Go.Files,
+		Clean:  r.Igo.Clean + r.Go.Clean,
+		Failed: r.Igo.Failed + r.Go.Failed,
+	}
+
+	if *JSONMode {
+		json.NewEncoder(os.Stdout).Encode(r) // Encode ends the object with a newline
+	} else {
+		r.print(os.Stdout)
+	}
+	return exitCode
+}
+
+// reportPath adds to r the sources at path or, if path is a directory,
+// below it.
+func reportPath(r *report, path string) error {
+	f, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !f.IsDir() {
+		reportFile(r, path, f)
+		return nil
+	}
+	return filepath.Walk(path, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ignored(path, f) {
+			return skip(f)
+		}
+		reportFile(r, path, f)
+		return nil
+	})
+}
+
+// reportFile adds to r the source at path, if any, printing its errors.
+func reportFile(r *report, path string, f os.FileInfo) {
+	var err error
+	switch {
+	case igoFile(f):
+		err = igoCheckFile(path)
+		r.Igo.add(err)
+	case goFile(f):
+		err = goCheckFile(path)
+		r.Go.add(err)
+	}
+	switch {
+	case err == nil:
+	case *errFormat == "json":
+		printErrorsJSON(err)
+	case *showCarets:
+		printErrorCarets(err)
+	default:
+		scanner.PrintError(os.Stderr, err)
+	}
+}
+
+func (r *report) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "language\tfiles\tclean\tfailed")
+	for _, row := range []struct {
+		name string
+		c    reportCounts
+	}{{"iGo", r.Igo}, {"Go", r.Go}, {"total", r.Total}} {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", row.name, row.c.Files, row.c.Clean, row.c.Failed)
+	}
+	tw.Flush()
+}
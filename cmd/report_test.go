@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// reportTree is a tree of sources, by path, counted by Report.
+var reportTree = map[string]string{
+	"a.igo":         "package p\n\nvar a = 1\n",
+	"b.igo":         "package p\n\nvar = 1\n",
+	"c.go":          "package p\n\nvar c = 1\n",
+	"d/e.go":        "package d\n\nvar e = 1\n",
+	"d/f.go":        "package d\n\nvar f = )\n",
+	"d/g.txt":       "not a source\n",
+	"d/.h.igo":      "package d\n\nvar = 1\n", // hidden
+	"d/i/j.igo":     "package i\n\nfunc j(): return\n",
+	"d/i/k.igo":     "package i\n\nfunc k(\n",
+	"d/i/l.igo":     "package i\n\nvar l = 1\n",
+	"d/i/m_test.go": "package i\n\nvar m = 1\n",
+}
+
+var reportTests = []struct {
+	json bool
+	out  string
+}{
+	{false, "language  files  clean  failed\n" +
+		"iGo       5      3      2\n" +
+		"Go        4      3      1\n" +
+		"total     9      6      3\n"},
+	{true, `{"igo":{"files":5,"clean":3,"failed":2},"go":{"files":4,"clean":3,"failed":1},"total":{"files":9,"clean":6,"failed":3}}` + "\n"},
+}
+
+// setJSONMode sets -json.
+func setJSONMode(json bool) {
+	*JSONMode = json
+}
+
+func TestReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range reportTree {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer setExitCode(exitCode)
+	defer setJSONMode(*JSONMode)
+	for _, test := range reportTests {
+		setExitCode(0)
+		setJSONMode(test.json)
+		stdout := captureOutput(t, &os.Stdout)
+		stderr := captureOutput(t, &os.Stderr)
+		code := Report([]string{dir})
+		errs := stderr.read()
+		out := stdout.read()
+		if code != 0 {
+			t.Errorf("-json %v: exit code %d; want 0", test.json, code)
+		}
+		if out != test.out {
+			t.Errorf("-json %v: got\n%s\nwant\n%s", test.json, out, test.out)
+		}
+		// the errors of the failing files, and only those
+		for _, name := range []string{"b.igo:3:5: ", "d/f.go:3:9: ", "d/i/k.igo:"} {
+			if !strings.Contains(errs, filepath.Join(dir, filepath.FromSlash(name))) {
+				t.Errorf("-json %v: no error of %s in\n%s", test.json, name, errs)
+			}
+		}
+		if strings.Contains(errs, ".h.igo") {
+			t.Errorf("-json %v: hidden file reported:\n%s", test.json, errs)
+		}
+	}
+
+	// a path that cannot be walked stops the report
+	stderr := captureOutput(t, &os.Stderr)
+	code := Report([]string{filepath.Join(dir, "missing")})
+	errs := stderr.read()
+	if code != 2 || !strings.Contains(errs, "missing") {
+		t.Errorf("missing path: exit code %d, %q; want 2 and its error", code, errs)
+	}
+}
@@ -0,0 +1,393 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/token"
+)
+
+var (
+	rewriteRule  = flag.String("r", "", "rewrite rule applied to iGo sources (e.g., 'a[b:len(a)] -> a[b:]')")
+	rewriteRules = flag.String("rewrite-file", "", "JSON file with a list of rewrite rules applied in order to iGo sources")
+	verbose      = flag.Bool("v", false, "verbose mode")
+
+	rewrites []*rewrite // rules given via -r and -rewrite-file, set up by igoInit
+)
+
+// A rewrite is a rule of the form 'pattern -> replacement'. Lower-case
+// single letter identifiers in the pattern are wildcards matching any
+// expression; Types optionally restricts the kind of AST node a wildcard
+// may match (e.g., {"x": "Ident"}).
+type rewrite struct {
+	Pattern     string            `json:"pattern"`
+	Replacement string            `json:"replacement"`
+	Types       map[string]string `json:"types,omitempty"`
+
+	pattern, replace ast.Expr
+	matches          int // number of times the rule fired
+}
+
+func (r *rewrite) String() string {
+	return r.Pattern + " -> " + r.Replacement
+}
+
+// initRewrite returns the rewrite rules given via -r and -rewrite-file,
+// in order of application: the rule given with -r comes first. The rules
+// are new at each call, their counts of matches starting at zero.
+func initRewrite() ([]*rewrite, error) {
+	var rules []*rewrite
+
+	if *rewriteRule != "" {
+		f := strings.Split(*rewriteRule, "->")
+		if len(f) != 2 {
+			return nil, errors.New("rewrite rule must be of the form 'pattern -> replacement'")
+		}
+		rules = append(rules, &rewrite{Pattern: f[0], Replacement: f[1]})
+	}
+
+	if *rewriteRules != "" {
+		data, err := ioutil.ReadFile(*rewriteRules)
+		if err == nil {
+			var list []*rewrite
+			if err = json.Unmarshal(data, &list); err == nil {
+				rules = append(rules, list...)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading rewrite rules %s: %s", *rewriteRules, err)
+		}
+	}
+
+	for _, r := range rules {
+		if err := r.parse(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// parseRules returns the rewrite rules of the form 'pattern -> replacement'
+// in list.
+func parseRules(list []string) ([]*rewrite, error) {
+	var rules []*rewrite
+	for _, rule := range list {
+		f := strings.Split(rule, "->")
+		if len(f) != 2 {
+			return nil, fmt.Errorf("rewrite rule %q must be of the form 'pattern -> replacement'", rule)
+		}
+		r := &rewrite{Pattern: f[0], Replacement: f[1]}
+		if err := r.parse(); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// reportRewrites reports, in verbose mode, the rules that never fired.
+func reportRewrites() {
+	if !*verbose {
+		return
+	}
+	for i, r := range rewrites {
+		if r.matches == 0 {
+			fmt.Fprintf(os.Stderr, "rewrite rule %d (%s) matched nothing\n", i+1, r)
+		}
+	}
+}
+
+// parse parses the pattern and the replacement of the rule.
+func (r *rewrite) parse() (err error) {
+	r.Pattern = strings.TrimSpace(r.Pattern)
+	r.Replacement = strings.TrimSpace(r.Replacement)
+	if r.pattern, err = parseExpr(r.Pattern, "pattern"); err == nil {
+		r.replace, err = parseExpr(r.Replacement, "replacement")
+	}
+	return
+}
+
+// parseExpr parses s as an expression.
+// It might make sense to expand this to allow statement patterns,
+// but there are problems with preserving formatting and also
+// with what a wildcard for a statement looks like.
+func parseExpr(s, what string) (ast.Expr, error) {
+	x, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s %s at %s", what, s, err)
+	}
+	return x, nil
+}
+
+// rewriteFile applies rules, in order, to an entire file, recording the
+// rules that fired in applied, if not nil.
+func rewriteFile(fset *token.FileSet, p *ast.File, rules []*rewrite, applied *transforms) *ast.File {
+	for i, r := range rules {
+		matches := r.matches
+		p = r.apply(fset, p)
+		if r.matches > matches {
+			applied.add("applied rewrite rule %d (%s)", i+1, r)
+		}
+	}
+	return p
+}
+
+// apply applies the rewrite rule 'pattern -> replace' to an entire file.
+func (r *rewrite) apply(fset *token.FileSet, p *ast.File) *ast.File {
+	cmap := ast.NewCommentMap(fset, p, p.Comments)
+	m := make(map[string]reflect.Value)
+	pat := reflect.ValueOf(r.pattern)
+	repl := reflect.ValueOf(r.replace)
+
+	var rewriteVal func(val reflect.Value) reflect.Value
+	rewriteVal = func(val reflect.Value) reflect.Value {
+		// don't bother if val is invalid to start with
+		if !val.IsValid() {
+			return reflect.Value{}
+		}
+		for k := range m {
+			delete(m, k)
+		}
+		val = apply(rewriteVal, val)
+		if r.match(m, pat, val) {
+			r.matches++
+			val = subst(m, repl, reflect.ValueOf(val.Interface().(ast.Node).Pos()))
+		}
+		return val
+	}
+
+	f := apply(rewriteVal, reflect.ValueOf(p)).Interface().(*ast.File)
+	f.Comments = cmap.Filter(f).Comments() // recreate comments list
+	return f
+}
+
+// set is a wrapper for x.Set(y); it protects the caller from panics if x cannot be changed to y.
+func set(x, y reflect.Value) {
+	// don't bother if x cannot be set or y is invalid
+	if !x.CanSet() || !y.IsValid() {
+		return
+	}
+	defer func() {
+		if x := recover(); x != nil {
+			if s, ok := x.(string); ok &&
+				(strings.Contains(s, "type mismatch") || strings.Contains(s, "not assignable")) {
+				// x cannot be set to y - ignore this rewrite
+				return
+			}
+			panic(x)
+		}
+	}()
+	x.Set(y)
+}
+
+// Values/types for special cases.
+var (
+	objectPtrNil = reflect.ValueOf((*ast.Object)(nil))
+	scopePtrNil  = reflect.ValueOf((*ast.Scope)(nil))
+
+	identType     = reflect.TypeOf((*ast.Ident)(nil))
+	objectPtrType = reflect.TypeOf((*ast.Object)(nil))
+	positionType  = reflect.TypeOf(token.NoPos)
+	scopePtrType  = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+// apply replaces each AST field x in val with f(x), returning val.
+// To avoid extra conversions, f operates on the reflect.Value form.
+func apply(f func(reflect.Value) reflect.Value, val reflect.Value) reflect.Value {
+	if !val.IsValid() {
+		return reflect.Value{}
+	}
+
+	// *ast.Objects introduce cycles and are likely incorrect after
+	// rewrite; don't follow them but replace with nil instead
+	if val.Type() == objectPtrType {
+		return objectPtrNil
+	}
+
+	// similarly for scopes: they are likely incorrect after a rewrite;
+	// replace them with nil
+	if val.Type() == scopePtrType {
+		return scopePtrNil
+	}
+
+	switch v := reflect.Indirect(val); v.Kind() {
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			e := v.Index(i)
+			set(e, f(e))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			e := v.Field(i)
+			set(e, f(e))
+		}
+	case reflect.Interface:
+		e := v.Elem()
+		set(v, f(e))
+	}
+	return val
+}
+
+func isWildcard(s string) bool {
+	rune, size := utf8.DecodeRuneInString(s)
+	return size == len(s) && unicode.IsLower(rune)
+}
+
+// accepts reports whether the wildcard name may match val, according
+// to the type constraints of the rule, if any.
+func (r *rewrite) accepts(name string, val reflect.Value) bool {
+	typ, ok := r.Types[name]
+	if !ok {
+		return true
+	}
+	return strings.TrimPrefix(typ, "*ast.") == reflect.Indirect(reflect.ValueOf(val.Interface())).Type().Name()
+}
+
+// match returns true if pattern matches val,
+// recording wildcard submatches in m.
+// If m == nil, match checks whether pattern == val.
+func (r *rewrite) match(m map[string]reflect.Value, pattern, val reflect.Value) bool {
+	// Wildcard matches any expression.  If it appears multiple
+	// times in the pattern, it must match the same expression
+	// each time.
+	if m != nil && pattern.IsValid() && pattern.Type() == identType {
+		name := pattern.Interface().(*ast.Ident).Name
+		if isWildcard(name) && val.IsValid() {
+			// wildcards only match valid (non-nil) expressions.
+			if _, ok := val.Interface().(ast.Expr); ok && !val.IsNil() && r.accepts(name, val) {
+				if old, ok := m[name]; ok {
+					return r.match(nil, old, val)
+				}
+				m[name] = val
+				return true
+			}
+		}
+	}
+
+	// Otherwise, pattern and val must match recursively.
+	if !pattern.IsValid() || !val.IsValid() {
+		return !pattern.IsValid() && !val.IsValid()
+	}
+	if pattern.Type() != val.Type() {
+		return false
+	}
+
+	// Special cases.
+	switch pattern.Type() {
+	case identType:
+		// For identifiers, only the names need to match
+		// (and none of the other *ast.Object information).
+		// This is a common case, handle it all here instead
+		// of recursing down any further via reflection.
+		p := pattern.Interface().(*ast.Ident)
+		v := val.Interface().(*ast.Ident)
+		return p == nil && v == nil || p != nil && v != nil && p.Name == v.Name
+	case objectPtrType, positionType:
+		// object pointers and token positions always match
+		return true
+	}
+
+	p := reflect.Indirect(pattern)
+	v := reflect.Indirect(val)
+	if !p.IsValid() || !v.IsValid() {
+		return !p.IsValid() && !v.IsValid()
+	}
+
+	switch p.Kind() {
+	case reflect.Slice:
+		if p.Len() != v.Len() {
+			return false
+		}
+		for i := 0; i < p.Len(); i++ {
+			if !r.match(m, p.Index(i), v.Index(i)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Struct:
+		for i := 0; i < p.NumField(); i++ {
+			if !r.match(m, p.Field(i), v.Field(i)) {
+				return false
+			}
+		}
+		return true
+
+	case reflect.Interface:
+		return r.match(m, p.Elem(), v.Elem())
+	}
+
+	// Handle token integers, etc.
+	return p.Interface() == v.Interface()
+}
+
+// subst returns a copy of pattern with values from m substituted in place
+// of wildcards and pos used as the position of tokens from the pattern.
+// if m == nil, subst returns a copy of pattern and doesn't change the line
+// number information.
+func subst(m map[string]reflect.Value, pattern reflect.Value, pos reflect.Value) reflect.Value {
+	if !pattern.IsValid() {
+		return reflect.Value{}
+	}
+
+	// Wildcard gets replaced with map value.
+	if m != nil && pattern.Type() == identType {
+		name := pattern.Interface().(*ast.Ident).Name
+		if isWildcard(name) {
+			if old, ok := m[name]; ok {
+				return subst(nil, old, reflect.Value{})
+			}
+		}
+	}
+
+	if pos.IsValid() && pattern.Type() == positionType {
+		// use new position only if old position was valid in the first place
+		if old := pattern.Interface().(token.Pos); !old.IsValid() {
+			return pattern
+		}
+		return pos
+	}
+
+	// Otherwise copy.
+	switch p := pattern; p.Kind() {
+	case reflect.Slice:
+		v := reflect.MakeSlice(p.Type(), p.Len(), p.Len())
+		for i := 0; i < p.Len(); i++ {
+			v.Index(i).Set(subst(m, p.Index(i), pos))
+		}
+		return v
+
+	case reflect.Struct:
+		v := reflect.New(p.Type()).Elem()
+		for i := 0; i < p.NumField(); i++ {
+			v.Field(i).Set(subst(m, p.Field(i), pos))
+		}
+		return v
+
+	case reflect.Ptr:
+		v := reflect.New(p.Type()).Elem()
+		if elem := p.Elem(); elem.IsValid() {
+			v.Set(subst(m, elem, pos).Addr())
+		}
+		return v
+
+	case reflect.Interface:
+		v := reflect.New(p.Type()).Elem()
+		if elem := p.Elem(); elem.IsValid() {
+			v.Set(subst(m, elem, pos))
+		}
+		return v
+	}
+
+	return pattern
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var rewriteFileTests = []struct {
+	rule    string // -r
+	rules   string // the content of -rewrite-file
+	src     string
+	out     string // substring of the output
+	matches []int  // of each rule
+}{
+	// rules apply in order, each to the result of the previous ones
+	{"", `[{"pattern": "foo(x)", "replacement": "bar(x)"}, {"pattern": "bar(x)", "replacement": "baz(x)"}]`,
+		"package p\n\nvar a = foo(1)\n", "var a = baz(1)", []int{1, 1}},
+	{"", `[{"pattern": "bar(x)", "replacement": "baz(x)"}, {"pattern": "foo(x)", "replacement": "bar(x)"}]`,
+		"package p\n\nvar a = foo(1)\n", "var a = bar(1)", []int{0, 1}},
+	// the rule of -r comes first
+	{"foo(x) -> bar(x)", `[{"pattern": "bar(x)", "replacement": "baz(x)"}]`,
+		"package p\n\nvar a = foo(1)\n", "var a = baz(1)", []int{1, 1}},
+	// types restrict what a wildcard matches
+	{"", `[{"pattern": "f(x)", "replacement": "g(x)", "types": {"x": "BasicLit"}}]`,
+		"package p\n\nvar a, b = f(1), f(c)\n", "var a, b = g(1), f(c)", []int{1}},
+	{"", `[{"pattern": "f(x)", "replacement": "g(x)", "types": {"x": "*ast.Ident"}}]`,
+		"package p\n\nvar a, b = f(1), f(c)\n", "var a, b = f(1), g(c)", []int{1}},
+	{"", `[{"pattern": "len(s) == 0", "replacement": "s == nil"}]`,
+		"package p\n\nvar a = 1\n", "var a = 1", []int{0}},
+}
+
+// setRewriteRules sets -r and -rewrite-file.
+func setRewriteRules(rule, file string) {
+	*rewriteRule, *rewriteRules = rule, file
+}
+
+func TestRewriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setRewriteRules(*rewriteRule, *rewriteRules)
+	igoInitMode()
+
+	file := filepath.Join(dir, "rules.json")
+	for _, test := range rewriteFileTests {
+		if err := ioutil.WriteFile(file, []byte(test.rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+		setRewriteRules(test.rule, file)
+		rules, err := initRewrite()
+		if err != nil {
+			t.Errorf("%s: %v", test.rules, err)
+			continue
+		}
+		out, _, err := igoTranslate("a.igo", []byte(test.src), rules, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.rules, err)
+			continue
+		}
+		if !strings.Contains(string(out), test.out) {
+			t.Errorf("%s: output\n%s\nwithout %q", test.rules, out, test.out)
+		}
+		matches := make([]int, len(rules))
+		for i, r := range rules {
+			matches[i] = r.matches
+		}
+		if !equalInts(matches, test.matches) {
+			t.Errorf("%s: matches %v; want %v", test.rules, matches, test.matches)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var rewriteErrorTests = []struct {
+	rule, rules, err string
+}{
+	{"foo", "", "must be of the form"},
+	{"", `[{"pattern": "foo(x)"`, "reading rewrite rules"},
+	{"", `[{"pattern": "foo(", "replacement": "x"}]`, "parsing pattern"},
+	{"", `[{"pattern": "foo(x)", "replacement": "(x"}]`, "parsing replacement"},
+}
+
+func TestRewriteErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer setRewriteRules(*rewriteRule, *rewriteRules)
+
+	for _, test := range rewriteErrorTests {
+		file := ""
+		if test.rules != "" {
+			file = filepath.Join(dir, "rules.json")
+			if err := ioutil.WriteFile(file, []byte(test.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		setRewriteRules(test.rule, file)
+		if _, err := initRewrite(); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q, %s: got error %v; want %q", test.rule, test.rules, err, test.err)
+		}
+	}
+
+	setRewriteRules("", filepath.Join(dir, "missing.json"))
+	if _, err := initRewrite(); err == nil {
+		t.Errorf("missing rules file accepted")
+	}
+}
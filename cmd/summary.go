@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+var summary = flag.Bool("summary", false, "write nothing, only print to standard error how many files would change")
+
+// counts accumulates the numbers printed by -summary.
+var counts summaryCounts
+
+// summaryCounts are the numbers of files processed, of those that would
+// change, of those with errors and the total sizes of the sources and
+// of their conversions.
+type summaryCounts struct {
+	files, changed, failed int
+	bytesIn, bytesOut      int
+}
+
+// add counts the conversion of src into res, which would be written to
+// dest.
+func (c *summaryCounts) add(dest string, src, res []byte) {
+	c.bytesIn += len(src)
+	c.bytesOut += len(res)
+	if old, err := ioutil.ReadFile(dest); err != nil || !bytes.Equal(old, res) {
+		c.changed++
+	}
+}
+
+func (c *summaryCounts) print(w io.Writer) {
+	fmt.Fprintf(w, "%d files processed, %d would change, %d with errors, %d bytes in, %d bytes out\n",
+		c.files, c.changed, c.failed, c.bytesIn, c.bytesOut)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// summaryTree is a tree of iGo sources and of the Go files already there
+// for some, by path, converted with -summary.
+var summaryTree = map[string]string{
+	"a.igo":   "package p\n\nvar a = 1\n",
+	"a.go":    "package p\n\nvar a = 1\n", // unchanged
+	"b.igo":   "package p\n\nfunc b(): return\n",
+	"c.igo":   "package p\n\nvar c = 1\n",
+	"c.go":    "package p\n\nvar c = 2\n", // stale
+	"d/e.igo": "package d\n\nvar = 1\n",   // fails
+}
+
+// setSummary sets -summary.
+func setSummary(s bool) {
+	*summary = s
+}
+
+func TestSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, src := range summaryTree {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer setDestDir(*DestDir)
+	defer setExitCode(exitCode)
+	defer setSummary(*summary)
+	setDestDir("") // the Go next to the iGo
+	setSummary(true)
+	stderr := captureOutput(t, &os.Stderr)
+	code := To(GO, []string{dir})
+	out := stderr.read()
+	if code != 2 {
+		t.Errorf("exit code %d; want 2", code)
+	}
+
+	// b.go is printed with braces, the others as they are
+	in := len(summaryTree["a.igo"]) + len(summaryTree["b.igo"]) + len(summaryTree["c.igo"])
+	res := len(summaryTree["a.go"]) + len("package p\n\nfunc b() { return }\n") + len("package p\n\nvar c = 1\n")
+	want := fmt.Sprintf("4 files processed, 2 would change, 1 with errors, %d bytes in, %d bytes out\n", in, res)
+	if !strings.HasSuffix(out, want) { // after the errors
+		t.Errorf("got %q; want %q", out, want)
+	}
+
+	// nothing is written
+	for _, name := range []string{"b.go", "d/e.go"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s written", name)
+		}
+	}
+	if got, err := ioutil.ReadFile(filepath.Join(dir, "c.go")); err != nil || string(got) != summaryTree["c.go"] {
+		t.Errorf("c.go is %q, %v; want it unchanged", got, err)
+	}
+}
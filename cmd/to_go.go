@@ -25,24 +25,51 @@ var (
 )
 
 func igoReport(err error) {
-	scanner.PrintError(os.Stderr, err)
+	counts.failed++
+	switch {
+	case *errFormat == "json":
+		printErrorsJSON(err)
+	case *showCarets:
+		printErrorCarets(err)
+	default:
+		scanner.PrintError(os.Stderr, err)
+	}
 	exitCode = 2
 }
 
-func igoInit() {
+// igoInit sets up the iGo parser and printer modes and the rewrite rules
+// from the flags. It returns an error if the rules are invalid.
+func igoInit() error {
+	igoInitMode()
+	var err error
+	rewrites, err = initRewrite()
+	return err
+}
+
+// igoInitMode sets up the iGo parser and printer modes from the flags.
+func igoInitMode() {
 	igoParserMode = parser.Mode(0)
 	if *comments {
 		igoParserMode |= parser.ParseComments
 	}
 	igoParserMode |= parser.AllErrors
+	// alignment is always done with spaces, -spaces only changes the
+	// indentation
 	igoPrinterMode = printer.UseSpaces
-	if *tabIndent {
+	if *tabIndent && !*useSpaces {
 		igoPrinterMode |= printer.TabIndent
 	}
+	if *wsOnly {
+		igoPrinterMode |= printer.SourceLines
+	}
 }
 
 func igoProcessFile(filename string, in io.Reader, out io.Writer) error {
-	dest := strings.TrimSuffix(filename, ".igo") + ".go"
+	counts.files++
+	dest, err := outPath(filename, destName(filename, *igoExt, *goExt))
+	if err != nil {
+		return err
+	}
 
 	f, err := os.Open(filename)
 	if err != nil {
@@ -55,47 +82,135 @@ func igoProcessFile(filename string, in io.Reader, out io.Writer) error {
 		return err
 	}
 
-	file, adjust, err := igoParse(igoFileSet, filename, src)
+	res, pos, err := igoTranslateCached(filename, src)
 	if err != nil {
 		return err
 	}
 
-	ast.SortImports(igoFileSet, file)
+	IgoPositions[filename] = pos
 
-	var buf bytes.Buffer
-	var pos *printer.Positions
-	pos, err = (&printer.Config{Mode: igoPrinterMode, Tabwidth: *tabWidth}).Fprint(&buf, igoFileSet, file)
+	// the Go is written anyway, the uses of features newer than -go
+	// and the lines wider than -max-col being reported at their
+	// positions in it
+	verr := checkOutput(GO, dest, res)
+
+	if *summary {
+		counts.add(dest, src, res)
+		return verr
+	}
+
+	if err := createDir(dest); err != nil {
+		return err
+	}
+
+	if ok, err := confirmWrite(dest, res); !ok {
+		return err
+	}
+
+	err = writeFile(dest, res, 0644)
 	if err != nil {
 		return err
 	}
 
-	IgoPositions[filename] = pos
+	return verr
+}
+
+// igoTranslate converts src, which was read from filename, from iGo to Go,
+// applying the rewrite rules and recording the transformations applied in
+// applied, if not nil.
+func igoTranslate(filename string, src []byte, rules []*rewrite, applied *transforms) ([]byte, *printer.Positions, error) {
+	if *allowEmpty && isBlank(src) {
+		return []byte{}, nil, nil
+	}
+	file, adjust, err := igoParse(igoFileSet, filename, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return igoPrint(igoFileSet, file, src, adjust, rules, applied)
+}
+
+// igoPrint rewrites file, parsed from src by igoParse together with
+// adjust, with rules and prints it as Go.
+func igoPrint(fset *token.FileSet, file *ast.File, src []byte, adjust func(orig, src []byte) []byte, rules []*rewrite, applied *transforms) ([]byte, *printer.Positions, error) {
+	file = rewriteFile(fset, file, rules, applied)
+
+	imports := igoImports(file)
+	ast.SortImports(fset, file)
+	if !equalStrings(imports, igoImports(file)) {
+		applied.add("sorted imports")
+	}
+
+	cfg := printer.Config{Mode: igoPrinterMode, Tabwidth: *tabWidth, Simplify: *simplifyAST}
+	cfg.PreserveBlankLines, cfg.MaxBlankLines = *blankLines > 0, *blankLines
+	if *genHeader && adjust == nil {
+		// fragments are not files of their own
+		cfg.GeneratedHeader = printer.DefaultGeneratedHeader
+	}
+	var buf bytes.Buffer
+	pos, err := cfg.Fprint(&buf, fset, file)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	res := buf.Bytes()
 	if adjust != nil {
 		res = adjust(src, res)
 	}
+	return res, pos, nil
+}
 
-	createDir(filepath.Join(*DestDir, dest))
+// igoImports returns the paths of the imports of file, in order.
+func igoImports(file *ast.File) (paths []string) {
+	for _, decl := range file.Decls {
+		if d, ok := decl.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			for _, spec := range d.Specs {
+				paths = append(paths, spec.(*ast.ImportSpec).Path.Value)
+			}
+		}
+	}
+	return
+}
 
-	err = ioutil.WriteFile(dest, res, 0644)
+// igoCheckFile parses filename reporting any syntax error. With -max-col,
+// filename is converted and a line of the Go too wide is reported too.
+func igoCheckFile(filename string) error {
+	src, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-
+	if *allowEmpty && isBlank(src) {
+		return nil
+	}
+	if *maxCol > 0 {
+		res, _, err := igoTranslate(filename, src, rewrites, nil)
+		if err != nil {
+			return err
+		}
+		if errs := checkMaxCol(destName(filename, *igoExt, *goExt), res); errs != nil {
+			return errs
+		}
+		return nil
+	}
+	_, _, err = igoParse(igoFileSet, filename, src)
 	return err
 }
 
 func igoFile(f os.FileInfo) bool {
 	// ignore non-iGo files
 	name := f.Name()
-	return !f.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".igo")
+	return !f.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, *igoExt)
 }
 
 func igoVisitFile(path string, f os.FileInfo, err error) error {
+	if err == nil && ignored(path, f) {
+		return skip(f)
+	}
 	if err == nil && igoFile(f) {
 		err = igoProcessFile(path, nil, os.Stdout)
 	}
+	if err == errQuit {
+		return err // stops the walk
+	}
 	if err != nil {
 		igoReport(err)
 	}
@@ -110,7 +225,7 @@ func igoWalkPath(path string) {
 		filepath.Walk(path, igoVisitFile)
 	default:
 		err := igoProcessFile(path, nil, os.Stdout)
-		if err != nil {
+		if err != nil && err != errQuit {
 			igoReport(err)
 		}
 	}
@@ -119,15 +234,31 @@ func igoWalkPath(path string) {
 // parse parses src, which was read from filename,
 // as a Go source file or statement list.
 func igoParse(fset *token.FileSet, filename string, src []byte) (*ast.File, func(orig, src []byte) []byte, error) {
-	// Try as whole source file.
-	file, err := parser.ParseFile(fset, filename, src, igoParserMode)
+	if err := checkUTF8(filename, src); err != nil {
+		return nil, nil, err
+	}
+
+	// A byte order mark is only permitted at the very beginning:
+	// remove it, or it would end up after the package clause below.
+	src = stripBOM(src)
+
+	// Try as whole source file. The missing package clause of an empty
+	// source is reported alone, not with the errors on the same line
+	// following from it, which would sort before it.
+	mode := igoParserMode
+	if isBlank(src) {
+		mode &^= parser.AllErrors
+	}
+	file, err := parser.ParseFile(fset, filename, src, mode)
+	err = ignoreBadUTF8(err)
 	if err == nil {
 		return file, nil, nil
 	}
 	// If the error is that the source file didn't begin with a
 	// package line and this is standard input, fall through to
 	// try as a source fragment.  Stop and return on any other error.
-	if !strings.Contains(err.Error(), "expected 'package'") {
+	// An empty source is no fragment either.
+	if !strings.Contains(err.Error(), "expected 'package'") || isBlank(src) {
 		return nil, nil, err
 	}
 
@@ -137,6 +268,7 @@ func igoParse(fset *token.FileSet, filename string, src []byte) (*ast.File, func
 	// in psrc match the ones in src.
 	psrc := append([]byte("package p;"), src...)
 	file, err = parser.ParseFile(fset, filename, psrc, igoParserMode)
+	err = ignoreBadUTF8(err)
 	if err == nil {
 		adjust := func(orig, src []byte) []byte {
 			// Remove the package clause.
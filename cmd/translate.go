@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"fmt"
+	goast "go/ast"
+	gotoken "go/token"
+	"sort"
+	"strings"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/token"
+)
+
+// Options controls a translation made through the cmd API. Anything not
+// covered here follows the command line flags, or their defaults.
+type Options struct {
+	// Rewrite rules of the form 'pattern -> replacement' applied, in
+	// order, to iGo sources in place of those given via -r and
+	// -rewrite-file.
+	Rewrite []string
+
+	// IgoTransform, if not nil, is called by TranslateAll with all the
+	// iGo sources, parsed into the same file set and keyed by filename,
+	// before any of them is printed. It may change the trees freely (e.g.,
+	// to rename an identifier consistently across files) but must not add
+	// or remove files.
+	IgoTransform func(fset *token.FileSet, files map[string]*ast.File)
+
+	// GoTransform is like IgoTransform, for Go sources translated to iGo.
+	GoTransform func(fset *gotoken.FileSet, files map[string]*goast.File)
+
+	// AllowEmpty, if set, converts the sources containing nothing but
+	// white space to empty outputs, as -allow-empty does, instead of
+	// failing with a missing package clause.
+	AllowEmpty bool
+}
+
+// rules returns the rewrite rules of opts or, if it has none, those given
+// via -r and -rewrite-file, set up anew so that translations do not share
+// them.
+func (opts *Options) rules() ([]*rewrite, error) {
+	if opts != nil && opts.Rewrite != nil {
+		return parseRules(opts.Rewrite)
+	}
+	return initRewrite()
+}
+
+// transforms records, in order, a description of the transformations
+// applied to a source. A nil *transforms records nothing.
+type transforms []string
+
+func (t *transforms) add(format string, args ...interface{}) {
+	if t != nil {
+		*t = append(*t, fmt.Sprintf(format, args...))
+	}
+}
+
+// TranslateVerbose converts src, which was read from filename, from the
+// language opposite to m into m, as To would do, and returns the result
+// along with a description of the transformations that actually fired
+// (e.g., "sorted imports").
+func TranslateVerbose(m Mode, src []byte, filename string, opts *Options) (out []byte, applied []string, err error) {
+	igoInitMode()
+	goInitParserMode()
+	goInitPrinterMode()
+
+	rules, err := opts.rules()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts != nil && opts.AllowEmpty && isBlank(src) {
+		return []byte{}, nil, nil
+	}
+
+	var t transforms
+	if m == GO {
+		out, _, err = igoTranslate(filename, src, rules, &t)
+	} else {
+		out, err = goTranslate(filename, src, &t)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, t, nil
+}
+
+// TranslateAll converts sources, keyed by filename, from the language
+// opposite to m into m, as To would do, and returns the results keyed by
+// filename. Unlike TranslateVerbose, all the sources are parsed into one
+// file set before anything is printed, so that the IgoTransform or
+// GoTransform hook of opts can see the whole package. If any source
+// cannot be translated, the result is nil and the error is a FileErrors.
+func TranslateAll(m Mode, sources map[string][]byte, opts *Options) (map[string][]byte, error) {
+	igoInitMode()
+	goInitParserMode()
+	goInitPrinterMode()
+
+	rules, err := opts.rules()
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = new(Options)
+	}
+
+	// parse in a fixed order, positions must not depend on map iteration
+	names := make([]string, 0, len(sources))
+	var empty []string
+	for name, src := range sources {
+		if (opts.AllowEmpty || *allowEmpty) && isBlank(src) {
+			empty = append(empty, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out map[string][]byte
+	if m == GO {
+		out, err = igoTranslateAll(names, sources, rules, opts.IgoTransform)
+	} else {
+		out, err = goTranslateAll(names, sources, opts.GoTransform)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range empty {
+		out[name] = []byte{}
+	}
+	return out, nil
+}
+
+func igoTranslateAll(names []string, sources map[string][]byte, rules []*rewrite, transform func(*token.FileSet, map[string]*ast.File)) (map[string][]byte, error) {
+	fset := token.NewFileSet()
+	files := make(map[string]*ast.File)
+	adjust := make(map[string]func(orig, src []byte) []byte)
+	errs := make(FileErrors)
+	for _, name := range names {
+		file, adj, err := igoParse(fset, name, sources[name])
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		files[name], adjust[name] = file, adj
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	if transform != nil {
+		transform(fset, files)
+	}
+
+	out := make(map[string][]byte)
+	for _, name := range names {
+		res, _, err := igoPrint(fset, files[name], sources[name], adjust[name], rules, nil)
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		out[name] = res
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return out, nil
+}
+
+func goTranslateAll(names []string, sources map[string][]byte, transform func(*gotoken.FileSet, map[string]*goast.File)) (map[string][]byte, error) {
+	fset := gotoken.NewFileSet()
+	files := make(map[string]*goast.File)
+	adjust := make(map[string]func(orig, src []byte) []byte)
+	errs := make(FileErrors)
+	for _, name := range names {
+		file, adj, err := goParse(fset, name, sources[name])
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		files[name], adjust[name] = file, adj
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	if transform != nil {
+		transform(fset, files)
+	}
+
+	out := make(map[string][]byte)
+	for _, name := range names {
+		res, err := goPrint(fset, files[name], sources[name], adjust[name], nil)
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		out[name] = res
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return out, nil
+}
+
+// FileErrors is the error returned by TranslateAll: the errors met while
+// translating each source, keyed by filename.
+type FileErrors map[string]error
+
+func (e FileErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = e[name].Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,374 @@
+package cmd
+
+import (
+	goast "go/ast"
+	gotoken "go/token"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/token"
+)
+
+var translateVerboseTests = []struct {
+	m       Mode
+	src     string
+	rewrite []string
+	applied []string
+	out     string // substring of the output
+	err     string // substring of the error, if any
+}{
+	{GO, "package p\n\nvar a int\n", nil, nil, "var a int", ""},
+	{GO, "package p\n\nimport\n\t\"os\"\n\t\"fmt\"\n\nvar _ = fmt.Sprint(os.Args)\n", nil,
+		[]string{"sorted imports"}, "\"fmt\"\n\t\"os\"", ""},
+	{GO, "package p\n\nvar a = foo(1)\n", []string{"foo(x) -> bar(x)"},
+		[]string{"applied rewrite rule 1 (foo(x) -> bar(x))"}, "var a = bar(1)", ""},
+	{GO, "package p\n\nvar a = foo(1)\n", []string{"baz(x) -> bar(x)", "foo(x) -> bar(x)"},
+		[]string{"applied rewrite rule 2 (foo(x) -> bar(x))"}, "var a = bar(1)", ""},
+	{GO, "package p\n\nvar a = foo(1)\n", []string{"baz(x) -> bar(x)"}, nil, "var a = foo(1)", ""},
+	{GO, "package p\n", []string{"foo(x)"}, nil, "", "must be of the form"},
+	{GO, "package p\n", []string{"foo( -> bar"}, nil, "", "parsing pattern"},
+	{IGO, "package p\n\nimport (\n\t\"os\"\n\t\"fmt\"\n)\n\nvar _ = fmt.Sprint(os.Args)\n", nil,
+		[]string{"sorted imports"}, "\"fmt\"\n\t\"os\"", ""},
+	{IGO, "package p\n\nvar a = foo(1)\n", []string{"foo(x) -> bar(x)"}, nil, "var a = foo(1)", ""}, // iGo is never rewritten
+}
+
+func TestTranslateVerbose(t *testing.T) {
+	for _, test := range translateVerboseTests {
+		out, applied, err := TranslateVerbose(test.m, []byte(test.src), "f", &Options{Rewrite: test.rewrite})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q, %q: got error %v; want %q", test.src, test.rewrite, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q, %q: %v", test.src, test.rewrite, err)
+			continue
+		}
+		if !equalStrings(applied, test.applied) {
+			t.Errorf("%q, %q: applied %q; want %q", test.src, test.rewrite, applied, test.applied)
+		}
+		if !strings.Contains(string(out), test.out) {
+			t.Errorf("%q, %q: output\n%s\nwithout %q", test.src, test.rewrite, out, test.out)
+		}
+	}
+	if rewrites != nil {
+		t.Errorf("the rules of the calls were left in rewrites: %v", rewrites)
+	}
+}
+
+// setRewriteRule sets -r.
+func setRewriteRule(rule string) {
+	*rewriteRule = rule
+}
+
+// TestTranslateRewriteFlag checks that the rules given via -r are used
+// without Options.Rewrite, and that an invalid one is returned as an
+// error instead of exiting.
+func TestTranslateRewriteFlag(t *testing.T) {
+	defer setRewriteRule(*rewriteRule)
+	src := []byte("package p\n\nvar a = foo(1)\n")
+
+	setRewriteRule("foo(x) -> bar(x)")
+	for i := 0; i < 2; i++ { // the rules are new at each call
+		_, applied, err := TranslateVerbose(GO, src, "f", nil)
+		if err != nil || len(applied) != 1 {
+			t.Errorf("call %d: got %q, %v; want one rule applied", i, applied, err)
+		}
+	}
+	out, err := TranslateAll(GO, map[string][]byte{"f": src}, nil)
+	if err != nil || !strings.Contains(string(out["f"]), "bar(1)") {
+		t.Errorf("TranslateAll: got %q, %v; want foo rewritten", out["f"], err)
+	}
+	// rules in the options replace those of the flags
+	if _, applied, err := TranslateVerbose(GO, src, "f", &Options{Rewrite: []string{}}); err != nil || applied != nil {
+		t.Errorf("empty Rewrite: got %q, %v; want no rule applied", applied, err)
+	}
+
+	setRewriteRule("foo(x)")
+	if _, _, err := TranslateVerbose(GO, src, "f", nil); err == nil {
+		t.Errorf("TranslateVerbose: invalid -r accepted")
+	}
+	if _, err := TranslateAll(GO, map[string][]byte{"f": src}, nil); err == nil {
+		t.Errorf("TranslateAll: invalid -r accepted")
+	}
+	if rewrites != nil {
+		t.Errorf("the rules of the calls were left in rewrites: %v", rewrites)
+	}
+}
+
+// renameIgo renames the identifiers old to new in all the iGo files.
+func renameIgo(fset *token.FileSet, files map[string]*ast.File) {
+	for _, file := range files {
+		ast.Walk(renamer{"Old", "New"}, file)
+	}
+}
+
+// A renamer renames the identifiers from to to.
+type renamer struct {
+	from, to string
+}
+
+func (r renamer) Visit(n ast.Node) ast.Visitor {
+	if id, ok := n.(*ast.Ident); ok && id.Name == r.from {
+		id.Name = r.to
+	}
+	return r
+}
+
+// renameGo is renameIgo for Go files.
+func renameGo(fset *gotoken.FileSet, files map[string]*goast.File) {
+	for _, file := range files {
+		goast.Walk(goRenamer{"Old", "New"}, file)
+	}
+}
+
+// goRenamer is renamer for Go files.
+type goRenamer struct {
+	from, to string
+}
+
+func (r goRenamer) Visit(n goast.Node) goast.Visitor {
+	if id, ok := n.(*goast.Ident); ok && id.Name == r.from {
+		id.Name = r.to
+	}
+	return r
+}
+
+var translateAllTests = []struct {
+	m       Mode
+	sources map[string]string
+	opts    *Options
+	out     map[string]string
+	errs    []string // the files that fail
+}{
+	// a type declared in one file and used in the other, renamed in both
+	{GO, map[string]string{
+		"a.igo": "package p\n\ntype Old int\n",
+		"b.igo": "package p\n\nfunc f(x Old) Old: return x\n",
+	}, &Options{IgoTransform: renameIgo}, map[string]string{
+		"a.igo": "package p\n\ntype New int\n",
+		"b.igo": "package p\n\nfunc f(x New) New { return x }\n",
+	}, nil},
+	{IGO, map[string]string{
+		"a.go": "package p\n\ntype Old int\n",
+		"b.go": "package p\n\nfunc f(x Old) Old { return x }\n",
+	}, &Options{GoTransform: renameGo}, map[string]string{
+		"a.go": "package p\n\ntype New int\n",
+		"b.go": "package p\n\nfunc f(x New) New: return x\n",
+	}, nil},
+	{GO, map[string]string{
+		"a.igo": "package p\n\ntype Old int\n",
+		"b.igo": "",
+	}, &Options{AllowEmpty: true}, map[string]string{
+		"a.igo": "package p\n\ntype Old int\n",
+		"b.igo": "",
+	}, nil},
+	// the errors are collected per file
+	{GO, map[string]string{
+		"a.igo": "package p\n\ntype Old\n",
+		"b.igo": "package p\n\nvar = 1\n",
+		"c.igo": "package p\n",
+	}, nil, nil, []string{"a.igo", "b.igo"}},
+}
+
+func TestTranslateAll(t *testing.T) {
+	for i, test := range translateAllTests {
+		sources := make(map[string][]byte)
+		for name, src := range test.sources {
+			sources[name] = []byte(src)
+		}
+		out, err := TranslateAll(test.m, sources, test.opts)
+		if test.errs != nil {
+			errs, ok := err.(FileErrors)
+			if !ok {
+				t.Errorf("%d: got error %v; want FileErrors", i, err)
+				continue
+			}
+			var names []string
+			for name := range errs {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !equalStrings(names, test.errs) || out != nil {
+				t.Errorf("%d: got errors in %v and %d results; want errors in %v and none", i, names, len(out), test.errs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		if len(out) != len(test.out) {
+			t.Errorf("%d: got %d results; want %d", i, len(out), len(test.out))
+		}
+		for name, want := range test.out {
+			if got := string(out[name]); got != want {
+				t.Errorf("%d: %s:\n%s\nwant\n%s", i, name, got, want)
+			}
+		}
+	}
+}
+
+// opt is Options.AllowEmpty and flag -allow-empty.
+var emptyTests = []struct {
+	m         Mode
+	src       string
+	opt, flag bool
+	err       string // substring of the error, if any
+}{
+	{GO, "", false, false, "expected 'package'"},
+	{GO, " \n\t\n", false, false, "expected 'package'"},
+	{IGO, "", false, false, "expected 'package'"},
+	{IGO, "\n\n", false, false, "expected 'package'"},
+	{GO, "", true, false, ""},
+	{GO, " \n\t\n", true, false, ""},
+	{IGO, "\ufeff\n", true, false, ""},
+	{GO, "\n", false, true, ""},
+	{IGO, "\t\n", false, true, ""},
+	// only the sources without anything else
+	{GO, "# c\n", true, true, "expected declaration"},
+}
+
+// setAllowEmpty sets -allow-empty.
+func setAllowEmpty(allow bool) {
+	*allowEmpty = allow
+}
+
+func TestTranslateEmpty(t *testing.T) {
+	defer setAllowEmpty(*allowEmpty)
+	for _, test := range emptyTests {
+		setAllowEmpty(test.flag)
+		out, _, err := TranslateVerbose(test.m, []byte(test.src), "f", &Options{AllowEmpty: test.opt})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q, %v, %v: got error %v; want %q", test.src, test.opt, test.flag, err, test.err)
+			}
+			continue
+		}
+		if err != nil || len(out) != 0 {
+			t.Errorf("%q, %v, %v: got %q, %v; want an empty output", test.src, test.opt, test.flag, out, err)
+		}
+	}
+}
+
+var utf8Tests = []struct {
+	m     Mode
+	src   string
+	allow bool   // -allow-invalid-utf8
+	out   string // substring of the output
+	err   string // substring of the error, if any
+}{
+	{GO, "package p\n\nvar s = `\x80`\n", false, "", "f: invalid UTF-8 at byte 20"},
+	{IGO, "package p\n\nvar s = `a\xc3`\n", false, "", "f: invalid UTF-8 at byte 21"},
+	{IGO, "\ufeffpackage p\n\nvar s = \"\xe2\x82\"\n", false, "", "f: invalid UTF-8 at byte 23"},
+	{GO, "package p\n\nvar s = `\x80`\n", true, "var s = `\x80`", ""},
+	{IGO, "package p\n\nvar s = `a\xc3`\n", true, "var s = `a\xc3`", ""},
+	// the other errors are still reported
+	{GO, "package p\n\nvar s = `\x80`(\n", true, "", "expected"},
+	// the printers escape with 0xff
+	{GO, "package p\n\nvar s = `\xff`\n", true, "", "byte 0xff at byte 20"},
+}
+
+// setAllowUTF8 sets -allow-invalid-utf8.
+func setAllowUTF8(allow bool) {
+	*allowUTF8 = allow
+}
+
+func TestTranslateInvalidUTF8(t *testing.T) {
+	defer setAllowUTF8(*allowUTF8)
+	for _, test := range utf8Tests {
+		setAllowUTF8(test.allow)
+		out, _, err := TranslateVerbose(test.m, []byte(test.src), "f", nil)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q, %v: got error %v; want %q", test.src, test.allow, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q, %v: %v", test.src, test.allow, err)
+			continue
+		}
+		if !strings.Contains(string(out), test.out) {
+			t.Errorf("%q, %v: output %q without %q", test.src, test.allow, out, test.out)
+		}
+	}
+}
+
+// setGenHeader sets -header.
+func setGenHeader(header bool) {
+	*genHeader = header
+}
+
+var genHeaderTests = []struct {
+	header   bool
+	src, out string
+}{
+	{false, "package p\n\nvar a int\n", "package p\n\nvar a int\n"},
+	{true, "package p\n\nvar a int\n", "// Code generated by igo; DO NOT EDIT.\n\npackage p\n\nvar a int\n"},
+	// a fragment is no file of its own
+	{true, "var a int\n", "var a int\n"},
+}
+
+func TestTranslateGenHeader(t *testing.T) {
+	defer setGenHeader(*genHeader)
+	for _, test := range genHeaderTests {
+		setGenHeader(test.header)
+		out, _, err := TranslateVerbose(GO, []byte(test.src), "f", nil)
+		if err != nil || string(out) != test.out {
+			t.Errorf("-header=%v, %q: got %q, %v; want %q", test.header, test.src, out, err, test.out)
+		}
+	}
+}
+
+// setBlankLines sets -blank-lines.
+func setBlankLines(n int) {
+	*blankLines = n
+}
+
+var blankLinesTests = []struct {
+	mode     Mode
+	n        int
+	src, out string
+}{
+	{GO, 0, "package p\n\n\n\nvar a int\n", "package p\n\nvar a int\n"},
+	{GO, 2, "package p\n\n\n\nvar a int\n", "package p\n\n\nvar a int\n"},
+	{GO, 5, "package p\n\n\n\nvar a int\n", "package p\n\n\n\nvar a int\n"},
+	{IGO, 0, "package p\n\n\n\nvar a int\n", "package p\n\nvar a int\n"},
+	{IGO, 5, "package p\n\n\n\nvar a int\n", "package p\n\n\n\nvar a int\n"},
+}
+
+func TestTranslateBlankLines(t *testing.T) {
+	defer setBlankLines(*blankLines)
+	for _, test := range blankLinesTests {
+		setBlankLines(test.n)
+		out, _, err := TranslateVerbose(test.mode, []byte(test.src), "f", nil)
+		if err != nil || string(out) != test.out {
+			t.Errorf("%v, -blank-lines=%d, %q: got %q, %v; want %q", test.mode, test.n, test.src, out, err, test.out)
+		}
+	}
+}
+
+// setSimplify sets -s.
+func setSimplify(simplify bool) {
+	*simplifyAST = simplify
+}
+
+func TestTranslateSimplify(t *testing.T) {
+	defer setSimplify(*simplifyAST)
+	src := []byte("package p\n\nvar a = []T{T{1}}\n")
+	for _, simplify := range []bool{false, true} {
+		setSimplify(simplify)
+		want := "package p\n\nvar a = []T{T{1}}\n"
+		if simplify {
+			want = "package p\n\nvar a = []T{{1}}\n"
+		}
+		out, _, err := TranslateVerbose(GO, src, "f", nil)
+		if err != nil || string(out) != want {
+			t.Errorf("-s=%v: got %q, %v; want %q", simplify, out, err, want)
+		}
+	}
+}
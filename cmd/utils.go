@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"unicode/utf8"
 )
 
 type Mode int
@@ -17,10 +21,29 @@ const (
 
 var (
 	// layout control
-	comments  = flag.Bool("comments", true, "print comments")
-	tabWidth  = flag.Int("tabwidth", 8, "tab width")
-	tabIndent = flag.Bool("tabs", true, "indent with tabs")
-	DestDir   = flag.String("dest", "./", "destination directory")
+	comments    = flag.Bool("comments", true, "print comments")
+	tabWidth    = flag.Int("tabwidth", 8, "tab width")
+	tabIndent   = flag.Bool("tabs", true, "indent with tabs")
+	useSpaces   = flag.Bool("spaces", false, "indent with spaces, tabwidth of them per level, instead of tabs")
+	wsOnly      = flag.Bool("whitespace-only", false, "keep line breaks from the source, only normalize indentation and blank lines")
+	blankLines  = flag.Int("blank-lines", 0, "keep up to this many blank lines in a row where the source has them, instead of at most one")
+	keepBOM     = flag.Bool("preserve-bom", false, "keep the byte order mark of Go sources in the iGo output of parse")
+	genHeader   = flag.Bool("header", false, "start the Go files written by compile with a // Code generated by igo; DO NOT EDIT. line")
+	simplifyAST = flag.Bool("s", false, "simplify the Go code written by compile, as gofmt -s")
+	DestDir     = flag.String("dest", "./", "destination directory")
+	outDir      = flag.String("outdir", "", "write the converted files below this directory, in the same subdirectories as their sources")
+	igoExt      = flag.String("ext", ".igo", "the extension of iGo sources, read by compile and written by parse")
+	goExt       = flag.String("out-ext", ".go", "the extension of Go sources, written by compile and read by parse")
+	stdinName   = flag.String("stdin-filename", "", "convert standard input to standard output instead of files, with this name for the source in positions and errors")
+
+	// processing control
+	CheckOnly  = flag.Bool("check", false, "only check syntax, do not produce any output")
+	showCarets = flag.Bool("caret", false, "show the source line of each syntax error with a caret under its column")
+	errFormat  = flag.String("errorformat", "", "print errors as json, an object {file, line, col, message} per line, instead of file:line:col: message")
+	allowEmpty = flag.Bool("allow-empty", false, "convert empty sources, or with only white space, to empty outputs instead of failing")
+	allowUTF8  = flag.Bool("allow-invalid-utf8", false, "convert sources containing invalid UTF-8, e.g. binary data in raw strings, instead of failing")
+	dryRun     = flag.Bool("n", false, "write nothing, only print to standard error the files that would be written or are unchanged")
+	keepGoing  = flag.Bool("keep-going", false, "print at the end how many files failed, the others being converted anyway, and exit with status 1 instead of 2 if some did")
 
 	// ExitCode
 	exitCode = 0
@@ -34,11 +57,55 @@ func To(m Mode, paths []string) int {
 		exitCode = 2
 	}
 
+	if *blankLines < 0 {
+		fmt.Fprintf(os.Stderr, "negative blank-lines %d\n", *blankLines)
+		exitCode = 2
+	}
+
+	if *goVersion != "" {
+		if _, err := goMinor(*goVersion); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 2
+			return exitCode
+		}
+	}
+
+	if *igoExt == *goExt || !strings.HasPrefix(*igoExt, ".") || !strings.HasPrefix(*goExt, ".") {
+		fmt.Fprintf(os.Stderr, "invalid -ext %q and -out-ext %q, want two different extensions, e.g. .igo and .go\n", *igoExt, *goExt)
+		exitCode = 2
+		return exitCode
+	}
+
+	if *errFormat != "" && *errFormat != "json" {
+		fmt.Fprintf(os.Stderr, "unknown -errorformat %q, only json is supported\n", *errFormat)
+		exitCode = 2
+		return exitCode
+	}
+
+	if err := initInteractive(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
+	}
+
+	if *stdinName != "" {
+		if err := ToReader(m, *stdinName, os.Stdin, os.Stdout); err != nil {
+			if m == IGO {
+				goReport(err)
+			} else {
+				igoReport(err)
+			}
+		}
+		return exitCode
+	}
+
 	if m == IGO {
 		goInitParserMode()
 		goInitPrinterMode()
-	} else {
-		igoInit()
+	} else if err := igoInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
 	}
 
 	// If we don't want to process a single file or directory,
@@ -47,7 +114,12 @@ func To(m Mode, paths []string) int {
 		paths = append(paths, ".")
 	}
 
+	counts = summaryCounts{} // of this call only
 	for _, path := range paths {
+		if quitting() {
+			break
+		}
+		path = trimDots(path)
 		if m == IGO {
 			goWalkPath(path)
 		} else {
@@ -55,15 +127,258 @@ func To(m Mode, paths []string) int {
 		}
 	}
 
+	if m == GO {
+		reportRewrites()
+	}
+
+	if *summary {
+		counts.print(os.Stderr)
+	} else if *keepGoing && counts.failed > 0 && *errFormat == "" {
+		fmt.Fprintf(os.Stderr, "%d of %d files failed\n", counts.failed, counts.files)
+	}
+	if *keepGoing && counts.failed > 0 {
+		// the files that failed are known, the others are converted
+		exitCode = 1
+	}
+
+	return exitCode
+}
+
+// ToReader converts the source read from in, from the language opposite
+// to m into m, and writes the result to out. filename is the name of the
+// source in positions and error messages, e.g. the path of the unsaved
+// buffer of an editor; it is neither read nor written.
+func ToReader(m Mode, filename string, in io.Reader, out io.Writer) error {
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	var res []byte
+	if m == IGO {
+		goInitParserMode()
+		goInitPrinterMode()
+		res, err = goTranslate(filename, src, nil)
+	} else {
+		if err := igoInit(); err != nil {
+			return err
+		}
+		res, IgoPositions[filename], err = igoTranslate(filename, src, rewrites, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = out.Write(res); err != nil {
+		return err
+	}
+	// the output is written anyway, as by igoProcessFile
+	if m == GO {
+		return checkOutput(m, destName(filename, *igoExt, *goExt), res)
+	}
+	return checkOutput(m, destName(filename, *goExt, *igoExt), res)
+}
+
+// Check parses the files found in paths, as To would do, but without
+// printing anything. It stops at the first file containing syntax errors,
+// reports them and returns a non-zero exit code.
+func Check(m Mode, paths []string) int {
+	flag.Parse()
+
+	if m == IGO {
+		goInitParserMode()
+		goInitPrinterMode() // for -max-col
+	} else if err := igoInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
+	}
+
+	if len(paths) == 0 {
+		paths = append(paths, ".")
+	}
+
+	for _, path := range paths {
+		path = trimDots(path)
+		var err error
+		if m == IGO {
+			err = checkPath(path, goFile, goCheckFile)
+		} else {
+			err = checkPath(path, igoFile, igoCheckFile)
+		}
+		if err != nil {
+			if m == IGO {
+				goReport(err)
+			} else {
+				igoReport(err)
+			}
+			break
+		}
+	}
+
 	return exitCode
 }
 
-func createDir(file string) {
+// checkPath calls check for path or, if path is a directory, for every
+// source file below it accepted by isSrc, until the first error.
+func checkPath(path string, isSrc func(os.FileInfo) bool, check func(string) error) error {
+	dir, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !dir.IsDir() {
+		return check(path)
+	}
+	return filepath.Walk(path, func(path string, f os.FileInfo, err error) error {
+		if err == nil && ignored(path, f) {
+			return skip(f)
+		}
+		if err == nil && isSrc(f) {
+			err = check(path)
+		}
+		return err
+	})
+}
+
+// trimDots returns path without its trailing "/...", if any, as in the
+// package patterns of the go command: directories are always walked
+// recursively, so "./..." is the same as ".".
+func trimDots(path string) string {
+	if path == "..." {
+		return "."
+	}
+	return strings.TrimSuffix(path, string(filepath.Separator)+"...")
+}
+
+// destName returns the name of the conversion of filename, a source with
+// the extension from, to a source with the extension to. Only the
+// extension is replaced: the _test, _GOOS and _GOARCH suffixes of the
+// base name are kept, so that foo_linux_amd64_test.igo becomes
+// foo_linux_amd64_test.go, still recognized as such by go build and go
+// test. A filename without the extension from gets to appended.
+func destName(filename, from, to string) string {
+	return strings.TrimSuffix(filename, from) + to
+}
+
+// SourceName returns the name of the iGo source that compile converts to
+// filename, a Go file, with the extensions set by -ext and -out-ext.
+func SourceName(filename string) string {
+	return destName(filename, *goExt, *igoExt)
+}
+
+// outPath returns the path the conversion of the source filename is
+// written to, dest being the one next to the source. With -outdir, dest
+// is moved from the current directory, which must contain the source, to
+// the same subdirectory of the -outdir one, -dest being ignored.
+func outPath(filename, dest string) (string, error) {
+	if *outDir == "" {
+		return dest, nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(dest)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: -outdir requires the sources to be below the current directory", filename)
+	}
+	return filepath.Join(*outDir, rel), nil
+}
+
+func createDir(file string) error {
+	if *dryRun {
+		return nil
+	}
 	dir := filepath.Dir(file)
 	err := os.MkdirAll(dir, 0700)
 	if err != nil && !os.IsExist(err) {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(2)
+		return err
+	}
+	return nil
+}
+
+// writeFile writes data to filename atomically: data is written to a
+// temporary file in the same directory which is then renamed over filename.
+// Nothing is written if filename already holds data, so that its
+// modification time, which build systems may rely on, does not change.
+// With -n, nothing is written at all, what would be done is printed to
+// standard error instead.
+func writeFile(filename string, data []byte, perm os.FileMode) error {
+	old, err := ioutil.ReadFile(filename)
+	unchanged := err == nil && bytes.Equal(old, data)
+	if *dryRun {
+		if unchanged {
+			fmt.Fprintf(os.Stderr, "unchanged %s\n", filename)
+		} else {
+			fmt.Fprintf(os.Stderr, "would write %s\n", filename)
+		}
+		return nil
+	}
+	if unchanged {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// utf8BOM is the UTF-8 encoding of the byte order mark.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// stripBOM returns src without its leading byte order mark, if any.
+func stripBOM(src []byte) []byte {
+	return bytes.TrimPrefix(src, utf8BOM)
+}
+
+// isBlank reports whether src contains nothing but white space, after
+// any byte order mark.
+func isBlank(src []byte) bool {
+	return len(bytes.TrimSpace(stripBOM(src))) == 0
+}
+
+// checkUTF8 returns an error giving the offset of the first invalid UTF-8
+// sequence in src, read from filename, unless -allow-invalid-utf8 is set.
+// Neither the parsers nor the printers are meant for such sources. Even
+// then, a 0xff byte is refused: the printers use it to escape literals
+// from the tabwriter, which drops it.
+func checkUTF8(filename string, src []byte) error {
+	if *allowUTF8 {
+		if n := bytes.IndexByte(src, 0xff); n >= 0 {
+			return fmt.Errorf("%s: byte 0xff at byte %d cannot be converted, even with -allow-invalid-utf8", filename, n)
+		}
+		return nil
+	}
+	if utf8.Valid(src) {
+		return nil
+	}
+	n := 0
+	for {
+		r, size := utf8.DecodeRune(src[n:])
+		if r == utf8.RuneError && size == 1 {
+			return fmt.Errorf("%s: invalid UTF-8 at byte %d", filename, n)
+		}
+		n += size
 	}
 }
 
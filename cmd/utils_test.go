@@ -0,0 +1,395 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var bomTranslateTests = []struct {
+	m    Mode
+	src  string
+	keep bool // -preserve-bom
+	out  string
+}{
+	{GO, "package p\n\nvar a = 1\n", false, "package p\n\nvar a = 1\n"},
+	{GO, "\ufeffpackage p\n\nvar a = 1\n", false, "package p\n\nvar a = 1\n"},
+	{GO, "\ufeffpackage p\n\nvar a = 1\n", true, "package p\n\nvar a = 1\n"}, // only for iGo outputs
+	{IGO, "package p\n\nvar a = 1\n", true, "package p\n\nvar a = 1\n"},
+	{IGO, "\ufeffpackage p\n\nvar a = 1\n", false, "package p\n\nvar a = 1\n"},
+	{IGO, "\ufeffpackage p\n\nvar a = 1\n", true, "\ufeffpackage p\n\nvar a = 1\n"},
+	// fragments have no beginning of file to keep it at
+	{IGO, "\ufeffvar a = 1\n", true, "var a = 1\n"},
+}
+
+// setKeepBOM sets -preserve-bom.
+func setKeepBOM(keep bool) {
+	*keepBOM = keep
+}
+
+func TestTranslateBOM(t *testing.T) {
+	defer setKeepBOM(*keepBOM)
+	for _, test := range bomTranslateTests {
+		setKeepBOM(test.keep)
+		out, _, err := TranslateVerbose(test.m, []byte(test.src), "f", nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if string(out) != test.out {
+			t.Errorf("%q, keep %v: got %q; want %q", test.src, test.keep, out, test.out)
+		}
+	}
+}
+
+func TestStripBOM(t *testing.T) {
+	for _, src := range []string{"", "package p\n", "\ufeffpackage p\n", "\ufeff\ufeffpackage p\n"} {
+		got := stripBOM([]byte(src))
+		if want := []byte(src); bytes.HasPrefix(want, utf8BOM) {
+			want = want[len(utf8BOM):]
+			if !bytes.Equal(got, want) {
+				t.Errorf("%q: got %q; want %q", src, got, want)
+			}
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%q: got %q; want it unchanged", src, got)
+		}
+	}
+}
+
+var destNameTests = []struct {
+	filename, from, to string
+	want               string
+}{
+	{"foo.igo", ".igo", ".go", "foo.go"},
+	{"foo_test.igo", ".igo", ".go", "foo_test.go"},
+	{"foo_linux.igo", ".igo", ".go", "foo_linux.go"},
+	{"foo_linux_amd64_test.igo", ".igo", ".go", "foo_linux_amd64_test.go"},
+	{"dir/foo_test.igo", ".igo", ".go", "dir/foo_test.go"},
+	{"foo_windows_test.go", ".go", ".igo", "foo_windows_test.igo"},
+	{"foo.igo.igo", ".igo", ".go", "foo.igo.go"},
+	// no extension from
+	{"foo", ".igo", ".go", "foo.go"},
+	{"foo.txt", ".igo", ".go", "foo.txt.go"},
+}
+
+func TestDestName(t *testing.T) {
+	for _, test := range destNameTests {
+		if got := destName(test.filename, test.from, test.to); got != test.want {
+			t.Errorf("destName(%q, %q, %q) = %q; want %q", test.filename, test.from, test.to, got, test.want)
+		}
+	}
+}
+
+var trimDotsTests = []struct {
+	path, want string
+}{
+	{"...", "."},
+	{"./...", "."},
+	{"dir/...", "dir"},
+	{"dir", "dir"},
+	{"dir...", "dir..."},
+}
+
+func TestTrimDots(t *testing.T) {
+	for _, test := range trimDotsTests {
+		if got := trimDots(test.path); got != test.want {
+			t.Errorf("trimDots(%q) = %q; want %q", test.path, got, test.want)
+		}
+	}
+}
+
+// setExts sets -ext and -out-ext.
+func setExts(igo, goext string) {
+	*igoExt, *goExt = igo, goext
+}
+
+// setDestDir sets -dest.
+func setDestDir(dir string) {
+	*DestDir = dir
+}
+
+// setExitCode sets the exit code of the command.
+func setExitCode(code int) {
+	exitCode = code
+}
+
+// A capture holds what is written to os.Stdout or os.Stderr, redirected
+// to a temporary file, until it is read.
+type capture struct {
+	f   *os.File
+	std **os.File // &os.Stdout or &os.Stderr
+	old *os.File
+}
+
+// captureOutput redirects std, &os.Stdout or &os.Stderr, until the read
+// method of the capture returned is called.
+func captureOutput(t *testing.T, std **os.File) *capture {
+	f, err := ioutil.TempFile("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &capture{f, std, *std}
+	*std = f
+	return c
+}
+
+// read restores the output and returns what was written to it meanwhile.
+func (c *capture) read() string {
+	*c.std = c.old
+	defer os.Remove(c.f.Name())
+	c.f.Close()
+	out, _ := ioutil.ReadFile(c.f.Name())
+	return string(out)
+}
+
+// extTree is a tree of sources, by path, converted with -ext .ig and
+// -out-ext .go2.
+var extTree = map[string]string{
+	"a.ig":      "package p\n\nvar a = 1\n",
+	"sub/b.ig":  "package p\n\nvar b = 2\n",
+	"c.igo":     "package p\n\nvar c = 3\n",
+	"sub/d.go2": "package p\n\nvar d = 4\n",
+	"e.go":      "package p\n\nvar e = 5\n",
+}
+
+var extTests = []struct {
+	m         Mode
+	written   []string // by the conversion
+	untouched []string
+}{
+	{GO, []string{"a.go2", "sub/b.go2"}, []string{"c.go", "c.go2", "sub/d.ig"}},
+	{IGO, []string{"sub/d.ig"}, []string{"e.ig", "e.igo", "a.go2"}},
+}
+
+func TestExtensions(t *testing.T) {
+	defer setExts(*igoExt, *goExt)
+	defer setDestDir(*DestDir)
+	defer setExitCode(exitCode)
+	setExts(".ig", ".go2")
+	setDestDir("") // the iGo next to the Go
+
+	for _, test := range extTests {
+		dir, err := ioutil.TempDir("", "igo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for name, src := range extTree {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if code := To(test.m, []string{dir}); code != 0 {
+			t.Fatalf("mode %v: exit code %d", test.m, code)
+		}
+		for _, name := range test.written {
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+				t.Errorf("mode %v: %s not written: %v", test.m, name, err)
+			}
+		}
+		for _, name := range test.untouched {
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+				t.Errorf("mode %v: %s written", test.m, name)
+			}
+		}
+	}
+
+	// the extensions must differ
+	setExts(".go", ".go")
+	if code := To(GO, nil); code != 2 {
+		t.Errorf("-ext .go -out-ext .go: exit code %d; want 2", code)
+	}
+}
+
+var sourceNameTests = []struct {
+	igoExt, goExt string
+	filename      string
+	want          string
+}{
+	{".igo", ".go", "a/f.go", "a/f.igo"},
+	{".igo", ".go", "f_linux_test.go", "f_linux_test.igo"},
+	{".ig", ".go2", "a/f.go2", "a/f.ig"},
+	{".ig", ".go2", "a/f.go", "a/f.go.ig"}, // not a Go file of compile
+}
+
+func TestSourceName(t *testing.T) {
+	defer setExts(*igoExt, *goExt)
+	for _, test := range sourceNameTests {
+		setExts(test.igoExt, test.goExt)
+		if got := SourceName(test.filename); got != test.want {
+			t.Errorf("-ext %s -out-ext %s, %s: got %s; want %s", test.igoExt, test.goExt, test.filename, got, test.want)
+		}
+	}
+}
+
+var writeFileTests = []struct {
+	old  string // "" for no file
+	data string
+	perm os.FileMode
+	want os.FileMode // the mode after writeFile
+}{
+	{"", "package p\n", 0644, 0644},
+	{"package p\n", "package q\n", 0600, 0600},
+	// an unchanged file is left as it is
+	{"package p\n", "package p\n", 0600, 0644},
+}
+
+// TestWriteFile checks that writeFile replaces the file through a
+// temporary file, leaving nothing else behind in the directory.
+func TestWriteFile(t *testing.T) {
+	for _, test := range writeFileTests {
+		dir, err := ioutil.TempDir("", "igo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "f.go")
+		if test.old != "" {
+			if err := ioutil.WriteFile(filename, []byte(test.old), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := writeFile(filename, []byte(test.data), test.perm); err != nil {
+			t.Errorf("%q: %v", test.data, err)
+			continue
+		}
+		if out, err := ioutil.ReadFile(filename); err != nil || string(out) != test.data {
+			t.Errorf("%q: got %q, %v", test.data, out, err)
+		}
+		if fi, err := os.Stat(filename); err != nil {
+			t.Errorf("%q: %v", test.data, err)
+		} else if fi.Mode().Perm() != test.want {
+			t.Errorf("%q: got mode %v; want %v", test.data, fi.Mode().Perm(), test.want)
+		}
+		if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+			t.Errorf("%q: %d files in the directory; want 1", test.data, len(files))
+		}
+	}
+}
+
+// setOutDir sets -outdir.
+func setOutDir(dir string) {
+	*outDir = dir
+}
+
+var outDirTests = []struct {
+	m         Mode
+	src, dest string
+}{
+	{GO, "a.igo", "a.go"},
+	{GO, "sub/b.igo", "sub/b.go"},
+	{IGO, "c.go", "c.igo"},
+	{IGO, "sub/d.go", "sub/d.igo"},
+}
+
+// TestOutDir checks that the conversions are written below an absolute
+// -outdir, and not below -dest.
+func TestOutDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer setOutDir(*outDir)
+	defer setDestDir(*DestDir)
+	src, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	out, err := ioutil.TempDir("", "igo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(out)
+	if err := os.Chdir(src); err != nil {
+		t.Fatal(err)
+	}
+	setOutDir(out)
+	setDestDir("./")
+
+	for _, test := range outDirTests {
+		if err := os.MkdirAll(filepath.Dir(test.src), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(test.src, []byte("package p\n\nvar a = 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if code := To(test.m, []string{test.src}); code != 0 {
+			t.Errorf("%s: exit code %d", test.src, code)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(out, test.dest)); err != nil {
+			t.Errorf("%s: %v", test.src, err)
+		}
+		if _, err := os.Stat(test.dest); !os.IsNotExist(err) {
+			t.Errorf("%s: %s written next to the source", test.src, test.dest)
+		}
+		if _, err := os.Stat(filepath.Join(strings.TrimPrefix(out, string(filepath.Separator)), test.dest)); !os.IsNotExist(err) {
+			t.Errorf("%s: written below the current directory", test.src)
+		}
+	}
+}
+
+var toReaderTests = []struct {
+	m        Mode
+	filename string
+	src      string
+	out      string
+	err      string
+}{
+	{GO, "buf.igo", "package p\n\nfunc f(): return\n", "package p\n\nfunc f() { return }\n", ""},
+	{GO, "dir/buf.igo", "package p\n\nvar = 1\n", "", "dir/buf.igo:3:5: expected 'IDENT', found '='"},
+	{IGO, "buf.go", "package p\n\nfunc f() { return }\n", "package p\n\nfunc f(): return\n", ""},
+	{IGO, "dir/buf.go", "package p\n\nvar a = )\n", "", "dir/buf.go:3:9: expected operand, found ')'"},
+}
+
+// TestToReader checks that the name given, as with -stdin-filename, is
+// the one of the errors and of the positions recorded for the source.
+func TestToReader(t *testing.T) {
+	for _, test := range toReaderTests {
+		delete(IgoPositions, test.filename)
+		var out bytes.Buffer
+		err := ToReader(test.m, test.filename, strings.NewReader(test.src), &out)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v; want %q", test.filename, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.filename, err)
+			continue
+		}
+		if out.String() != test.out {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.filename, out.String(), test.out)
+		}
+
+		// the positions are recorded for compile, the only direction
+		// whose errors are mapped back to the source
+		pos := IgoPositions[test.filename]
+		if (pos != nil) != (test.m == GO) {
+			t.Errorf("%s: positions recorded %v; want %v", test.filename, pos != nil, test.m == GO)
+		}
+		if pos == nil {
+			continue
+		}
+		for in := range *pos {
+			if in.Filename != test.filename {
+				t.Errorf("%s: position %v of another file", test.filename, in)
+			}
+		}
+		if len(*pos) == 0 {
+			t.Errorf("%s: no positions", test.filename)
+		}
+	}
+}
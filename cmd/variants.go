@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	goast "go/ast"
+	gofmt "go/format"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	VariantsMode = flag.Bool("variants", false, "convert the GOOS/GOARCH variants of each base name given, e.g. foo for foo_linux.igo and foo_darwin.igo, if their exported declarations match")
+	force        = flag.Bool("force", false, "with -variants, write the converted files even if their exported declarations differ")
+)
+
+// knownOS and knownArch are the GOOS and GOARCH values recognized by go
+// build in the suffixes of filenames.
+var (
+	knownOS = []string{
+		"aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos", "ios", "js",
+		"linux", "nacl", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows", "zos",
+	}
+	knownArch = []string{
+		"386", "amd64", "amd64p32", "arm", "armbe", "arm64", "arm64be", "loong64", "mips",
+		"mipsle", "mips64", "mips64le", "mips64p32", "mips64p32le", "ppc", "ppc64", "ppc64le",
+		"riscv", "riscv64", "s390", "s390x", "sparc", "sparc64", "wasm",
+	}
+)
+
+// Variants converts, from the language opposite to m into m, the
+// variants of each base name in paths: the sources of the same directory
+// named after it with a _GOOS, _GOARCH or _GOOS_GOARCH suffix, e.g.
+// foo_linux.igo and foo_darwin_arm64.igo for foo. Their exported API is
+// compared first: the exported functions, methods, types, constants and
+// variables, with the types of parameters and results, the exported
+// fields of structs and the methods of interfaces. The differences are
+// reported with the files declaring them and nothing is written, unless
+// -force is given.
+func Variants(m Mode, paths []string) int {
+	flag.Parse()
+
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "-variants requires the base names of the variants")
+		exitCode = 2
+		return exitCode
+	}
+
+	ext, report := *igoExt, igoReport
+	if m == IGO {
+		goInitParserMode()
+		goInitPrinterMode()
+		ext, report = *goExt, goReport
+	} else if err := igoInit(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 2
+		return exitCode
+	}
+
+	for _, path := range paths {
+		if err := convertVariants(m, strings.TrimSuffix(path, ext), ext, report); err != nil {
+			report(err)
+		}
+	}
+	return exitCode
+}
+
+// convertVariants converts the variants of base, sources with the
+// extension ext, as Variants describes. The differences of their APIs
+// are passed to report.
+func convertVariants(m Mode, base, ext string, report func(error)) error {
+	filenames, err := variantFiles(base, ext)
+	if err != nil {
+		return err
+	}
+	if len(filenames) == 0 {
+		return fmt.Errorf("%s: no GOOS/GOARCH variants", base)
+	}
+
+	var (
+		dests = make([]string, len(filenames))
+		res   = make([][]byte, len(filenames))
+		apis  = make([]map[string]string, len(filenames))
+	)
+	for i, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		gosrc := stripBOM(src)
+		if m == IGO {
+			dests[i] = destName(filename, *goExt, *igoExt)
+			res[i], err = goTranslate(filename, src, nil)
+		} else {
+			dests[i] = destName(filename, *igoExt, *goExt)
+			res[i], _, err = igoTranslate(filename, src, rewrites, nil)
+			gosrc = res[i]
+		}
+		if err != nil {
+			return err
+		}
+		if apis[i], err = exportedAPI(filename, gosrc); err != nil {
+			return err
+		}
+	}
+
+	same := true
+	for i := 1; i < len(filenames); i++ {
+		for _, diff := range apiDiffs(filenames[0], apis[0], filenames[i], apis[i]) {
+			report(diff)
+			same = false
+		}
+	}
+	if !same && !*force {
+		return nil
+	}
+
+	for i, filename := range filenames {
+		dest, err := outPath(filename, dests[i])
+		if err != nil {
+			return err
+		}
+		if err := createDir(dest); err != nil {
+			return err
+		}
+		if err := writeFile(dest, res[i], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variantFiles returns the names of the variants of base with the
+// extension ext, sorted. Tests are left out.
+func variantFiles(base, ext string) ([]string, error) {
+	matches, err := filepath.Glob(base + "_*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	var filenames []string
+	for _, match := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(match, base+"_"), ext)
+		if isVariantSuffix(suffix) {
+			filenames = append(filenames, match)
+		}
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// isVariantSuffix reports whether s is GOOS, GOARCH or GOOS_GOARCH, as
+// go build recognizes them at the end of a filename.
+func isVariantSuffix(s string) bool {
+	parts := strings.Split(s, "_")
+	switch len(parts) {
+	case 1:
+		return contains(knownOS, parts[0]) || contains(knownArch, parts[0])
+	case 2:
+		return contains(knownOS, parts[0]) && contains(knownArch, parts[1])
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// exportedAPI returns the exported declarations of the Go source src,
+// read from filename, keyed by kind and name (e.g., "func Open" or
+// "method File.Close"), with their types as gofmt prints them on a
+// single line.
+// Parameters and results are given without their names, unexported
+// struct fields are left out. Type parameters are given as declared.
+func exportedAPI(filename string, src []byte) (map[string]string, error) {
+	fset := gotoken.NewFileSet()
+	file, err := goparser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	node := func(n goast.Node) string {
+		var buf bytes.Buffer
+		gofmt.Node(&buf, fset, n)
+		return strings.Join(strings.Fields(buf.String()), " ") // on one line
+	}
+
+	api := make(map[string]string)
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *goast.FuncDecl:
+			if !d.Name.IsExported() {
+				continue
+			}
+			key := "func " + d.Name.Name
+			if recv := goRecvName(d); recv != "" {
+				if !goast.IsExported(recv) {
+					continue
+				}
+				key = "method " + recv + "." + d.Name.Name
+			}
+			api[key] = node(&goast.FuncType{TypeParams: d.Type.TypeParams, Params: unnamed(d.Type.Params), Results: unnamed(d.Type.Results)})
+		case *goast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *goast.TypeSpec:
+					if s.Name.IsExported() {
+						api["type "+s.Name.Name] = node(&goast.TypeSpec{Name: s.Name, TypeParams: s.TypeParams, Assign: s.Assign, Type: exportedType(s.Type)})
+					}
+				case *goast.ValueSpec:
+					for _, name := range s.Names {
+						if !name.IsExported() {
+							continue
+						}
+						typ := ""
+						if s.Type != nil {
+							typ = node(s.Type)
+						}
+						api[d.Tok.String()+" "+name.Name] = typ
+					}
+				}
+			}
+		}
+	}
+	return api, nil
+}
+
+// unnamed returns a copy of the parameters or results list without the
+// names, a field for each.
+func unnamed(list *goast.FieldList) *goast.FieldList {
+	if list == nil {
+		return nil
+	}
+	res := &goast.FieldList{}
+	for _, f := range list.List {
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			res.List = append(res.List, &goast.Field{Type: f.Type})
+		}
+	}
+	return res
+}
+
+// exportedType returns typ, or a copy of it without the unexported fields
+// if it is a struct.
+func exportedType(typ goast.Expr) goast.Expr {
+	st, ok := typ.(*goast.StructType)
+	if !ok {
+		return typ
+	}
+	fields := &goast.FieldList{}
+	for _, f := range st.Fields.List {
+		var names []*goast.Ident
+		for _, name := range f.Names {
+			if name.IsExported() {
+				names = append(names, name)
+			}
+		}
+		if len(f.Names) == 0 && isExportedEmbed(f.Type) || len(names) > 0 {
+			fields.List = append(fields.List, &goast.Field{Names: names, Type: f.Type, Tag: f.Tag})
+		}
+	}
+	return &goast.StructType{Fields: fields}
+}
+
+// isExportedEmbed reports whether the embedded field typ is exported.
+func isExportedEmbed(typ goast.Expr) bool {
+	switch t := typ.(type) {
+	case *goast.StarExpr:
+		return isExportedEmbed(t.X)
+	case *goast.SelectorExpr:
+		return t.Sel.IsExported()
+	case *goast.Ident:
+		return t.IsExported()
+	}
+	return false
+}
+
+// apiDiffs returns the differences between the APIs a and b, of the
+// files named name and other.
+func apiDiffs(name string, a map[string]string, other string, b map[string]string) []error {
+	var keys []string
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var diffs []error
+	for _, key := range keys {
+		x, inA := a[key]
+		y, inB := b[key]
+		switch {
+		case !inB:
+			diffs = append(diffs, fmt.Errorf("%s: no %s, declared in %s", other, key, name))
+		case !inA:
+			diffs = append(diffs, fmt.Errorf("%s: %s, not declared in %s", other, key, name))
+		case x != y:
+			diffs = append(diffs, fmt.Errorf("%s: %s is %s, but %s in %s", other, key, y, x, name))
+		}
+	}
+	return diffs
+}
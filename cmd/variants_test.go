@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+var exportedAPITests = []struct {
+	src  string
+	want map[string]string
+}{
+	{"package p\n\nfunc F(a, b int) (err error) { return nil }\n\nfunc f() {}\n",
+		map[string]string{"func F": "func(int, int) error"}},
+	{"package p\n\nfunc F[T any](x T) T { return x }\n",
+		map[string]string{"func F": "func[T any](T) T"}},
+	{"package p\n\ntype T struct {\n\tX int\n\ty int\n}\n\nfunc (t *T) M() {}\n\nfunc (t T) m() {}\n",
+		map[string]string{"type T": "T struct { X int }", "method T.M": "func()"}},
+	{"package p\n\ntype L[E comparable] struct{ Items []E }\n\nfunc (l *L[E]) Add(e E) {}\n",
+		map[string]string{"type L": "L[E comparable] struct { Items []E }", "method L.Add": "func(E)"}},
+	{"package p\n\ntype A = int\n\nconst C = 1\n\nvar V, w int\n",
+		map[string]string{"type A": "A = int", "const C": "", "var V": "int"}},
+}
+
+func TestExportedAPI(t *testing.T) {
+	for _, test := range exportedAPITests {
+		got, err := exportedAPI("f.go", []byte(test.src))
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q; want %q", test.src, got, test.want)
+		}
+	}
+}
+
+var apiDiffsTests = []struct {
+	a, b  string
+	diffs []string
+}{
+	{"package p\n\nfunc F(x int) {}\n", "package p\n\nfunc F(y int) {}\n", nil},
+	{"package p\n\nfunc F(x int) {}\n", "package p\n\nfunc F(x int64) {}\n",
+		[]string{"b.go: func F is func(int64), but func(int) in a.go"}},
+	// the type parameters count
+	{"package p\n\nfunc F[T any](x T) {}\n", "package p\n\nfunc F[T comparable](x T) {}\n",
+		[]string{"b.go: func F is func[T comparable](T), but func[T any](T) in a.go"}},
+	{"package p\n\ntype L[E any] []E\n", "package p\n\ntype L[E comparable] []E\n",
+		[]string{"b.go: type L is L[E comparable] []E, but L[E any] []E in a.go"}},
+	{"package p\n\nfunc F() {}\n", "package p\n\nfunc G() {}\n",
+		[]string{"b.go: no func F, declared in a.go", "b.go: func G, not declared in a.go"}},
+}
+
+func TestAPIDiffs(t *testing.T) {
+	for _, test := range apiDiffsTests {
+		a, err := exportedAPI("a.go", []byte(test.a))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := exportedAPI("b.go", []byte(test.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var diffs []string
+		for _, err := range apiDiffs("a.go", a, "b.go", b) {
+			diffs = append(diffs, err.Error())
+		}
+		if !reflect.DeepEqual(diffs, test.diffs) {
+			t.Errorf("%q and %q: got %q; want %q", test.a, test.b, diffs, test.diffs)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+var WatchMode = flag.Bool("watch", false, "keep running and convert again the files changed on disk")
+
+const (
+	pollInterval  = 100 * time.Millisecond // how often watched files are checked
+	watchDebounce = 100 * time.Millisecond // quiet time after the last write before converting
+)
+
+// Watch converts the files found in paths, as To does, then keeps running
+// converting again every source file created or changed on disk, until the
+// process is interrupted. Files are polled, and rapid successive writes to a
+// file result in a single conversion once the file stays unchanged for
+// watchDebounce.
+func Watch(m Mode, paths []string) int {
+	To(m, paths)
+	if quitting() {
+		return exitCode
+	}
+
+	if len(paths) == 0 {
+		paths = append(paths, ".")
+	}
+
+	var (
+		isSrc   = igoFile
+		process = igoProcessFile
+		report  = igoReport
+	)
+	if m == IGO {
+		isSrc, process, report = goFile, goProcessFile, goReport
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	modTimes := scanFiles(paths, isSrc)
+	pending := make(map[string]time.Time) // changed files by time of the last change seen
+	for {
+		select {
+		case <-interrupt:
+			return exitCode
+		case now := <-ticker.C:
+			current := scanFiles(paths, isSrc)
+			for path, t := range current {
+				if old, ok := modTimes[path]; !ok || !old.Equal(t) {
+					pending[path] = now
+				}
+			}
+			modTimes = current
+
+			// convert in a fixed order, not the one of map iteration
+			var ready []string
+			for path, t := range pending {
+				if _, ok := current[path]; !ok {
+					delete(pending, path) // removed meanwhile
+					continue
+				}
+				if now.Sub(t) < watchDebounce {
+					continue
+				}
+				delete(pending, path)
+				ready = append(ready, path)
+			}
+			sort.Strings(ready)
+			for _, path := range ready {
+				convert(path, process, report)
+				if quitting() {
+					return exitCode
+				}
+			}
+		}
+	}
+}
+
+// convert processes a single changed file logging the outcome.
+func convert(path string, process func(string, io.Reader, io.Writer) error, report func(error)) {
+	start := time.Now()
+	if err := process(path, nil, os.Stdout); err != nil {
+		if err != errQuit {
+			report(err)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: converted in %v\n", path, time.Since(start))
+}
+
+// scanFiles returns the modification times of the source files, accepted
+// by isSrc, found in paths.
+func scanFiles(paths []string, isSrc func(os.FileInfo) bool) map[string]time.Time {
+	files := make(map[string]time.Time)
+	for _, path := range paths {
+		filepath.Walk(trimDots(path), func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if ignored(path, f) {
+				return skip(f)
+			}
+			if isSrc(f) {
+				files[path] = f.ModTime()
+			}
+			return nil
+		})
+	}
+	return files
+}
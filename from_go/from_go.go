@@ -6,15 +6,16 @@
 package from_go
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
 	"go/token"
 	"io"
-	"os"
 	"strconv"
 	"strings"
 	"text/tabwriter"
 	"unicode"
+	"unicode/utf8"
 
 	iToken "github.com/DAddYE/igo/token"
 )
@@ -23,6 +24,8 @@ const (
 	maxNewlines = 2     // max. number of newlines between source text
 	debug       = false // enable for debugging
 	infinity    = 1 << 30
+
+	defaultMaxBlankLines = 10 // default Config.MaxBlankLines
 )
 
 type whiteSpace byte
@@ -55,10 +58,11 @@ type printer struct {
 	mode        pmode        // current printer mode
 	impliedSemi bool         // if set, a linebreak implies a semicolon
 	lastTok     token.Token  // the last token printed (token.ILLEGAL if it's whitespace)
+	wroteTok    token.Token  // the last token printed, whitespace aside
 	wsbuf       []whiteSpace // delayed white space
-	findent     int          // function indentation idx
-	inFunc      bool         // track if we are in a function call
 	consBrakes  int          // track consecutive line breaks
+	nesting     int          // of the expression printed, with MaxDepth
+	declLines   int          // if > 0, the line breaks before the next declaration, its comments included
 
 	// Positions
 	// The out position differs from the pos position when the result
@@ -70,6 +74,10 @@ type printer struct {
 	out  token.Position // current position in output space
 	last token.Position // value of pos after calling writeString
 
+	// The number of source lines since last that are not printed
+	// (e.g., those of closing braces, or of empty bodies).
+	elided int
+
 	// The list of all source comments, in order of appearance.
 	comments        []*ast.CommentGroup // may be nil
 	cindex          int                 // current comment index
@@ -80,7 +88,15 @@ type printer struct {
 	commentOffset  int               // = p.posFor(p.comments[p.cindex].List[0].Pos()).Offset; or infinity
 	commentNewline bool              // true if the comment group contains newlines
 
-	// Cache of already computed node sizes.
+	// Documentation comment groups, only recorded if ReflowDocComments
+	// is set.
+	docs map[*ast.CommentGroup]bool
+
+	// Import specs following others dropped by filterDecls (see specLine).
+	joined map[ast.Spec][]ast.Spec
+
+	// Cache of already computed node sizes. It is only ever looked up,
+	// never iterated, so that the output does not depend on map order.
 	nodeSizes map[ast.Node]int
 
 	// Cache of most recently computed line position.
@@ -162,9 +178,10 @@ func (p *printer) lineFor(pos token.Pos) int {
 func (p *printer) atLineBegin(pos token.Position) {
 	// write a //line comment if necessary
 	if p.Config.Mode&SourcePos != 0 && pos.IsValid() && (p.out.Line != pos.Line || p.out.Filename != pos.Filename) {
-		p.output = append(p.output, tabwriter.Escape) // protect '\n' in //line from tabwriter interpretation
+		// Unlike go/printer, do not protect the '\n' from the tabwriter:
+		// it would indent the following lines wrongly, and indentation is
+		// significant in iGo. Alignment does not span the comment instead.
 		p.output = append(p.output, fmt.Sprintf("#line %s:%d\n", pos.Filename, pos.Line)...)
-		p.output = append(p.output, tabwriter.Escape)
 		// p.out must match the //line comment
 		p.out.Filename = pos.Filename
 		p.out.Line = pos.Line
@@ -174,8 +191,16 @@ func (p *printer) atLineBegin(pos token.Position) {
 	// use "hard" htabs - indentation columns
 	// must not be discarded by the tabwriter
 	n := p.Config.Indent + p.indent // include base indentation
-	for i := 0; i < n; i++ {
-		p.output = append(p.output, '\t')
+	if width := p.spaceIndent(); width > 0 {
+		// expand it here, the tabwriter, if any, would use Tabwidth
+		n *= width
+		for i := 0; i < n; i++ {
+			p.output = append(p.output, ' ')
+		}
+	} else {
+		for i := 0; i < n; i++ {
+			p.output = append(p.output, '\t')
+		}
 	}
 
 	// update positions
@@ -184,11 +209,69 @@ func (p *printer) atLineBegin(pos token.Position) {
 	p.out.Column += n
 }
 
+// writeRaw writes text, the output of a BeforeDecl or AfterDecl hook, as
+// it is, on lines of its own indented as the code, and ends the last one
+// if eol is set. Only p.out is updated: text has no source position.
+func (p *printer) writeRaw(text []byte, eol bool) {
+	if len(text) == 0 {
+		return
+	}
+	newline := func() {
+		p.output = append(p.output, '\f')
+		p.out.Line++
+		p.out.Column = 1
+	}
+	if p.out.Column > 1 {
+		newline()
+	}
+	for i, line := range bytes.Split(bytes.TrimSuffix(text, []byte("\n")), []byte("\n")) {
+		if i > 0 {
+			newline()
+		}
+		if len(line) == 0 {
+			continue
+		}
+		n := p.Config.Indent + p.indent
+		if width := p.spaceIndent(); width > 0 {
+			p.output = append(p.output, bytes.Repeat([]byte{' '}, n*width)...)
+			n *= width
+		} else {
+			p.output = append(p.output, bytes.Repeat([]byte{'\t'}, n)...)
+		}
+		p.output = append(p.output, tabwriter.Escape)
+		p.output = append(p.output, line...)
+		p.output = append(p.output, tabwriter.Escape)
+		p.out.Column += n + len(line)
+	}
+	if eol {
+		newline()
+	}
+}
+
+// spaceIndent returns the number of blanks written for each level of
+// indentation, or 0 if it is written as tabs: in UseSpaces mode without
+// TabIndent, DisplayTabwidth if set, or else Tabwidth in RawFormat mode,
+// where no tabwriter expands the tabs.
+func (p *printer) spaceIndent() int {
+	if p.Config.Mode&(TabIndent|UseSpaces) != UseSpaces {
+		return 0
+	}
+	switch {
+	case p.Config.DisplayTabwidth > 0:
+		return p.Config.DisplayTabwidth
+	case p.Config.Mode&RawFormat != 0:
+		return p.Config.Tabwidth
+	}
+	return 0
+}
+
 // writeByte writes ch n times to p.output and updates p.pos.
 func (p *printer) writeByte(ch byte, n int) {
 	if p.out.Column == 1 {
 		p.consBrakes++
-		p.atLineBegin(p.pos)
+		// Only indentation here: p.pos may already be the position
+		// of the next item, and blank lines need no //line comment.
+		p.atLineBegin(token.Position{})
 	}
 
 	for i := 0; i < n; i++ {
@@ -220,6 +303,9 @@ func (p *printer) writeByte(ch byte, n int) {
 // avoids processing extra escape characters and reduces run time of the
 // printer benchmark by up to 10%.
 //
+// The lines of s after the first, e.g. those of a raw string, are written
+// as they are: indentation is only written by atLineBegin, before s.
+//
 func (p *printer) writeString(pos token.Position, s string, isLit bool) {
 	if p.out.Column == 1 {
 		p.consBrakes++
@@ -275,6 +361,13 @@ func (p *printer) writeString(pos token.Position, s string, isLit bool) {
 	}
 
 	p.last = p.pos
+	p.elided = 0
+}
+
+// onLastLine reports whether line is that of the last item written, or
+// one of the source lines folded into it.
+func (p *printer) onLastLine(line int) bool {
+	return p.last.Line <= line && line <= p.last.Line+p.elided
 }
 
 // writeCommentPrefix writes the whitespace before a comment.
@@ -296,9 +389,9 @@ func (p *printer) writeCommentPrefix(pos, next token.Position, prev, comment *as
 		return
 	}
 
-	if pos.Line == p.last.Line && (prev == nil || prev.Text[1] != '/') {
-		// comment on the same line as last item:
-		// separate with at least one separator
+	if p.onLastLine(pos.Line) && (prev == nil || prev.Text[1] != '/') {
+		// comment on the same line as last item, or on lines folded
+		// into it: separate with at least one separator
 		hasSep := false
 		if prev == nil {
 			// first comment of a comment group
@@ -359,11 +452,12 @@ func (p *printer) writeCommentPrefix(pos, next token.Position, prev, comment *as
 				}
 				// if the next token is not a closing }, apply the unindent
 				// if it appears that the comment is aligned with the
-				// token; otherwise assume the unindent is part of a
-				// closing block and stop (this scenario appears with
-				// comments before a case label where the comments
-				// apply to the next case instead of the current one)
-				if tok != token.RBRACE && pos.Column == next.Column {
+				// token, or indented less; otherwise assume the unindent
+				// is part of a closing block and stop (this scenario
+				// appears with comments before a case label where the
+				// comments apply to the next case instead of the current
+				// one)
+				if tok != token.RBRACE && pos.Column <= next.Column {
 					continue
 				}
 			case newline, formfeed:
@@ -378,17 +472,24 @@ func (p *printer) writeCommentPrefix(pos, next token.Position, prev, comment *as
 		// determine number of linebreaks before the comment
 		n := 0
 		if pos.IsValid() && p.last.IsValid() {
-			n = pos.Line - p.last.Line
+			n = pos.Line - p.last.Line - p.elided
 			if n < 0 { // should never happen
 				n = 0
 			}
 		}
 
+		// the line breaks before the first declaration are fixed
+		if p.declLines > 0 {
+			n, droppedLinebreak = p.declLines, false
+			p.declLines = 0
+		}
+
 		// at the package scope level only (p.indent == 0),
 		// add an extra newline if we dropped one before:
 		// this preserves a blank line before documentation
 		// comments at the package scope level (issue 2570)
-		if p.indent == 0 && droppedLinebreak {
+		// unless only line breaks from the source are kept
+		if p.indent == 0 && droppedLinebreak && p.Mode&SourceLines == 0 && !p.PreserveBlankLines {
 			n++
 		}
 
@@ -398,11 +499,18 @@ func (p *printer) writeCommentPrefix(pos, next token.Position, prev, comment *as
 			n = 1
 		}
 
+		n = p.nlimit(n)
+		if n > 0 && p.out.Column == 1 {
+			// a line break was written after the comments at the
+			// end of a construct (see close)
+			n--
+		}
+
 		if n > 0 {
 			// use formfeeds to break columns before a comment;
 			// this is analogous to using formfeeds to separate
 			// individual lines of /*-style comments
-			p.writeByte('\f', nlimit(n))
+			p.writeByte('\f', n)
 		}
 	}
 }
@@ -424,6 +532,34 @@ func trimRight(s string) string {
 	return strings.TrimRightFunc(s, unicode.IsSpace)
 }
 
+// expandTabs returns s with each tab replaced by blanks up to the next
+// tab stop, every tabwidth columns. Columns are counted in characters,
+// starting from col for the first one of s.
+func expandTabs(s string, col, tabwidth int) string {
+	if tabwidth <= 0 || strings.IndexByte(s, '\t') < 0 {
+		return s
+	}
+	b := make([]byte, 0, len(s)+tabwidth)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\t':
+			for {
+				b = append(b, ' ')
+				col++
+				if col%tabwidth == 0 {
+					break
+				}
+			}
+		case c&0xC0 != 0x80: // not a continuation byte of a UTF-8 sequence
+			b = append(b, c)
+			col++
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
 func trimSuffix(s string) string {
 	return strings.TrimRightFunc(s, func(r rune) bool {
 		switch r {
@@ -461,12 +597,102 @@ func stripCommonPrefix(lines []string) {
 	}
 }
 
+// reflowComment re-wraps the paragraphs of a //-style comment group, given
+// by the texts of its lines without comment marker, so that no line is
+// wider than width, if possible, breaking lines at blanks. Only the
+// paragraphs with a line wider than width change: blank lines, directives,
+// code blocks and lines holding a URL separate paragraphs and are kept as
+// they are. The result is nil if no paragraph is re-wrapped.
+func reflowComment(texts []string, width int) []string {
+	var res []string
+	changed := false
+	for i := 0; i < len(texts); {
+		if !isProse(texts[i]) {
+			res = append(res, texts[i])
+			i++
+			continue
+		}
+		j, wide := i, false
+		for ; j < len(texts) && isProse(texts[j]); j++ {
+			wide = wide || utf8.RuneCountInString(texts[j]) > width
+		}
+		if !wide {
+			res = append(res, texts[i:j]...)
+			i = j
+			continue
+		}
+		changed = true
+		line := ""
+		for _, word := range strings.Fields(strings.Join(texts[i:j], " ")) {
+			if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+				res = append(res, line)
+				line = ""
+			}
+			line += " " + word
+		}
+		res = append(res, line)
+		i = j
+	}
+	if !changed {
+		return nil
+	}
+	return res
+}
+
+// isProse reports whether text, a line of a //-style comment without its
+// marker, may be re-wrapped by reflowComment: it is not blank, neither a
+// directive nor indented code (which don't start with exactly one blank),
+// and holds no URL.
+func isProse(text string) bool {
+	return len(text) > 1 && text[0] == ' ' && text[1] != ' ' && text[1] != '\t' && !strings.Contains(text, "://")
+}
+
+// isDirective reports whether text, a line of a //-style comment without
+// its marker, is a directive to a tool, such as go:generate, lint:ignore
+// or nolint, which must be written exactly as it is: neither reflowed nor
+// with its tabs expanded. Trailing blanks are trimmed as for any comment.
+func isDirective(text string) bool {
+	// "//line " is a line directive, "//extern " is for gccgo,
+	// "//export " for cgo, "//nolint" for golangci-lint
+	for _, prefix := range []string{"line ", "extern ", "export ", "nolint"} {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	// "//[a-z0-9]+:[a-z0-9]"
+	colon := strings.Index(text, ":")
+	if colon <= 0 || colon+1 >= len(text) {
+		return false
+	}
+	for i := 0; i <= colon+1; i++ {
+		if b := text[i]; i != colon && !('a' <= b && b <= 'z' || '0' <= b && b <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// lineFilename returns the filename that the line directive comment, naming
+// filename, sets for the following line. The file set resolves relative
+// names, so its position for the start of the next line is used if the
+// comment is followed by one.
+func (p *printer) lineFilename(comment *ast.Comment, filename string) string {
+	if f := p.fset.File(comment.Pos()); f != nil {
+		if next := comment.End() + 1; int(next) < f.Base()+f.Size() {
+			return p.posFor(next).Filename
+		}
+	}
+	return filename
+}
+
 func (p *printer) writeComment(comment *ast.Comment) {
 	text := comment.Text
 	pos := p.posFor(comment.Pos())
 
-	const linePrefix = "#line "
-	if strings.HasPrefix(text, linePrefix) && (!pos.IsValid() || pos.Column == 1) {
+	const linePrefix = "//line "
+	// the column must be checked in the unadjusted position: a preceding
+	// line directive without column leaves the adjusted one unknown
+	if strings.HasPrefix(text, linePrefix) && (!pos.IsValid() || p.fset.PositionFor(comment.Pos(), false).Column == 1) {
 		// possibly a line directive
 		ldir := strings.TrimSpace(text[len(linePrefix):])
 		if i := strings.LastIndex(ldir, ":"); i >= 0 {
@@ -475,12 +701,19 @@ func (p *printer) writeComment(comment *ast.Comment) {
 				// the Filename and Line number used for subsequent
 				// tokens. We have to update our AST-space position
 				// accordingly and suspend indentation temporarily.
+				// The directive is copied to the output as well, so
+				// the output position changes the same way once the
+				// line is terminated; otherwise atLineBegin would
+				// emit a second, redundant //line comment.
+				filename := p.lineFilename(comment, ldir[:i])
 				indent := p.indent
 				p.indent = 0
 				defer func() {
-					p.pos.Filename = ldir[:i]
-					p.pos.Line = line
+					p.pos.Filename = filename
+					p.pos.Line = line - 1
 					p.pos.Column = 1
+					p.out.Filename = filename
+					p.out.Line = line - 1
 					p.indent = indent
 				}()
 			}
@@ -490,7 +723,13 @@ func (p *printer) writeComment(comment *ast.Comment) {
 	// shortcut common case of //-style comments
 	if text[1] == '/' {
 		text := "#" + text[2:]
-		p.writeString(pos, trimRight(text), true)
+		if p.Mode&KeepCommentSpace == 0 {
+			text = trimRight(text)
+		}
+		if p.ExpandCommentTabs && !isDirective(text[1:]) {
+			text = expandTabs(text, 0, p.Tabwidth)
+		}
+		p.writeString(pos, text, true)
 		return
 	}
 
@@ -520,11 +759,56 @@ func (p *printer) writeComment(comment *ast.Comment) {
 			pos = p.pos
 		}
 		if len(line) > 0 {
+			if p.ExpandCommentTabs {
+				line = expandTabs(line, 0, p.Tabwidth)
+			}
 			p.writeString(pos, trimRight(line), true)
 		}
 	}
 }
 
+// reflowDoc returns the lines of the documentation comment group g, without
+// comment marker, re-wrapped as ReflowDocComments requires, or nil if they
+// are kept as they are.
+func (p *printer) reflowDoc(g *ast.CommentGroup) []string {
+	if !p.docs[g] || p.MaxColumn <= 0 {
+		return nil
+	}
+	texts := make([]string, len(g.List))
+	for i, c := range g.List {
+		if c.Text[1] != '/' || strings.HasPrefix(c.Text, "//line ") {
+			return nil // left to writeComment
+		}
+		texts[i] = c.Text[2:]
+		if p.Mode&KeepCommentSpace == 0 {
+			texts[i] = trimRight(texts[i])
+		}
+		if p.ExpandCommentTabs && !isDirective(texts[i]) {
+			texts[i] = expandTabs(texts[i], len("#"), p.Tabwidth)
+		}
+	}
+	width := p.MaxColumn - (p.Indent+p.indent)*p.Tabwidth - len("#")
+	return reflowComment(texts, width)
+}
+
+// writeDoc writes lines, the re-wrapped text of the documentation comment
+// group g, and returns the last comment of g. The source position is then
+// the end of g, whatever the number of lines written.
+func (p *printer) writeDoc(g *ast.CommentGroup, lines []string) *ast.Comment {
+	pos := p.posFor(g.Pos())
+	for i, line := range lines {
+		if i > 0 {
+			p.writeByte('\f', 1)
+			pos = p.pos
+		}
+		p.writeString(pos, "#"+line, true)
+	}
+	last := g.List[len(g.List)-1]
+	p.pos = p.posFor(last.End())
+	p.last = p.pos
+	return last
+}
+
 // writeCommentSuffix writes a line break after a comment if indicated
 // and processes any leftover indentation information. If a line break
 // is needed, the kind of break (newline vs formfeed) depends on the
@@ -532,7 +816,7 @@ func (p *printer) writeComment(comment *ast.Comment) {
 // newline was written or if a formfeed was dropped from the whitespace
 // buffer.
 //
-func (p *printer) writeCommentSuffix(needsLinebreak bool) (wroteNewline, droppedFF bool) {
+func (p *printer) writeCommentSuffix(needsLinebreak, trailing bool) (wroteNewline, droppedFF bool) {
 	for i, ch := range p.wsbuf {
 		switch ch {
 		case blank, vtab:
@@ -543,12 +827,15 @@ func (p *printer) writeCommentSuffix(needsLinebreak bool) (wroteNewline, dropped
 		case newline, formfeed:
 			// if we need a line break, keep exactly one
 			// but remember if we dropped any formfeeds
-			if needsLinebreak {
+			if needsLinebreak || trailing {
 				needsLinebreak = false
 				wroteNewline = true
 			} else {
 				if ch == formfeed {
 					droppedFF = true
+					if p.Stats != nil {
+						p.Stats.DroppedFFs++
+					}
 				}
 				p.wsbuf[i] = ignore
 			}
@@ -573,19 +860,41 @@ func (p *printer) writeCommentSuffix(needsLinebreak bool) (wroteNewline, dropped
 //
 func (p *printer) intersperseComments(next token.Position, tok token.Token) (wroteNewline, droppedFF bool) {
 	var last *ast.Comment
+	trailing := false // the comments are on the line of the last item
+	folded := p.last.Line + p.elided
 	for p.commentBefore(next) {
-		for _, c := range p.comment.List {
-			// if the last comment is a /*-style comment and the next item
-			// follows on the same line but is not a comma or a "closing"
-			// token, drop it
+		for i, c := range p.comment.List {
+			// iGo has no /*-style comments: if the next item follows on
+			// the same line, drop the comment together with its
+			// separator, the pending white space separates the items
 			if c.Text[1] == '*' && p.lineFor(c.Pos()) == next.Line {
-				p.writeByte(' ', 1)
 				continue
 			}
+			// nor can a line be broken after some keywords, as Go
+			// allows: drop a comment between one and the next item
+			if noBreakAfter(p.wroteTok) && p.pendingLinebreaks() == 0 {
+				continue
+			}
+			trailing = p.onLastLine(p.lineFor(c.Pos()))
 			p.writeCommentPrefix(p.posFor(c.Pos()), next, last, c, tok)
+			if i == 0 {
+				if lines := p.reflowDoc(p.comment); lines != nil {
+					// the whole group is written at once
+					last = p.writeDoc(p.comment, lines)
+					break
+				}
+			}
 			p.writeComment(c)
 			last = c
 		}
+		if trailing && p.pos.Line < folded {
+			// the comment precedes lines folded into its own: keep them
+			p.elided = folded - p.pos.Line
+			p.pos.Line = folded
+		}
+		if p.Stats != nil {
+			p.Stats.Comments += len(p.comment.List)
+		}
 		p.nextComment()
 	}
 
@@ -596,17 +905,46 @@ func (p *printer) intersperseComments(next token.Position, tok token.Token) (wro
 			last.Text[1] == '/' ||
 				tok == token.RBRACE && p.mode&noExtraLinebreak == 0 ||
 				tok == token.EOF
-		return p.writeCommentSuffix(needsLinebreak)
+		return p.writeCommentSuffix(needsLinebreak, trailing)
 	}
 
-	// no comment was written - we should never reach here since
-	// intersperseComments should not be called in that case
-	p.internalError("intersperseComments called without pending comments")
+	// all comments were dropped: write the leftover whitespace as if
+	// there were none
+	p.writeWhitespace(len(p.wsbuf))
+	return
+}
+
+// noBreakAfter reports whether a line break after tok, which Go ignores,
+// ends the line in iGo: after the keyword starting a declaration, a
+// struct or interface type or the header of a statement, or after the
+// period of a selector.
+func noBreakAfter(tok token.Token) bool {
+	switch tok {
+	case token.PERIOD, token.PACKAGE, token.IMPORT, token.VAR, token.CONST, token.TYPE, token.FUNC,
+		token.STRUCT, token.INTERFACE,
+		token.IF, token.ELSE, token.FOR, token.SWITCH, token.SELECT, token.RANGE:
+		return true
+	}
+	return false
+}
+
+// pendingLinebreaks returns the number of line breaks in the whitespace
+// buffer, as after the keyword of a declaration group.
+func (p *printer) pendingLinebreaks() (n int) {
+	for _, ch := range p.wsbuf {
+		if ch == newline || ch == formfeed {
+			n++
+		}
+	}
 	return
 }
 
 // whiteWhitespace writes the first n whitespace entries.
 func (p *printer) writeWhitespace(n int) {
+	if p.Stats != nil {
+		p.Stats.Whitespace += n
+	}
+
 	// write entries
 	for i := 0; i < n; i++ {
 		switch ch := p.wsbuf[i]; ch {
@@ -614,6 +952,9 @@ func (p *printer) writeWhitespace(n int) {
 			// ignore!
 		case indent:
 			p.indent++
+			if p.Stats != nil && p.indent > p.Stats.MaxIndent {
+				p.Stats.MaxIndent = p.indent
+			}
 		case unindent:
 			p.indent--
 			if p.indent < 0 {
@@ -621,7 +962,7 @@ func (p *printer) writeWhitespace(n int) {
 				p.indent = 0
 			}
 		case newline, formfeed:
-			if p.consBrakes > 0 {
+			if p.consBrakes >= p.maxNewlines()-1 {
 				continue
 			}
 			// A line break immediately followed by a "correcting"
@@ -655,17 +996,61 @@ func (p *printer) writeWhitespace(n int) {
 	p.wsbuf = p.wsbuf[0:i]
 }
 
+// applyUnindents applies the unindents of the whitespace buffer and
+// removes them from it, keeping the other entries pending. Indentation
+// is only written before the next text, thus it is as if they were
+// applied after the line breaks buffered before them.
+func (p *printer) applyUnindents() {
+	j := 0
+	for _, ch := range p.wsbuf {
+		if ch == unindent {
+			p.indent--
+			if p.indent < 0 {
+				p.internalError("negative indentation:", p.indent)
+				p.indent = 0
+			}
+			continue
+		}
+		p.wsbuf[j] = ch
+		j++
+	}
+	p.wsbuf = p.wsbuf[:j]
+}
+
+// close ends the construct closed by tok at pos, which is not printed in
+// iGo, where tok would be: the comments before pos are printed in the
+// construct, those after it outside, however many constructs end there.
+func (p *printer) close(pos token.Pos, tok token.Token) {
+	if next := p.posFor(pos); p.commentBefore(next) {
+		p.flush(next, tok)
+	} else {
+		p.applyUnindents()
+	}
+}
+
 // ----------------------------------------------------------------------------
 // Printing interface
 
-// nlines limits n to maxNewlines.
-func nlimit(n int) int {
-	if n > maxNewlines {
-		n = maxNewlines
+// nlimit limits n to the maximum number of newlines between source text.
+func (p *printer) nlimit(n int) int {
+	if max := p.maxNewlines(); n > max {
+		n = max
 	}
 	return n
 }
 
+// maxNewlines returns the maximum number of newlines between source text:
+// maxNewlines, unless the blank lines of the source are preserved.
+func (p *printer) maxNewlines() int {
+	switch {
+	case !p.PreserveBlankLines:
+		return maxNewlines
+	case p.MaxBlankLines > 0:
+		return p.MaxBlankLines + 1
+	}
+	return defaultMaxBlankLines + 1
+}
+
 func mayCombine(prev token.Token, next byte) (b bool) {
 	switch prev {
 	case token.INT:
@@ -742,6 +1127,9 @@ func (p *printer) print(args ...interface{}) {
 
 		case *ast.BasicLit:
 			data = x.Value
+			if p.FormatNumber != nil && (x.Kind == token.INT || x.Kind == token.FLOAT) {
+				data = p.FormatNumber(x)
+			}
 			isLit = true
 			impliedSemi = true
 			p.lastTok = x.Kind
@@ -788,22 +1176,23 @@ func (p *printer) print(args ...interface{}) {
 			p.lastTok = token.STRING
 
 		default:
-			fmt.Fprintf(os.Stderr, "print: unsupported argument %v (%T)\n", arg, arg)
-			panic("github.com/DAddYE/igo/from_go printer type")
+			// incorrect AST - stop printing, printNode reports it
+			panic(unsupportedArg{p.pos, arg})
 		}
 		// data != ""
 
 		next := p.pos // estimated/accurate position of next item
 		wroteNewline, droppedFF := p.flush(next, p.lastTok)
+		p.declLines = 0 // only for the comments before the declaration
 
 		// intersperse extra newlines if present in the source and
 		// if they don't cause extra semicolons (don't do this in
 		// flush as it will cause extra newlines at the end of a file)
 		if !p.impliedSemi {
-			n := nlimit(next.Line - p.pos.Line)
+			n := p.nlimit(next.Line - p.pos.Line)
 			// don't exceed maxNewlines if we already wrote one
-			if wroteNewline && n == maxNewlines {
-				n = maxNewlines - 1
+			if max := p.maxNewlines(); wroteNewline && n == max {
+				n = max - 1
 			}
 			if n > 0 {
 				ch := byte('\n')
@@ -816,6 +1205,7 @@ func (p *printer) print(args ...interface{}) {
 		}
 
 		p.writeString(next, data, isLit)
+		p.wroteTok = p.lastTok
 		p.impliedSemi = impliedSemi
 	}
 }
@@ -865,7 +1255,79 @@ func getDoc(n ast.Node) *ast.CommentGroup {
 	return nil
 }
 
-func (p *printer) printNode(node interface{}) error {
+// collectDocs records in p.docs the documentation comment groups of node
+// and of the nodes it contains.
+func (p *printer) collectDocs(node interface{}) {
+	p.docs = make(map[*ast.CommentGroup]bool)
+	visit := func(n ast.Node) bool {
+		if doc := getDoc(n); doc != nil {
+			p.docs[doc] = true
+		}
+		return true
+	}
+	switch n := node.(type) {
+	case ast.Node:
+		ast.Inspect(n, visit)
+	case []ast.Decl:
+		for _, d := range n {
+			ast.Inspect(d, visit)
+		}
+	case []ast.Stmt:
+		for _, s := range n {
+			ast.Inspect(s, visit)
+		}
+	}
+}
+
+// An unsupportedArg panic is raised by print for an argument it cannot
+// print, at the AST position pos.
+type unsupportedArg struct {
+	pos token.Position
+	arg interface{}
+}
+
+func (e unsupportedArg) Error() string {
+	return fmt.Sprintf("%s: github.com/DAddYE/igo/from_go: unsupported argument %v (%T)", e.pos, e.arg, e.arg)
+}
+
+// An unsupportedNode panic is raised for a node which cannot be written in
+// iGo, though valid Go syntax, at the AST position pos.
+type unsupportedNode struct {
+	pos  token.Position
+	what string
+}
+
+func (e unsupportedNode) Error() string {
+	return fmt.Sprintf("%s: github.com/DAddYE/igo/from_go: %s cannot be written in iGo", e.pos, e.what)
+}
+
+// A tooDeep panic is raised by expr1 for an expression nested more than
+// max levels deep, at the AST position pos.
+type tooDeep struct {
+	pos token.Position
+	max int
+}
+
+func (e tooDeep) Error() string {
+	return fmt.Sprintf("%s: github.com/DAddYE/igo/from_go: expression nested more than %d levels deep", e.pos, e.max)
+}
+
+func (p *printer) printNode(node interface{}) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch e := e.(type) {
+			case unsupportedArg:
+				err = e
+			case unsupportedNode:
+				err = e
+			case tooDeep:
+				err = e
+			default:
+				panic(e)
+			}
+		}
+	}()
+
 	// unpack *CommentedNode, if any
 	var comments []*ast.CommentGroup
 	if cnode, ok := node.(*CommentedNode); ok {
@@ -889,9 +1351,11 @@ func (p *printer) printNode(node interface{}) error {
 			beg = doc.Pos()
 		}
 		// token.Pos values are global offsets, we can
-		// compare them directly
+		// compare them directly; a comment group ending
+		// right before the node (e.g., a synthetic one)
+		// is its documentation too
 		i := 0
-		for i < len(comments) && comments[i].End() < beg {
+		for i < len(comments) && comments[i].End()+1 < beg {
 			i++
 		}
 		j := i
@@ -902,6 +1366,8 @@ func (p *printer) printNode(node interface{}) error {
 			p.comments = comments[i:j]
 		}
 	} else if n, ok := node.(*ast.File); ok {
+		n, p.joined = p.filterFile(n)
+		node = n
 		// use ast.File comments, if any
 		p.comments = n.Comments
 	}
@@ -909,6 +1375,10 @@ func (p *printer) printNode(node interface{}) error {
 	// if there are no comments, use node comments
 	p.useNodeComments = p.comments == nil
 
+	if p.ReflowDocComments {
+		p.collectDocs(node)
+	}
+
 	// get comments ready for use
 	p.nextComment()
 
@@ -935,7 +1405,11 @@ func (p *printer) printNode(node interface{}) error {
 	return nil
 
 unsupported:
-	return fmt.Errorf("github.com/DAddYE/igo/printer: unsupported node type %T", node)
+	pos := p.pos
+	if n, ok := node.(ast.Node); ok {
+		pos = p.posFor(n.Pos())
+	}
+	return fmt.Errorf("%s: github.com/DAddYE/igo/from_go: unsupported node type %T", pos, node)
 }
 
 // ----------------------------------------------------------------------------
@@ -945,12 +1419,15 @@ unsupported:
 // characters, trailing blanks and tabs, and for converting formfeed
 // and vtab characters into newlines and htabs (in case no tabwriter
 // is used). Text bracketed by tabwriter.Escape characters is passed
-// through unchanged.
+// through unchanged, but for the carriage returns of its "\r\n" line
+// endings if forceLF is set.
 //
 type trimmer struct {
-	output io.Writer
-	state  int
-	space  []byte
+	output  io.Writer
+	state   int
+	space   []byte
+	forceLF bool
+	blanks  bool // write the tabs separating cells as blanks
 }
 
 // trimmer is implemented as a state machine.
@@ -986,6 +1463,9 @@ func (p *trimmer) Write(data []byte) (n int, err error) {
 		if b == '\v' {
 			b = '\t' // convert to htab
 		}
+		if b == '\t' && p.blanks && p.state != inEscape {
+			b = ' '
+		}
 		switch p.state {
 		case inSpace:
 			switch b {
@@ -1004,9 +1484,13 @@ func (p *trimmer) Write(data []byte) (n int, err error) {
 				m = n
 			}
 		case inEscape:
-			if b == tabwriter.Escape {
+			switch {
+			case b == tabwriter.Escape:
 				_, err = p.output.Write(data[m:n])
 				p.resetSpace()
+			case b == '\n' && p.forceLF && n > m && data[n-1] == '\r':
+				_, err = p.output.Write(data[m : n-1])
+				m = n
 			}
 		case inText:
 			switch b {
@@ -1048,10 +1532,12 @@ func (p *trimmer) Write(data []byte) (n int, err error) {
 type Mode uint
 
 const (
-	RawFormat Mode = 1 << iota // do not use a tabwriter; if set, UseSpaces is ignored
-	TabIndent                  // use tabs for indentation independent of UseSpaces
-	UseSpaces                  // use spaces instead of tabs for alignment
-	SourcePos                  // emit //line comments to preserve original source positions
+	RawFormat        Mode = 1 << iota // do not use a tabwriter; with UseSpaces, indent and separate with spaces, unaligned
+	TabIndent                         // use tabs for indentation independent of UseSpaces
+	UseSpaces                         // use spaces instead of tabs for alignment
+	SourcePos                         // emit //line comments to preserve original source positions
+	KeepCommentSpace                  // keep trailing white space in //-style comments
+	SourceLines                       // keep line breaks from the source, only normalize white space
 )
 
 // A Config node controls the output of Fprint.
@@ -1059,10 +1545,125 @@ type Config struct {
 	Mode     Mode // default: 0
 	Tabwidth int  // default: 8
 	Indent   int  // default: 0 (all code is indented at least by this much)
+
+	// In UseSpaces mode without TabIndent, each level of indentation is
+	// expanded to DisplayTabwidth spaces while Tabwidth remains the
+	// width of alignment cells. Default: Tabwidth. With RawFormat too,
+	// there is no alignment and cells are separated by a blank.
+	DisplayTabwidth int
+
+	// If set, the output starts with a UTF-8 byte order mark, e.g. to
+	// keep the one of the source.
+	PreserveBOM bool
+
+	// If set, tabs in the text of comments are expanded to blanks, with
+	// tab stops every Tabwidth columns from the start of the comment (or
+	// of the line, for the following lines of a /*-style comment).
+	ExpandCommentTabs bool
+
+	// If set, the blank lines between source text are kept as they are,
+	// up to MaxBlankLines (default: 10) in a row, instead of being
+	// collapsed into one.
+	PreserveBlankLines bool
+	MaxBlankLines      int
+
+	// If set, no block is printed on the line of its header (after a
+	// ':') unless it is empty, so that every statement gets a line of
+	// its own. The semicolons of clauses, e.g. in a for statement header,
+	// are not affected.
+	OneStatementPerLine bool
+
+	// If set, the final newline of the output, if any, is dropped, e.g.
+	// to concatenate fragments. Only one newline is removed.
+	NoFinalNewline bool
+
+	// If set together with MaxColumn, the paragraphs of documentation
+	// comments with lines ending past MaxColumn are re-wrapped at blanks
+	// to fit, indentation included. Directives, lines holding a URL and
+	// code blocks, indented further than the text, are kept as they are.
+	ReflowDocComments bool
+	MaxColumn         int
+
+	// If set, only the declarations of a file for which FilterDecls
+	// returns true are printed, with the comments outside the others.
+	// Imports are not passed to FilterDecls: they are pruned as with
+	// PruneUnusedImports, keeping those the declarations printed use.
+	FilterDecls func(ast.Decl) bool
+
+	// If set, the imports of a file whose package name no selector refers
+	// to are dropped, with their comments. The name is guessed from the
+	// import path if not given. Blank and dot imports are kept, as are
+	// those whose name is guessed when it cannot be relied on: it is not
+	// an identifier (e.g. the path ends in go-foo), the path ends in a
+	// version suffix such as v2, or a selector refers to a package name
+	// which is not that of an import, nor declared in the file.
+	PruneUnusedImports bool
+
+	// If set, the "\r\n" line endings within literals and comments are
+	// written as "\n", as are all the others. This changes the value of
+	// raw string literals. The scanner already drops carriage returns
+	// from those and comments, so only literals of syntax trees built or
+	// rewritten otherwise are affected.
+	ForceLF bool
+
+	// If set, printing an expression nested more than MaxDepth levels
+	// deep, e.g. a generated a+a+...+a, fails with an error instead of
+	// possibly exhausting the stack.
+	MaxDepth int
+
+	// If set, the first declaration of a file after the package clause
+	// and the imports, with its documentation, is not preceded by a blank
+	// line, even if the source has one; if not, there is one, as gofmt
+	// does, even if the source has none. Ignored in SourceLines mode.
+	NoBlankBeforeFirstDecl bool
+
+	// If set, BeforeDecl and AfterDecl are called for each declaration of
+	// a declaration list, e.g. the top-level ones of a file, and what they
+	// write is printed as it is, on lines of its own indented as the
+	// declaration. The output of BeforeDecl precedes the documentation of
+	// the declaration, after the other comments before it; the output of
+	// AfterDecl follows the comments on the last line of the declaration.
+	BeforeDecl func(ast.Decl, io.Writer)
+	AfterDecl  func(ast.Decl, io.Writer)
+
+	// If set, a binary expression is printed with blanks around its
+	// operator only where the source has white space, e.g. a*b+c * d as
+	// it is, instead of as the precedence of its operators asks for, e.g.
+	// a*b + c*d. The output is not canonical: it is meant to round-trip
+	// hand-formatted sources. Expressions without positions are printed
+	// as usual.
+	PreserveExprSpacing bool
+
+	// If set, FormatNumber is called for every integer and floating-point
+	// literal printed and the text it returns is printed instead of the
+	// literal's, e.g. to add digit separators or to lowercase hexadecimal
+	// digits. The text must be a valid literal of the same kind and value:
+	// it is not checked.
+	FormatNumber func(*ast.BasicLit) string
+
+	// If set, Stats is filled in with what the printer did by each call
+	// to Fprint, for diagnostics and benchmarks.
+	Stats *PrinterStats
+}
+
+// PrinterStats counts what the printer did while printing a node, see
+// Config.Stats.
+type PrinterStats struct {
+	Comments   int // comments written
+	DroppedFFs int // formfeeds dropped from the whitespace after comments
+	MaxIndent  int // deepest indentation written, in levels
+	Whitespace int // whitespace entries flushed, ignored ones included
 }
 
+// bom is the UTF-8 encoding of the byte order mark.
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
 // fprint implements Fprint and takes a nodesSizes map for setting up the printer state.
 func (cfg *Config) fprint(output io.Writer, fset *token.FileSet, node interface{}, nodeSizes map[ast.Node]int) (err error) {
+	if cfg.Stats != nil {
+		*cfg.Stats = PrinterStats{}
+	}
+
 	// print node
 	var p printer
 	p.init(cfg, fset, nodeSizes)
@@ -1073,11 +1674,30 @@ func (cfg *Config) fprint(output io.Writer, fset *token.FileSet, node interface{
 	p.impliedSemi = false // EOF acts like a newline
 	p.flush(token.Position{Offset: infinity, Line: infinity}, token.EOF)
 
+	// write the byte order mark before anything else, directly: the
+	// trimmer must not see it
+	if cfg.PreserveBOM {
+		if _, err = output.Write(bom); err != nil {
+			return
+		}
+	}
+
+	// hold the output back if its final newline is to be dropped
+	dest := output
+	var held bytes.Buffer
+	if cfg.NoFinalNewline {
+		output = &held
+	}
+
 	// redirect output through a trimmer to eliminate trailing whitespace
 	// (Input to a tabwriter must be untrimmed since trailing tabs provide
 	// formatting information. The tabwriter could provide trimming
 	// functionality but no tabwriter is used when RawFormat is set.)
-	output = &trimmer{output: output}
+	output = &trimmer{
+		output:  output,
+		forceLF: cfg.ForceLF,
+		blanks:  cfg.Mode&(RawFormat|TabIndent|UseSpaces) == RawFormat|UseSpaces,
+	}
 
 	// redirect output through a tabwriter if necessary
 	if cfg.Mode&RawFormat == 0 {
@@ -1104,7 +1724,13 @@ func (cfg *Config) fprint(output io.Writer, fset *token.FileSet, node interface{
 
 	// flush tabwriter, if any
 	if tw, _ := output.(*tabwriter.Writer); tw != nil {
-		err = tw.Flush()
+		if err = tw.Flush(); err != nil {
+			return
+		}
+	}
+
+	if cfg.NoFinalNewline {
+		_, err = dest.Write(bytes.TrimSuffix(held.Bytes(), []byte{'\n'}))
 	}
 
 	return
@@ -1113,6 +1739,11 @@ func (cfg *Config) fprint(output io.Writer, fset *token.FileSet, node interface{
 // A CommentedNode bundles an AST node and corresponding comments.
 // It may be provided as argument to any of the Fprint functions.
 //
+// Only the comments of Comments, sorted, within the node, its Doc
+// included, are printed, or ending at most one byte before it: a comment
+// group built to document a node, positioned there (e.g., its last
+// comment starting at Node.Pos()-1), is printed as its documentation.
+//
 type CommentedNode struct {
 	Node     interface{} // *ast.File, or ast.Expr, ast.Decl, ast.Spec, or ast.Stmt
 	Comments []*ast.CommentGroup
@@ -1127,6 +1758,19 @@ func (cfg *Config) Fprint(output io.Writer, fset *token.FileSet, node interface{
 	return cfg.fprint(output, fset, node, make(map[ast.Node]int))
 }
 
+// FprintWithCache is like Fprint but uses cache to remember the sizes of
+// the nodes it prints, so that formatting many nodes of the same AST one
+// after the other does not compute the same sizes again. The cache must
+// only be shared between calls using the same file set fset and the same
+// cfg. A nil cache behaves like Fprint.
+//
+func (cfg *Config) FprintWithCache(output io.Writer, fset *token.FileSet, node interface{}, cache map[ast.Node]int) error {
+	if cache == nil {
+		cache = make(map[ast.Node]int)
+	}
+	return cfg.fprint(output, fset, node, cache)
+}
+
 // Fprint "pretty-prints" an AST node to output.
 // It calls Config.Fprint with default settings.
 //
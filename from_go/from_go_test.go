@@ -0,0 +1,792 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package from_go
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	igoast "github.com/DAddYE/igo/ast"
+	igoparser "github.com/DAddYE/igo/parser"
+	igotoken "github.com/DAddYE/igo/token"
+)
+
+// testConfig is the configuration of the igo command, the one the tests
+// print with unless they are about another.
+var testConfig = Config{Mode: UseSpaces | TabIndent, Tabwidth: 8}
+
+// igoSource parses src as Go and returns it printed as iGo with cfg.
+func igoSource(t *testing.T, cfg *Config, src string) string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	var buf bytes.Buffer
+	if err := cfg.Fprint(&buf, fset, file); err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	return buf.String()
+}
+
+// A printTest is a Go source and the iGo it is printed as.
+type printTest struct {
+	src, out string
+}
+
+// runPrintTests prints the sources of tests with cfg and compares the
+// results to the expected ones.
+func runPrintTests(t *testing.T, cfg *Config, tests []printTest) {
+	for _, test := range tests {
+		if got := igoSource(t, cfg, test.src); got != test.out {
+			t.Errorf("%q:\ngot\n%s\nwant\n%s", test.src, got, test.out)
+		}
+	}
+}
+
+var keepCommentSpaceTests = []struct {
+	mode Mode
+	printTest
+}{
+	{0, printTest{"package p\n\n// art  \n// | x |\t\nvar a = 1 // one  \n",
+		"package p\n\n# art\n# | x |\nvar a = 1 # one\n"}},
+	{KeepCommentSpace, printTest{"package p\n\n// art  \n// | x |\t\nvar a = 1 // one  \n",
+		"package p\n\n# art  \n# | x |\t\nvar a = 1 # one  \n"}},
+	{KeepCommentSpace, printTest{"package p\n\n/* block  \n   comment */\nvar a = 1\n",
+		"package p\n\n# block\n#   comment\nvar a = 1\n"}},
+}
+
+func TestKeepCommentSpace(t *testing.T) {
+	for _, test := range keepCommentSpaceTests {
+		cfg := testConfig
+		cfg.Mode |= test.mode
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
+
+// full and lines are the outputs without and with SourceLines.
+var sourceLinesTests = []struct {
+	src, full, lines string
+}{
+	{"package p\n\nfunc f() {\n\treturn\n}\n",
+		"package p\n\nfunc f(): return\n",
+		"package p\n\nfunc f()\n\treturn\n\n"},
+	{"package p\nvar a = 1\nvar b = 2\nfunc f() {\n}\n",
+		"package p\n\nvar a = 1\nvar b = 2\n\nfunc f():\n",
+		"package p\nvar a = 1\nvar b = 2\nfunc f():\n"},
+	{"package p\n\nfunc f(a,\n\tb int) {\n\tx := []int{1,\n\t\t2}\n\t_ = x\n}\n",
+		"package p\n\nfunc f(a,\n\tb int)\n\tx := []int{1,\n\t\t2}\n\t_ = x\n\n",
+		"package p\n\nfunc f(a,\n\tb int)\n\tx := []int{1,\n\t\t2}\n\t_ = x\n\n"},
+}
+
+func TestSourceLines(t *testing.T) {
+	for _, test := range sourceLinesTests {
+		cfg := testConfig
+		cfg.Mode |= SourceLines
+		runPrintTests(t, &testConfig, []printTest{{test.src, test.full}})
+		runPrintTests(t, &cfg, []printTest{{test.src, test.lines}})
+	}
+}
+
+// An identLines collects the name of each identifier of a file, in order,
+// with the filename and line of its position, //line directives applied.
+type identLines struct {
+	fset *token.FileSet
+	list []string
+}
+
+func (v *identLines) Visit(n ast.Node) ast.Visitor {
+	if id, ok := n.(*ast.Ident); ok {
+		pos := v.fset.Position(id.Pos())
+		v.list = append(v.list, fmt.Sprintf("%s %s:%d", id.Name, pos.Filename, pos.Line))
+	}
+	return v
+}
+
+// igoIdentLines is identLines for iGo files.
+type igoIdentLines struct {
+	fset *igotoken.FileSet
+	list []string
+}
+
+func (v *igoIdentLines) Visit(n igoast.Node) igoast.Visitor {
+	if id, ok := n.(*igoast.Ident); ok {
+		pos := v.fset.Position(id.Pos())
+		v.list = append(v.list, fmt.Sprintf("%s %s:%d", id.Name, pos.Filename, pos.Line))
+	}
+	return v
+}
+
+var lineDirectiveTests = []string{
+	"package p\n\n//line a.go:10\nfunc f() {\n\treturn\n}\n\n//line b.go:20\nfunc g() {\n\tx := 1\n\n\n\t_ = x\n}\n",
+	"package p\n\nvar a = 1\n//line a.go:5\nvar b = 2\n//line b.go:7\nvar c = 3\n//line a.go:1\nvar d = 4\n",
+	"package p\n\n//line gen/a.go:100\nfunc f(x int) int {\n\tif x > 0 {\n\t\t//line gen/b.go:3\n\t\treturn x\n\t}\n\treturn -x\n}\n",
+}
+
+// TestLineDirectives checks that, with SourcePos, the identifiers of the
+// iGo output are at the positions of the Go ones, even when //line
+// directives change the filename mid-file.
+func TestLineDirectives(t *testing.T) {
+	cfg := testConfig
+	cfg.Mode |= SourcePos
+	for _, src := range lineDirectiveTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		var buf bytes.Buffer
+		if err := cfg.Fprint(&buf, fset, file); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		igofset := igotoken.NewFileSet()
+		igofile, err := igoparser.ParseFile(igofset, "test.igo", buf.Bytes(), igoparser.ParseComments)
+		if err != nil {
+			t.Fatalf("%q: %v\n%s", src, err, buf.Bytes())
+		}
+		want, got := &identLines{fset: fset}, &igoIdentLines{fset: igofset}
+		ast.Walk(want, file)
+		igoast.Walk(got, igofile)
+		if g, w := strings.Join(got.list, "\n"), strings.Join(want.list, "\n"); g != w {
+			t.Errorf("%q: positions\n%s\nwant\n%s\noutput\n%s", src, g, w, buf.Bytes())
+		}
+	}
+}
+
+var bomTests = []struct {
+	src  string
+	keep bool
+	out  string
+}{
+	{"package p\n", false, "package p\n"},
+	{"package p\n", true, "\ufeffpackage p\n"},
+	{"\ufeffpackage p\n", false, "package p\n"},
+	{"\ufeff// Package p.\npackage p\n", true, "\ufeff# Package p.\npackage p\n"},
+	{"\ufeff  \n\tpackage p\n", true, "\ufeffpackage p\n"}, // not mistaken for white space
+}
+
+func TestPreserveBOM(t *testing.T) {
+	for _, test := range bomTests {
+		cfg := testConfig
+		cfg.PreserveBOM = test.keep
+		runPrintTests(t, &cfg, []printTest{{test.src, test.out}})
+	}
+}
+
+// off and on are the outputs without and with OneStatementPerLine.
+var oneStatementPerLineTests = []struct {
+	src, off, on string
+}{
+	{"package p\n\nfunc f() int { return 1 }\n",
+		"package p\n\nfunc f() int: return 1\n",
+		"package p\n\nfunc f() int\n\treturn 1\n\n"},
+	// empty bodies and the semicolons of clauses are kept
+	{"package p\n\nfunc f() {}\n\nfunc g(s []int) {\n\tfor i := 0; i < len(s); i++ {\n\t\tif x := s[i]; x > 0 {\n\t\t\tbreak\n\t\t}\n\t}\n}\n",
+		"package p\n\nfunc f():\n\nfunc g(s []int)\n\tfor i := 0; i < len(s); i++\n\t\tif x := s[i]; x > 0\n\t\t\tbreak\n\n",
+		"package p\n\nfunc f():\n\nfunc g(s []int)\n\tfor i := 0; i < len(s); i++\n\t\tif x := s[i]; x > 0\n\t\t\tbreak\n\n"},
+}
+
+func TestOneStatementPerLine(t *testing.T) {
+	for _, test := range oneStatementPerLineTests {
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{test.src, test.off}})
+		cfg.OneStatementPerLine = true
+		runPrintTests(t, &cfg, []printTest{{test.src, test.on}})
+	}
+}
+
+var blankBeforeFirstDeclTests = []struct {
+	src, noBlank, blank string
+}{
+	{"package p\nfunc F() {}\n",
+		"package p\nfunc F():\n",
+		"package p\n\nfunc F():\n"},
+	{"package p\n\nimport \"fmt\"\n\nvar x = fmt.Sprint()\n",
+		"package p\n\nimport \"fmt\"\nvar x = fmt.Sprint()\n",
+		"package p\n\nimport \"fmt\"\n\nvar x = fmt.Sprint()\n"},
+	// the blank line goes before the doc comment of the declaration
+	{"package p\n\nimport \"fmt\"\n// X is printed.\nvar X = fmt.Sprint()\n",
+		"package p\n\nimport \"fmt\"\n# X is printed.\nvar X = fmt.Sprint()\n",
+		"package p\n\nimport \"fmt\"\n\n# X is printed.\nvar X = fmt.Sprint()\n"},
+}
+
+func TestBlankBeforeFirstDecl(t *testing.T) {
+	for _, test := range blankBeforeFirstDeclTests {
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{test.src, test.blank}})
+		cfg.NoBlankBeforeFirstDecl = true
+		runPrintTests(t, &cfg, []printTest{{test.src, test.noBlank}})
+	}
+}
+
+// blankLinesTests are run with PreserveBlankLines, up to max blank lines
+// in a row, or the default if max is 0.
+var blankLinesTests = []struct {
+	max int
+	printTest
+}{
+	// at the top level, after the package clause too
+	{0, printTest{"package p\n\n\n\nvar a = 1\n\n\n\nvar b = 2\n",
+		"package p\n\n\n\nvar a = 1\n\n\n\nvar b = 2\n"}},
+	{0, printTest{"package p\n\n\n\n// doc\nvar a = 1\n",
+		"package p\n\n\n\n# doc\nvar a = 1\n"}},
+	{0, printTest{"package p\nvar a = 1\n",
+		"package p\nvar a = 1\n"}},
+	// the line of the closing parenthesis of a group is not printed
+	{0, printTest{"package p\n\nimport (\n\t\"fmt\"\n)\n\n\n\nvar a = fmt.Sprint()\n",
+		"package p\n\nimport\n\t\"fmt\"\n\n\n\nvar a = fmt.Sprint()\n"}},
+	// inside blocks, whose closing brace is not printed either
+	{0, printTest{"package p\n\nfunc f(a int) {\n\tif a > 0 {\n\t\ta++\n\n\n\t\ta--\n\t}\n\n\n\n\tf(a)\n}\n",
+		"package p\n\nfunc f(a int)\n\tif a > 0\n\t\ta++\n\n\n\t\ta--\n\n\n\n\tf(a)\n\n"}},
+	// capped by MaxBlankLines
+	{2, printTest{"package p\n\n\n\nvar a = 1\n\n\n\nvar b = 2\n",
+		"package p\n\n\nvar a = 1\n\n\nvar b = 2\n"}},
+	{1, printTest{"package p\n\nfunc f(a int) {\n\tif a > 0 {\n\t\ta++\n\n\n\t\ta--\n\t}\n\n\n\n\tf(a)\n}\n",
+		"package p\n\nfunc f(a int)\n\tif a > 0\n\t\ta++\n\n\t\ta--\n\n\tf(a)\n\n"}},
+}
+
+func TestPreserveBlankLines(t *testing.T) {
+	for _, test := range blankLinesTests {
+		cfg := testConfig
+		cfg.PreserveBlankLines = true
+		cfg.MaxBlankLines = test.max
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
+
+// indentationTests are the outputs of the same source with each of the
+// indentation strategies: tabs and aligned cells, spaces and aligned
+// cells, tabs without a tabwriter, and spaces without alignment.
+var indentationTests = []struct {
+	mode            Mode
+	displayTabwidth int
+	out             string
+}{
+	{UseSpaces | TabIndent, 0, "package p\n\ntype T struct\n\tx    int    # x\n\tlong string # l\n\n"},
+	{UseSpaces, 0, "package p\n\ntype T struct\n        x       int     # x\n        long    string  # l\n\n"},
+	{UseSpaces, 4, "package p\n\ntype T struct\n    x    int     # x\n    long string  # l\n\n"},
+	{RawFormat, 0, "package p\n\ntype T struct\n\tx\tint\t# x\n\tlong\tstring\t# l\n\n"},
+	{RawFormat | UseSpaces, 0, "package p\n\ntype T struct\n        x int # x\n        long string # l\n\n"},
+	{RawFormat | UseSpaces, 4, "package p\n\ntype T struct\n    x int # x\n    long string # l\n\n"},
+}
+
+func TestIndentation(t *testing.T) {
+	const src = "package p\n\ntype T struct {\n\tx    int    // x\n\tlong string // l\n}\n"
+	for _, test := range indentationTests {
+		cfg := testConfig
+		cfg.Mode = test.mode
+		cfg.DisplayTabwidth = test.displayTabwidth
+		runPrintTests(t, &cfg, []printTest{{src, test.out}})
+	}
+}
+
+var pruneImportsTests = []printTest{
+	{"package p\n\nimport (\n\t\"fmt\"\n\t\"os\"\n)\n\nvar x = fmt.Sprint()\n",
+		"package p\n\nimport\n\t\"fmt\"\n\nvar x = fmt.Sprint()\n"},
+	{"package p\n\nimport \"os\"\n\nvar x int\n",
+		"package p\n\nvar x int\n"},
+	// blank and dot imports are kept
+	{"package p\n\nimport (\n\t_ \"embed\"\n\t. \"strings\"\n\t\"os\"\n)\n\nvar x int\n",
+		"package p\n\nimport\n\t_ \"embed\"\n\t. \"strings\"\n\nvar x int\n"},
+	// the name is the given one, or else guessed from the path
+	{"package p\n\nimport (\n\tf \"fmt\"\n\t\"example.com/m/v2\"\n\t\"example.com/go-foo\"\n\t\"os\"\n)\n\nvar x = f.Sprint(m.X)\n",
+		"package p\n\nimport\n\tf \"fmt\"\n\t\"example.com/m/v2\"\n\t\"example.com/go-foo\"\n\nvar x = f.Sprint(m.X)\n"},
+	// the comments of those dropped go too
+	{"package p\n\nimport (\n\t\"fmt\" // used\n\t\"os\"  // unused\n)\n\nvar x = fmt.Sprint()\n",
+		"package p\n\nimport\n\t\"fmt\" # used\n\nvar x = fmt.Sprint()\n"},
+	// names guessed from a version suffix, or which a selector to no known
+	// package may be the one of, are not sure
+	{"package p\n\nimport \"k8s.io/api/core/v1\"\n\nvar x v1.Pod\n",
+		"package p\n\nimport \"k8s.io/api/core/v1\"\n\nvar x v1.Pod\n"},
+	{"package p\n\nimport (\n\t\"example.com/m/v2\"\n\t\"os\"\n)\n\nvar x int\n",
+		"package p\n\nimport\n\t\"example.com/m/v2\"\n\nvar x int\n"},
+	{"package p\n\nimport (\n\t\"example.com/yaml\"\n\t\"os\"\n)\n\nvar x = goyaml.Marshal\n",
+		"package p\n\nimport\n\t\"example.com/yaml\"\n\t\"os\"\n\nvar x = goyaml.Marshal\n"},
+	{"package p\n\nimport (\n\tf \"fmt\"\n\t\"os\"\n)\n\ntype T struct {\n\tn int\n}\n\nvar t T\n\nvar x = t.n\n",
+		"package p\n\ntype T struct\n\tn int\n\nvar t T\n\nvar x = t.n\n"},
+}
+
+func TestPruneUnusedImports(t *testing.T) {
+	cfg := testConfig
+	cfg.PruneUnusedImports = true
+	runPrintTests(t, &cfg, pruneImportsTests)
+}
+
+// funcBanner writes a banner before a function declaration.
+func funcBanner(d ast.Decl, w io.Writer) {
+	if f, ok := d.(*ast.FuncDecl); ok {
+		fmt.Fprintf(w, "# --- %s ---", f.Name.Name)
+	}
+}
+
+// funcEnd writes a comment after a function declaration.
+func funcEnd(d ast.Decl, w io.Writer) {
+	if f, ok := d.(*ast.FuncDecl); ok {
+		fmt.Fprintf(w, "# end of %s", f.Name.Name)
+	}
+}
+
+var declHookTests = []struct {
+	before, after bool
+	printTest
+}{
+	{false, false, printTest{"package p\n\nvar x int\n\nfunc F() {}\n\n// G does.\nfunc G() int { return 1 } // one\n",
+		"package p\n\nvar x int\n\nfunc F():\n\n# G does.\nfunc G() int: return 1 # one\n"}},
+	// the banner precedes the documentation
+	{true, false, printTest{"package p\n\nvar x int\n\nfunc F() {}\n\n// G does.\nfunc G() int { return 1 } // one\n",
+		"package p\n\nvar x int\n\n# --- F ---\nfunc F():\n\n# --- G ---\n# G does.\nfunc G() int: return 1 # one\n"}},
+	// the comment follows the one on the last line
+	{false, true, printTest{"package p\n\nvar x int\n\nfunc F() {}\n\n// G does.\nfunc G() int { return 1 } // one\n",
+		"package p\n\nvar x int\n\nfunc F():\n# end of F\n\n# G does.\nfunc G() int: return 1 # one\n# end of G\n"}},
+	{true, true, printTest{"package p\n\nvar x int\n\nfunc F() {}\n\n// G does.\nfunc G() int { return 1 } // one\n",
+		"package p\n\nvar x int\n\n# --- F ---\nfunc F():\n# end of F\n\n# --- G ---\n# G does.\nfunc G() int: return 1 # one\n# end of G\n"}},
+}
+
+func TestDeclHooks(t *testing.T) {
+	for _, test := range declHookTests {
+		cfg := testConfig
+		if test.before {
+			cfg.BeforeDecl = funcBanner
+		}
+		if test.after {
+			cfg.AfterDecl = funcEnd
+		}
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
+
+// exprSpacingTests are expressions as printed canonically and with
+// PreserveExprSpacing, from the source expr.
+var exprSpacingTests = []struct {
+	expr, off, on string
+}{
+	{"a*b + c*d", "a*b + c*d", "a*b + c*d"},
+	{"a*b+c * d", "a*b + c*d", "a*b+c * d"},
+	{"a * b + c * d", "a*b + c*d", "a * b + c * d"},
+	{"a+ b-c", "a + b - c", "a+ b-c"},
+	{"(a+b) * c", "(a + b) * c", "(a+b) * c"},
+	{"-a+ -b", "-a + -b", "-a+ -b"},
+	{"f(a+b, c * d)", "f(a+b, c*d)", "f(a+b, c * d)"},
+	{"x == y&&z", "x == y && z", "x == y&&z"},
+	// a blank is kept as one, a line break as it is
+	{"a\t+  b", "a + b", "a + b"},
+	{"a +\n\tb", "a +\n\tb", "a +\n\tb"},
+}
+
+func TestPreserveExprSpacing(t *testing.T) {
+	for _, test := range exprSpacingTests {
+		src := "package p\n\nvar x = " + test.expr + "\n"
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{src, "package p\n\nvar x = " + test.off + "\n"}})
+		cfg.PreserveExprSpacing = true
+		runPrintTests(t, &cfg, []printTest{{src, "package p\n\nvar x = " + test.on + "\n"}})
+	}
+
+	// in statements too
+	cfg := testConfig
+	cfg.PreserveExprSpacing = true
+	runPrintTests(t, &cfg, []printTest{{"package p\n\nfunc f() {\n\tif a+b > c {\n\t\tg(a* b)\n\t}\n}\n",
+		"package p\n\nfunc f()\n\tif a+b > c\n\t\tg(a* b)\n\n"}})
+
+	// an expression without positions is printed as usual
+	x := &ast.BinaryExpr{
+		X:  &ast.BinaryExpr{X: ast.NewIdent("a"), Op: token.MUL, Y: ast.NewIdent("b")},
+		Op: token.ADD,
+		Y:  ast.NewIdent("c"),
+	}
+	var buf bytes.Buffer
+	if err := cfg.Fprint(&buf, token.NewFileSet(), x); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "a*b + c" {
+		t.Errorf("got %q; want %q", buf.String(), "a*b + c")
+	}
+}
+
+// groupDigits adds digit separators to the decimal integer literals of
+// more than four digits.
+func groupDigits(lit *ast.BasicLit) string {
+	s := lit.Value
+	if lit.Kind != token.INT || len(s) <= 4 || s[0] == '0' {
+		return s
+	}
+	n := len(s) % 3
+	if n == 0 {
+		n = 3
+	}
+	res := s[:n]
+	for i := n; i < len(s); i += 3 {
+		res += "_" + s[i:i+3]
+	}
+	return res
+}
+
+var formatNumberTests = []struct {
+	src, off, on string
+}{
+	{"package p\n\nvar (\n\ta = 1000000\n\tb = 1234\n\tc = 12345\n\td = 0x1000000\n\tf = 1000000.5\n\tg = \"1000000\"\n)\n",
+		"package p\n\nvar\n\ta = 1000000\n\tb = 1234\n\tc = 12345\n\td = 0x1000000\n\tf = 1000000.5\n\tg = \"1000000\"\n\n",
+		"package p\n\nvar\n\ta = 1_000_000\n\tb = 1234\n\tc = 12_345\n\td = 0x1000000\n\tf = 1000000.5\n\tg = \"1000000\"\n\n"},
+}
+
+func TestFormatNumber(t *testing.T) {
+	for _, test := range formatNumberTests {
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{test.src, test.off}})
+		cfg.FormatNumber = groupDigits
+		runPrintTests(t, &cfg, []printTest{{test.src, test.on}})
+	}
+}
+
+var statsTests = []struct {
+	src   string
+	stats PrinterStats
+}{
+	{"package p\n\nvar x int\n", PrinterStats{Comments: 0, DroppedFFs: 0, MaxIndent: 0, Whitespace: 6}},
+	{"// Package p.\npackage p\n\n// F does.\nfunc F(x int) { // f\n\tif x > 0 { // positive\n\t\tfor {\n\t\t\tx-- // down\n\t\t}\n\t}\n}\n", PrinterStats{Comments: 5, DroppedFFs: 0, MaxIndent: 3, Whitespace: 23}},
+	{"package p\n\nfunc f() {\n\tx := 1 // c\n\t_ = x\n\t// end\n}\n", PrinterStats{Comments: 2, DroppedFFs: 0, MaxIndent: 1, Whitespace: 16}},
+	{"package p\n\nfunc f() {\n\tswitch {\n\tcase true:\n\t\tf()\n\t\t/* c */\n\tdefault:\n\t}\n}\n", PrinterStats{Comments: 1, DroppedFFs: 1, MaxIndent: 3, Whitespace: 21}},
+}
+
+func TestStats(t *testing.T) {
+	for _, test := range statsTests {
+		var stats PrinterStats
+		cfg := testConfig
+		cfg.Stats = &stats
+		for i := 0; i < 2; i++ { // the counts are reset by each call
+			igoSource(t, &cfg, test.src)
+			if stats != test.stats {
+				t.Errorf("%q, call %d: got %+v; want %+v", test.src, i, stats, test.stats)
+			}
+		}
+	}
+}
+
+// keywordCommentTests are comments between a keyword, or the period of a
+// selector, and the item following it on another line, where iGo cannot
+// break the line.
+var keywordCommentTests = []printTest{
+	{"package // c\np\n",
+		"package p\n"},
+	{"package p\n\nvar // c\nx = 1\n",
+		"package p\n\nvar x = 1\n"},
+	{"package\n// c\n/* d */\np\n",
+		"package p\n"},
+	{"package p\n\nfunc f(x bool) {\n\tif // c\n\tx {\n\t\tf(x)\n\t}\n\tfor _, y := range // c\n\t[]int{} {\n\t\tf(y > 0)\n\t}\n}\n",
+		"package p\n\nfunc f(x bool)\n\tif x\n\t\tf(x)\n\n\tfor _, y := range []int{}\n\t\tf(y > 0)\n\n"},
+	{"package p\n\nfunc f() {\n\tx.\n\t\tY()\n\tx.\n\t\t// c\n\t\tZ = 1\n}\n",
+		"package p\n\nfunc f()\n\tx.Y()\n\tx.Z = 1\n\n"},
+	// the line is broken anyway after the keyword of a group
+	{"package p\n\nvar ( // c\n\tx = 1\n)\n",
+		"package p\n\nvar # c\n\tx = 1\n\n"},
+}
+
+func TestKeywordComments(t *testing.T) {
+	runPrintTests(t, &testConfig, keywordCommentTests)
+}
+
+// unsupportedTests are valid Go syntax, rejected by the type checker,
+// which cannot be written in iGo.
+var unsupportedTests = []struct {
+	src, err string
+}{
+	{"package p\n\nfunc () f() {}\n", "test.go:3:6: github.com/DAddYE/igo/from_go: a method without exactly one receiver cannot be written in iGo"},
+	{"package p\n\nfunc (a, b T) f() {}\n", "test.go:3:6: github.com/DAddYE/igo/from_go: a method without exactly one receiver cannot be written in iGo"},
+}
+
+func TestUnsupported(t *testing.T) {
+	for _, test := range unsupportedTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.go", test.src, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("%q: %v", test.src, err)
+		}
+		err = testConfig.Fprint(ioutil.Discard, fset, file)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%q: got error %v; want %s", test.src, err, test.err)
+		}
+	}
+}
+
+// emptyBodyTests are statements, types and groups without a body, which
+// iGo writes with a colon, or, for switch, select, struct, interface and
+// groups, with nothing.
+var emptyBodyTests = []printTest{
+	{"package p\n\nfunc f(x bool) {\n\tif x {\n\t} else {\n\t}\n\tfor {\n\t}\n\tf(x)\n}\n",
+		"package p\n\nfunc f(x bool)\n\tif x:\n\telse:\n\tfor:\n\tf(x)\n\n"},
+	{"package p\n\nfunc f(s []int) {\n\tfor range s {\n\t}\n\tfor i := range s {\n\t}\n}\n",
+		"package p\n\nfunc f(s []int)\n\tfor range s:\n\tfor i := range s:\n\n"},
+	{"package p\n\nfunc f(x int) {\n\tswitch x {\n\t}\n\tselect {\n\t}\n}\n",
+		"package p\n\nfunc f(x int)\n\tswitch x\n\tselect\n\n"},
+	{"package p\n\ntype T struct {\n}\n\ntype I interface {\n}\n\nfunc f() {}\n",
+		"package p\n\ntype T struct\n\ntype I interface\n\nfunc f():\n"},
+	// the comments of an empty struct follow it, or are dropped in a
+	// literal, where the '{' must follow the type
+	{"package p\n\ntype T struct { // c\n}\n\nvar x = []struct{ // d\n}{}\n",
+		"package p\n\ntype T struct # c\n\nvar x = []struct{}\n"},
+	{"package p\n\nimport ()\n\nvar (\n)\n",
+		"package p\n\nimport\n\nvar\n"},
+	{"package p\n\nfunc f(x bool) {\n\tfor {\n\t} // c\n\tf(x)\n}\n",
+		"package p\n\nfunc f(x bool)\n\tfor: # c\n\tf(x)\n\n"},
+	{"package p\n\nfunc f(x bool) {\n\tif x { // c\n\t}\n\tf(x)\n}\n",
+		"package p\n\nfunc f(x bool)\n\tif x: # c\n\tf(x)\n\n"},
+	// the comments inside a body follow the colon, as iGo reads them back
+	{"package p\n\nfunc f(x bool) {\n\tif x {\n\t\t// c\n\t}\n}\n",
+		"package p\n\nfunc f(x bool)\n\tif x:\n\t# c\n\n"},
+}
+
+func TestEmptyBodies(t *testing.T) {
+	runPrintTests(t, &testConfig, emptyBodyTests)
+}
+
+// trailingCommentTests are comments ending the line of a closing brace,
+// which is blank in iGo, with or without them.
+var trailingCommentTests = []printTest{
+	{"package p\n\nfunc f(x bool) {\n\tif x { f(x) }\n\tf(x)\n}\n",
+		"package p\n\nfunc f(x bool)\n\tif x\n\t\tf(x)\n\n\tf(x)\n\n"},
+	{"package p\n\nfunc f(x bool) {\n\tif x { f(x) } // c\n\tf(x)\n}\n",
+		"package p\n\nfunc f(x bool)\n\tif x\n\t\tf(x) # c\n\n\tf(x)\n\n"},
+}
+
+func TestTrailingComments(t *testing.T) {
+	runPrintTests(t, &testConfig, trailingCommentTests)
+}
+
+// doTests are function literals ending the arguments of a call, which
+// iGo writes after them, with do, and their comments.
+var doTests = []printTest{
+	{"package p\n\nfunc f() {\n\tg(1, func() {\n\t\tf()\n\t\t// c\n\t})\n}\n",
+		"package p\n\nfunc f()\n\tg(1) do()\n\t\tf()\n\t\t# c\n\n"},
+	{"package p\n\nfunc f(x bool) {\n\tg(func() {\n\t\tswitch {\n\t\tcase x: // c\n\t\t}\n\t})\n}\n",
+		"package p\n\nfunc f(x bool)\n\tg() do()\n\t\tswitch\n\t\t\tcase x: # c\n\n"},
+}
+
+func TestDo(t *testing.T) {
+	runPrintTests(t, &testConfig, doTests)
+}
+
+// TestDeterministic prints the same tree, that of a large source, 100
+// times and checks that the output is the same each time.
+func TestDeterministic(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "nodes.go", nil, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first []byte
+	for i := 0; i < 100; i++ {
+		var buf bytes.Buffer
+		if err := testConfig.Fprint(&buf, fset, file); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = buf.Bytes()
+		} else if !bytes.Equal(buf.Bytes(), first) {
+			t.Fatalf("output %d differs from the first one", i)
+		}
+	}
+}
+
+var reflowDocTests = []struct {
+	maxColumn int
+	printTest
+}{
+	{40, printTest{"package p\n\n// F does something with a rather long description that goes past the limit.\nfunc F() {}\n",
+		"package p\n\n# F does something with a rather long\n# description that goes past the limit.\nfunc F():\n"}},
+	{0, printTest{"package p\n\n// F does something with a rather long description that goes past the limit.\nfunc F() {}\n",
+		"package p\n\n# F does something with a rather long description that goes past the limit.\nfunc F():\n"}},
+	// the indentation counts
+	{40, printTest{"package p\n\ntype T struct {\n\t// X is a field with a documentation that is too long.\n\tX int\n}\n",
+		"package p\n\ntype T struct\n\t# X is a field with a\n\t# documentation that is too\n\t# long.\n\tX int\n\n"}},
+	// directives, URLs and code blocks are kept
+	{40, printTest{"package p\n\n// F does something with a rather long description that goes past the limit.\n//\n//go:noinline\nfunc F() {}\n",
+		"package p\n\n# F does something with a rather long\n# description that goes past the limit.\n#\n#go:noinline\nfunc F():\n"}},
+	{40, printTest{"package p\n\n// See https://example.com/a/very/long/path/that/does/not/fit for details.\n// Code:\n//\tx := a very long code line that does not fit either\nfunc F() {}\n",
+		"package p\n\n# See https://example.com/a/very/long/path/that/does/not/fit for details.\n# Code:\n#\tx := a very long code line that does not fit either\nfunc F():\n"}},
+	// only documentation comments are re-wrapped
+	{40, printTest{"package p\n\nfunc f() {\n\tvar a = 1 // a rather long inline comment that goes past the limit\n}\n",
+		"package p\n\nfunc f()\n\tvar a = 1 # a rather long inline comment that goes past the limit\n\n"}},
+}
+
+func TestReflowDocComments(t *testing.T) {
+	for _, test := range reflowDocTests {
+		cfg := testConfig
+		cfg.ReflowDocComments = true
+		cfg.MaxColumn = test.maxColumn
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
+
+// exportedFunc reports whether d is the declaration of an exported
+// function or method.
+func exportedFunc(d ast.Decl) bool {
+	f, ok := d.(*ast.FuncDecl)
+	return ok && f.Name.IsExported()
+}
+
+var filterDeclsTests = []printTest{
+	{"package p\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\t_ \"net/http/pprof\"\n\n\t\"github.com/x/y/v2\"\n\t\"github.com/x/z\"\n\tstr \"strings\"\n)\n\n// A is exported.\nfunc A() {\n\tfmt.Println(y.V)\n}\n\n// b is not.\nfunc b() {\n\tos.Exit(str.Count(\"\", z.S))\n}\n\ntype T int\n\nvar V = 1 // a var\n\n// M is a method.\nfunc (self T) M() string {\n\treturn str.TrimSpace(\"\")\n}\n",
+		"package p\n\nimport\n\t\"fmt\"\n\t_ \"net/http/pprof\"\n\n\t\"github.com/x/y/v2\"\n\tstr \"strings\"\n\n# A is exported.\nfunc A()\n\tfmt.Println(y.V)\n\n# M is a method.\nfunc T.M() string: return str.TrimSpace(\"\")\n"},
+	// the dropped imports leave no empty line, but the sections stay
+	{"package p\n\nimport (\n\t\"fmt\"\n\n\t\"os\" // os\n\n\t\"github.com/x/y/v2\"\n)\n\nfunc A() {\n\tfmt.Println(y.V)\n}\n",
+		"package p\n\nimport\n\t\"fmt\"\n\n\t\"github.com/x/y/v2\"\n\nfunc A()\n\tfmt.Println(y.V)\n\n"},
+	{"package p\n\nimport \"os\"\n\nfunc b() {\n\tos.Exit(1)\n}\n",
+		"package p\n"},
+}
+
+func TestFilterDecls(t *testing.T) {
+	cfg := testConfig
+	cfg.FilterDecls = exportedFunc
+	runPrintTests(t, &cfg, filterDeclsTests)
+}
+
+// directiveTests are printed with ExpandCommentTabs and ReflowDocComments,
+// and a MaxColumn of 40.
+var directiveTests = []printTest{
+	{"package p\n\nfunc f() {\n\tx := someFunctionWithAVeryLongName(argumentOne, argumentTwo) //nolint:gosec\n\ty := g() //nolint:all // a reason\n\t_, _ = x, y\n}\n",
+		"package p\n\nfunc f()\n\tx := someFunctionWithAVeryLongName(argumentOne, argumentTwo) #nolint:gosec\n\ty := g()                                                     #nolint:all // a reason\n\t_, _ = x, y\n\n"},
+	{"package p\n\n// a\tb\n//go:generate\tstringer -type=Foo\n//nolint:gocyclo // a reason long enough to pass the maximum column\ntype Foo int\n",
+		"package p\n\n# a     b\n#go:generate\tstringer -type=Foo\n#nolint:gocyclo // a reason long enough to pass the maximum column\ntype Foo int\n"},
+}
+
+func TestDirectives(t *testing.T) {
+	cfg := testConfig
+	cfg.ExpandCommentTabs = true
+	cfg.ReflowDocComments = true
+	cfg.MaxColumn = 40
+	runPrintTests(t, &cfg, directiveTests)
+}
+
+var forceLFTests = []struct {
+	lit     string // value given to the literal of "var s = `x`"
+	forceLF bool
+	out     string
+}{
+	{"`a\r\nb`", false, "package p\n\nvar s = `a\r\nb`\n"},
+	{"`a\r\nb`", true, "package p\n\nvar s = `a\nb`\n"},
+	{"`a\rb\r\n\r\n`", true, "package p\n\nvar s = `a\rb\n\n`\n"},
+	{"\"a\\r\\nb\"", true, "package p\n\nvar s = \"a\\r\\nb\"\n"}, // escapes are not line endings
+}
+
+// TestForceLF prints literals with "\r\n" line endings, which the scanner
+// never gives, set in the tree after parsing.
+func TestForceLF(t *testing.T) {
+	for _, test := range forceLFTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.go", "package p\n\nvar s = `x`\n", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0].(*ast.BasicLit).Value = test.lit
+		cfg := testConfig
+		cfg.ForceLF = test.forceLF
+		var buf bytes.Buffer
+		if err := cfg.Fprint(&buf, fset, file); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.out {
+			t.Errorf("%q, ForceLF %v: got %q; want %q", test.lit, test.forceLF, buf.String(), test.out)
+		}
+	}
+}
+
+var maxDepthTests = []struct {
+	n, maxDepth int // terms of a+a+...+a, MaxDepth
+	err         bool
+}{
+	{10, 0, false},
+	{10, 100, false},
+	{5000, 1000, true},
+	{5000, 10000, false},
+}
+
+// TestMaxDepth prints the generated sums a+a+...+a, left-nested as deep
+// as they have terms.
+func TestMaxDepth(t *testing.T) {
+	for _, test := range maxDepthTests {
+		src := "package p\n\nvar x = " + strings.Repeat("a+", test.n-1) + "a\n"
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.go", src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := testConfig
+		cfg.MaxDepth = test.maxDepth
+		err = cfg.Fprint(ioutil.Discard, fset, file)
+		if test.err {
+			if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("nested more than %d levels deep", test.maxDepth)) {
+				t.Errorf("%d terms, MaxDepth %d: got error %v; want too deep", test.n, test.maxDepth, err)
+			}
+		} else if err != nil {
+			t.Errorf("%d terms, MaxDepth %d: %v", test.n, test.maxDepth, err)
+		}
+	}
+}
+
+var commentedNodeTests = []struct {
+	end  int  // of the synthetic comment, relative to the position of the node
+	want bool // whether it is printed as its documentation
+}{
+	{-1, true},
+	{len("// F returns one.") - 1, true}, // starting at Pos()-1
+	{-2, false},
+	{-10, false},
+}
+
+// TestCommentedNode prints a declaration with a comment group built for
+// it, positioned before it, on a line of blanks, as a CommentedNode.
+func TestCommentedNode(t *testing.T) {
+	const doc = "// F returns one."
+	for _, test := range commentedNodeTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.go", "package p\n\n"+strings.Repeat(" ", 30)+"\nfunc F() int { return 1 }\n", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decl := file.Decls[0]
+		slash := decl.Pos() + token.Pos(test.end-len(doc))
+		g := &ast.CommentGroup{List: []*ast.Comment{{Slash: slash, Text: doc}}}
+		var buf bytes.Buffer
+		if err := testConfig.Fprint(&buf, fset, &CommentedNode{Node: decl, Comments: []*ast.CommentGroup{g}}); err != nil {
+			t.Fatal(err)
+		}
+		want := "func F() int: return 1"
+		if test.want {
+			want = "# F returns one.\n" + want
+		}
+		if buf.String() != want {
+			t.Errorf("comment ending at %d: got %q; want %q", test.end, buf.String(), want)
+		}
+	}
+}
+
+var noFinalNewlineTests = []struct {
+	mode Mode
+	printTest
+}{
+	{0, printTest{"package p\n\nvar a = 1\n", "package p\n\nvar a = 1"}},
+	{RawFormat, printTest{"package p\n\nvar a = 1\n", "package p\n\nvar a = 1"}},
+	{0, printTest{"package p\n\nvar a = 1 // one\n", "package p\n\nvar a = 1 # one"}},
+	{RawFormat, printTest{"package p\n\nvar a = 1 // one\n", "package p\n\nvar a = 1\t# one"}}, // unaligned
+	{0, printTest{"package p\n\nvar a = 1\n\n// the end\n", "package p\n\nvar a = 1\n\n# the end"}},
+	{0, printTest{"package p\n\ntype T struct {\n\tx    int // x\n\tlong int // l\n}\n",
+		"package p\n\ntype T struct\n\tx    int # x\n\tlong int # l\n"}},
+}
+
+func TestNoFinalNewline(t *testing.T) {
+	for _, test := range noFinalNewlineTests {
+		cfg := testConfig
+		cfg.Mode |= test.mode
+		cfg.NoFinalNewline = true
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
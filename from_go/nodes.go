@@ -12,6 +12,7 @@ import (
 	"bytes"
 	"go/ast"
 	"go/token"
+	"strings"
 	"unicode/utf8"
 
 	iToken "github.com/DAddYE/igo/token"
@@ -42,7 +43,15 @@ import (
 *             future (not yet interspersed) comments in this function.
 */
 func (p *printer) linebreak(line, min int, ws whiteSpace, newSection bool) (printedBreak bool) {
-	n := nlimit(line - p.pos.Line)
+	n := p.nlimit(line - p.pos.Line)
+	if p.PreserveBlankLines {
+		// the line breaks not written yet count as well (e.g., the one
+		// after a block, which has no closing brace to be written)
+		pending := p.pendingLinebreaks()
+		n -= pending
+		min -= pending
+		printedBreak = pending > 0
+	}
 	if n < min {
 		n = min
 	}
@@ -138,7 +147,11 @@ func (p *printer) exprList(prev0 token.Pos, list []ast.Expr, depth int, mode exp
 				// comma position for correct comment placement
 				p.print(x.Pos(), token.COMMA, blank)
 			}
-			p.expr0(x, depth)
+			if i < len(list)-1 {
+				p.followedExpr(x, depth)
+			} else {
+				p.expr0(x, depth)
+			}
 		}
 		return
 	}
@@ -240,7 +253,13 @@ func (p *printer) exprList(prev0 token.Pos, list []ast.Expr, depth int, mode exp
 			// key such that consecutive entries can align if possible
 			p.expr(pair.Key)
 			p.print(pair.Colon, token.COLON, vtab)
-			p.expr(pair.Value)
+			if i < len(list)-1 || mode&commaTerm != 0 {
+				p.followedExpr(pair.Value, depth)
+			} else {
+				p.expr(pair.Value)
+			}
+		} else if i < len(list)-1 || mode&commaTerm != 0 {
+			p.followedExpr(x, depth) // by a comma
 		} else {
 			p.expr0(x, depth)
 		}
@@ -263,8 +282,21 @@ func (p *printer) exprList(prev0 token.Pos, list []ast.Expr, depth int, mode exp
 	}
 }
 
-func (p *printer) parameters(fields *ast.FieldList) {
-	p.print(fields.Opening, token.LPAREN)
+// A paramMode selects the kind of list printed by parameters.
+type paramMode int
+
+const (
+	funcParam  paramMode = iota // parameters or results, in ()'s
+	funcTParam                  // type parameters of a function, in []'s
+	typeTParam                  // type parameters of a type, in []'s
+)
+
+func (p *printer) parameters(fields *ast.FieldList, mode paramMode) {
+	openTok, closeTok := token.LPAREN, token.RPAREN
+	if mode != funcParam {
+		openTok, closeTok = token.LBRACK, token.RBRACK
+	}
+	p.print(fields.Opening, openTok)
 	if len(fields.List) > 0 {
 		prevLine := p.lineFor(fields.Opening)
 		ws := indent
@@ -323,12 +355,12 @@ func (p *printer) parameters(fields *ast.FieldList) {
 			p.print(unindent)
 		}
 	}
-	p.print(fields.Closing, token.RPAREN)
+	p.print(fields.Closing, closeTok)
 }
 
 func (p *printer) signature(params, result *ast.FieldList) {
 	if params != nil {
-		p.parameters(params)
+		p.parameters(params, funcParam)
 	} else {
 		p.print(token.LPAREN, token.RPAREN)
 	}
@@ -341,7 +373,7 @@ func (p *printer) signature(params, result *ast.FieldList) {
 			p.expr(stripParensAlways(result.List[0].Type))
 			return
 		}
-		p.parameters(result)
+		p.parameters(result, funcParam)
 	}
 }
 
@@ -391,15 +423,34 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 	hasComments := isIncomplete || p.commentBefore(p.posFor(rbrace))
 	srcIsOneLine := lbrace.IsValid() && rbrace.IsValid() && p.lineFor(lbrace) == p.lineFor(rbrace)
 
+	if len(list) == 0 && !isIncomplete {
+		// an empty struct/interface, on one line or not: its comments
+		// follow it
+		if !p.commentsInside(lbrace, rbrace) {
+			p.skipBody(rbrace)
+		}
+		return
+	}
 	if !hasComments && srcIsOneLine {
 		// possibly a one-line struct/interface
-		if len(list) == 0 {
-			return
-		} else if isStruct && p.isOneLineFieldList(list) { // for now ignore interfaces
+		if p.isOneLineFieldList(list) {
 			// small enough - print on one line
 			// (don't use identList and ignore source line breaks)
 			p.print(lbrace, token.COLON, blank)
 			f := list[0]
+			if !isStruct {
+				// a type set, e.g. interface{ ~int | ~string }, is on
+				// one line in the type parameters of a signature
+				if ftyp, isFtyp := f.Type.(*ast.FuncType); isFtyp && len(f.Names) > 0 {
+					// method
+					p.expr(f.Names[0])
+					p.signature(ftyp.Params, ftyp.Results)
+				} else {
+					// embedded interface or type set
+					p.expr(f.Type)
+				}
+				return
+			}
 			for i, x := range f.Names {
 				if i > 0 {
 					// no comments so no need for comma position
@@ -416,11 +467,13 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 	}
 	// hasComments || !srcIsOneLine
 
+	// indent first, as after the '{' of Go, so that the doc comment of
+	// the first field, written at the formfeed, is indented as well
+	p.print(indent)
 	if hasComments || len(list) > 0 {
 		p.print(formfeed)
 	}
 
-	p.print(indent)
 	if isStruct {
 
 		sep := vtab
@@ -498,6 +551,7 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 
 	}
 	p.print(unindent, formfeed)
+	p.close(rbrace, token.RBRACE)
 }
 
 // ----------------------------------------------------------------------------
@@ -640,6 +694,12 @@ func (p *printer) binaryExpr(x *ast.BinaryExpr, prec1, cutoff, depth int) {
 	}
 
 	printBlank := prec < cutoff
+	blankAfter := printBlank
+	if p.PreserveExprSpacing && x.X.End().IsValid() && x.OpPos.IsValid() && x.Y.Pos().IsValid() {
+		// a blank where the source has any space
+		printBlank = x.X.End() < x.OpPos
+		blankAfter = x.OpPos+token.Pos(len(x.Op.String())) < x.Y.Pos()
+	}
 
 	ws := indent
 	p.expr1(x.X, prec, depth+diffPrec(x.X, prec))
@@ -654,10 +714,10 @@ func (p *printer) binaryExpr(x *ast.BinaryExpr, prec1, cutoff, depth int) {
 		// in the source
 		if p.linebreak(yline, 1, ws, true) {
 			ws = ignore
-			printBlank = false // no blank after line break
+			blankAfter = false // no blank after line break
 		}
 	}
-	if printBlank {
+	if blankAfter {
 		p.print(blank)
 	}
 	p.expr1(x.Y, prec+1, depth+1)
@@ -672,6 +732,12 @@ func isBinary(expr ast.Expr) bool {
 }
 
 func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
+	if p.MaxDepth > 0 {
+		if p.nesting++; p.nesting > p.MaxDepth {
+			panic(tooDeep{p.posFor(expr.Pos()), p.MaxDepth})
+		}
+		defer func() { p.nesting-- }()
+	}
 	p.print(expr.Pos())
 
 	switch x := expr.(type) {
@@ -748,12 +814,9 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 
 	case *ast.SelectorExpr:
 		p.expr1(x.X, token.HighestPrec, depth)
-		p.print(token.PERIOD)
-		if line := p.lineFor(x.Sel.Pos()); p.pos.IsValid() && p.pos.Line < line {
-			p.print(indent, newline, x.Sel.Pos(), x.Sel, unindent)
-		} else {
-			p.print(x.Sel.Pos(), x.Sel)
-		}
+		// iGo ends the line after the period: the selector follows on
+		// the same line
+		p.print(token.PERIOD, x.Sel.Pos(), x.Sel)
 
 	case *ast.TypeAssertExpr:
 		p.expr1(x.X, token.HighestPrec, depth)
@@ -772,6 +835,13 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		p.expr0(x.Index, depth+1)
 		p.print(x.Rbrack, token.RBRACK)
 
+	case *ast.IndexListExpr:
+		// instantiation of a generic function or type
+		p.expr1(x.X, token.HighestPrec, 1)
+		p.print(x.Lbrack, token.LBRACK)
+		p.exprList(x.Lbrack, x.Indices, depth+1, commaTerm, x.Rbrack)
+		p.print(x.Rbrack, token.RBRACK)
+
 	case *ast.SliceExpr:
 		// TODO(gri): should treat[] like parentheses and undo one level of depth
 		p.expr1(x.X, token.HighestPrec, 1)
@@ -794,8 +864,9 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		if len(x.Args) > 1 {
 			depth++
 		}
-		if _, ok := x.Fun.(*ast.FuncType); ok {
-			// conversions to literal function types require parentheses around the type
+		if _, ok := x.Fun.(*ast.FuncType); ok || p.isOneLineFuncLit(x.Fun) {
+			// conversions to literal function types require parentheses around the type,
+			// calls of function literals with one-line bodies around the literal
 			p.print(token.LPAREN)
 			p.expr1(x.Fun, token.HighestPrec, depth)
 			p.print(token.RPAREN)
@@ -816,8 +887,9 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 				last := x.Args[len(x.Args)-1]
 				if fn, ok := last.(*ast.FuncLit); ok {
 					args := x.Args[:len(x.Args)-1]
-					p.exprList(x.Lparen, args, depth, commaTerm, x.Rparen)
-					p.print(x.Rparen, token.RPAREN)
+					// the ')' is written before the literal
+					p.exprList(x.Lparen, args, depth, commaTerm, fn.Pos())
+					p.print(fn.Pos(), token.RPAREN)
 					p.print(blank, iToken.DO)
 					p.signature(fn.Type.Params, fn.Type.Results)
 					p.adjBlock(fn.Body)
@@ -912,9 +984,6 @@ func (p *printer) stmtList(list []ast.Stmt, nindent int, nextIsRBrace bool) {
 	if nindent > 0 {
 		p.print(indent)
 	}
-	if p.inFunc && p.findent == 0 {
-		p.findent = p.indent
-	}
 	multiLine := false
 	i := 0
 	for _, s := range list {
@@ -932,9 +1001,6 @@ func (p *printer) stmtList(list []ast.Stmt, nindent int, nextIsRBrace bool) {
 			i++
 		}
 	}
-	if !p.inFunc {
-		p.findent = 0
-	}
 	if nindent > 0 {
 		p.print(unindent)
 	}
@@ -944,6 +1010,78 @@ func (p *printer) stmtList(list []ast.Stmt, nindent int, nextIsRBrace bool) {
 func (p *printer) block(b *ast.BlockStmt, nindent int) {
 	p.stmtList(b.List, nindent, true)
 	p.linebreak(p.lineFor(b.Rbrace), 1, ignore, true)
+	p.close(b.Rbrace, token.RBRACE)
+	if p.PreserveBlankLines {
+		// the line of the closing brace is not printed, it must not
+		// count as a blank line before what follows
+		p.pos.Line++
+		p.elided++
+	}
+}
+
+// body prints b, the body of a function, if, else or for statement, as a
+// block, or as ':' if it has no statements: an indented block cannot be
+// empty.
+func (p *printer) body(b *ast.BlockStmt) {
+	if len(b.List) > 0 {
+		p.block(b, 1)
+		return
+	}
+	// no blank before the colon
+	for i := len(p.wsbuf) - 1; i >= 0 && p.wsbuf[i] == blank; i-- {
+		p.wsbuf[i] = ignore
+	}
+	p.print(token.COLON)
+	if p.commentsInside(b.Lbrace, b.Rbrace) {
+		// they follow on lines of their own, as iGo reads them back
+		return
+	}
+	// those on the lines of the braces follow the colon
+	p.skipBody(b.Rbrace)
+}
+
+// commentsInside reports whether the empty braces lbrace and rbrace, of a
+// body or a field list, hold comments on lines of their own.
+func (p *printer) commentsInside(lbrace, rbrace token.Pos) bool {
+	if p.comment == nil {
+		return false
+	}
+	first, end := p.lineFor(lbrace), p.posFor(rbrace)
+	for _, g := range p.comments[p.cindex-1:] {
+		if p.posFor(g.Pos()).Offset >= end.Offset {
+			break
+		}
+		for _, c := range g.List {
+			if line := p.lineFor(c.Pos()); first < line && line < end.Line {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// skipBody folds the lines of a body that is not printed, up to its
+// closing brace rbrace, into the current one, so they are not taken for
+// blank lines.
+func (p *printer) skipBody(rbrace token.Pos) {
+	if n := p.lineFor(rbrace) - p.pos.Line; n > 0 {
+		p.pos.Line += n
+		p.elided += n
+	}
+}
+
+// clauseBlock prints b, the body of a switch or select statement: its
+// clauses are indented below the statement.
+func (p *printer) clauseBlock(b *ast.BlockStmt) {
+	if len(b.List) == 0 && p.commentOffset >= p.posFor(b.Rbrace).Offset {
+		// a switch without clauses is printed without a body
+		p.skipBody(b.Rbrace)
+		return
+	}
+	p.print(indent)
+	p.block(b, 0)
+	p.print(unindent)
+	p.applyUnindents() // the block is closed already
 }
 
 func isTypeName(x ast.Expr) bool {
@@ -1058,17 +1196,6 @@ func (p *printer) indentList(list []ast.Expr) bool {
 	return false
 }
 
-func (p *printer) alignFuncIndent() {
-	p.flush(p.pos, p.lastTok)
-	i := p.indent
-	for ; i < p.findent; i++ {
-		p.print(indent)
-	}
-	for ; i > p.findent; i-- {
-		p.print(unindent)
-	}
-}
-
 func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 	p.print(stmt.Pos())
 
@@ -1083,18 +1210,23 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 		// nothing to do
 
 	case *ast.LabeledStmt:
-		p.alignFuncIndent()
+		// the label is at the indentation of its block, the statement
+		// below it indented once more; an empty one is written L: ;
 		p.expr(s.Label)
-		p.print(s.Colon, token.COLON, indent)
+		p.print(s.Colon, token.COLON)
 		if e, isEmpty := s.Stmt.(*ast.EmptyStmt); isEmpty {
-			if !nextIsRBrace {
-				p.print(newline, e.Pos(), token.SEMICOLON)
-				break
+			pos := e.Pos()
+			if e.Implicit {
+				// at the closing brace, after any comments
+				pos = token.NoPos
 			}
-		} else {
-			p.linebreak(p.lineFor(s.Stmt.Pos()), 1, ignore, true)
+			p.print(blank, pos, token.SEMICOLON)
+			break
 		}
+		p.print(indent)
+		p.linebreak(p.lineFor(s.Stmt.Pos()), 1, ignore, true)
 		p.stmt(s.Stmt, nextIsRBrace)
+		p.print(unindent)
 
 	case *ast.ExprStmt:
 		const depth = 1
@@ -1159,11 +1291,18 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 	case *ast.IfStmt:
 		p.print(token.IF)
 		p.controlClause(false, s.Init, s.Cond, nil)
-		p.block(s.Body, 1)
+		p.body(s.Body)
 		if s.Else != nil {
+			if len(s.Body.List) == 0 {
+				// else starts a line of its own after a colon
+				p.print(newline)
+			}
 			p.print(token.ELSE)
-			switch s.Else.(type) {
-			case *ast.BlockStmt, *ast.IfStmt:
+			switch e := s.Else.(type) {
+			case *ast.BlockStmt:
+				p.print(blank)
+				p.body(e)
+			case *ast.IfStmt:
 				p.print(blank)
 				p.stmt(s.Else, nextIsRBrace)
 			default:
@@ -1186,9 +1325,7 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 	case *ast.SwitchStmt:
 		p.print(token.SWITCH)
 		p.controlClause(false, s.Init, s.Tag, nil)
-		p.print(indent)
-		p.block(s.Body, 0)
-		p.print(unindent)
+		p.clauseBlock(s.Body)
 
 	case *ast.TypeSwitchStmt:
 		p.print(token.SWITCH)
@@ -1199,9 +1336,7 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 		}
 		p.print(blank)
 		p.stmt(s.Assign, false)
-		p.print(indent)
-		p.block(s.Body, 0)
-		p.print(unindent)
+		p.clauseBlock(s.Body)
 
 	case *ast.CommClause:
 		if s.Comm != nil {
@@ -1214,35 +1349,37 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 		p.stmtList(s.Body, 1, nextIsRBrace)
 
 	case *ast.SelectStmt:
-		p.print(token.SELECT, blank)
-		p.print(indent)
+		p.print(token.SELECT)
 		body := s.Body
-		if len(body.List) == 0 && !p.commentBefore(p.posFor(body.Rbrace)) {
-			// print empty select statement w/o comments on one line
-			p.internalError("found a select without body")
+		if len(body.List) > 0 || p.commentBefore(p.posFor(body.Rbrace)) {
+			// an empty select statement w/o comments is printed
+			// without a body
+			p.print(blank)
+			p.clauseBlock(body)
 		} else {
-			p.block(body, 0)
+			p.skipBody(body.Rbrace)
 		}
-		p.print(unindent)
 
 	case *ast.ForStmt:
 		p.print(token.FOR)
 		p.controlClause(true, s.Init, s.Cond, s.Post)
-		p.block(s.Body, 1)
+		p.body(s.Body)
 
 	case *ast.RangeStmt:
 		p.print(token.FOR, blank)
-		p.expr(s.Key)
-		if s.Value != nil {
-			// use position of value following the comma as
-			// comma position for correct comment placement
-			p.print(s.Value.Pos(), token.COMMA, blank)
-			p.expr(s.Value)
-		}
-		p.print(blank, s.TokPos, s.Tok, blank, token.RANGE, blank)
+		if s.Key != nil {
+			p.expr(s.Key)
+			if s.Value != nil {
+				// use position of value following the comma as
+				// comma position for correct comment placement
+				p.print(s.Value.Pos(), token.COMMA, blank)
+				p.expr(s.Value)
+			}
+			p.print(blank, s.TokPos, s.Tok, blank)
+		}
+		p.print(token.RANGE, blank)
 		p.expr(stripParens(s.X))
-		p.print(blank)
-		p.block(s.Body, 1)
+		p.body(s.Body)
 
 	default:
 		panic("unreachable")
@@ -1378,11 +1515,17 @@ func (p *printer) spec(spec ast.Spec, n int, doIndent bool) {
 	case *ast.TypeSpec:
 		p.setComment(s.Doc)
 		p.expr(s.Name)
+		if s.TypeParams != nil {
+			p.parameters(s.TypeParams, typeTParam)
+		}
 		if n == 1 {
 			p.print(blank)
 		} else {
 			p.print(vtab)
 		}
+		if s.Assign.IsValid() {
+			p.print(token.ASSIGN, blank)
+		}
 		p.expr(s.Type)
 		p.setComment(s.Comment)
 
@@ -1415,13 +1558,18 @@ func (p *printer) genDecl(d *ast.GenDecl) {
 				newSection := false
 				for i, s := range d.Specs {
 					if i > 0 {
-						p.linebreak(p.lineFor(s.Pos()), 1, ignore, newSection)
+						p.linebreak(p.specLine(s), 1, ignore, newSection)
 					}
 					p.spec(s, n, false)
 					newSection = p.isMultiLine(s)
 				}
 			}
 			p.print(unindent, formfeed)
+			p.close(d.Rparen, token.RPAREN)
+			if p.PreserveBlankLines {
+				// nor is the line of the closing parenthesis
+				p.skipBody(d.Rparen)
+			}
 		}
 
 	} else {
@@ -1430,6 +1578,27 @@ func (p *printer) genDecl(d *ast.GenDecl) {
 	}
 }
 
+// specLine returns the line of the spec s of a group as far as the line
+// breaks before it are concerned. For a spec following others dropped by
+// filterDecls, it is the line after the previous spec kept, or the one
+// after that if an empty line separated any two of the original specs
+// between them, so that the dropped specs leave no gap.
+func (p *printer) specLine(s ast.Spec) int {
+	run, ok := p.joined[s]
+	if !ok {
+		return p.lineFor(s.Pos())
+	}
+	_, end := nodeRange(run[0])
+	line := p.lineFor(end) + 1
+	for i := 1; i < len(run); i++ {
+		_, end := nodeRange(run[i-1])
+		if beg, _ := nodeRange(run[i]); p.lineFor(beg)-p.lineFor(end) > 1 {
+			return line + 1
+		}
+	}
+	return line
+}
+
 // nodeSize determines the size of n in chars after formatting.
 // The result is <= maxSize if the node fits on one line with at
 // most maxSize chars and the formatted output doesn't contain
@@ -1502,23 +1671,66 @@ func (p *printer) adjBlock(b *ast.BlockStmt) {
 		return
 	}
 
+	switch {
+	case len(b.List) == 0:
+		p.body(b)
+		return
+	case p.isOneLineBlock(b):
+		p.print(token.COLON, blank)
+		p.stmt(b.List[0], true)
+	default:
+		p.block(b, 1)
+		return
+	}
+	if b.Rbrace.IsValid() {
+		// the lines up to the closing brace are folded into this one
+		p.skipBody(b.Rbrace)
+	}
+}
+
+// isOneLineBlock reports whether adjBlock prints b on the current line.
+func (p *printer) isOneLineBlock(b *ast.BlockStmt) bool {
+	if p.Mode&SourceLines != 0 && p.lineFor(b.Lbrace) != p.lineFor(b.Rbrace) {
+		return false
+	}
 	switch len(b.List) {
 	case 0:
-		p.print(token.COLON)
-		return
+		return true
 	case 1:
-		if !p.commentNewline {
-			switch s := b.List[0]; s.(type) {
+		// a comment inside the block must stay on its own line
+		if !p.OneStatementPerLine && p.commentOffset >= p.posFor(b.Rbrace).Offset {
+			switch b.List[0].(type) {
 			case *ast.ReturnStmt, *ast.BranchStmt, *ast.EmptyStmt, *ast.IncDecStmt:
-				p.print(token.COLON, blank)
-				p.stmt(s, true)
-				return
+				return true
 			}
 		}
-		fallthrough
-	default:
-		p.block(b, 1)
 	}
+	return false
+}
+
+// isOneLineFuncLit reports whether x is a function literal printed with a
+// one-line body. Such a body extends to the end of the line, thus the literal
+// must be parenthesized when anything follows it on the same line.
+func (p *printer) isOneLineFuncLit(x ast.Expr) bool {
+	fn, ok := x.(*ast.FuncLit)
+	return ok && p.isOneLineBlock(fn.Body)
+}
+
+// followedExpr prints x, which is followed by other tokens on the same line.
+func (p *printer) followedExpr(x ast.Expr, depth int) {
+	if pair, isPair := x.(*ast.KeyValueExpr); isPair && p.isOneLineFuncLit(pair.Value) {
+		p.expr(pair.Key)
+		p.print(pair.Colon, token.COLON, blank)
+		p.followedExpr(pair.Value, depth)
+		return
+	}
+	if p.isOneLineFuncLit(x) {
+		p.print(token.LPAREN)
+		p.expr0(x, depth)
+		p.print(token.RPAREN)
+		return
+	}
+	p.expr0(x, depth)
 }
 
 // distanceFrom returns the column difference between from and p.pos (the current
@@ -1537,6 +1749,9 @@ func (p *printer) funcDecl(d *ast.FuncDecl) {
 	p.setComment(d.Doc)
 	p.print(d.Pos(), token.FUNC, blank)
 	if d.Recv != nil {
+		if d.Recv.NumFields() != 1 {
+			panic(unsupportedNode{p.posFor(d.Recv.Pos()), "a method without exactly one receiver"})
+		}
 		p.expr(d.Recv.List[0].Type) // method: print receiver
 		p.print(d.Pos(), ".")
 		if names := d.Recv.List[0].Names; len(names) > 0 {
@@ -1549,10 +1764,11 @@ func (p *printer) funcDecl(d *ast.FuncDecl) {
 		}
 	}
 	p.expr(d.Name)
+	if d.Type.TypeParams != nil {
+		p.parameters(d.Type.TypeParams, funcTParam)
+	}
 	p.signature(d.Type.Params, d.Type.Results)
-	p.inFunc = true
 	p.adjBlock(d.Body)
-	p.inFunc = false
 	p.print(unindent)
 }
 
@@ -1599,15 +1815,88 @@ func (p *printer) declList(list []ast.Decl) {
 			// only print line break if we are not at the beginning of the output
 			// (i.e., we are not printing only a partial program)
 			min := 1
-			if prev != tok || getDoc(d) != nil {
+			if (prev != tok || getDoc(d) != nil) && p.Mode&SourceLines == 0 {
 				min = 2
 			}
-			p.linebreak(p.lineFor(d.Pos()), min, ignore, false)
+			if first := tok != token.IMPORT && (prev == token.IMPORT || prev == token.ILLEGAL); first && p.Mode&SourceLines == 0 {
+				// exactly as many line breaks as NoBlankBeforeFirstDecl
+				// asks for, before the comments preceding d too
+				p.declLines = 2
+				if p.NoBlankBeforeFirstDecl {
+					p.declLines = 1
+				}
+				if n := p.nlimit(p.firstLine(d) - p.pos.Line); p.PreserveBlankLines && n > 0 {
+					// or as many as the source has
+					p.declLines = n
+				}
+				// the pending ones, e.g. after a group, included
+				for n := p.declLines - p.pendingLinebreaks(); n > 0; n-- {
+					p.print(newline)
+				}
+			} else {
+				p.linebreak(p.lineFor(d.Pos()), min, ignore, false)
+			}
+		}
+		if p.BeforeDecl != nil {
+			p.beforeDecl(d)
 		}
 		p.decl(d)
+		if p.AfterDecl != nil {
+			p.afterDecl(d)
+		}
+	}
+}
+
+// firstLine returns the line of d, or of the comments preceding it.
+func (p *printer) firstLine(d ast.Decl) int {
+	if pos := p.posFor(d.Pos()); p.commentOffset < pos.Offset {
+		return p.lineFor(p.comment.Pos())
+	}
+	return p.lineFor(d.Pos())
+}
+
+// beforeDecl prints the output of BeforeDecl for d, after the comments
+// preceding d but before its documentation.
+func (p *printer) beforeDecl(d ast.Decl) {
+	doc := getDoc(d)
+	next := d.Pos()
+	if doc != nil {
+		next = doc.Pos()
+	}
+	p.flush(p.posFor(next), token.ILLEGAL)
+	p.declLines = 0 // written by the flush, if not by a comment
+	if doc != nil {
+		// the blank lines after the comments flushed
+		p.linebreak(p.lineFor(doc.Pos()), 0, ignore, false)
+		p.flush(p.posFor(next), token.ILLEGAL)
+	}
+
+	var buf bytes.Buffer
+	p.BeforeDecl(d, &buf)
+	p.writeRaw(buf.Bytes(), true)
+	if doc != nil {
+		// the line breaks before doc are written already: an invalid
+		// p.last keeps writeCommentPrefix from writing more
+		p.last = token.Position{Filename: p.last.Filename}
 	}
 }
 
+// afterDecl prints the output of AfterDecl for d, after the comments on
+// the line d ends.
+func (p *printer) afterDecl(d ast.Decl) {
+	next := p.posFor(d.End())
+	if p.commentOffset < infinity && p.lineFor(p.comment.Pos()) == p.lineFor(d.End()-1) {
+		next = p.posFor(p.comment.End())
+		next.Column = 1 // as the next declaration, to keep any unindent
+	}
+	p.impliedSemi = false // the output starts a new line, as EOF
+	p.flush(next, token.ILLEGAL)
+
+	var buf bytes.Buffer
+	p.AfterDecl(d, &buf)
+	p.writeRaw(buf.Bytes(), false)
+}
+
 func (p *printer) file(src *ast.File) {
 	p.setComment(src.Doc)
 	p.print(src.Pos(), token.PACKAGE, blank)
@@ -1615,3 +1904,167 @@ func (p *printer) file(src *ast.File) {
 	p.declList(src.Decls)
 	p.print(newline)
 }
+
+// filterDecls returns a copy of the file f holding the declarations for
+// which keep returns true and the imports they use, as described for
+// Config.FilterDecls. The comments of f falling within the declarations
+// or import specs dropped, documentation and line comments included, are
+// dropped as well. The import specs kept after others dropped are mapped
+// to the original specs from the previous one kept to them, for specLine.
+func filterDecls(f *ast.File, keep func(ast.Decl) bool) (*ast.File, map[ast.Spec][]ast.Spec) {
+	var (
+		decls   []ast.Decl
+		dropped []ast.Node // comments within these are dropped
+		used    = make(map[string]bool)
+		bases   []string // of the selectors, not declared in f
+		joined  = make(map[ast.Spec][]ast.Spec)
+	)
+	for _, d := range f.Decls {
+		if d, ok := d.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			continue
+		}
+		if !keep(d) {
+			dropped = append(dropped, d)
+			continue
+		}
+		ast.Inspect(d, func(n ast.Node) bool {
+			if x, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := x.X.(*ast.Ident); ok {
+					used[id.Name] = true
+					if id.Obj == nil {
+						bases = append(bases, id.Name)
+					}
+				}
+			}
+			return true
+		})
+	}
+	// a base which is not the name of an import may be that of any of
+	// those whose name is guessed
+	names := make(map[string]bool)
+	for _, s := range f.Imports {
+		name, _ := importName(s)
+		names[name] = true
+	}
+	guessed := false
+	for _, name := range bases {
+		if !names[name] {
+			guessed = true
+			break
+		}
+	}
+
+	for _, d := range f.Decls {
+		gen, ok := d.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			if keep(d) {
+				decls = append(decls, d)
+			}
+			continue
+		}
+		var specs []ast.Spec
+		prev := 0 // index of the previous spec kept
+		for i, s := range gen.Specs {
+			spec := s.(*ast.ImportSpec)
+			name, sure := importName(spec)
+			if name == "_" || name == "." || used[name] || !token.IsIdentifier(name) || !sure || guessed && spec.Name == nil {
+				if len(specs) > 0 && i > prev+1 {
+					joined[s] = gen.Specs[prev : i+1]
+				}
+				specs = append(specs, s)
+				prev = i
+			} else {
+				dropped = append(dropped, s)
+			}
+		}
+		switch {
+		case len(specs) == len(gen.Specs):
+			decls = append(decls, gen)
+		case len(specs) > 0:
+			g := *gen
+			g.Specs = specs
+			decls = append(decls, &g)
+		default:
+			dropped = append(dropped, gen)
+		}
+	}
+
+	var comments []*ast.CommentGroup
+	for _, c := range f.Comments {
+		in := false
+		for _, n := range dropped {
+			if beg, end := nodeRange(n); beg <= c.Pos() && c.End() <= end {
+				in = true
+				break
+			}
+		}
+		if !in {
+			comments = append(comments, c)
+		}
+	}
+
+	res := *f
+	res.Decls = decls
+	res.Comments = comments
+	return &res, joined
+}
+
+// filterFile returns f as printed with FilterDecls or PruneUnusedImports,
+// f itself if neither is set, and the specs joined by filterDecls.
+func (cfg *Config) filterFile(f *ast.File) (*ast.File, map[ast.Spec][]ast.Spec) {
+	switch {
+	case cfg.FilterDecls != nil:
+		return filterDecls(f, cfg.FilterDecls)
+	case cfg.PruneUnusedImports:
+		return filterDecls(f, func(ast.Decl) bool { return true })
+	}
+	return f, nil
+}
+
+// importName returns the name an import is referred to by: its explicit
+// name, or else the last element of its path, not counting a major
+// version suffix such as "v2". The name is not sure when guessed from a
+// path with such a suffix: "k8s.io/api/core/v1" is package v1.
+func importName(s *ast.ImportSpec) (name string, sure bool) {
+	if s.Name != nil {
+		return s.Name.Name, true
+	}
+	path := strings.Trim(s.Path.Value, "`\"")
+	elems := strings.Split(path, "/")
+	name = elems[len(elems)-1]
+	if n := len(elems); n > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		return elems[n-2], false
+	}
+	return name, true
+}
+
+// nodeRange returns the range of source covered by the declaration or
+// spec n, including its documentation and line comments.
+func nodeRange(n ast.Node) (beg, end token.Pos) {
+	beg, end = n.Pos(), n.End()
+	if doc := getDoc(n); doc != nil {
+		beg = doc.Pos()
+	}
+	var specs []ast.Spec
+	switch n := n.(type) {
+	case *ast.GenDecl:
+		specs = n.Specs
+	case ast.Spec:
+		specs = []ast.Spec{n}
+	}
+	for _, s := range specs {
+		var c *ast.CommentGroup
+		switch s := s.(type) {
+		case *ast.ImportSpec:
+			c = s.Comment
+		case *ast.ValueSpec:
+			c = s.Comment
+		case *ast.TypeSpec:
+			c = s.Comment
+		}
+		if c != nil && c.End() > end {
+			end = c.End()
+		}
+	}
+	return
+}
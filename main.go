@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"runtime/pprof"
 	"strconv"
 	"strings"
 
@@ -25,6 +26,9 @@ const (
 	BUILD
 	RUN
 	TEST
+	FMT
+	REPORT
+	VERSION
 )
 
 var commands = []string{
@@ -33,12 +37,26 @@ var commands = []string{
 	BUILD:   "build",
 	RUN:     "run",
 	TEST:    "test",
+	FMT:     "fmt",
+	REPORT:  "report",
+	VERSION: "version",
 }
 
+// The version of igo and the git commit it is built from, set at build
+// time, e.g. with -ldflags "-X main.version=v0.2 -X main.commit=abc123".
+var (
+	version = "devel"
+	commit  = ""
+)
+
+var (
+	cpuProfile = flag.String("cpuprofile", "", "write a CPU profile of the run to this file")
+	memProfile = flag.String("memprofile", "", "write a heap profile, taken at the end of the run, to this file")
+)
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "usage: igo ["+strings.Join(commands[1:], "|")+"] [flags] [path ...]\n")
 	flag.PrintDefaults()
-	os.Exit(2)
 }
 
 func toCmd(c string) Cmd {
@@ -75,7 +93,7 @@ func parseError(err []byte) {
 			line, _ := strconv.Atoi(match[0][2])
 			col, _ := strconv.Atoi(match[0][3])
 			message := match[0][4]
-			igoFile := strings.TrimSuffix(file, ".go") + ".igo"
+			igoFile := cmd.SourceName(file)
 			if pos := cmd.IgoPositions[igoFile]; pos != nil {
 				var cols []int
 				for in, out := range *pos {
@@ -99,14 +117,62 @@ func parseError(err []byte) {
 	}
 }
 
+// writeHeapProfile writes a heap profile to filename, after a garbage
+// collection so that it is up to date.
+func writeHeapProfile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func main() {
 	flag.Usage = usage
 	flag.Parse()
+	os.Exit(run())
+}
+
+// run runs the command of the command line and returns the exit code. The
+// -cpuprofile and -memprofile files are written on return, whatever the
+// exit code.
+func run() (exitCode int) {
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		defer func() {
+			pprof.StopCPUProfile()
+			if err := f.Close(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exitCode = 2
+			}
+		}()
+	}
+	if *memProfile != "" {
+		defer func() {
+			if err := writeHeapProfile(*memProfile); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				exitCode = 2
+			}
+		}()
+	}
 
 	var (
-		command  Cmd
-		paths    []string
-		exitCode = 0
+		command Cmd
+		paths   []string
 	)
 
 	for i := 0; i < flag.NArg(); i++ {
@@ -121,10 +187,50 @@ func main() {
 
 	switch command {
 	case PARSE:
-		exitCode = cmd.To(cmd.IGO, paths)
+		switch {
+		case *cmd.CheckOnly:
+			exitCode = cmd.Check(cmd.IGO, paths)
+		case *cmd.FuncName != "":
+			exitCode = cmd.Func(cmd.IGO, *cmd.FuncName, paths)
+		case *cmd.MergeMode:
+			exitCode = cmd.Merge(cmd.IGO, paths)
+		case *cmd.JSONMode:
+			exitCode = cmd.JSON(cmd.IGO, paths)
+		case *cmd.VariantsMode:
+			exitCode = cmd.Variants(cmd.IGO, paths)
+		case *cmd.WatchMode:
+			exitCode = cmd.Watch(cmd.IGO, paths)
+		default:
+			exitCode = cmd.To(cmd.IGO, paths)
+		}
 	case COMPILE:
-		os.Chdir(*cmd.DestDir)
-		exitCode = cmd.To(cmd.GO, paths)
+		switch {
+		case *cmd.CheckOnly:
+			exitCode = cmd.Check(cmd.GO, paths)
+		case *cmd.FuncName != "":
+			exitCode = cmd.Func(cmd.GO, *cmd.FuncName, paths)
+		case *cmd.MergeMode:
+			exitCode = cmd.Merge(cmd.GO, paths)
+		case *cmd.JSONMode:
+			exitCode = cmd.JSON(cmd.GO, paths)
+		case *cmd.DocMode:
+			exitCode = cmd.Doc(paths)
+		case *cmd.VariantsMode:
+			os.Chdir(*cmd.DestDir)
+			exitCode = cmd.Variants(cmd.GO, paths)
+		case *cmd.WatchMode:
+			os.Chdir(*cmd.DestDir)
+			exitCode = cmd.Watch(cmd.GO, paths)
+		default:
+			os.Chdir(*cmd.DestDir)
+			exitCode = cmd.To(cmd.GO, paths)
+		}
+	case FMT:
+		if *cmd.ByteRange != "" {
+			exitCode = cmd.FormatRange(paths)
+			break
+		}
+		exitCode = cmd.Format(paths)
 	case BUILD, RUN, TEST:
 		os.Chdir(*cmd.DestDir)
 		exitCode = cmd.To(cmd.GO, paths)
@@ -136,9 +242,17 @@ func main() {
 				exitCode = 1
 			}
 		}
+	case REPORT:
+		exitCode = cmd.Report(paths)
+	case VERSION:
+		fmt.Printf("igo version %s %s/%s (%s)\n", version, runtime.GOOS, runtime.GOARCH, runtime.Version())
+		if commit != "" {
+			fmt.Printf("commit %s\n", commit)
+		}
 	default:
 		fmt.Fprintln(os.Stderr, "Invalid command")
 		usage()
+		exitCode = 2
 	}
-	os.Exit(exitCode)
+	return exitCode
 }
@@ -304,7 +304,16 @@ func (p *parser) consumeCommentGroup(n int) (comments *ast.CommentGroup, endline
 // Lead and line comments may be considered documentation that is
 // stored in the AST.
 //
+// Unlike in Go, a line comment is scanned before the newline ending its
+// line: it is kept until that newline is consumed, so that it is still
+// known after expectSemi, where the declarations of fields and specs
+// collect it.
+//
 func (p *parser) next() {
+	var lineComment *ast.CommentGroup
+	if p.isIndent() {
+		lineComment = p.lineComment
+	}
 	p.leadComment = nil
 	p.lineComment = nil
 	prev := p.pos
@@ -318,7 +327,7 @@ func (p *parser) next() {
 			// The comment is on same line as the previous token; it
 			// cannot be a lead comment but may be a line comment.
 			comment, endline = p.consumeCommentGroup(0)
-			if p.file.Line(p.pos) != endline {
+			if p.file.Line(p.pos) != endline || p.isIndent() {
 				// The next token is on a different line, thus
 				// the last comment group is a line comment.
 				p.lineComment = comment
@@ -338,6 +347,10 @@ func (p *parser) next() {
 		}
 
 	}
+
+	if p.lineComment == nil {
+		p.lineComment = lineComment
+	}
 }
 
 // A bailout panic is raised to indicate early termination.
@@ -402,7 +415,7 @@ func (p *parser) expectClosing(tok token.Token, context string) token.Pos {
 
 func (p *parser) expectSemi() {
 	// semicolon is optional before:
-	if p.tok != token.RPAREN && p.tok != token.RBRACE && p.tok != token.DEDENT {
+	if p.tok != token.RPAREN && p.tok != token.RBRACE && p.tok != token.DEDENT && p.tok != token.EOF {
 		switch {
 		case p.tok == token.SEMICOLON:
 			p.next()
@@ -623,19 +636,53 @@ func (p *parser) parseTypeName() ast.Expr {
 	return ident
 }
 
-func (p *parser) parseArrayType() ast.Expr {
+// parseTypeInstance parses the type arguments instantiating the generic
+// type typ.
+func (p *parser) parseTypeInstance(typ ast.Expr) ast.Expr {
 	if p.trace {
-		defer un(trace(p, "ArrayType"))
+		defer un(trace(p, "TypeInstance"))
 	}
 
 	lbrack := p.expect(token.LBRACK)
-	var len ast.Expr
-	// always permit ellipsis for more fault-tolerant parsing
-	if p.tok == token.ELLIPSIS {
-		len = &ast.Ellipsis{Ellipsis: p.pos}
+	p.exprLev++
+	var list []ast.Expr
+	for p.tok != token.RBRACK && p.tok != token.EOF {
+		list = append(list, p.parseType())
+		if !p.atComma("type argument list") {
+			break
+		}
 		p.next()
-	} else if p.tok != token.RBRACK {
-		len = p.parseRhs()
+	}
+	p.exprLev--
+	rbrack := p.expectClosing(token.RBRACK, "type argument list")
+
+	return packIndexExpr(typ, lbrack, list, rbrack)
+}
+
+// packIndexExpr returns the IndexExpr x[indices[0]], or the IndexListExpr
+// x[indices...] if there is not exactly one index.
+func packIndexExpr(x ast.Expr, lbrack token.Pos, indices []ast.Expr, rbrack token.Pos) ast.Expr {
+	if len(indices) == 1 {
+		return &ast.IndexExpr{X: x, Lbrack: lbrack, Index: indices[0], Rbrack: rbrack}
+	}
+	return &ast.IndexListExpr{X: x, Lbrack: lbrack, Indices: indices, Rbrack: rbrack}
+}
+
+// parseArrayType parses an array or slice type whose '[' is at lbrack
+// and whose length, if not nil, has been parsed already.
+func (p *parser) parseArrayType(lbrack token.Pos, len ast.Expr) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "ArrayType"))
+	}
+
+	if len == nil {
+		// always permit ellipsis for more fault-tolerant parsing
+		if p.tok == token.ELLIPSIS {
+			len = &ast.Ellipsis{Ellipsis: p.pos}
+			p.next()
+		} else if p.tok != token.RBRACK {
+			len = p.parseRhs()
+		}
 	}
 	p.expect(token.RBRACK)
 	elt := p.parseType()
@@ -684,7 +731,7 @@ func (p *parser) parseFieldDecl(scope *ast.Scope) *ast.Field {
 	} else {
 		// ["*"] TypeName (AnonymousField)
 		typ = list[0] // we always have at least one element
-		if n := len(list); n > 1 || !isTypeName(deref(typ)) {
+		if n := len(list); n > 1 || !isTypeName(deref(typ)) && !isTypeInstance(deref(typ)) {
 			pos := typ.Pos()
 			p.errorExpected(pos, "anonymous field")
 			typ = &ast.BadExpr{From: pos, To: list[n-1].End()}
@@ -720,6 +767,9 @@ func (p *parser) parseStructType() *ast.StructType {
 		start = p.expect(token.COLON)
 		if p.tok == token.IDENT || p.tok == token.MUL || p.tok == token.LPAREN {
 			list = append(list, p.parseFieldDecl(scope))
+			end = list[0].End() // a one-line struct
+			// a comment ending the line follows the type, not the field
+			list[0].Comment = nil
 		} else {
 			p.expect(token.IDENT)
 		}
@@ -727,7 +777,8 @@ func (p *parser) parseStructType() *ast.StructType {
 		p.expectSemi()
 		if p.tok == token.INDENT {
 			start = p.expect(token.INDENT)
-			for p.tok == token.IDENT {
+			// a field may also be an embedded pointer, e.g. *pkg.T
+			for p.tok == token.IDENT || p.tok == token.MUL || p.tok == token.LPAREN {
 				list = append(list, p.parseFieldDecl(scope))
 			}
 			end = p.expect(token.DEDENT)
@@ -789,6 +840,57 @@ func (p *parser) parseVarType(isParam bool) ast.Expr {
 	return typ
 }
 
+// parseVarElem parses an element of a variable list, an identifier or a
+// type, which must be present if required. An identifier followed by
+// '[' is either the name of a variable of array type, returned as x with
+// the type as array, or a generic type instantiated.
+//
+// If x is an identifier, it is not resolved.
+func (p *parser) parseVarElem(isParam, required bool) (x, array ast.Expr) {
+	if p.tok != token.IDENT {
+		if required {
+			return p.parseVarType(isParam), nil
+		}
+		return p.tryVarType(isParam), nil
+	}
+
+	x = p.parseTypeName()
+	if p.tok != token.LBRACK {
+		return x, nil
+	}
+	name, isIdent := x.(*ast.Ident)
+	if !isIdent {
+		return p.parseTypeInstance(x), nil
+	}
+
+	// name [N]E, name []E, or name[P1, P2, ...]
+	lbrack := p.expect(token.LBRACK)
+	var args []ast.Expr
+	p.exprLev++
+	for p.tok != token.RBRACK && p.tok != token.EOF {
+		args = append(args, p.parseRhsOrType())
+		if p.tok != token.COMMA {
+			break
+		}
+		p.next()
+	}
+	p.exprLev--
+	rbrack := p.expect(token.RBRACK)
+	if len(args) == 0 {
+		// name []E
+		return name, &ast.ArrayType{Lbrack: lbrack, Elt: p.parseType()}
+	}
+	if len(args) == 1 {
+		if elt := p.tryIdentOrType(); elt != nil {
+			// name [N]E
+			p.resolve(elt)
+			return name, &ast.ArrayType{Lbrack: lbrack, Len: args[0], Elt: elt}
+		}
+	}
+	// name[P1, P2, ...]
+	return packIndexExpr(name, lbrack, args, rbrack), nil
+}
+
 // If any of the results are identifiers, they are not resolved.
 func (p *parser) parseVarList(isParam bool) (list []ast.Expr, typ ast.Expr) {
 	if p.trace {
@@ -800,13 +902,17 @@ func (p *parser) parseVarList(isParam bool) (list []ast.Expr, typ ast.Expr) {
 	// parse/tryVarType accepts any type (including parenthesized
 	// ones) even though the syntax does not permit them here: we
 	// accept them all for more robust parsing and complain later
-	for typ := p.parseVarType(isParam); typ != nil; {
-		list = append(list, typ)
+	for x, array := p.parseVarElem(isParam, true); x != nil; {
+		list = append(list, x)
+		if array != nil {
+			// the last identifier of the list, followed by its type
+			return list, array
+		}
 		if p.tok != token.COMMA {
 			break
 		}
 		p.next()
-		typ = p.tryVarType(isParam) // maybe nil as in: func f(int,) {}
+		x, array = p.parseVarElem(isParam, false) // maybe nil as in: func f(int,) {}
 	}
 
 	// if we had a list of identifiers, it must be followed by a type
@@ -927,7 +1033,10 @@ func (p *parser) parseMethodSpec(scope *ast.Scope) *ast.Field {
 	doc := p.leadComment
 	var idents []*ast.Ident
 	var typ ast.Expr
-	x := p.parseTypeName()
+	var x ast.Expr
+	if p.tok == token.IDENT {
+		x = p.parseTypeName()
+	}
 	if ident, isIdent := x.(*ast.Ident); isIdent && p.tok == token.LPAREN {
 		// method
 		idents = []*ast.Ident{ident}
@@ -935,9 +1044,12 @@ func (p *parser) parseMethodSpec(scope *ast.Scope) *ast.Field {
 		params, results := p.parseSignature(scope)
 		typ = &ast.FuncType{Func: token.NoPos, Params: params, Results: results}
 	} else {
-		// embedded interface
-		typ = x
-		p.resolve(typ)
+		// embedded interface, or type set: a union of types and of
+		// ~terms, e.g. ~int | ~string
+		if x != nil {
+			p.resolve(x)
+		}
+		typ = p.embeddedElem(x)
 	}
 
 	// We can allow it on the same line
@@ -951,6 +1063,63 @@ func (p *parser) parseMethodSpec(scope *ast.Scope) *ast.Field {
 	return spec
 }
 
+// embeddedElem parses the union of type terms starting with x, if not
+// nil, of an interface.
+func (p *parser) embeddedElem(x ast.Expr) ast.Expr {
+	if p.trace {
+		defer un(trace(p, "EmbeddedElem"))
+	}
+
+	if x == nil {
+		x = p.embeddedTerm()
+	}
+	for p.tok == token.OR {
+		t := new(ast.BinaryExpr)
+		t.OpPos = p.pos
+		t.Op = token.OR
+		p.next()
+		t.X = x
+		t.Y = p.embeddedTerm()
+		x = t
+	}
+	return x
+}
+
+// embeddedTerm parses a type, or a ~term, of the union of an interface.
+func (p *parser) embeddedTerm() ast.Expr {
+	if p.trace {
+		defer un(trace(p, "EmbeddedTerm"))
+	}
+
+	if p.tok == token.TILDE {
+		t := new(ast.UnaryExpr)
+		t.OpPos = p.pos
+		t.Op = token.TILDE
+		p.next()
+		t.X = p.parseType()
+		return t
+	}
+
+	t := p.tryType()
+	if t == nil {
+		pos := p.pos
+		p.errorExpected(pos, "~ term or type")
+		p.next() // make progress
+		return &ast.BadExpr{From: pos, To: p.pos}
+	}
+	return t
+}
+
+// isInterfaceElem reports whether the current token starts an element of
+// an interface: a method, an embedded interface or a union of types.
+func (p *parser) isInterfaceElem() bool {
+	switch p.tok {
+	case token.IDENT, token.TILDE, token.LBRACK, token.MUL, token.MAP, token.CHAN, token.FUNC:
+		return true
+	}
+	return false
+}
+
 func (p *parser) parseInterfaceType() *ast.InterfaceType {
 	if p.trace {
 		defer un(trace(p, "InterfaceType"))
@@ -965,8 +1134,11 @@ func (p *parser) parseInterfaceType() *ast.InterfaceType {
 	switch p.tok {
 	case token.COLON:
 		start = p.expect(token.COLON)
-		if p.tok == token.IDENT {
+		if p.isInterfaceElem() {
 			list = append(list, p.parseMethodSpec(scope))
+			end = list[0].End() // a one-line interface
+			// a comment ending the line follows the type, not the method
+			list[0].Comment = nil
 		} else {
 			p.expect(token.IDENT)
 		}
@@ -974,7 +1146,7 @@ func (p *parser) parseInterfaceType() *ast.InterfaceType {
 		p.expectSemi()
 		if p.tok == token.INDENT {
 			start = p.expect(token.INDENT)
-			for p.tok == token.IDENT {
+			for p.isInterfaceElem() {
 				list = append(list, p.parseMethodSpec(scope))
 			}
 			end = p.expect(token.DEDENT)
@@ -1039,9 +1211,14 @@ func (p *parser) parseChanType() *ast.ChanType {
 func (p *parser) tryIdentOrType() ast.Expr {
 	switch p.tok {
 	case token.IDENT:
-		return p.parseTypeName()
+		typ := p.parseTypeName()
+		if p.tok == token.LBRACK {
+			typ = p.parseTypeInstance(typ)
+		}
+		return typ
 	case token.LBRACK:
-		return p.parseArrayType()
+		lbrack := p.expect(token.LBRACK)
+		return p.parseArrayType(lbrack, nil)
 	case token.STRUCT:
 		return p.parseStructType()
 	case token.MUL:
@@ -1101,10 +1278,11 @@ func (p *parser) parseBody(scope *ast.Scope) *ast.BlockStmt {
 	if p.tok == token.COLON {
 		colon := p.expect(token.COLON)
 		p.topScope = scope // open function scope
-		// Allow empty body
+		// Allow empty body, also closing a function literal in
+		// parentheses, e.g. (func():)()
 		var list []ast.Stmt
 		var pos token.Pos
-		if p.tok == token.SEMICOLON {
+		if p.tok == token.SEMICOLON || p.tok == token.RPAREN || p.tok == token.RBRACE {
 			pos = p.pos
 			p.expectSemi()
 		} else {
@@ -1149,6 +1327,10 @@ func (p *parser) parseBlockStmt() *ast.BlockStmt {
 		p.closeScope()
 		p.expectSemi()
 		pos := p.pos
+		if e, isEmpty := list[0].(*ast.EmptyStmt); isEmpty {
+			// an empty block ends with its line, as a function body does
+			pos = e.Semicolon
+		}
 		return &ast.BlockStmt{Opening: colon, List: list, Closing: pos, Small: true}
 	} else {
 		p.expectSemi()
@@ -1178,6 +1360,10 @@ func (p *parser) parseFuncTypeOrLit() ast.Expr {
 	}
 
 	typ, scope := p.parseFuncType()
+	if p.tok == token.RPAREN {
+		// function type only, e.g. in the conversion (func())(f)
+		return typ
+	}
 
 	p.exprLev++
 	body := p.parseBody(scope)
@@ -1289,7 +1475,22 @@ func (p *parser) parseIndexOrSlice(x ast.Expr) ast.Expr {
 	var low, high ast.Expr
 	isSlice := false
 	if p.tok != token.COLON {
-		low = p.parseRhs()
+		// an index may be a type argument too
+		low = p.parseRhsOrType()
+	}
+	if p.tok == token.COMMA {
+		// the type arguments of a generic function or type
+		list := []ast.Expr{low}
+		for p.tok == token.COMMA {
+			p.next()
+			if p.tok == token.RBRACK {
+				break
+			}
+			list = append(list, p.parseRhsOrType())
+		}
+		p.exprLev--
+		rbrack := p.expectClosing(token.RBRACK, "type argument list")
+		return &ast.IndexListExpr{X: x, Lbrack: lbrack, Indices: list, Rbrack: rbrack}
 	}
 	if p.tok == token.COLON {
 		isSlice = true
@@ -1435,6 +1636,7 @@ func (p *parser) checkExpr(x ast.Expr) ast.Expr {
 		panic("unreachable")
 	case *ast.SelectorExpr:
 	case *ast.IndexExpr:
+	case *ast.IndexListExpr:
 	case *ast.SliceExpr:
 	case *ast.TypeAssertExpr:
 		// If t.Type == nil we have a type assertion of the form
@@ -1476,6 +1678,8 @@ func isLiteralType(x ast.Expr) bool {
 	case *ast.SelectorExpr:
 		_, isIdent := t.X.(*ast.Ident)
 		return isIdent
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		return isTypeInstance(t)
 	case *ast.ArrayType:
 	case *ast.StructType:
 	case *ast.MapType:
@@ -1485,6 +1689,17 @@ func isLiteralType(x ast.Expr) bool {
 	return true
 }
 
+// isTypeInstance returns true iff x is an instantiated generic TypeName.
+func isTypeInstance(x ast.Expr) bool {
+	switch t := x.(type) {
+	case *ast.IndexExpr:
+		return isTypeName(t.X)
+	case *ast.IndexListExpr:
+		return isTypeName(t.X)
+	}
+	return false
+}
+
 // If x is of the form *T, deref returns T, otherwise it returns x.
 func deref(x ast.Expr) ast.Expr {
 	if p, isPtr := x.(*ast.StarExpr); isPtr {
@@ -1521,12 +1736,15 @@ func (p *parser) checkExprOrType(x ast.Expr) ast.Expr {
 }
 
 // If lhs is set and the result is an identifier, it is not resolved.
-func (p *parser) parsePrimaryExpr(lhs bool) ast.Expr {
+// If x is not nil, it is the operand, parsed already.
+func (p *parser) parsePrimaryExpr(x ast.Expr, lhs bool) ast.Expr {
 	if p.trace {
 		defer un(trace(p, "PrimaryExpr"))
 	}
 
-	x := p.parseOperand(lhs)
+	if x == nil {
+		x = p.parseOperand(lhs)
+	}
 L:
 	for {
 		switch p.tok {
@@ -1641,7 +1859,7 @@ func (p *parser) parseUnaryExpr(lhs bool) ast.Expr {
 		return &ast.StarExpr{Star: pos, X: p.checkExprOrType(x)}
 	}
 
-	return p.parsePrimaryExpr(lhs)
+	return p.parsePrimaryExpr(nil, lhs)
 }
 
 func (p *parser) tokPrec() (token.Token, int) {
@@ -1653,12 +1871,15 @@ func (p *parser) tokPrec() (token.Token, int) {
 }
 
 // If lhs is set and the result is an identifier, it is not resolved.
-func (p *parser) parseBinaryExpr(lhs bool, prec1 int) ast.Expr {
+// If x is not nil, it is the first operand, parsed already.
+func (p *parser) parseBinaryExpr(x ast.Expr, lhs bool, prec1 int) ast.Expr {
 	if p.trace {
 		defer un(trace(p, "BinaryExpr"))
 	}
 
-	x := p.parseUnaryExpr(lhs)
+	if x == nil {
+		x = p.parseUnaryExpr(lhs)
+	}
 	for _, prec := p.tokPrec(); prec >= prec1; prec-- {
 		for {
 			op, oprec := p.tokPrec()
@@ -1670,7 +1891,7 @@ func (p *parser) parseBinaryExpr(lhs bool, prec1 int) ast.Expr {
 				p.resolve(x)
 				lhs = false
 			}
-			y := p.parseBinaryExpr(false, prec+1)
+			y := p.parseBinaryExpr(nil, false, prec+1)
 			x = &ast.BinaryExpr{X: p.checkExpr(x), OpPos: pos, Op: op, Y: p.checkExpr(y)}
 		}
 	}
@@ -1687,7 +1908,7 @@ func (p *parser) parseExpr(lhs bool) ast.Expr {
 		defer un(trace(p, "Expression"))
 	}
 
-	return p.parseBinaryExpr(lhs, token.LowestPrec+1)
+	return p.parseBinaryExpr(nil, lhs, token.LowestPrec+1)
 }
 
 func (p *parser) parseRhs() ast.Expr {
@@ -1768,6 +1989,10 @@ func (p *parser) parseSimpleStmt(mode int) (ast.Stmt, bool) {
 			colon := p.pos
 			p.next()
 			stmt := &ast.LabeledStmt{Label: label, Colon: colon, Stmt: p.parseStmt()}
+			if _, isEmpty := stmt.Stmt.(*ast.EmptyStmt); isEmpty {
+				// L: ; the line ends after the semicolon
+				p.expectSemi()
+			}
 			p.declare(stmt, nil, p.labelScope, ast.Lbl, label)
 			return stmt, false
 		}
@@ -1848,7 +2073,7 @@ func (p *parser) parseReturnStmt() *ast.ReturnStmt {
 	pos := p.pos
 	p.expect(token.RETURN)
 	var x []ast.Expr
-	if p.tok != token.SEMICOLON && p.tok != token.DEDENT {
+	if p.tok != token.SEMICOLON && p.tok != token.DEDENT && p.tok != token.RPAREN && p.tok != token.RBRACE && p.tok != token.EOF {
 		x = p.parseRhsList()
 	}
 	p.expectSemi()
@@ -2031,15 +2256,22 @@ func (p *parser) parseSwitchStmt() ast.Stmt {
 	}
 
 	typeSwitch := isTypeSwitchGuard(s2)
+	end := p.pos
 	p.expectSemi()
-	indent := p.expect(token.INDENT)
-	var list []ast.Stmt
-	for p.tok == token.CASE || p.tok == token.DEFAULT {
-		list = append(list, p.parseCaseClause(typeSwitch))
+	var body *ast.BlockStmt
+	if p.tok != token.INDENT {
+		// switch without clauses
+		body = &ast.BlockStmt{Opening: end, Closing: end}
+	} else {
+		indent := p.expect(token.INDENT)
+		var list []ast.Stmt
+		for p.tok == token.CASE || p.tok == token.DEFAULT {
+			list = append(list, p.parseCaseClause(typeSwitch))
+		}
+		dedent := p.expect(token.DEDENT)
+		// p.expectSemi()
+		body = &ast.BlockStmt{Opening: indent, List: list, Closing: dedent}
 	}
-	dedent := p.expect(token.DEDENT)
-	// p.expectSemi()
-	body := &ast.BlockStmt{Opening: indent, List: list, Closing: dedent}
 
 	if typeSwitch {
 		return &ast.TypeSwitchStmt{Switch: pos, Init: s1, Assign: s2, Body: body}
@@ -2115,6 +2347,11 @@ func (p *parser) parseSelectStmt() *ast.SelectStmt {
 
 	pos := p.expect(token.SELECT)
 	p.expectSemi()
+	if p.tok != token.INDENT {
+		// select without clauses, blocking forever
+		end := pos + token.Pos(len("select"))
+		return &ast.SelectStmt{Select: pos, Body: &ast.BlockStmt{Opening: end, Closing: end}}
+	}
 	indent := p.expect(token.INDENT)
 	var list []ast.Stmt
 	for p.tok == token.CASE || p.tok == token.DEFAULT {
@@ -2141,7 +2378,14 @@ func (p *parser) parseForStmt() ast.Stmt {
 	if !p.isIndent() && p.tok != token.COLON {
 		prevLev := p.exprLev
 		p.exprLev = -1
-		if p.tok != token.SEMICOLON {
+		if p.tok == token.RANGE {
+			// "for range x" (nil key and value)
+			pos := p.pos
+			p.next()
+			y := []ast.Expr{&ast.UnaryExpr{OpPos: pos, Op: token.RANGE, X: p.parseRhs()}}
+			s2 = &ast.AssignStmt{Rhs: y}
+			isRange = true
+		} else if p.tok != token.SEMICOLON {
 			s2, isRange = p.parseSimpleStmt(rangeOk)
 		}
 		if !isRange && p.tok == token.SEMICOLON && !p.isIndent() {
@@ -2152,7 +2396,7 @@ func (p *parser) parseForStmt() ast.Stmt {
 				s2, _ = p.parseSimpleStmt(basic)
 			}
 			p.expectSemi()
-			if !p.isIndent() {
+			if !p.isIndent() && p.tok != token.COLON {
 				s3, _ = p.parseSimpleStmt(basic)
 			}
 		}
@@ -2167,6 +2411,8 @@ func (p *parser) parseForStmt() ast.Stmt {
 		// check lhs
 		var key, value ast.Expr
 		switch len(as.Lhs) {
+		case 0:
+			// nothing to do
 		case 2:
 			key, value = as.Lhs[0], as.Lhs[1]
 		case 1:
@@ -2398,7 +2644,41 @@ func (p *parser) parseTypeSpec(doc *ast.CommentGroup, _ token.Token, _ int) ast.
 	spec := &ast.TypeSpec{Doc: doc, Name: ident}
 	p.declare(spec, nil, p.topScope, ast.Typ, ident)
 
-	spec.Type = p.parseType()
+	if p.tok == token.LBRACK {
+		// type parameters or array type: the name of the first type
+		// parameter is followed by a comma or by its constraint, while
+		// the length of an array is an expression
+		lbrack := p.expect(token.LBRACK)
+		if p.tok == token.IDENT {
+			x := ast.Expr(p.parseIdent())
+			switch p.tok {
+			case token.COMMA, token.IDENT, token.LBRACK, token.INTERFACE, token.FUNC, token.MAP, token.CHAN, token.ARROW, token.STRUCT, token.TILDE:
+				p.openScope()
+				spec.TypeParams = p.parseTypeParams(p.topScope, lbrack, []*ast.Ident{x.(*ast.Ident)})
+				if p.tok == token.ASSIGN {
+					// generic type alias
+					spec.Assign = p.pos
+					p.next()
+				}
+				spec.Type = p.parseType()
+				p.closeScope()
+			default:
+				p.exprLev++
+				x = p.parseBinaryExpr(p.parsePrimaryExpr(x, false), false, token.LowestPrec+1)
+				p.exprLev--
+				spec.Type = p.parseArrayType(lbrack, p.checkExpr(x))
+			}
+		} else {
+			spec.Type = p.parseArrayType(lbrack, nil)
+		}
+	} else {
+		if p.tok == token.ASSIGN {
+			// type alias
+			spec.Assign = p.pos
+			p.next()
+		}
+		spec.Type = p.parseType()
+	}
 	p.expectSemi() // call before accessing p.linecomment
 	spec.Comment = p.lineComment
 
@@ -2415,12 +2695,18 @@ func (p *parser) parseGenDecl(keyword token.Token, f parseSpecFunction) *ast.Gen
 	var indent, dedent token.Pos
 	var list []ast.Spec
 	if p.tok == token.SEMICOLON {
+		end := p.pos
 		p.expectSemi()
-		indent = p.expect(token.INDENT)
-		for iota := 0; p.tok != token.DEDENT && p.tok != token.EOF; iota++ {
-			list = append(list, f(p.leadComment, keyword, iota))
+		if p.tok != token.INDENT {
+			// an empty group
+			indent, dedent = end, end
+		} else {
+			indent = p.expect(token.INDENT)
+			for iota := 0; p.tok != token.DEDENT && p.tok != token.EOF; iota++ {
+				list = append(list, f(p.leadComment, keyword, iota))
+			}
+			dedent = p.expect(token.DEDENT)
 		}
-		dedent = p.expect(token.DEDENT)
 	} else {
 		list = append(list, f(nil, keyword, 0))
 	}
@@ -2435,6 +2721,42 @@ func (p *parser) parseGenDecl(keyword token.Token, f parseSpecFunction) *ast.Gen
 	}
 }
 
+// parseTypeParams parses a list of type parameters, opened by '[' at
+// lbrack, declaring them in scope. The names of the first parameters,
+// if not nil, have been parsed already.
+func (p *parser) parseTypeParams(scope *ast.Scope, lbrack token.Pos, names []*ast.Ident) *ast.FieldList {
+	if p.trace {
+		defer un(trace(p, "TypeParams"))
+	}
+
+	var list []*ast.Field
+	for p.tok != token.RBRACK && p.tok != token.EOF {
+		if names == nil {
+			names = p.parseIdentList()
+		} else {
+			for p.tok == token.COMMA {
+				p.next()
+				names = append(names, p.parseIdent())
+			}
+		}
+		// the constraint may be a union, e.g. ~int | ~string
+		field := &ast.Field{Names: names, Type: p.embeddedElem(nil)}
+		p.declare(field, nil, scope, ast.Typ, names...)
+		list = append(list, field)
+		names = nil
+		if !p.atComma("type parameter list") {
+			break
+		}
+		p.next()
+	}
+	rbrack := p.expectClosing(token.RBRACK, "type parameter list")
+	if len(list) == 0 {
+		p.error(rbrack, "empty type parameter list")
+	}
+
+	return &ast.FieldList{Opening: lbrack, List: list, Closing: rbrack}
+}
+
 func (p *parser) parseReceiver(typ ast.Expr, scope *ast.Scope) *ast.Field {
 	if p.trace {
 		defer un(trace(p, "Receiver"))
@@ -2451,6 +2773,19 @@ func (p *parser) parseReceiver(typ ast.Expr, scope *ast.Scope) *ast.Field {
 	return field
 }
 
+// parseRecvTypeParams parses the end of the type typ[names...] of a
+// receiver, from its ']', the names of the type parameters being
+// declared in scope.
+func (p *parser) parseRecvTypeParams(typ ast.Expr, lbrack token.Pos, names []*ast.Ident, scope *ast.Scope) ast.Expr {
+	rbrack := p.expectClosing(token.RBRACK, "receiver type parameter list")
+	indices := make([]ast.Expr, len(names))
+	for i, name := range names {
+		indices[i] = name
+		p.declare(nil, nil, scope, ast.Typ, name)
+	}
+	return packIndexExpr(typ, lbrack, indices, rbrack)
+}
+
 func (p *parser) parseFuncDecl() *ast.FuncDecl {
 	if p.trace {
 		defer un(trace(p, "FunctionDecl"))
@@ -2463,26 +2798,46 @@ func (p *parser) parseFuncDecl() *ast.FuncDecl {
 	var recv *ast.Field
 	var ident *ast.Ident
 	var recvList *ast.FieldList
+	var tparams *ast.FieldList
 
 	lparen := p.pos
 
 	// *T.ident
 	if p.tok == token.MUL {
 		star := p.expect(token.MUL)
-		typ := p.parseIdent()
+		var typ ast.Expr = p.parseIdent()
+		if p.tok == token.LBRACK {
+			// *T[P1, P2, ...].ident
+			lbrack := p.expect(token.LBRACK)
+			typ = p.parseRecvTypeParams(typ, lbrack, p.parseIdentList(), scope)
+		}
 		expr := &ast.StarExpr{Star: star, X: typ}
 		recv = p.parseReceiver(expr, scope)
 		p.expect(token.PERIOD)
 		ident = p.parseIdent()
 	} else {
 		ident = p.parseIdent()
+		var typ ast.Expr = ident
+		if p.tok == token.LBRACK {
+			// T[P1, P2, ...].ident, or ident[P1, P2, ... C]
+			lbrack := p.expect(token.LBRACK)
+			names := p.parseIdentList()
+			if p.tok == token.RBRACK {
+				typ = p.parseRecvTypeParams(ident, lbrack, names, scope)
+			} else {
+				tparams = p.parseTypeParams(scope, lbrack, names)
+			}
+		}
 		// T.ident
 		if p.tok == token.PERIOD {
-			recv = p.parseReceiver(ident, scope) // ident is T here
+			recv = p.parseReceiver(typ, scope) // ident is T here
 			p.next()
 			ident = p.parseIdent()
 		}
 	}
+	if p.tok == token.LBRACK && tparams == nil {
+		tparams = p.parseTypeParams(scope, p.expect(token.LBRACK), nil)
+	}
 
 	if recv != nil {
 		recvList = &ast.FieldList{
@@ -2500,9 +2855,10 @@ func (p *parser) parseFuncDecl() *ast.FuncDecl {
 		Recv: recvList,
 		Name: ident,
 		Type: &ast.FuncType{
-			Func:    pos,
-			Params:  params,
-			Results: results,
+			Func:       pos,
+			TypeParams: tparams,
+			Params:     params,
+			Results:    results,
 		},
 		Body: body,
 	}
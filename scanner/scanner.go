@@ -215,6 +215,10 @@ func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9' || ch >= 0x80 && unicode.IsDigit(ch)
 }
 
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
+
 func (s *Scanner) scanIdentifier() string {
 	offs := s.offset
 	for isLetter(s.ch) || isDigit(s.ch) {
@@ -235,83 +239,185 @@ func digitVal(ch rune) int {
 	return 16 // larger than any legal digit val
 }
 
-func (s *Scanner) scanMantissa(base int) {
-	for digitVal(s.ch) < base {
-		s.next()
+func lower(ch rune) rune     { return ('a' - 'A') | ch } // returns lower-case ch iff ch is ASCII letter
+func isDecimal(ch rune) bool { return '0' <= ch && ch <= '9' }
+func isHex(ch rune) bool     { return '0' <= ch && ch <= '9' || 'a' <= lower(ch) && lower(ch) <= 'f' }
+
+// digits accepts the sequence { digit | '_' }.
+// If base <= 10, digits accepts any decimal digit but records
+// the offset (relative to the source start) of a digit >= base
+// in *invalid, if *invalid < 0.
+// digits returns a bitset describing whether the sequence contained
+// digits (bit 0 is set), or separators '_' (bit 1 is set).
+func (s *Scanner) digits(base int, invalid *int) (digsep int) {
+	if base <= 10 {
+		max := rune('0' + base)
+		for isDecimal(s.ch) || s.ch == '_' {
+			ds := 1
+			if s.ch == '_' {
+				ds = 2
+			} else if s.ch >= max && *invalid < 0 {
+				*invalid = s.offset // record invalid rune offset
+			}
+			digsep |= ds
+			s.next()
+		}
+	} else {
+		for isHex(s.ch) || s.ch == '_' {
+			ds := 1
+			if s.ch == '_' {
+				ds = 2
+			}
+			digsep |= ds
+			s.next()
+		}
 	}
+	return
 }
 
+// scanNumber scans a number literal, with the prefixes, separators and
+// hexadecimal floats of Go 1.13. If seenDecimalPoint is set, the '.'
+// starting the literal is scanned already.
 func (s *Scanner) scanNumber(seenDecimalPoint bool) (token.Token, string) {
-	// digitVal(s.ch) < 10
 	offs := s.offset
 	tok := token.INT
 
+	base := 10        // number base
+	prefix := rune(0) // one of 0 (decimal), '0' (0-octal), 'x', 'o', or 'b'
+	digsep := 0       // bit 0: digit present, bit 1: '_' present
+	invalid := -1     // index of invalid digit in literal, or < 0
+
 	if seenDecimalPoint {
 		offs--
 		tok = token.FLOAT
-		s.scanMantissa(10)
-		goto exponent
-	}
-
-	if s.ch == '0' {
-		// int or float
-		offs := s.offset
-		s.next()
-		if s.ch == 'x' || s.ch == 'X' {
-			// hexadecimal int
+		digsep |= s.digits(base, &invalid)
+	} else {
+		// integer part
+		if s.ch == '0' {
 			s.next()
-			s.scanMantissa(16)
-			if s.offset-offs <= 2 {
-				// only scanned "0x" or "0X"
-				s.error(offs, "illegal hexadecimal number")
-			}
-		} else {
-			// octal int or float
-			seenDecimalDigit := false
-			s.scanMantissa(8)
-			if s.ch == '8' || s.ch == '9' {
-				// illegal octal int or float
-				seenDecimalDigit = true
-				s.scanMantissa(10)
-			}
-			if s.ch == '.' || s.ch == 'e' || s.ch == 'E' || s.ch == 'i' {
-				goto fraction
+			switch lower(s.ch) {
+			case 'x':
+				s.next()
+				base, prefix = 16, 'x'
+			case 'o':
+				s.next()
+				base, prefix = 8, 'o'
+			case 'b':
+				s.next()
+				base, prefix = 2, 'b'
+			default:
+				base, prefix = 8, '0'
+				digsep = 1 // leading 0
 			}
-			// octal int
-			if seenDecimalDigit {
-				s.error(offs, "illegal octal number")
+		}
+		digsep |= s.digits(base, &invalid)
+
+		// fractional part
+		if s.ch == '.' {
+			tok = token.FLOAT
+			if prefix == 'o' || prefix == 'b' {
+				s.error(s.offset, "invalid radix point in "+litname(prefix))
 			}
+			s.next()
+			digsep |= s.digits(base, &invalid)
 		}
-		goto exit
 	}
 
-	// decimal int or float
-	s.scanMantissa(10)
-
-fraction:
-	if s.ch == '.' {
-		tok = token.FLOAT
-		s.next()
-		s.scanMantissa(10)
+	if digsep&1 == 0 {
+		s.error(s.offset, litname(prefix)+" has no digits")
 	}
 
-exponent:
-	if s.ch == 'e' || s.ch == 'E' {
-		tok = token.FLOAT
+	// exponent
+	if e := lower(s.ch); e == 'e' || e == 'p' {
+		switch {
+		case e == 'e' && prefix != 0 && prefix != '0':
+			s.error(s.offset, fmt.Sprintf("%q exponent requires decimal mantissa", s.ch))
+		case e == 'p' && prefix != 'x':
+			s.error(s.offset, fmt.Sprintf("%q exponent requires hexadecimal mantissa", s.ch))
+		}
 		s.next()
-		if s.ch == '-' || s.ch == '+' {
+		tok = token.FLOAT
+		if s.ch == '+' || s.ch == '-' {
 			s.next()
 		}
-		s.scanMantissa(10)
+		ds := s.digits(10, nil)
+		digsep |= ds
+		if ds&1 == 0 {
+			s.error(s.offset, "exponent has no digits")
+		}
+	} else if prefix == 'x' && tok == token.FLOAT {
+		s.error(s.offset, "hexadecimal mantissa requires a 'p' exponent")
 	}
 
+	// suffix 'i'
 	if s.ch == 'i' {
 		tok = token.IMAG
 		s.next()
 	}
 
-exit:
-	return tok, string(s.src[offs:s.offset])
+	lit := string(s.src[offs:s.offset])
+	if tok == token.INT && invalid >= 0 {
+		s.error(invalid, fmt.Sprintf("invalid digit %q in %s", lit[invalid-offs], litname(prefix)))
+	}
+	if digsep&2 != 0 {
+		if i := invalidSep(lit); i >= 0 {
+			s.error(offs+i, "'_' must separate successive digits")
+		}
+	}
+
+	return tok, lit
+}
+
+func litname(prefix rune) string {
+	switch prefix {
+	case 'x':
+		return "hexadecimal literal"
+	case 'o', '0':
+		return "octal literal"
+	case 'b':
+		return "binary literal"
+	}
+	return "decimal literal"
+}
+
+// invalidSep returns the index of the first invalid separator in x, or -1.
+func invalidSep(x string) int {
+	x1 := ' ' // prefix char, we only care if it's 'x'
+	d := '.'  // digit, one of '_', '0' (a digit), or '.' (anything else)
+	i := 0
+
+	// a prefix counts as a digit
+	if len(x) >= 2 && x[0] == '0' {
+		x1 = lower(rune(x[1]))
+		if x1 == 'x' || x1 == 'o' || x1 == 'b' {
+			d = '0'
+			i = 2
+		}
+	}
+
+	// mantissa and exponent
+	for ; i < len(x); i++ {
+		p := d // previous digit
+		d = rune(x[i])
+		switch {
+		case d == '_':
+			if p != '0' {
+				return i
+			}
+		case isDecimal(d) || x1 == 'x' && isHex(d):
+			d = '0'
+		default:
+			if p == '_' {
+				return i - 1
+			}
+			d = '.'
+		}
+	}
+	if d == '_' {
+		return len(x) - 1
+	}
+
+	return -1
 }
 
 func (s *Scanner) scanEscape(quote rune) {
@@ -551,6 +657,76 @@ func (s *Scanner) switch4(tok0, tok1 token.Token, ch2 rune, tok2, tok3 token.Tok
 	return tok0
 }
 
+// lineEnd returns the position right after the newline terminating the
+// last non-blank line before offset, or offset itself if there is none.
+// DEDENTs are placed there so that blank lines preceding a dedent stay
+// outside of the block being closed.
+//
+func (s *Scanner) lineEnd(offset int) token.Pos {
+	i := offset
+	for i > 0 && isSpace(s.src[i-1]) {
+		i--
+	}
+	for ; i < offset; i++ {
+		if s.src[i] == '\n' {
+			return s.file.Pos(i + 1)
+		}
+	}
+	return s.file.Pos(offset)
+}
+
+// commentLevel returns the indentation level to apply to a line holding
+// only a comment, indented at cl: a comment belongs to the code following
+// it, whose line decides where blocks open and close, so that a comment
+// before a case label may be indented as the switch, or a comment after
+// the last statement of a block as the statement. Within the current
+// block, a comment keeps its own level, as long as the next code line
+// does not close the block further.
+//
+func (s *Scanner) commentLevel(cl int) int {
+	cur := s.indent.stack[s.indent.idx]
+	next := s.nextLevel()
+	switch {
+	case next > cur:
+		return next
+	case cl >= cur:
+		return cur
+	case cl < next:
+		return next
+	}
+	return cl
+}
+
+// nextLevel returns the indentation level of the first line after the
+// current one holding code, neither blank nor only a comment, or 0 if
+// there is none.
+//
+func (s *Scanner) nextLevel() int {
+	i := s.offset
+	for {
+		for i < len(s.src) && s.src[i] != '\n' {
+			i++
+		}
+		if i == len(s.src) {
+			return 0
+		}
+		i++
+		cl := 0
+		for ; i < len(s.src); i++ {
+			if s.src[i] == '\t' {
+				cl += 2
+			} else if s.src[i] == ' ' {
+				cl++
+			} else {
+				break
+			}
+		}
+		if i < len(s.src) && s.src[i] != '\n' && s.src[i] != '\r' && s.src[i] != '#' {
+			return cl
+		}
+	}
+}
+
 // Scan scans the next token and returns the token position, the token,
 // and its literal string if applicable. The source end is indicated by
 // token.EOF.
@@ -580,7 +756,8 @@ func (s *Scanner) switch4(tok0, tok1 token.Token, ch2 rune, tok2, tok3 token.Tok
 //
 // Scan adds line information to the file added to the file
 // set with Init. Token positions are relative to that file
-// and thus relative to the file set.
+// and thus relative to the file set. A token is positioned at
+// its first character, after the blanks preceding it.
 //
 func (s *Scanner) Scan() (pos token.Pos, tok token.Token, lit string) {
 newLine:
@@ -606,10 +783,14 @@ newLine:
 		}
 
 		blankLine = s.ch == '\n'
+		commentLine := s.ch == '#'
 
 		// If we are not inside [](){}
 		// Comments '#' or empty lines, should not affect indentation
 		if s.indent.level == 0 && !blankLine && !s.unfinished {
+			if commentLine {
+				cl = s.commentLevel(cl)
+			}
 			switch {
 			case cl == s.indent.stack[s.indent.idx]:
 				// noting to do
@@ -622,7 +803,7 @@ newLine:
 					s.indent.pendin--
 					s.indent.idx--
 				}
-				if cl != s.indent.stack[s.indent.idx] {
+				if cl != s.indent.stack[s.indent.idx] && !commentLine {
 					s.error(s.offset, "incosistent indentation")
 				}
 			}
@@ -632,20 +813,20 @@ newLine:
 	switch {
 	case s.indent.pendin < 0:
 		s.indent.pendin++
-		return pos - 1, token.DEDENT, "}"
+		return s.lineEnd(s.lineOffset), token.DEDENT, "}"
 
 	case s.indent.pendin > 0:
 		s.indent.pendin--
 		return pos - 1, token.INDENT, "{"
 	}
 
-	// current token start
-	pos = s.file.Pos(s.offset)
-
 scanAgain:
 
 	s.skipWhitespace()
 
+	// current token start
+	pos = s.file.Pos(s.offset)
+
 	// determine token value
 	switch ch := s.ch; {
 	case isLetter(ch):
@@ -669,7 +850,7 @@ scanAgain:
 		case -1:
 			for s.indent.idx > 0 {
 				s.indent.idx--
-				return pos, token.DEDENT, "}"
+				return s.lineEnd(s.offset), token.DEDENT, "}"
 			}
 			tok = token.EOF
 		case '\n':
@@ -799,6 +980,10 @@ scanAgain:
 			tok = s.switch2(token.NOT, token.NEQ)
 			s.unfinished = true
 			return
+		case '~':
+			tok = token.TILDE
+			s.unfinished = true
+			return
 		case '&':
 			if s.ch == '^' {
 				s.next()
@@ -0,0 +1,48 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scanner
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DAddYE/igo/token"
+)
+
+var positionTests = []struct {
+	src    string
+	tokens []string // line:column and token of each token, up to EOF
+}{
+	{"package p\n", []string{"1:1 package", "1:9 IDENT", "1:10 ;"}},
+	// the blanks before a token are not part of it
+	{"x :=  a  +\t1 # c\n", []string{"1:1 IDENT", "1:3 :=", "1:7 IDENT", "1:10 +", "1:12 INT", "1:14 COMMENT", "1:17 ;"}},
+	{"if x:  g()\n", []string{"1:1 if", "1:4 IDENT", "1:5 :", "1:8 IDENT", "1:9 (", "1:10 )", "1:11 ;"}},
+	// nor is the indentation of a line
+	{"func f()\n\t  x  =  1\n", []string{"1:1 func", "1:6 IDENT", "1:7 (", "1:8 )", "1:9 ;", "1:9 INDENT",
+		"2:4 IDENT", "2:7 =", "2:10 INT", "2:11 ;", "2:12 DEDENT"}},
+}
+
+// TestPositions checks that tokens are positioned at their first
+// character, as go/scanner does.
+func TestPositions(t *testing.T) {
+	for _, test := range positionTests {
+		fset := token.NewFileSet()
+		file := fset.AddFile("f.igo", fset.Base(), len(test.src))
+		var s Scanner
+		s.Init(file, []byte(test.src), nil, ScanComments)
+		var tokens []string
+		for {
+			pos, tok, _ := s.Scan()
+			if tok == token.EOF {
+				break
+			}
+			p := fset.Position(pos)
+			tokens = append(tokens, fmt.Sprintf("%d:%d %s", p.Line, p.Column, tok))
+		}
+		if fmt.Sprint(tokens) != fmt.Sprint(test.tokens) {
+			t.Errorf("%q:\ngot  %q\nwant %q", test.src, tokens, test.tokens)
+		}
+	}
+}
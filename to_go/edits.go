@@ -0,0 +1,182 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package to_go
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"github.com/DAddYE/igo/token"
+)
+
+// A TextEdit replaces the bytes of a source from Offset up to End, not
+// included, with NewText. The offsets are those of the source before
+// any edit is applied.
+type TextEdit struct {
+	Offset, End int
+	NewText     string
+}
+
+// FormatWithEdits formats node, parsed from src, as Fprint does and
+// returns the edits turning src into the result instead of the result
+// itself, e.g. for an editor to apply them in place of replacing the
+// whole buffer. The edits are sorted by offset and don't overlap: the
+// lines changed are found by a line diff and only the bytes in which
+// they differ are replaced.
+func (cfg *Config) FormatWithEdits(fset *token.FileSet, node interface{}, src []byte) ([]TextEdit, error) {
+	var buf bytes.Buffer
+	if _, err := cfg.Fprint(&buf, fset, node); err != nil {
+		return nil, err
+	}
+	return textEdits(src, buf.Bytes()), nil
+}
+
+// FormatWithEdits is like Config.FormatWithEdits with the settings of
+// Fprint.
+func FormatWithEdits(fset *token.FileSet, node interface{}, src []byte) ([]TextEdit, error) {
+	return (&Config{Tabwidth: 8}).FormatWithEdits(fset, node, src)
+}
+
+// textEdits returns the edits turning old into new.
+func textEdits(old, new []byte) []TextEdit {
+	a, b := splitLines(old), splitLines(new)
+	var edits []TextEdit
+	offset := 0 // of a[i] in old
+	i := 0
+	for _, h := range diffLines(a, b) {
+		for ; i < h.i0; i++ {
+			offset += len(a[i])
+		}
+		x := bytes.Join(a[h.i0:h.i1], nil)
+		y := bytes.Join(b[h.j0:h.j1], nil)
+		i = h.i1
+
+		// only replace the bytes in between the common prefix and
+		// suffix, not cutting runes in two
+		p := 0
+		for p < len(x) && p < len(y) && x[p] == y[p] {
+			p++
+		}
+		for p > 0 && (p < len(x) && !utf8.RuneStart(x[p]) || p < len(y) && !utf8.RuneStart(y[p])) {
+			p--
+		}
+		s := 0
+		for s < len(x)-p && s < len(y)-p && x[len(x)-1-s] == y[len(y)-1-s] {
+			s++
+		}
+		for s > 0 && !utf8.RuneStart(x[len(x)-s]) {
+			s--
+		}
+		edits = append(edits, TextEdit{
+			Offset:  offset + p,
+			End:     offset + len(x) - s,
+			NewText: string(y[p : len(y)-s]),
+		})
+		offset += len(x)
+	}
+	return edits
+}
+
+// splitLines splits src after each newline; the last line may not end
+// with one.
+func splitLines(src []byte) [][]byte {
+	var lines [][]byte
+	for len(src) > 0 {
+		i := bytes.IndexByte(src, '\n') + 1
+		if i == 0 {
+			i = len(src)
+		}
+		lines = append(lines, src[:i])
+		src = src[i:]
+	}
+	return lines
+}
+
+// A hunk replaces the lines [i0, i1) of a diff by the lines [j0, j1).
+type hunk struct {
+	i0, i1, j0, j1 int
+}
+
+// diffLines returns the hunks of a shortest edit script turning the
+// lines a into the lines b, as found by the algorithm of E. Myers, "An
+// O(ND) Difference Algorithm and Its Variations" (1986).
+func diffLines(a, b [][]byte) []hunk {
+	n, m := len(a), len(b)
+	max := n + m
+	off := max + 1 // of diagonal 0 in v
+	v := make([]int, 2*max+3)
+
+	// trace[d] holds v[off-d : off+d+1] after step d: the furthest
+	// reaching x on each diagonal k = x-y with d lines deleted or inserted
+	var trace [][]int
+	for d := 0; d <= max; d++ {
+		done := false
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[off+k-1] < v[off+k+1] {
+				x = v[off+k+1] // insertion of b[y-1]
+			} else {
+				x = v[off+k-1] + 1 // deletion of a[x-1]
+			}
+			y := x - k
+			for x < n && y < m && bytes.Equal(a[x], b[y]) {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				done = true
+			}
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+		if done {
+			break
+		}
+	}
+
+	// walk the path back from (n, m), marking the lines off it
+	deleted, inserted := make([]bool, n), make([]bool, m)
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var pk int
+		if k == -d || k != d && prev[k-1+d-1] < prev[k+1+d-1] {
+			pk = k + 1
+		} else {
+			pk = k - 1
+		}
+		px := prev[pk+d-1]
+		py := px - pk
+		if pk == k+1 {
+			inserted[py] = true
+		} else {
+			deleted[px] = true
+		}
+		x, y = px, py
+	}
+
+	// the lines neither deleted nor inserted match pairwise in order
+	var hunks []hunk
+	i, j := 0, 0
+	for i < n || j < m {
+		if i < n && j < m && !deleted[i] && !inserted[j] {
+			i++
+			j++
+			continue
+		}
+		h := hunk{i0: i, j0: j}
+		for i < n && deleted[i] || j < m && inserted[j] {
+			if i < n && deleted[i] {
+				i++
+			} else {
+				j++
+			}
+		}
+		h.i1, h.j1 = i, j
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
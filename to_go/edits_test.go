@@ -0,0 +1,94 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package to_go
+
+import (
+	"testing"
+
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/token"
+)
+
+// applyEdits returns src with edits applied, checking that they are
+// sorted and don't overlap.
+func applyEdits(t *testing.T, src []byte, edits []TextEdit) string {
+	var res []byte
+	last := 0
+	for _, e := range edits {
+		if e.Offset < last || e.End < e.Offset || e.End > len(src) {
+			t.Fatalf("%q: edit %+v out of order or range, after offset %d", src, e, last)
+		}
+		res = append(res, src[last:e.Offset]...)
+		res = append(res, e.NewText...)
+		last = e.End
+	}
+	return string(append(res, src[last:]...))
+}
+
+var textEditsTests = []struct {
+	old, new string
+	edits    int // number of edits
+}{
+	{"", "", 0},
+	{"", "a\n", 1},
+	{"a\n", "", 1},
+	{"a\nb\nc\n", "a\nb\nc\n", 0},
+	{"a\nb\nc\n", "x\ny\n", 1},
+	{"a\nb\nc\n", "a\nx\nc\n", 1},
+	{"a\nb\nc\nd\ne\n", "x\nb\nc\nd\ny\n", 2},
+	{"a\nb", "a\nc", 1},   // no final newline
+	{"a\nb", "a\nb\n", 1}, // one added
+	{"a\r\nb\r\nc\r\n", "a\nb\nc\n", 1},
+	{"a\r\nb\r\nc\r\n", "a\r\nx\r\nc\r\n", 1},
+	{"é\n", "è\n", 1}, // runes with a common first byte
+	{"aé\n", "aè\n", 1},
+}
+
+func TestTextEdits(t *testing.T) {
+	for _, test := range textEditsTests {
+		edits := textEdits([]byte(test.old), []byte(test.new))
+		if got := applyEdits(t, []byte(test.old), edits); got != test.new {
+			t.Errorf("%q to %q: edits %+v give %q", test.old, test.new, edits, got)
+		}
+		if len(edits) != test.edits {
+			t.Errorf("%q to %q: %d edits %+v; want %d", test.old, test.new, len(edits), edits, test.edits)
+		}
+	}
+}
+
+var formatWithEditsTests = []string{
+	"package p\n", // identical
+	"package p\n\nvar a = 1\n",
+	"package p\nvar  a=1\nfunc f(x int) int\n    return x+1\n", // all changed
+	"package p\n\nfunc f()\n\tif x\n\t\tg()\n",
+	"package p\n\nvar s = `a\r\nb`\n", // iGo has "\r\n" line endings only in literals
+	"package p\n\n# é\nvar s = \"é\"\n",
+}
+
+// TestFormatWithEdits checks that the edits applied to the source give
+// what Source prints.
+func TestFormatWithEdits(t *testing.T) {
+	for _, src := range formatWithEditsTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.igo", src, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		want, err := Source(fset, file)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		edits, err := FormatWithEdits(fset, file, []byte(src))
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		if got := applyEdits(t, []byte(src), edits); got != string(want) {
+			t.Errorf("%q: edits %+v give\n%s\nwant\n%s", src, edits, got, want)
+		}
+		if (len(edits) == 0) != (src == string(want)) {
+			t.Errorf("%q: %d edits for output %q", src, len(edits), want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file implements a fuzz test of the round trip between Go and iGo:
+// go test -fuzz=FuzzRoundTrip
+//
+// The seed corpus is in testdata/fuzz/FuzzRoundTrip.
+
+package to_go
+
+import (
+	"bytes"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/DAddYE/igo/from_go"
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/token"
+)
+
+var fuzzSeeds = []string{
+	"package p\n",
+	"package p\n\nfunc f(a, b int) int {\n\treturn a + b\n}\n",
+	"package p\n\n// T is a type.\ntype T struct {\n\tx int // the x\n}\n\nfunc (t *T) X() int {\n\treturn t.x\n}\n",
+	"package p\n\nfunc f(s []int) (n int) {\n\tfor _, x := range s {\n\t\tif x > 0 {\n\t\t\tn += x\n\t\t} else {\n\t\t\tcontinue\n\t\t}\n\t}\n\treturn\n}\n",
+}
+
+// FuzzRoundTrip translates valid Go sources to iGo and checks that the
+// result parses and that its translation to Go is stable, as reported by
+// IsIdempotent. Sources that are not valid Go, or that iGo cannot
+// represent, are skipped. The Go sources of this repository are seeds too.
+func FuzzRoundTrip(f *testing.F) {
+	for _, src := range fuzzSeeds {
+		f.Add([]byte(src))
+	}
+	files, err := filepath.Glob(filepath.Join("..", "*", "*.go"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, filename := range files {
+		if corpusSkip[filepath.Base(filename)] {
+			continue
+		}
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(src)
+	}
+	f.Fuzz(roundTrip)
+}
+
+func roundTrip(t *testing.T, src []byte) {
+	gofset := gotoken.NewFileSet()
+	gofile, err := goparser.ParseFile(gofset, "fuzz.go", src, goparser.ParseComments)
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, decl := range gofile.Decls {
+		if fn, ok := decl.(*goast.FuncDecl); ok && fn.Body == nil {
+			t.Skip("iGo has no function declarations without a body")
+		}
+	}
+	var why unwritable
+	goast.Walk(&why, gofile)
+	if why != "" {
+		t.Skip(why)
+	}
+	var lits funcLits
+	goast.Walk(&lits, gofile)
+	if lits.nested {
+		t.Skip("a function literal iGo cannot end")
+	}
+	// checks go/parser leaves to the type checker, and the iGo parser does not
+	if gofile.Name.Name == "_" {
+		t.Skip("invalid package name _")
+	}
+	for _, s := range gofile.Imports {
+		if !validImport(s.Path.Value) {
+			t.Skip("invalid import path")
+		}
+	}
+	var errs typeErrors
+	conf := types.Config{Error: errs.add}
+	conf.Check("p", gofset, []*goast.File{gofile}, nil)
+	for _, err := range errs {
+		for _, msg := range unrepresentable {
+			if strings.Contains(err.Error(), msg) {
+				t.Skip(err)
+			}
+		}
+	}
+	var igo bytes.Buffer
+	cfg := &from_go.Config{Mode: from_go.UseSpaces | from_go.TabIndent, Tabwidth: 8}
+	if err := cfg.Fprint(&igo, gofset, gofile); err != nil {
+		t.Fatalf("translating to iGo: %v", err)
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "fuzz.igo", igo.Bytes(), parser.ParseComments)
+	if err != nil {
+		t.Fatalf("parsing the iGo translation: %v\n%s", err, igo.Bytes())
+	}
+	ok, err := IsIdempotent(fset, file)
+	if err != nil {
+		t.Fatalf("round trip: %v\n%s", err, igo.Bytes())
+	}
+	if !ok {
+		t.Fatalf("translation to Go not stable for:\n%s", igo.Bytes())
+	}
+}
+
+// validImport reports whether the import path lit is valid, as the iGo
+// parser, and go/parser before Go 1.22, check.
+func validImport(lit string) bool {
+	const illegalChars = `!"#$%&'()*,:;<=>?[\]^{|}` + "`\uFFFD"
+	s, _ := strconv.Unquote(lit)
+	for _, r := range s {
+		if !unicode.IsGraphic(r) || unicode.IsSpace(r) || strings.ContainsRune(illegalChars, r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// unrepresentable are the type errors of the sources iGo cannot represent,
+// or which the iGo parser rejects already.
+var unrepresentable = []string{
+	"is not an expression", // e.g. var x = struct{}, a composite literal in iGo
+	"method has no receiver",
+	"method has multiple receivers",
+	"missing init expr", // a lone const x, an implicit one in iGo
+	"is not a type",     // e.g. case a * b in a type switch
+}
+
+// typeErrors are the errors of a type check.
+type typeErrors []error
+
+func (errs *typeErrors) add(err error) {
+	*errs = append(*errs, err)
+}
+
+// unwritable records why a walk finds syntax iGo cannot write.
+type unwritable string
+
+func (why *unwritable) Visit(n goast.Node) goast.Visitor {
+	var list []goast.Stmt
+	switch n := n.(type) {
+	case *goast.Ident:
+		if token.Lookup(n.Name).IsKeyword() {
+			*why = unwritable(n.Name + " is a keyword of iGo")
+		}
+	case *goast.FuncDecl:
+		if n.Recv != nil && len(n.Recv.List) == 1 {
+			typ := n.Recv.List[0].Type
+			if star, ok := typ.(*goast.StarExpr); ok {
+				typ = star.X
+			}
+			switch t := typ.(type) {
+			case *goast.IndexExpr:
+				typ = t.X
+			case *goast.IndexListExpr:
+				typ = t.X
+			}
+			if _, ok := typ.(*goast.Ident); !ok {
+				// e.g. **T or pkg.T, which func T.M cannot write
+				*why = "a receiver that is not a type name"
+			}
+		}
+	case *goast.UnaryExpr:
+		if n.Op == gotoken.TILDE {
+			// which the type checker may not tell, e.g. in 0(~x)
+			*why = "~ outside of a constraint"
+		}
+	case *goast.InterfaceType:
+		return constraint{why}
+	case *goast.FuncType:
+		if n.TypeParams != nil {
+			goast.Walk(constraint{why}, n.TypeParams)
+			goast.Walk(why, n.Params)
+			if n.Results != nil {
+				goast.Walk(why, n.Results)
+			}
+			return nil
+		}
+	case *goast.TypeSpec:
+		if n.TypeParams != nil {
+			goast.Walk(why, n.Name)
+			goast.Walk(constraint{why}, n.TypeParams)
+			goast.Walk(why, n.Type)
+			return nil
+		}
+	case *goast.CallExpr:
+		if paren, ok := n.Fun.(*goast.ParenExpr); ok && isTypeLit(paren.X) {
+			// a conversion, e.g. ([]T)(x)
+			goast.Walk(why, paren.X)
+			for _, x := range n.Args {
+				goast.Walk(why, x)
+			}
+			return nil
+		}
+	case *goast.ParenExpr:
+		if isTypeLit(n.X) {
+			// which the type checker may not tell, e.g. ([]T) with T
+			// undefined
+			*why = "a type used as a value"
+		}
+	case *goast.AssignStmt:
+		if n.Tok == gotoken.DEFINE {
+			why.defines(n.Lhs...)
+		}
+	case *goast.RangeStmt:
+		if n.Tok == gotoken.DEFINE {
+			why.defines(n.Key, n.Value)
+		}
+	case *goast.BlockStmt:
+		list = n.List
+	case *goast.CaseClause:
+		list = n.Body
+	case *goast.CommClause:
+		list = n.Body
+	case *goast.LabeledStmt:
+		list = []goast.Stmt{n.Stmt}
+	}
+	for _, s := range list {
+		if _, ok := s.(*goast.BlockStmt); ok {
+			*why = "iGo has no bare blocks"
+		}
+	}
+	return why
+}
+
+// funcLits records whether a walk finds a function literal with
+// statements which does not end its expression: its body is indented, so
+// it must be the last argument of a call that is not an argument itself,
+// or stand alone.
+type funcLits struct {
+	stack  []goast.Node // the nodes enclosing the one visited
+	nested bool
+}
+
+func (v *funcLits) Visit(n goast.Node) goast.Visitor {
+	if n == nil {
+		v.stack = v.stack[:len(v.stack)-1]
+		return nil
+	}
+	if lit, ok := n.(*goast.FuncLit); ok && len(lit.Body.List) > 0 && v.enclosed(lit) {
+		v.nested = true
+	}
+	v.stack = append(v.stack, n)
+	return v
+}
+
+// enclosed reports whether the expressions enclosing lit continue after it.
+func (v *funcLits) enclosed(lit *goast.FuncLit) bool {
+	var x goast.Node = lit
+	arg := false // x is the argument of a call
+	for i := len(v.stack) - 1; i >= 0; i-- {
+		switch p := v.stack[i].(type) {
+		case *goast.CallExpr:
+			switch {
+			case x == p.Fun:
+			case !arg && x == p.Args[len(p.Args)-1] && !p.Ellipsis.IsValid():
+				arg = true
+			default:
+				return true
+			}
+		case goast.Expr:
+			return true
+		default:
+			return false
+		}
+		x = v.stack[i]
+	}
+	return false
+}
+
+// isTypeLit reports whether x is a type literal other than a pointer.
+func isTypeLit(x goast.Expr) bool {
+	switch x.(type) {
+	case *goast.ArrayType, *goast.MapType, *goast.ChanType, *goast.FuncType, *goast.StructType, *goast.InterfaceType:
+		return true
+	}
+	return false
+}
+
+// constraint walks an interface or type parameters, where ~ is valid.
+type constraint struct {
+	why *unwritable
+}
+
+func (c constraint) Visit(n goast.Node) goast.Visitor {
+	if x, ok := n.(*goast.UnaryExpr); ok && x.Op == gotoken.TILDE {
+		return c
+	}
+	if c.why.Visit(n) == nil {
+		return nil
+	}
+	return c
+}
+
+// defines records the operands of a := that are not identifiers, which
+// go/parser leaves to the type checker but the iGo parser rejects.
+func (why *unwritable) defines(lhs ...goast.Expr) {
+	for _, x := range lhs {
+		if _, ok := x.(*goast.Ident); x != nil && !ok {
+			*why = "a non-name on the left side of :="
+		}
+	}
+}
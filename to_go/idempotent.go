@@ -0,0 +1,54 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package to_go
+
+import (
+	"bytes"
+	goparser "go/parser"
+	gotoken "go/token"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/from_go"
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/token"
+)
+
+// IsIdempotent reports whether the translation of file to Go is stable:
+// file is printed as Go, the result is translated back to iGo and then
+// to Go again, and the two Go outputs are compared byte by byte. A
+// difference usually means that a comment or a blank line moved on the
+// way. The error is not nil if any step fails, e.g., because file is not
+// printed as valid Go.
+//
+func IsIdempotent(fset *token.FileSet, file *ast.File) (bool, error) {
+	cfg := &Config{Mode: UseSpaces | TabIndent, Tabwidth: 8}
+
+	var first bytes.Buffer
+	if _, err := cfg.Fprint(&first, fset, file); err != nil {
+		return false, err
+	}
+
+	gofset := gotoken.NewFileSet()
+	gofile, err := goparser.ParseFile(gofset, fset.Position(file.Pos()).Filename, first.Bytes(), goparser.ParseComments)
+	if err != nil {
+		return false, err
+	}
+	var igo bytes.Buffer
+	igocfg := &from_go.Config{Mode: from_go.UseSpaces | from_go.TabIndent, Tabwidth: 8}
+	if err := igocfg.Fprint(&igo, gofset, gofile); err != nil {
+		return false, err
+	}
+
+	fset = token.NewFileSet()
+	if file, err = parser.ParseFile(fset, gofset.Position(gofile.Pos()).Filename, igo.Bytes(), parser.ParseComments); err != nil {
+		return false, err
+	}
+	var second bytes.Buffer
+	if _, err := cfg.Fprint(&second, fset, file); err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(first.Bytes(), second.Bytes()), nil
+}
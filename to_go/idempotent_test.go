@@ -0,0 +1,96 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package to_go
+
+import (
+	"bytes"
+	goparser "go/parser"
+	gotoken "go/token"
+	"path/filepath"
+	"testing"
+
+	"github.com/DAddYE/igo/from_go"
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/token"
+)
+
+var idempotentTests = []struct {
+	src  string
+	want bool
+}{
+	{"package p\n\n# Doc.\nfunc f(x int) int\n\tif x > 0\n\t\treturn x\n\n\t# c\n\treturn 0\n", true},
+	{"package p\n\nfunc f(): return\n", true},
+	// the body of one return is put on one line on the way back
+	{"package p\n\nfunc f() int\n\treturn 1\n", false},
+}
+
+func TestIsIdempotent(t *testing.T) {
+	for _, test := range idempotentTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.igo", test.src, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("%q: %v", test.src, err)
+		}
+		ok, err := IsIdempotent(fset, file)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if ok != test.want {
+			t.Errorf("%q: got %v; want %v", test.src, ok, test.want)
+		}
+	}
+}
+
+// corpusSkip are the sources of the corpus whose iGo translation does not
+// parse.
+var corpusSkip = map[string]bool{
+	"parser.go": true, // a bare block in parseIfStmt
+}
+
+// TestIsIdempotentCorpus checks that the Go sources of this repository, a
+// corpus of real code and comments, translate to iGo and then to Go
+// stably. They are translated to iGo first, as the iGo sources written by
+// hand need not be in the form the translation gives.
+func TestIsIdempotentCorpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("..", "*", "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no Go sources found")
+	}
+	cfg := &from_go.Config{Mode: from_go.UseSpaces | from_go.TabIndent, Tabwidth: 8}
+	for _, filename := range files {
+		if corpusSkip[filepath.Base(filename)] {
+			continue
+		}
+		gofset := gotoken.NewFileSet()
+		gofile, err := goparser.ParseFile(gofset, filename, nil, goparser.ParseComments)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		var igo bytes.Buffer
+		if err := cfg.Fprint(&igo, gofset, gofile); err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, filename, igo.Bytes(), parser.ParseComments)
+		if err != nil {
+			t.Errorf("%s: translation to iGo: %v", filename, err)
+			continue
+		}
+		ok, err := IsIdempotent(fset, file)
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: translation to Go not stable", filename)
+		}
+	}
+}
@@ -10,6 +10,8 @@ package to_go
 
 import (
 	"bytes"
+	gotoken "go/token"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/DAddYE/igo/ast"
@@ -41,7 +43,7 @@ import (
 //            future (not yet interspersed) comments in this function.
 //
 func (p *printer) linebreak(line, min int, ws whiteSpace, newSection bool) (printedBreak bool) {
-	n := nlimit(line - p.pos.Line)
+	n := p.nlimit(line - p.pos.Line)
 	if n < min {
 		n = min
 	}
@@ -262,8 +264,21 @@ func (p *printer) exprList(prev0 token.Pos, list []ast.Expr, depth int, mode exp
 	}
 }
 
-func (p *printer) parameters(fields *ast.FieldList) {
-	p.print(fields.Opening, token.LPAREN)
+// A paramMode selects the kind of list printed by parameters.
+type paramMode int
+
+const (
+	funcParam  paramMode = iota // parameters or results, in ()'s
+	funcTParam                  // type parameters of a function, in []'s
+	typeTParam                  // type parameters of a type, in []'s
+)
+
+func (p *printer) parameters(fields *ast.FieldList, mode paramMode) {
+	openTok, closeTok := token.LPAREN, token.RPAREN
+	if mode != funcParam {
+		openTok, closeTok = token.LBRACK, token.RBRACK
+	}
+	p.print(fields.Opening, openTok)
 	if len(fields.List) > 0 {
 		prevLine := p.lineFor(fields.Opening)
 		ws := indent
@@ -322,12 +337,12 @@ func (p *printer) parameters(fields *ast.FieldList) {
 			p.print(unindent)
 		}
 	}
-	p.print(fields.Closing, token.RPAREN)
+	p.print(fields.Closing, closeTok)
 }
 
 func (p *printer) signature(params, result *ast.FieldList) {
 	if params != nil {
-		p.parameters(params)
+		p.parameters(params, funcParam)
 	} else {
 		p.print(token.LPAREN, token.RPAREN)
 	}
@@ -340,7 +355,7 @@ func (p *printer) signature(params, result *ast.FieldList) {
 			p.expr(stripParensAlways(result.List[0].Type))
 			return
 		}
-		p.parameters(result)
+		p.parameters(result, funcParam)
 	}
 }
 
@@ -396,11 +411,23 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 			// no blank between keyword and {} in this case
 			p.print(lbrace, token.LBRACE, rbrace, token.RBRACE)
 			return
-		} else if isStruct && p.isOneLineFieldList(list) { // for now ignore interfaces
+		} else if p.isOneLineFieldList(list) {
 			// small enough - print on one line
 			// (don't use identList and ignore source line breaks)
 			p.print(lbrace, token.LBRACE, blank)
 			f := list[0]
+			if !isStruct {
+				if ftyp, isFtyp := f.Type.(*ast.FuncType); isFtyp && len(f.Names) > 0 {
+					// method
+					p.expr(f.Names[0])
+					p.signature(ftyp.Params, ftyp.Results)
+				} else {
+					// embedded interface or type set
+					p.expr(f.Type)
+				}
+				p.print(blank, rbrace, token.RBRACE)
+				return
+			}
 			for i, x := range f.Names {
 				if i > 0 {
 					// no comments so no need for comma position
@@ -499,6 +526,9 @@ func (p *printer) fieldList(fields *ast.FieldList, isStruct, isIncomplete bool)
 		}
 
 	}
+	if rbrace.IsValid() {
+		rbrace-- // before a comment starting at the DEDENT, as in genDecl
+	}
 	p.print(unindent, formfeed, rbrace, token.RBRACE)
 }
 
@@ -642,6 +672,12 @@ func (p *printer) binaryExpr(x *ast.BinaryExpr, prec1, cutoff, depth int) {
 	}
 
 	printBlank := prec < cutoff
+	blankAfter := printBlank
+	if p.PreserveExprSpacing && x.X.End().IsValid() && x.OpPos.IsValid() && x.Y.Pos().IsValid() {
+		// a blank where the source has any space
+		printBlank = x.X.End() < x.OpPos
+		blankAfter = x.OpPos+token.Pos(len(x.Op.String())) < x.Y.Pos()
+	}
 
 	ws := indent
 	p.expr1(x.X, prec, depth+diffPrec(x.X, prec))
@@ -656,10 +692,10 @@ func (p *printer) binaryExpr(x *ast.BinaryExpr, prec1, cutoff, depth int) {
 		// in the source
 		if p.linebreak(yline, 1, ws, true) {
 			ws = ignore
-			printBlank = false // no blank after line break
+			blankAfter = false // no blank after line break
 		}
 	}
-	if printBlank {
+	if blankAfter {
 		p.print(blank)
 	}
 	p.expr1(x.Y, prec+1, depth+1)
@@ -674,6 +710,12 @@ func isBinary(expr ast.Expr) bool {
 }
 
 func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
+	if p.MaxDepth > 0 {
+		if p.nesting++; p.nesting > p.MaxDepth {
+			panic(tooDeep{p.posFor(expr.Pos()), p.MaxDepth})
+		}
+		defer func() { p.nesting-- }()
+	}
 	p.print(expr.Pos())
 
 	switch x := expr.(type) {
@@ -738,6 +780,9 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 			// don't print parentheses around an already parenthesized expression
 			// TODO(gri) consider making this more general and incorporate precedence levels
 			p.expr0(x.X, reduceDepth(depth)) // parentheses undo one level of depth
+		} else if fn, ok := x.X.(*ast.FuncLit); ok && fn.Body.Small {
+			// parentheses are only needed in iGo to end a one-line body
+			p.expr0(x.X, depth)
 		} else {
 			p.print(token.LPAREN)
 			p.expr0(x.X, reduceDepth(depth)) // parentheses undo one level of depth
@@ -770,6 +815,13 @@ func (p *printer) expr1(expr ast.Expr, prec1, depth int) {
 		p.expr0(x.Index, depth+1)
 		p.print(x.Rbrack, token.RBRACK)
 
+	case *ast.IndexListExpr:
+		// instantiation of a generic function or type
+		p.expr1(x.X, token.HighestPrec, 1)
+		p.print(x.Lbrack, token.LBRACK)
+		p.exprList(x.Lbrack, x.Indices, depth+1, commaTerm, x.Rbrack)
+		p.print(x.Rbrack, token.RBRACK)
+
 	case *ast.SliceExpr:
 		// TODO(gri): should treat[] like parentheses and undo one level of depth
 		p.expr1(x.X, token.HighestPrec, 1)
@@ -906,6 +958,7 @@ func (p *printer) stmtList(list []ast.Stmt, nindent int, nextIsRBrace bool) {
 				// (i.e., we are not printing only a partial program)
 				p.linebreak(p.lineFor(s.Pos()), 1, ignore, i == 0 || nindent == 0 || multiLine)
 			}
+			p.alignAssign = p.Config.AlignAssignments
 			p.stmt(s, nextIsRBrace && i == len(list)-1)
 			multiLine = p.isMultiLine(s)
 			i++
@@ -918,21 +971,21 @@ func (p *printer) stmtList(list []ast.Stmt, nindent int, nextIsRBrace bool) {
 
 // block prints an *ast.BlockStmt; it always spans at least two lines.
 func (p *printer) block(b *ast.BlockStmt, nindent int) {
-	noBrace := p.noBrace
-	if noBrace {
-		nindent = 0
-		p.noBrace = false
-		p.print(b.Opening)
-	} else {
-		p.print(b.Opening, token.LBRACE)
-	}
+	p.print(b.Opening, token.LBRACE)
 	p.stmtList(b.List, nindent, true)
 	p.linebreak(p.lineFor(b.Closing), 1, ignore, true)
-	if noBrace {
-		p.print(b.Closing)
-	} else {
-		p.print(b.Closing-1, token.RBRACE)
+	p.print(b.Closing-1, token.RBRACE)
+}
+
+// clauseBody returns the statements of list, the body of a case clause:
+// the parser wraps an indented body in a block, which has no braces in Go.
+func clauseBody(list []ast.Stmt) []ast.Stmt {
+	if len(list) == 1 {
+		if b, ok := list[0].(*ast.BlockStmt); ok {
+			return b.List
+		}
 	}
+	return list
 }
 
 func isTypeName(x ast.Expr) bool {
@@ -1048,6 +1101,10 @@ func (p *printer) indentList(list []ast.Expr) bool {
 }
 
 func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
+	// only statements of a list align, not those nested in them
+	alignAssign := p.alignAssign
+	p.alignAssign = false
+
 	p.print(stmt.Pos())
 
 	switch s := stmt.(type) {
@@ -1067,16 +1124,18 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 		p.print(unindent)
 		p.expr(s.Label)
 		p.print(s.Colon, token.COLON, indent)
-		if e, isEmpty := s.Stmt.(*ast.EmptyStmt); isEmpty {
+		switch t := s.Stmt.(type) {
+		case *ast.EmptyStmt:
 			if !nextIsRBrace {
-				p.print(newline, e.Pos(), token.SEMICOLON)
-				break
+				p.print(newline, t.Pos(), token.SEMICOLON)
 			}
-		} else {
+		case *ast.BlockStmt:
+			// the statements indented below the label
+			p.stmtList(t.List, 0, nextIsRBrace)
+		default:
 			p.linebreak(p.lineFor(s.Stmt.Pos()), 1, ignore, true)
+			p.stmt(s.Stmt, nextIsRBrace)
 		}
-		p.noBrace = true
-		p.stmt(s.Stmt, nextIsRBrace)
 
 	case *ast.ExprStmt:
 		const depth = 1
@@ -1099,7 +1158,13 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 			depth++
 		}
 		p.exprList(s.Pos(), s.Lhs, depth, 0, s.TokPos)
-		p.print(blank, s.TokPos, s.Tok, blank)
+		if alignAssign && len(s.Lhs) == 1 && len(s.Rhs) == 1 {
+			// the tabwriter aligns the tokens of consecutive lines
+			p.print(vtab)
+		} else {
+			p.print(blank)
+		}
+		p.print(s.TokPos, s.Tok, blank)
 		p.exprList(s.TokPos, s.Rhs, depth, 0, token.NoPos)
 
 	case *ast.GoStmt:
@@ -1162,8 +1227,7 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 			p.print(token.DEFAULT)
 		}
 		p.print(s.Colon, token.COLON)
-		p.noBrace = true
-		p.stmtList(s.Body, 1, nextIsRBrace)
+		p.stmtList(clauseBody(s.Body), 1, nextIsRBrace)
 
 	case *ast.SwitchStmt:
 		p.print(token.SWITCH)
@@ -1190,7 +1254,7 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 			p.print(token.DEFAULT)
 		}
 		p.print(s.Colon, token.COLON)
-		p.stmtList(s.Body, 1, nextIsRBrace)
+		p.stmtList(clauseBody(s.Body), 1, nextIsRBrace)
 
 	case *ast.SelectStmt:
 		p.print(token.SELECT, blank)
@@ -1209,14 +1273,18 @@ func (p *printer) stmt(stmt ast.Stmt, nextIsRBrace bool) {
 
 	case *ast.RangeStmt:
 		p.print(token.FOR, blank)
-		p.expr(s.Key)
-		if s.Value != nil {
-			// use position of value following the comma as
-			// comma position for correct comment placement
-			p.print(s.Value.Pos(), token.COMMA, blank)
-			p.expr(s.Value)
-		}
-		p.print(blank, s.TokPos, s.Tok, blank, token.RANGE, blank)
+		if s.Key != nil {
+			// none once simplified from for _ = range x
+			p.expr(s.Key)
+			if s.Value != nil {
+				// use position of value following the comma as
+				// comma position for correct comment placement
+				p.print(s.Value.Pos(), token.COMMA, blank)
+				p.expr(s.Value)
+			}
+			p.print(blank, s.TokPos, s.Tok, blank)
+		}
+		p.print(token.RANGE, blank)
 		p.expr(stripParens(s.X))
 		p.print(blank)
 		p.block(s.Body, 1)
@@ -1355,11 +1423,17 @@ func (p *printer) spec(spec ast.Spec, n int, doIndent bool) {
 	case *ast.TypeSpec:
 		p.setComment(s.Doc)
 		p.expr(s.Name)
+		if s.TypeParams != nil {
+			p.parameters(s.TypeParams, typeTParam)
+		}
 		if n == 1 {
 			p.print(blank)
 		} else {
 			p.print(vtab)
 		}
+		if s.Assign.IsValid() {
+			p.print(token.ASSIGN, blank)
+		}
 		p.expr(s.Type)
 		p.setComment(s.Comment)
 
@@ -1374,7 +1448,7 @@ func (p *printer) genDecl(d *ast.GenDecl) {
 
 	if d.Indent.IsValid() {
 		// group of parenthesized declarations
-		p.print(d.Indent, token.LPAREN)
+		p.print(declGroup, d.Indent, token.LPAREN, declGroup)
 		if n := len(d.Specs); n > 0 {
 			p.print(indent, formfeed)
 			if n > 1 && (d.Tok == token.CONST || d.Tok == token.VAR) {
@@ -1393,7 +1467,7 @@ func (p *printer) genDecl(d *ast.GenDecl) {
 				newSection := false
 				for i, s := range d.Specs {
 					if i > 0 {
-						p.linebreak(p.lineFor(s.Pos()), 1, ignore, newSection)
+						p.linebreak(p.specLine(s), 1, ignore, newSection)
 					}
 					p.spec(s, n, false)
 					newSection = p.isMultiLine(s)
@@ -1401,7 +1475,16 @@ func (p *printer) genDecl(d *ast.GenDecl) {
 			}
 			p.print(unindent, formfeed)
 		}
-		p.print(d.Dedent, token.RPAREN)
+		// the DEDENT is at the start of the line after the group, as a
+		// comment following it may be: print the comments within the
+		// group and close it before, as block does, so that the comment
+		// stays out of it and off its line
+		p.flush(p.posFor(d.Dedent), token.RPAREN)
+		rparen := d.Dedent
+		if rparen.IsValid() {
+			rparen--
+		}
+		p.print(rparen, token.RPAREN)
 
 	} else {
 		// single declaration
@@ -1409,6 +1492,27 @@ func (p *printer) genDecl(d *ast.GenDecl) {
 	}
 }
 
+// specLine returns the line of the spec s of a group as far as the line
+// breaks before it are concerned. For a spec following others dropped by
+// filterDecls, it is the line after the previous spec kept, or the one
+// after that if an empty line separated any two of the original specs
+// between them, so that the dropped specs leave no gap.
+func (p *printer) specLine(s ast.Spec) int {
+	run, ok := p.joined[s]
+	if !ok {
+		return p.lineFor(s.Pos())
+	}
+	_, end := nodeRange(run[0])
+	line := p.lineFor(end) + 1
+	for i := 1; i < len(run); i++ {
+		_, end := nodeRange(run[i-1])
+		if beg, _ := nodeRange(run[i]); p.lineFor(beg)-p.lineFor(end) > 1 {
+			return line + 1
+		}
+	}
+	return line
+}
+
 // nodeSize determines the size of n in chars after formatting.
 // The result is <= maxSize if the node fits on one line with at
 // most maxSize chars and the formatted output doesn't contain
@@ -1429,7 +1533,7 @@ func (p *printer) nodeSize(n ast.Node, maxSize int) (size int) {
 	// nodeSize computation must be independent of particular
 	// style so that we always get the same decision; print
 	// in RawFormat
-	cfg := Config{Mode: RawFormat}
+	cfg := Config{Mode: RawFormat, RenameFunc: p.RenameFunc}
 	var buf bytes.Buffer
 	if _, err := cfg.fprint(&buf, p.fset, n, p.nodeSizes); err != nil {
 		return
@@ -1482,7 +1586,7 @@ func (p *printer) adjBlock(headerSize int, sep whiteSpace, b *ast.BlockStmt) {
 	}
 
 	const maxSize = 100
-	if headerSize+p.bodySize(b, maxSize) <= maxSize {
+	if headerSize+p.bodySize(b, maxSize) <= maxSize && !(p.OneStatementPerLine && len(b.List) > 0) {
 		p.print(sep, b.Opening, token.LBRACE)
 		if len(b.List) > 0 {
 			p.print(blank)
@@ -1520,10 +1624,13 @@ func (p *printer) funcDecl(d *ast.FuncDecl) {
 	p.setComment(d.Doc)
 	p.print(d.Pos(), token.FUNC, blank)
 	if d.Recv != nil {
-		p.parameters(d.Recv) // method: print receiver
+		p.parameters(d.Recv, funcParam) // method: print receiver
 		p.print(blank)
 	}
 	p.expr(d.Name)
+	if d.Type.TypeParams != nil {
+		p.parameters(d.Type.TypeParams, funcTParam)
+	}
 	p.signature(d.Type.Params, d.Type.Results)
 	p.adjBlock(p.distanceFrom(d.Pos()), vtab, d.Body)
 }
@@ -1555,8 +1662,19 @@ func declToken(decl ast.Decl) (tok token.Token) {
 	return
 }
 
+// isOneLineFunc reports whether decl is a function declaration
+// without body or with a body on the same line as its signature.
+func (p *printer) isOneLineFunc(decl ast.Decl) bool {
+	d, ok := decl.(*ast.FuncDecl)
+	if ok && d.Body != nil && p.OneStatementPerLine && len(d.Body.List) > 0 {
+		return false
+	}
+	return ok && (d.Body == nil || d.Body.Small)
+}
+
 func (p *printer) declList(list []ast.Decl) {
 	tok := token.ILLEGAL
+	var last ast.Decl
 	for _, d := range list {
 		prev := tok
 		tok = declToken(d)
@@ -1574,12 +1692,94 @@ func (p *printer) declList(list []ast.Decl) {
 			if prev != tok || getDoc(d) != nil {
 				min = 2
 			}
-			p.linebreak(p.lineFor(d.Pos()), min, ignore, false)
+			// Functions are always separated by an empty line,
+			// unless both of them are one-liners.
+			if prev == token.FUNC && tok == token.FUNC && !(p.isOneLineFunc(last) && p.isOneLineFunc(d)) {
+				min = 2
+			}
+			if p.Mode&SourceLines != 0 {
+				min = 1 // blank lines only where the source has them
+			}
+			if first := tok != token.IMPORT && (prev == token.IMPORT || prev == token.ILLEGAL); first && p.Mode&SourceLines == 0 {
+				// exactly as many line breaks as NoBlankBeforeFirstDecl
+				// asks for, before the comments preceding d too
+				p.declLines = 2
+				if p.NoBlankBeforeFirstDecl {
+					p.declLines = 1
+				}
+				if n := p.nlimit(p.firstLine(d) - p.pos.Line); p.PreserveBlankLines && n > 0 {
+					// or as many as the source has
+					p.declLines = n
+				}
+				for n := p.declLines; n > 0; n-- {
+					p.print(newline)
+				}
+			} else {
+				p.linebreak(p.lineFor(d.Pos()), min, ignore, false)
+			}
+		}
+		if p.BeforeDecl != nil {
+			p.beforeDecl(d)
 		}
 		p.decl(d)
+		if p.AfterDecl != nil {
+			p.afterDecl(d)
+		}
+		last = d
+	}
+}
+
+// firstLine returns the line of d, or of the comments preceding it.
+func (p *printer) firstLine(d ast.Decl) int {
+	if pos := p.posFor(d.Pos()); p.commentOffset < pos.Offset {
+		return p.lineFor(p.comment.Pos())
+	}
+	return p.lineFor(d.Pos())
+}
+
+// beforeDecl prints the output of BeforeDecl for d, after the comments
+// preceding d but before its documentation.
+func (p *printer) beforeDecl(d ast.Decl) {
+	doc := getDoc(d)
+	next := d.Pos()
+	if doc != nil {
+		next = doc.Pos()
+	}
+	p.flush(p.posFor(next), token.ILLEGAL)
+	p.declLines = 0 // written by the flush, if not by a comment
+	if doc != nil {
+		// the blank lines after the comments flushed
+		p.linebreak(p.lineFor(doc.Pos()), 0, ignore, false)
+		p.flush(p.posFor(next), token.ILLEGAL)
+	}
+
+	var buf bytes.Buffer
+	p.BeforeDecl(d, &buf)
+	p.writeRaw(buf.Bytes(), true)
+	if doc != nil {
+		// the line breaks before doc are written already: an invalid
+		// p.last keeps writeCommentPrefix from writing more
+		p.last = token.Position{Filename: p.last.Filename}
 	}
 }
 
+// afterDecl prints the output of AfterDecl for d, after the comments on
+// the line d ends.
+func (p *printer) afterDecl(d ast.Decl) {
+	// an indented block ends at the start of the line after it
+	next := p.posFor(d.End())
+	if p.commentOffset < infinity && p.lineFor(p.comment.Pos()) == p.lineFor(d.End()-1) {
+		next = p.posFor(p.comment.End())
+		next.Column = 1 // as the next declaration, to keep any unindent
+	}
+	p.impliedSemi = false // the output starts a new line, as EOF
+	p.flush(next, token.ILLEGAL)
+
+	var buf bytes.Buffer
+	p.AfterDecl(d, &buf)
+	p.writeRaw(buf.Bytes(), false)
+}
+
 func (p *printer) file(src *ast.File) {
 	p.setComment(src.Doc)
 	p.print(src.Pos(), token.PACKAGE, blank)
@@ -1587,3 +1787,167 @@ func (p *printer) file(src *ast.File) {
 	p.declList(src.Decls)
 	p.print(newline)
 }
+
+// filterDecls returns a copy of the file f holding the declarations for
+// which keep returns true and the imports they use, as described for
+// Config.FilterDecls. The comments of f falling within the declarations
+// or import specs dropped, documentation and line comments included, are
+// dropped as well. The import specs kept after others dropped are mapped
+// to the original specs from the previous one kept to them, for specLine.
+func filterDecls(f *ast.File, keep func(ast.Decl) bool) (*ast.File, map[ast.Spec][]ast.Spec) {
+	var (
+		decls   []ast.Decl
+		dropped []ast.Node // comments within these are dropped
+		used    = make(map[string]bool)
+		bases   []string // of the selectors, not declared in f
+		joined  = make(map[ast.Spec][]ast.Spec)
+	)
+	for _, d := range f.Decls {
+		if d, ok := d.(*ast.GenDecl); ok && d.Tok == token.IMPORT {
+			continue
+		}
+		if !keep(d) {
+			dropped = append(dropped, d)
+			continue
+		}
+		ast.Inspect(d, func(n ast.Node) bool {
+			if x, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := x.X.(*ast.Ident); ok {
+					used[id.Name] = true
+					if id.Obj == nil {
+						bases = append(bases, id.Name)
+					}
+				}
+			}
+			return true
+		})
+	}
+	// a base which is not the name of an import may be that of any of
+	// those whose name is guessed
+	names := make(map[string]bool)
+	for _, s := range f.Imports {
+		name, _ := importName(s)
+		names[name] = true
+	}
+	guessed := false
+	for _, name := range bases {
+		if !names[name] {
+			guessed = true
+			break
+		}
+	}
+
+	for _, d := range f.Decls {
+		gen, ok := d.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			if keep(d) {
+				decls = append(decls, d)
+			}
+			continue
+		}
+		var specs []ast.Spec
+		prev := 0 // index of the previous spec kept
+		for i, s := range gen.Specs {
+			spec := s.(*ast.ImportSpec)
+			name, sure := importName(spec)
+			if name == "_" || name == "." || used[name] || !gotoken.IsIdentifier(name) || !sure || guessed && spec.Name == nil {
+				if len(specs) > 0 && i > prev+1 {
+					joined[s] = gen.Specs[prev : i+1]
+				}
+				specs = append(specs, s)
+				prev = i
+			} else {
+				dropped = append(dropped, s)
+			}
+		}
+		switch {
+		case len(specs) == len(gen.Specs):
+			decls = append(decls, gen)
+		case len(specs) > 0:
+			g := *gen
+			g.Specs = specs
+			decls = append(decls, &g)
+		default:
+			dropped = append(dropped, gen)
+		}
+	}
+
+	var comments []*ast.CommentGroup
+	for _, c := range f.Comments {
+		in := false
+		for _, n := range dropped {
+			if beg, end := nodeRange(n); beg <= c.Pos() && c.End() <= end {
+				in = true
+				break
+			}
+		}
+		if !in {
+			comments = append(comments, c)
+		}
+	}
+
+	res := *f
+	res.Decls = decls
+	res.Comments = comments
+	return &res, joined
+}
+
+// filterFile returns f as printed with FilterDecls or PruneUnusedImports,
+// f itself if neither is set, and the specs joined by filterDecls.
+func (cfg *Config) filterFile(f *ast.File) (*ast.File, map[ast.Spec][]ast.Spec) {
+	switch {
+	case cfg.FilterDecls != nil:
+		return filterDecls(f, cfg.FilterDecls)
+	case cfg.PruneUnusedImports:
+		return filterDecls(f, func(ast.Decl) bool { return true })
+	}
+	return f, nil
+}
+
+// importName returns the name an import is referred to by: its explicit
+// name, or else the last element of its path, not counting a major
+// version suffix such as "v2". The name is not sure when guessed from a
+// path with such a suffix: "k8s.io/api/core/v1" is package v1.
+func importName(s *ast.ImportSpec) (name string, sure bool) {
+	if s.Name != nil {
+		return s.Name.Name, true
+	}
+	path := strings.Trim(s.Path.Value, "`\"")
+	elems := strings.Split(path, "/")
+	name = elems[len(elems)-1]
+	if n := len(elems); n > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		return elems[n-2], false
+	}
+	return name, true
+}
+
+// nodeRange returns the range of source covered by the declaration or
+// spec n, including its documentation and line comments.
+func nodeRange(n ast.Node) (beg, end token.Pos) {
+	beg, end = n.Pos(), n.End()
+	if doc := getDoc(n); doc != nil {
+		beg = doc.Pos()
+	}
+	var specs []ast.Spec
+	switch n := n.(type) {
+	case *ast.GenDecl:
+		specs = n.Specs
+	case ast.Spec:
+		specs = []ast.Spec{n}
+	}
+	for _, s := range specs {
+		var c *ast.CommentGroup
+		switch s := s.(type) {
+		case *ast.ImportSpec:
+			c = s.Comment
+		case *ast.ValueSpec:
+			c = s.Comment
+		case *ast.TypeSpec:
+			c = s.Comment
+		}
+		if c != nil && c.End() > end {
+			end = c.End()
+		}
+	}
+	return
+}
@@ -0,0 +1,412 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package to_go
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/token"
+)
+
+var methodSpacingTests = []printTest{
+	// no blank line, and several, become one
+	{"package p\n\ntype T int\nfunc T.a()\n\treturn\nfunc T.b()\n\treturn\n\n\n\nfunc *T.c()\n\treturn\n",
+		"package p\n\ntype T int\n\nfunc (self T) a() {\n\treturn\n}\n\nfunc (self T) b() {\n\treturn\n}\n\nfunc (self *T) c() {\n\treturn\n}\n"},
+	// the blank line goes before the doc comment
+	{"package p\n\ntype T int\n\nfunc T.a()\n\treturn\n# b does nothing.\nfunc T.b()\n\treturn\n",
+		"package p\n\ntype T int\n\nfunc (self T) a() {\n\treturn\n}\n\n// b does nothing.\nfunc (self T) b() {\n\treturn\n}\n"},
+	{"package p\n\ntype T int\n\nfunc T.a()\n\treturn\n\n\n# b does nothing.\nfunc T.b()\n\treturn\n",
+		"package p\n\ntype T int\n\nfunc (self T) a() {\n\treturn\n}\n\n// b does nothing.\nfunc (self T) b() {\n\treturn\n}\n"},
+	// one-liners may stay together
+	{"package p\n\ntype T int\n\nfunc T.a(): return\nfunc T.b(): return\n\nfunc T.c()\n\treturn\n",
+		"package p\n\ntype T int\n\nfunc (self T) a() { return }\nfunc (self T) b() { return }\n\nfunc (self T) c() {\n\treturn\n}\n"},
+	{"package p\n\ntype T int\n\nfunc T.a(): return\nfunc T.b()\n\treturn\n",
+		"package p\n\ntype T int\n\nfunc (self T) a() { return }\n\nfunc (self T) b() {\n\treturn\n}\n"},
+}
+
+func TestMethodSpacing(t *testing.T) {
+	runPrintTests(t, &testConfig, methodSpacingTests)
+}
+
+var incDecTests = []printTest{
+	{"package p\n\nfunc f(x []int, p *int, k int)\n\tk++\n\tk--\n\tx[k]++\n\t*p--\n\tx[k-1]--\n",
+		"package p\n\nfunc f(x []int, p *int, k int) {\n\tk++\n\tk--\n\tx[k]++\n\t*p--\n\tx[k-1]--\n}\n"},
+	{"package p\n\nfunc f(x []int, k int)\n\tfor i := 0; i < k; i++: x[i]--\n",
+		"package p\n\nfunc f(x []int, k int) {\n\tfor i := 0; i < k; i++ {\n\t\tx[i]--\n\t}\n}\n"},
+	{"package p\n\nvar i int\n\nfunc f(): i++\nfunc g(): i--\n",
+		"package p\n\nvar i int\n\nfunc f() { i++ }\nfunc g() { i-- }\n"},
+}
+
+func TestIncDec(t *testing.T) {
+	runPrintTests(t, &testConfig, incDecTests)
+}
+
+var nestedFuncLitTests = []printTest{
+	// three levels, invoked immediately
+	{"package p\n\nfunc f(x int) int: return func() int\n\ty := x + 1\n\treturn func() int\n\t\tz := y * 2\n\t\treturn (func() int: return z + x)()\n\t()\n()\n\nvar g = func() func() int: return func() int: return 1\n",
+		"package p\n\nfunc f(x int) int {\n\treturn func() int {\n\t\ty := x + 1\n\t\treturn func() int {\n\t\t\tz := y * 2\n\t\t\treturn func() int { return z + x }()\n\t\t}()\n\t}()\n}\n\nvar g = func() func() int { return func() int { return 1 } }\n"},
+	// one-line bodies, parenthesized when followed by a call
+	{"package p\n\nvar h = (func(a int) func() int: return func() int: return (func(b int) int: return a + b)(a))(1)\n",
+		"package p\n\nvar h = func(a int) func() int { return func() int { return func(b int) int { return a + b }(a) } }(1)\n"},
+}
+
+func TestNestedFuncLits(t *testing.T) {
+	runPrintTests(t, &testConfig, nestedFuncLitTests)
+}
+
+var bareReturnTests = []printTest{
+	{"package p\n\nfunc f() (n int)\n\tn = 1\n\treturn\n",
+		"package p\n\nfunc f() (n int) {\n\tn = 1\n\treturn\n}\n"},
+	// in a deferred closure, and before the end of blocks
+	{"package p\n\nfunc f() (n int)\n\tdefer func()\n\t\tn++\n\t\treturn\n\t()\n\tif n > 0: return\n\treturn\n",
+		"package p\n\nfunc f() (n int) {\n\tdefer func() {\n\t\tn++\n\t\treturn\n\t}()\n\tif n > 0 {\n\t\treturn\n\t}\n\treturn\n}\n"},
+	{"package p\n\nfunc f() (n int, err error)\n\tdefer (func(): return)()\n\treturn\n",
+		"package p\n\nfunc f() (n int, err error) {\n\tdefer func() { return }()\n\treturn\n}\n"},
+	// at the end of a file without final newline
+	{"package p\n\nfunc f() (n int): return",
+		"package p\n\nfunc f() (n int) { return }\n"},
+	{"package p\n\nfunc f() (n int)\n\treturn",
+		"package p\n\nfunc f() (n int) {\n\treturn\n}\n"},
+}
+
+func TestBareReturn(t *testing.T) {
+	runPrintTests(t, &testConfig, bareReturnTests)
+}
+
+var arrayLenTests = []printTest{
+	{"package p\n\nconst N, K = 4, 8\n\nvar x [N]string\n\nvar\n\ta [N]int\n\tb [2*K]byte\n\tc [len(x)]T\n\td [N + 1][K*2 - 1]float64\n\te = [...]int{1, 2}\n\ntype T [unsafe.Sizeof(x) / 2]byte\n",
+		"package p\n\nconst N, K = 4, 8\n\nvar x [N]string\n\nvar (\n\ta [N]int\n\tb [2 * K]byte\n\tc [len(x)]T\n\td [N + 1][K*2 - 1]float64\n\te = [...]int{1, 2}\n)\n\ntype T [unsafe.Sizeof(x) / 2]byte\n"},
+	{"package p\n\nvar f [ N ]int\n",
+		"package p\n\nvar f [N]int\n"},
+}
+
+func TestArrayLen(t *testing.T) {
+	runPrintTests(t, &testConfig, arrayLenTests)
+}
+
+var flowControlTests = []printTest{
+	{"package p\n\nfunc f(x int)\n\tL:\n\t\tfor i := 0; i < x; i++\n\t\t\tswitch i\n\t\t\t\tcase 0:\n\t\t\t\t\tcontinue\n\t\t\t\tcase 1:\n\t\t\t\t\tfallthrough\n\t\t\t\tcase 2:\n\t\t\t\t\tbreak\n\t\t\t\tcase 3:\n\t\t\t\t\tgoto M\n\t\t\t\tdefault:\n\t\t\t\t\tbreak L\n\t\t\tif i > 5\n\t\t\t\tcontinue\n\t\t\tbreak\n\tM:\n\t\treturn\n",
+		"package p\n\nfunc f(x int) {\nL:\n\tfor i := 0; i < x; i++ {\n\t\tswitch i {\n\t\tcase 0:\n\t\t\tcontinue\n\t\tcase 1:\n\t\t\tfallthrough\n\t\tcase 2:\n\t\t\tbreak\n\t\tcase 3:\n\t\t\tgoto M\n\t\tdefault:\n\t\t\tbreak L\n\t\t}\n\t\tif i > 5 {\n\t\t\tcontinue\n\t\t}\n\t\tbreak\n\t}\nM:\n\treturn\n}\n"},
+	{"package p\n\nfunc f(x int)\n\tswitch x\n\t\tcase 0: fallthrough\n\t\tcase 1: goto L\n\t\tdefault: break\n\tL:\n\t\treturn\n",
+		"package p\n\nfunc f(x int) {\n\tswitch x {\n\tcase 0:\n\t\tfallthrough\n\tcase 1:\n\t\tgoto L\n\tdefault:\n\t\tbreak\n\t}\nL:\n\treturn\n}\n"},
+	{"package p\n\nfunc f(c chan int)\n\tfor\n\t\tselect\n\t\t\tcase <-c:\n\t\t\t\tcontinue\n\t\t\tdefault:\n\t\t\t\tbreak\n",
+		"package p\n\nfunc f(c chan int) {\n\tfor {\n\t\tselect {\n\t\tcase <-c:\n\t\t\tcontinue\n\t\tdefault:\n\t\t\tbreak\n\t\t}\n\t}\n}\n"},
+	{"package p\n\nfunc f(x int)\n\tfor\n\t\tif x > 0: break\n\t\tx++\n\t\tcontinue\n",
+		"package p\n\nfunc f(x int) {\n\tfor {\n\t\tif x > 0 {\n\t\t\tbreak\n\t\t}\n\t\tx++\n\t\tcontinue\n\t}\n}\n"},
+}
+
+func TestFlowControl(t *testing.T) {
+	runPrintTests(t, &testConfig, flowControlTests)
+}
+
+var compositeArgTests = []printTest{
+	{"package p\n\nfunc f()\n\tg(Config{A: 1, B: 2, C: 3})\n",
+		"package p\n\nfunc f() {\n\tg(Config{A: 1, B: 2, C: 3})\n}\n"},
+	{"package p\n\nfunc f()\n\tg(Config{\n\t\tA: 1,\n\t\tB: 2,\n\t\tC: 3,\n\t})\n",
+		"package p\n\nfunc f() {\n\tg(Config{\n\t\tA: 1,\n\t\tB: 2,\n\t\tC: 3,\n\t})\n}\n"},
+	{"package p\n\nfunc f()\n\tg(Point{1, 2}, Point{\n\t\tX: 3,\n\t\tY: 4,\n\t}, []int{5, 6})\n",
+		"package p\n\nfunc f() {\n\tg(Point{1, 2}, Point{\n\t\tX: 3,\n\t\tY: 4,\n\t}, []int{5, 6})\n}\n"},
+	{"package p\n\nfunc f()\n\tg(\n\t\tConfig{A: 1, B: []Point{{1, 2}, {3, 4}}},\n\t\tx,\n\t)\n",
+		"package p\n\nfunc f() {\n\tg(\n\t\tConfig{A: 1, B: []Point{{1, 2}, {3, 4}}},\n\t\tx,\n\t)\n}\n"},
+	// there is no width limit: a long line is kept
+	{"package p\n\nfunc f()\n\tg(Config{Name: \"a rather long name\", Path: \"/a/rather/long/path\", Mode: 0644, Size: 4096})\n",
+		"package p\n\nfunc f() {\n\tg(Config{Name: \"a rather long name\", Path: \"/a/rather/long/path\", Mode: 0644, Size: 4096})\n}\n"},
+}
+
+func TestCompositeArgs(t *testing.T) {
+	runPrintTests(t, &testConfig, compositeArgTests)
+}
+
+var fieldCommentTests = []printTest{
+	{"package p\n\ntype T struct\n\tx    int               # x\n\tlong map[string][]byte # a longer comment\n\ty, z float64           # the coordinates\n\tT                      # embedded\n",
+		"package p\n\ntype T struct {\n\tx    int               // x\n\tlong map[string][]byte // a longer comment\n\ty, z float64           // the coordinates\n\tT                      // embedded\n}\n"},
+	{"package p\n\ntype T struct\n\ta int # a\n\n\tbb string `json:\"bb\"` # bb\n\tc  bool   # c\n",
+		"package p\n\ntype T struct {\n\ta int // a\n\n\tbb string `json:\"bb\"` // bb\n\tc  bool   // c\n}\n"},
+	{"package p\n\nconst\n\tA    = 1   # one\n\tLong = 100 # a hundred\n\nvar\n\tx int    # x\n\ty string # y\n",
+		"package p\n\nconst (\n\tA    = 1   // one\n\tLong = 100 // a hundred\n)\n\nvar (\n\tx int    // x\n\ty string // y\n)\n"},
+	{"package p\n\ntype I interface\n\tM() # m\n\tN() # n\n",
+		"package p\n\ntype I interface {\n\tM() // m\n\tN() // n\n}\n"},
+}
+
+func TestFieldComments(t *testing.T) {
+	runPrintTests(t, &testConfig, fieldCommentTests)
+}
+
+var caseCommentTests = []printTest{
+	{"package p\n\nfunc f(x int)\n\tswitch x\n\t\tcase 1:\n\t\t\tx++\n\t\t# two\n\t\tcase 2:\n\t\t\tx--\n\t\t# otherwise\n\t\tdefault:\n\t\t\tx = 0\n",
+		"package p\n\nfunc f(x int) {\n\tswitch x {\n\tcase 1:\n\t\tx++\n\t// two\n\tcase 2:\n\t\tx--\n\t// otherwise\n\tdefault:\n\t\tx = 0\n\t}\n}\n"},
+	{"package p\n\nfunc f(x int)\n\tswitch x\n\t\t# one\n\t\tcase 1:\n\t\t\tx++\n\t\t\t# still one\n\t\tcase 2:\n\t\t\tx--\n",
+		"package p\n\nfunc f(x int) {\n\tswitch x {\n\t// one\n\tcase 1:\n\t\tx++\n\t\t// still one\n\tcase 2:\n\t\tx--\n\t}\n}\n"},
+	{"package p\n\nfunc f(v interface)\n\tswitch v.(type)\n\t\tcase int:\n\t\t\tprintln(1)\n\t\t# not an int\n\t\tdefault:\n\t\t\tprintln(2)\n",
+		"package p\n\nfunc f(v interface{}) {\n\tswitch v.(type) {\n\tcase int:\n\t\tprintln(1)\n\t// not an int\n\tdefault:\n\t\tprintln(2)\n\t}\n}\n"},
+	{"package p\n\nfunc f(c chan int)\n\tfor\n\t\tselect\n\t\t\tcase <-c:\n\t\t\t\treturn\n\t\t\t# nothing yet\n\t\t\tdefault:\n\t\t\t\tprintln(0)\n",
+		"package p\n\nfunc f(c chan int) {\n\tfor {\n\t\tselect {\n\t\tcase <-c:\n\t\t\treturn\n\t\t// nothing yet\n\t\tdefault:\n\t\t\tprintln(0)\n\t\t}\n\t}\n}\n"},
+	// comments indented less than the label go with it
+	{"package p\n\nfunc f(x int)\n\tswitch x\n\t\tcase 1:\n\t\t\tx++\n\t# two, as the switch\n\t\tcase 2:\n\t\t\tx--\n# otherwise, at the margin\n\t\tdefault:\n\t\t\tx = 0\n",
+		"package p\n\nfunc f(x int) {\n\tswitch x {\n\tcase 1:\n\t\tx++\n\t// two, as the switch\n\tcase 2:\n\t\tx--\n\t// otherwise, at the margin\n\tdefault:\n\t\tx = 0\n\t}\n}\n"},
+}
+
+func TestCaseComments(t *testing.T) {
+	runPrintTests(t, &testConfig, caseCommentTests)
+}
+
+var typeParamTests = []printTest{
+	{"package p\n\nfunc Map[T, U any](s []T, f func(T) U) []U\n\tvar r []U\n\tfor _, x := range s\n\t\tr = append(r, f(x))\n\n\treturn r\n",
+		"package p\n\nfunc Map[T, U any](s []T, f func(T) U) []U {\n\tvar r []U\n\tfor _, x := range s {\n\t\tr = append(r, f(x))\n\t}\n\n\treturn r\n}\n"},
+	{"package p\n\ntype List[T any] struct\n\tnext *List[T]\n\tval  T\n\nfunc *List[T].Push(v T)\n\tself.val = v\n",
+		"package p\n\ntype List[T any] struct {\n\tnext *List[T]\n\tval  T\n}\n\nfunc (self *List[T]) Push(v T) {\n\tself.val = v\n}\n"},
+	{"package p\n\ntype Pair[K comparable, V any] struct\n\tk K\n\tv V\n\nvar p Pair[int, string]\n\nvar m = Map[int, string]\n\nvar l List[int]\n",
+		"package p\n\ntype Pair[K comparable, V any] struct {\n\tk K\n\tv V\n}\n\nvar p Pair[int, string]\n\nvar m = Map[int, string]\n\nvar l List[int]\n"},
+	{"package p\n\nfunc Zero[T any]() T\n\tvar z T\n\tf := Map[T, T]\n\t_ = f\n\treturn z\n",
+		"package p\n\nfunc Zero[T any]() T {\n\tvar z T\n\tf := Map[T, T]\n\t_ = f\n\treturn z\n}\n"},
+	// array lengths, not type parameters
+	{"package p\n\nconst N = 4\n\ntype A [N]int\n\ntype B [N * 2]byte\n\nvar x = a[i]\n",
+		"package p\n\nconst N = 4\n\ntype A [N]int\n\ntype B [N * 2]byte\n\nvar x = a[i]\n"},
+}
+
+func TestTypeParams(t *testing.T) {
+	runPrintTests(t, &testConfig, typeParamTests)
+}
+
+var goGenerateTests = []printTest{
+	{"package p\n\n#go:generate stringer -type=Foo\ntype Foo int\n\nconst\n\tA Foo = iota\n\tB\n",
+		"package p\n\n//go:generate stringer -type=Foo\ntype Foo int\n\nconst (\n\tA Foo = iota\n\tB\n)\n"},
+	{"package p\n\n# Foo is a kind.\n#\n#go:generate stringer -type=Foo\ntype Foo int\n",
+		"package p\n\n// Foo is a kind.\n//\n//go:generate stringer -type=Foo\ntype Foo int\n"},
+	{"package p\n\ntype T struct\n\t#go:generate echo a\n\tA int\n\t# B is b.\n\tB int\n",
+		"package p\n\ntype T struct {\n\t//go:generate echo a\n\tA int\n\t// B is b.\n\tB int\n}\n"},
+	{"package p\n\ntype I interface\n\t# M does m.\n\tM()\n",
+		"package p\n\ntype I interface {\n\t// M does m.\n\tM()\n}\n"},
+	{"package p\n\n#go:generate echo one\n#go:generate echo two\n\nfunc f():\n",
+		"package p\n\n//go:generate echo one\n//go:generate echo two\n\nfunc f() {}\n"},
+}
+
+func TestGoGenerate(t *testing.T) {
+	runPrintTests(t, &testConfig, goGenerateTests)
+}
+
+// The lines of multi-line literals after the first are never indented.
+var multiLineStringTests = []printTest{
+	{"package p\n\nfunc f() string\n\tif true\n\t\ts := \"\"\"first\n  two spaces\n\ttab\nlast\"\"\"\n\t\treturn s\n\n\treturn \"\"\n",
+		"package p\n\nfunc f() string {\n\tif true {\n\t\ts := `first\n  two spaces\n\ttab\nlast`\n\t\treturn s\n\t}\n\n\treturn \"\"\n}\n"},
+	{"package p\n\nvar s = \"\"\"a `quoted`\n\tline\"\"\"\n\nvar t = 1\n",
+		"package p\n\nvar s = \"a `quoted`\\n\\tline\"\n\nvar t = 1\n"},
+	{"package p\n\nfunc f()\n\tg(`\n\tindented\nnot`, 1)\n\tx := 2\n",
+		"package p\n\nfunc f() {\n\tg(`\n\tindented\nnot`, 1)\n\tx := 2\n}\n"},
+}
+
+func TestMultiLineStrings(t *testing.T) {
+	runPrintTests(t, &testConfig, multiLineStringTests)
+}
+
+var embeddedFieldTests = []printTest{
+	{"package p\n\ntype T struct\n\tio.Reader\n\t*pkg.T\n\tU\n\t*V\n\tname string\n",
+		"package p\n\ntype T struct {\n\tio.Reader\n\t*pkg.T\n\tU\n\t*V\n\tname string\n}\n"},
+	{"package p\n\ntype T struct\n\tio.Writer `json:\"w\"` # w\n\t*pkg.T               # t\n\tn         int\n",
+		"package p\n\ntype T struct {\n\tio.Writer `json:\"w\"` // w\n\t*pkg.T               // t\n\tn         int\n}\n"},
+	{"package p\n\ntype I interface\n\tio.Reader\n\tfmt.Stringer\n\tM()\n",
+		"package p\n\ntype I interface {\n\tio.Reader\n\tfmt.Stringer\n\tM()\n}\n"},
+}
+
+func TestEmbeddedFields(t *testing.T) {
+	runPrintTests(t, &testConfig, embeddedFieldTests)
+}
+
+// selector returns the expression x.sel, without positions.
+func selector(x, sel string) ast.Expr {
+	return &ast.SelectorExpr{X: ast.NewIdent(x), Sel: ast.NewIdent(sel)}
+}
+
+// TestEmbeddedFieldsGenerated prints embedded fields of a struct built
+// without positions.
+func TestEmbeddedFieldsGenerated(t *testing.T) {
+	fields := []*ast.Field{
+		{Type: selector("io", "Reader")},
+		{Type: &ast.StarExpr{X: selector("pkg", "T")}},
+		{Names: []*ast.Ident{ast.NewIdent("x")}, Type: ast.NewIdent("int")},
+	}
+	decl := &ast.GenDecl{Tok: token.TYPE, Specs: []ast.Spec{&ast.TypeSpec{
+		Name: ast.NewIdent("T"),
+		Type: &ast.StructType{Fields: &ast.FieldList{List: fields}},
+	}}}
+	var buf bytes.Buffer
+	if _, err := testConfig.Fprint(&buf, token.NewFileSet(), decl); err != nil {
+		t.Fatal(err)
+	}
+	if want := "type T struct {\n\tio.Reader\n\t*pkg.T\n\tx int\n}"; buf.String() != want {
+		t.Errorf("got %q; want %q", buf.String(), want)
+	}
+}
+
+var constCommentTests = []printTest{
+	{"package p\n\nconst\n\tA = 1 # a\n\n\t# B is the last.\n\tB = 2 # b\n\nvar x int\n",
+		"package p\n\nconst (\n\tA = 1 // a\n\n\t// B is the last.\n\tB = 2 // b\n)\n\nvar x int\n"},
+	{"package p\n\nvar\n\ta = 1\n\t# b is the last.\n\tb = 2 # b\n\n# F follows.\nfunc F():\n",
+		"package p\n\nvar (\n\ta = 1\n\t// b is the last.\n\tb = 2 // b\n)\n\n// F follows.\nfunc F() {}\n"},
+	{"package p\n\nconst\n\tA = iota\n\t# B is the last.\n\tB # b\n",
+		"package p\n\nconst (\n\tA = iota\n\t// B is the last.\n\tB // b\n)\n"},
+	{"package p\n\nconst\n\tA = 1\n\t# B is the last.\n\tB = 2 # b\n\n# after the group\n\ntype T struct\n\t# x is the last.\n\tx int # x\n\n# after the struct\n",
+		"package p\n\nconst (\n\tA = 1\n\t// B is the last.\n\tB = 2 // b\n)\n\n// after the group\n\ntype T struct {\n\t// x is the last.\n\tx int // x\n}\n\n// after the struct\n"},
+	// a comment at the DEDENT closing a group or a struct follows it
+	{"package p\n\nconst\n\tA = 1\n\tB = 2 # b\n# after the group\nvar x int\n\ntype T struct\n\tx int # x\n# after the struct\nvar y int\n",
+		"package p\n\nconst (\n\tA = 1\n\tB = 2 // b\n)\n\n// after the group\nvar x int\n\ntype T struct {\n\tx int // x\n}\n\n// after the struct\nvar y int\n"},
+}
+
+func TestConstComments(t *testing.T) {
+	runPrintTests(t, &testConfig, constCommentTests)
+}
+
+var implicitConstTests = []printTest{
+	{"package p\n\nconst\n\tA = iota\n\tB\n\tC\n\t_\n\tX = iota + 10\n",
+		"package p\n\nconst (\n\tA = iota\n\tB\n\tC\n\t_\n\tX = iota + 10\n)\n"},
+	{"package p\n\nconst\n\tA, AA = iota, -iota # a\n\tB, BB               # b\n\t_, _\n\tX, XX = iota + 10, 0\n\tY, YY\n",
+		"package p\n\nconst (\n\tA, AA = iota, -iota // a\n\tB, BB               // b\n\t_, _\n\tX, XX = iota + 10, 0\n\tY, YY\n)\n"},
+	{"package p\n\ntype T int\n\nconst\n\tA T = iota\n\tB\n\tC # c\n\n\tD = 1 << iota\n\tE\n",
+		"package p\n\ntype T int\n\nconst (\n\tA T = iota\n\tB\n\tC // c\n\n\tD = 1 << iota\n\tE\n)\n"},
+}
+
+func TestImplicitConsts(t *testing.T) {
+	runPrintTests(t, &testConfig, implicitConstTests)
+}
+
+var multiValueTests = []printTest{
+	{"package p\n\nfunc f() (int, int, int)\n\t_, _, x := f()\n\ta, b := 1, 2\n\ta, b = b, a\n\t_, _ = a, b\n\treturn x, a, b\n",
+		"package p\n\nfunc f() (int, int, int) {\n\t_, _, x := f()\n\ta, b := 1, 2\n\ta, b = b, a\n\t_, _ = a, b\n\treturn x, a, b\n}\n"},
+	{"package p\n\nfunc f(m map[string]int)\n\tfor k, v := range m\n\t\t_, _ = k, v\n\n\tfor _, v := range m\n\t\t_ = v\n\n\tfor k := range m\n\t\t_ = k\n",
+		"package p\n\nfunc f(m map[string]int) {\n\tfor k, v := range m {\n\t\t_, _ = k, v\n\t}\n\n\tfor _, v := range m {\n\t\t_ = v\n\t}\n\n\tfor k := range m {\n\t\t_ = k\n\t}\n}\n"},
+	{"package p\n\nvar a, b, _ = 1, 2, 3\n\nconst\n\tx, y = iota, -iota\n\t_, z\n",
+		"package p\n\nvar a, b, _ = 1, 2, 3\n\nconst (\n\tx, y = iota, -iota\n\t_, z\n)\n"},
+	{"package p\n\nfunc f(s []int)\n\ts[0], s[1] = s[1], s[0]\n\tvar a, b int\n\ta, b = b,\n\t\ta # swapped\n",
+		"package p\n\nfunc f(s []int) {\n\ts[0], s[1] = s[1], s[0]\n\tvar a, b int\n\ta, b = b,\n\t\ta // swapped\n}\n"},
+	// unformatted lists are spaced as gofmt does
+	{"package p\n\nfunc f()\n\t_,_,x:=g()\n\ta ,b=b , a\n\tfor k,v:=range m\n\t\t_,_ = k,v\n",
+		"package p\n\nfunc f() {\n\t_, _, x := g()\n\ta, b = b, a\n\tfor k, v := range m {\n\t\t_, _ = k, v\n\t}\n}\n"},
+}
+
+func TestMultiValueAssignments(t *testing.T) {
+	runPrintTests(t, &testConfig, multiValueTests)
+}
+
+var methodExprTests = []printTest{
+	{"package p\n\nvar\n\tf = T.M\n\tg = (*T).M\n\th = x.M\n\ti = (&x).M\n\tj = (-1.5).String\n\tk = (a + b).M\n",
+		"package p\n\nvar (\n\tf = T.M\n\tg = (*T).M\n\th = x.M\n\ti = (&x).M\n\tj = (-1.5).String\n\tk = (a + b).M\n)\n"},
+	{"package p\n\nfunc f()\n\ta.b.c.d()\n\ta.b().c.d()\n\t_ = (*T).M(nil)\n\tdefer x.M()\n",
+		"package p\n\nfunc f() {\n\ta.b.c.d()\n\ta.b().c.d()\n\t_ = (*T).M(nil)\n\tdefer x.M()\n}\n"},
+}
+
+func TestMethodExprs(t *testing.T) {
+	runPrintTests(t, &testConfig, methodExprTests)
+}
+
+// method returns the selector x.M, without positions.
+func method(x ast.Expr) ast.Expr {
+	return &ast.SelectorExpr{X: x, Sel: ast.NewIdent("M")}
+}
+
+var methodExprTreeTests = []struct {
+	x   ast.Expr
+	out string
+}{
+	{method(ast.NewIdent("T")), "T.M"},
+	{method(&ast.ParenExpr{X: &ast.StarExpr{X: ast.NewIdent("T")}}), "(*T).M"},
+	{method(&ast.StarExpr{X: ast.NewIdent("T")}), "(*T).M"},
+	{method(&ast.UnaryExpr{Op: token.AND, X: ast.NewIdent("x")}), "(&x).M"},
+	{method(&ast.BinaryExpr{X: ast.NewIdent("a"), Op: token.ADD, Y: ast.NewIdent("b")}), "(a + b).M"},
+	{method(&ast.BasicLit{Kind: token.INT, Value: "1"}), "1 .M"},
+	{&ast.CallExpr{Fun: method(method(method(ast.NewIdent("a"))))}, "a.M.M.M()"},
+}
+
+// TestMethodExprTrees prints method expressions and values built without
+// positions.
+func TestMethodExprTrees(t *testing.T) {
+	for _, test := range methodExprTreeTests {
+		var buf bytes.Buffer
+		if _, err := testConfig.Fprint(&buf, token.NewFileSet(), test.x); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.out {
+			t.Errorf("got %q; want %q", buf.String(), test.out)
+		}
+	}
+}
+
+var selectTests = []printTest{
+	{"package p\n\nfunc f(a, b chan int, x int)\n\tselect\n\t\tcase v := <-a:\n\t\t\t_ = v\n\t\tcase b <- x:\n\n\t\t# nothing to do\n\t\tdefault:\n\t\t\treturn\n",
+		"package p\n\nfunc f(a, b chan int, x int) {\n\tselect {\n\tcase v := <-a:\n\t\t_ = v\n\tcase b <- x:\n\n\t// nothing to do\n\tdefault:\n\t\treturn\n\t}\n}\n"},
+	{"package p\n\nfunc f(a chan int) int\n\tselect\n\t\tcase <-a:\n\t\tcase v, ok := <-a: # received\n\t\t\tif ok\n\t\t\t\treturn v\n\n\t\t\treturn 0\n\n\t\tcase a <- 1:\n\t\t\tfmt.Println()\n\t\t\tfmt.Println()\n\n\treturn 1\n",
+		"package p\n\nfunc f(a chan int) int {\n\tselect {\n\tcase <-a:\n\tcase v, ok := <-a: // received\n\t\tif ok {\n\t\t\treturn v\n\t\t}\n\n\t\treturn 0\n\n\tcase a <- 1:\n\t\tfmt.Println()\n\t\tfmt.Println()\n\t}\n\n\treturn 1\n}\n"},
+	{"package p\n\nfunc f()\n\tselect\n",
+		"package p\n\nfunc f() {\n\tselect {}\n}\n"},
+}
+
+func TestSelect(t *testing.T) {
+	runPrintTests(t, &testConfig, selectTests)
+}
+
+var typeAliasTests = []printTest{
+	{"package p\n\ntype\n\tA = int\n\tB int\n\tC = map[string]B # alias\n\tD struct\n\t\tx A\n\ntype E = D\n\ntype F = *pkg.T\n",
+		"package p\n\ntype (\n\tA = int\n\tB int\n\tC = map[string]B // alias\n\tD struct {\n\t\tx A\n\t}\n)\n\ntype E = D\n\ntype F = *pkg.T\n"},
+}
+
+func TestTypeAliases(t *testing.T) {
+	runPrintTests(t, &testConfig, typeAliasTests)
+}
+
+var goDeferTests = []printTest{
+	{"package p\n\nfunc f(t *T, ch chan int)\n\tgo g()\n\tdefer f(g(), h())\n\tgo t.M(1)\n\tdefer (t.M)()\n\tdefer (f)(nil, ch)\n\tgo (func():)()\n\tdefer func(x int)\n\t\tch <- x\n\t(1)\n",
+		"package p\n\nfunc f(t *T, ch chan int) {\n\tgo g()\n\tdefer f(g(), h())\n\tgo t.M(1)\n\tdefer (t.M)()\n\tdefer (f)(nil, ch)\n\tgo func() {}()\n\tdefer func(x int) {\n\t\tch <- x\n\t}(1)\n}\n"},
+	// conversions to function types are called as they are
+	{"package p\n\nfunc f(g func())\n\tgo (func())(g)()\n\tdefer (func(int))(h)(1)\n",
+		"package p\n\nfunc f(g func()) {\n\tgo (func())(g)()\n\tdefer (func(int))(h)(1)\n}\n"},
+}
+
+func TestGoDefer(t *testing.T) {
+	runPrintTests(t, &testConfig, goDeferTests)
+}
+
+var typeSetTests = []printTest{
+	{"package p\n\ntype Integer interface\n\t~int | ~int8 | ~int16\n\ntype Number interface\n\tInteger | ~float64\n\tString() string\n\ntype Ordered interface\n\tInteger\n\t~string\n",
+		"package p\n\ntype Integer interface {\n\t~int | ~int8 | ~int16\n}\n\ntype Number interface {\n\tInteger | ~float64\n\tString() string\n}\n\ntype Ordered interface {\n\tInteger\n\t~string\n}\n"},
+	{"package p\n\nfunc Max[T interface: ~int | ~string](a, b T) T: return a\n\nfunc Min[T ~int | ~float64](a T) T: return a\n\nvar x interface: ~int\n",
+		"package p\n\nfunc Max[T interface{ ~int | ~string }](a, b T) T { return a }\n\nfunc Min[T ~int | ~float64](a T) T { return a }\n\nvar x interface{ ~int }\n"},
+	// terms and unions are spaced as gofmt does
+	{"package p\n\ntype I interface\n\t~ int|~string   |  Other\n",
+		"package p\n\ntype I interface {\n\t~int | ~string | Other\n}\n"},
+}
+
+func TestTypeSets(t *testing.T) {
+	runPrintTests(t, &testConfig, typeSetTests)
+}
+
+var structTagTests = []printTest{
+	{"package p\n\ntype T struct\n\tA int    `json:\"a,omitempty\" xml:\"a\"`\n\tB string \"json:\\\"b\\\"\"\n\tC bool   `desc:\"with  spaces\tand a tab\"`\n\tD int    `é:\"ü\" x:\"\\xff\"`\n\tE int    \"x:\\\"\\xff\\\"\"\n",
+		"package p\n\ntype T struct {\n\tA int    `json:\"a,omitempty\" xml:\"a\"`\n\tB string \"json:\\\"b\\\"\"\n\tC bool   `desc:\"with  spaces\tand a tab\"`\n\tD int    `é:\"ü\" x:\"\\xff\"`\n\tE int    \"x:\\\"\\xff\\\"\"\n}\n"},
+	{"package p\n\ntype T struct\n\tA int `a:\"1\"\nb:\"2\"`\n\tB int\n",
+		"package p\n\ntype T struct {\n\tA int `a:\"1\"\nb:\"2\"`\n\tB int\n}\n"},
+}
+
+func TestStructTags(t *testing.T) {
+	runPrintTests(t, &testConfig, structTagTests)
+}
+
+var labelTests = []printTest{
+	{"package p\n\nfunc f(n int)\n\ti := 0\n\tloop:\n\t\tif i < n\n\t\t\ti++\n\t\t\tgoto loop\n\n\tgoto done\n\tdone:\n\t\tprintln(i)\n",
+		"package p\n\nfunc f(n int) {\n\ti := 0\nloop:\n\tif i < n {\n\t\ti++\n\t\tgoto loop\n\t}\n\n\tgoto done\ndone:\n\tprintln(i)\n}\n"},
+	{"package p\n\nfunc f(m [][]int)\n\touter:\n\t\tfor _, r := range m\n\t\t\tinner:\n\t\t\t\tfor _, x := range r\n\t\t\t\t\tswitch\n\t\t\t\t\t\tcase x < 0:\n\t\t\t\t\t\t\tcontinue outer\n\t\t\t\t\t\tcase x == 0:\n\t\t\t\t\t\t\tbreak inner\n\t\t\t\t\t\tcase x > 9:\n\t\t\t\t\t\t\tbreak outer\n",
+		"package p\n\nfunc f(m [][]int) {\nouter:\n\tfor _, r := range m {\n\tinner:\n\t\tfor _, x := range r {\n\t\t\tswitch {\n\t\t\tcase x < 0:\n\t\t\t\tcontinue outer\n\t\t\tcase x == 0:\n\t\t\t\tbreak inner\n\t\t\tcase x > 9:\n\t\t\t\tbreak outer\n\t\t\t}\n\t\t}\n\t}\n}\n"},
+	{"package p\n\nfunc f(x int)\n\tif x > 0\n\t\tgoto end\n\n\tx++\n\tend: ;\n",
+		"package p\n\nfunc f(x int) {\n\tif x > 0 {\n\t\tgoto end\n\t}\n\n\tx++\nend:\n}\n"},
+	{"package p\n\nfunc f(x int)\n\tfor\n\t\tif x > 0\n\t\t\tgoto next\n\n\t\tx++\n\t\tnext: ;\n\t\tx--\n",
+		"package p\n\nfunc f(x int) {\n\tfor {\n\t\tif x > 0 {\n\t\t\tgoto next\n\t\t}\n\n\t\tx++\n\tnext:\n\t\t;\n\t\tx--\n\t}\n}\n"},
+	// comments before a label stay at the indentation of the statements
+	{"package p\n\nfunc f(x int)\n\tfor\n\t\tx++\n\t\t# try again\n\t\tagain:\n\t\t\tif x < 0\n\t\t\t\tgoto again\n\t\t# done\n\t\tdone: ;\n",
+		"package p\n\nfunc f(x int) {\n\tfor {\n\t\tx++\n\t\t// try again\n\tagain:\n\t\tif x < 0 {\n\t\t\tgoto again\n\t\t}\n\t\t// done\n\tdone:\n\t}\n}\n"},
+}
+
+func TestLabels(t *testing.T) {
+	runPrintTests(t, &testConfig, labelTests)
+}
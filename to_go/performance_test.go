@@ -0,0 +1,68 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This file implements a simple printer performance benchmark:
+// go test -bench=BenchmarkFlush
+
+package to_go
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/token"
+)
+
+// benchSource returns an iGo source of n functions, with a comment after
+// each of their simple statements if commented.
+func benchSource(n int, commented bool) []byte {
+	comment := ""
+	if commented {
+		comment = " # a comment"
+	}
+	var buf bytes.Buffer
+	buf.WriteString("package p\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "\nfunc f%d(a, b int) (int, error)\n", i)
+		fmt.Fprintf(&buf, "\tx := a*b + %d%s\n", i, comment)
+		buf.WriteString("\tfor i := 0; i < b; i++\n")
+		buf.WriteString("\t\tif x > a\n")
+		fmt.Fprintf(&buf, "\t\t\tx -= i%s\n", comment)
+		fmt.Fprintf(&buf, "\treturn x, nil%s\n", comment)
+	}
+	return buf.Bytes()
+}
+
+func benchmarkFlush(b *testing.B, commented bool) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "bench.igo", benchSource(1000, commented), parser.ParseComments)
+	if err != nil {
+		b.Fatal(err)
+	}
+	cfg := &Config{Mode: UseSpaces | TabIndent, Tabwidth: 8}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cfg.Fprint(ioutil.Discard, fset, file); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkFlushNoComments prints a source of 6000 lines without
+// comments. A fast path of flush skipping the search for comments to
+// intersperse when there are none was measured with it: about 52ms per
+// op with or without it, the difference within the noise, so there is
+// none.
+func BenchmarkFlushNoComments(b *testing.B) {
+	benchmarkFlush(b, false)
+}
+
+// BenchmarkFlushComments prints the same source with 3000 comments.
+func BenchmarkFlushComments(b *testing.B) {
+	benchmarkFlush(b, true)
+}
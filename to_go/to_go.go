@@ -6,13 +6,16 @@
 package to_go
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
-	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"text/tabwriter"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/DAddYE/igo/ast"
 	"github.com/DAddYE/igo/token"
@@ -22,6 +25,8 @@ const (
 	maxNewlines = 2     // max. number of newlines between source text
 	debug       = false // enable for debugging
 	infinity    = 1 << 30
+
+	defaultMaxBlankLines = 10 // default Config.MaxBlankLines
 )
 
 type whiteSpace byte
@@ -41,6 +46,7 @@ type pmode int
 
 const (
 	noExtraLinebreak pmode = 1 << iota
+	declGroup              // the '(' being printed opens a group of declarations
 )
 
 type Positions map[token.Position]token.Position
@@ -57,7 +63,9 @@ type printer struct {
 	impliedSemi bool         // if set, a linebreak implies a semicolon
 	lastTok     token.Token  // the last token printed (token.ILLEGAL if it's whitespace)
 	wsbuf       []whiteSpace // delayed white space
-	noBrace     bool         // in certains scenarios (read case/default) we don't want a {} closure.
+	alignAssign bool         // the next statement may align its assignment token (see Config.AlignAssignments)
+	nesting     int          // of the expression printed, with MaxDepth
+	declLines   int          // if > 0, the line breaks before the next declaration, its comments included
 
 	// Positions
 	// The out position differs from the pos position when the result
@@ -70,6 +78,11 @@ type printer struct {
 	last      token.Position // value of pos after calling writeString
 	Positions                // history of all positions
 
+	// Source map, only recorded if EmitSourceMap is set.
+	sourceMap  map[int]token.Position // source position of the first token of each output line
+	mapOffset  int                    // offset in output up to which newlines have been counted
+	mapNewline int                    // number of newlines in the header and output[:mapOffset]
+
 	// The list of all source comments, in order of appearance.
 	comments        []*ast.CommentGroup // may be nil
 	cindex          int                 // current comment index
@@ -80,7 +93,15 @@ type printer struct {
 	commentOffset  int               // = p.posFor(p.comments[p.cindex].List[0].Pos()).Offset; or infinity
 	commentNewline bool              // true if the comment group contains newlines
 
-	// Cache of already computed node sizes.
+	// Documentation comment groups, only recorded if ReflowDocComments
+	// is set.
+	docs map[*ast.CommentGroup]bool
+
+	// Import specs following others dropped by filterDecls (see specLine).
+	joined map[ast.Spec][]ast.Spec
+
+	// Cache of already computed node sizes. It is only ever looked up,
+	// never iterated, so that the output does not depend on map order.
 	nodeSizes map[ast.Node]int
 
 	// Cache of most recently computed line position.
@@ -94,6 +115,9 @@ func (p *printer) init(cfg *Config, fset *token.FileSet, nodeSizes map[ast.Node]
 	p.pos = token.Position{Line: 1, Column: 1}
 	p.out = token.Position{Line: 1, Column: 1}
 	p.Positions = make(map[token.Position]token.Position)
+	if cfg.EmitSourceMap != nil {
+		p.sourceMap = make(map[int]token.Position)
+	}
 	p.wsbuf = make([]whiteSpace, 0, 16) // whitespace sequences are short
 	p.nodeSizes = nodeSizes
 	p.cachedPos = -1
@@ -172,8 +196,16 @@ func (p *printer) atLineBegin(pos token.Position) {
 	// use "hard" htabs - indentation columns
 	// must not be discarded by the tabwriter
 	n := p.Config.Indent + p.indent // include base indentation
-	for i := 0; i < n; i++ {
-		p.output = append(p.output, '\t')
+	if width := p.spaceIndent(); width > 0 {
+		// expand it here, the tabwriter, if any, would use Tabwidth
+		n *= width
+		for i := 0; i < n; i++ {
+			p.output = append(p.output, ' ')
+		}
+	} else {
+		for i := 0; i < n; i++ {
+			p.output = append(p.output, '\t')
+		}
 	}
 
 	// update positions
@@ -183,10 +215,80 @@ func (p *printer) atLineBegin(pos token.Position) {
 	p.Positions[p.pos] = p.out
 }
 
+// writeRaw writes text, the output of a BeforeDecl or AfterDecl hook, as
+// it is, on lines of its own indented as the code, and ends the last one
+// if eol is set. Only p.out is updated: text has no source position.
+func (p *printer) writeRaw(text []byte, eol bool) {
+	if len(text) == 0 {
+		return
+	}
+	newline := func() {
+		p.output = append(p.output, '\f')
+		p.out.Line++
+		p.out.Column = 1
+	}
+	if p.out.Column > 1 {
+		newline()
+	}
+	for i, line := range bytes.Split(bytes.TrimSuffix(text, []byte("\n")), []byte("\n")) {
+		if i > 0 {
+			newline()
+		}
+		if len(line) == 0 {
+			continue
+		}
+		n := p.Config.Indent + p.indent
+		if width := p.spaceIndent(); width > 0 {
+			p.output = append(p.output, bytes.Repeat([]byte{' '}, n*width)...)
+			n *= width
+		} else {
+			p.output = append(p.output, bytes.Repeat([]byte{'\t'}, n)...)
+		}
+		p.output = append(p.output, tabwriter.Escape)
+		p.output = append(p.output, line...)
+		p.output = append(p.output, tabwriter.Escape)
+		p.out.Column += n + len(line)
+	}
+	if eol {
+		newline()
+	}
+}
+
+// spaceIndent returns the number of blanks written for each level of
+// indentation, or 0 if it is written as tabs: in UseSpaces mode without
+// TabIndent, DisplayTabwidth if set, or else Tabwidth in RawFormat mode,
+// where no tabwriter expands the tabs.
+func (p *printer) spaceIndent() int {
+	if p.Config.Mode&(TabIndent|UseSpaces) != UseSpaces {
+		return 0
+	}
+	switch {
+	case p.Config.DisplayTabwidth > 0:
+		return p.Config.DisplayTabwidth
+	case p.Config.Mode&RawFormat != 0:
+		return p.Config.Tabwidth
+	}
+	return 0
+}
+
+// mapLine records pos as the source position of the current output line.
+// Output lines are counted in p.output since p.out follows //line comments.
+func (p *printer) mapLine(pos token.Position) {
+	for _, ch := range p.output[p.mapOffset:] {
+		if ch == '\n' || ch == '\f' {
+			p.mapNewline++
+		}
+	}
+	p.mapOffset = len(p.output)
+	p.sourceMap[p.mapNewline+1] = pos
+}
+
 // writeByte writes ch n times to p.output and updates p.pos.
 func (p *printer) writeByte(ch byte, n int) {
 	if p.out.Column == 1 {
-		p.atLineBegin(p.pos)
+		// Only indentation here: p.pos may already be the position
+		// of the next item, and blank lines need no //line comment.
+		p.atLineBegin(token.Position{})
 	}
 
 	for i := 0; i < n; i++ {
@@ -218,9 +320,15 @@ func (p *printer) writeByte(ch byte, n int) {
 // avoids processing extra escape characters and reduces run time of the
 // printer benchmark by up to 10%.
 //
+// The lines of s after the first, e.g. those of a raw string, are written
+// as they are: indentation is only written by atLineBegin, before s.
+//
 func (p *printer) writeString(pos token.Position, s string, isLit bool) {
 	if p.out.Column == 1 {
 		p.atLineBegin(pos)
+		if p.Config.EmitSourceMap != nil && pos.IsValid() {
+			p.mapLine(pos)
+		}
 	}
 
 	if pos.IsValid() {
@@ -356,11 +464,12 @@ func (p *printer) writeCommentPrefix(pos, next token.Position, prev, comment *as
 				}
 				// if the next token is not a closing }, apply the unindent
 				// if it appears that the comment is aligned with the
-				// token; otherwise assume the unindent is part of a
-				// closing block and stop (this scenario appears with
-				// comments before a case label where the comments
-				// apply to the next case instead of the current one)
-				if tok != token.RBRACE && pos.Column == next.Column {
+				// token, or indented less; otherwise assume the unindent
+				// is part of a closing block and stop (this scenario
+				// appears with comments before a case label where the
+				// comments apply to the next case instead of the current
+				// one)
+				if tok != token.RBRACE && pos.Column <= next.Column {
 					continue
 				}
 			case newline, formfeed:
@@ -381,11 +490,18 @@ func (p *printer) writeCommentPrefix(pos, next token.Position, prev, comment *as
 			}
 		}
 
+		// the line breaks before the first declaration are fixed
+		if p.declLines > 0 {
+			n, droppedLinebreak = p.declLines, false
+			p.declLines = 0
+		}
+
 		// at the package scope level only (p.indent == 0),
 		// add an extra newline if we dropped one before:
 		// this preserves a blank line before documentation
 		// comments at the package scope level (issue 2570)
-		if p.indent == 0 && droppedLinebreak {
+		// unless only line breaks from the source are kept
+		if p.indent == 0 && droppedLinebreak && p.Mode&SourceLines == 0 && !p.PreserveBlankLines {
 			n++
 		}
 
@@ -399,7 +515,7 @@ func (p *printer) writeCommentPrefix(pos, next token.Position, prev, comment *as
 			// use formfeeds to break columns before a comment;
 			// this is analogous to using formfeeds to separate
 			// individual lines of /*-style comments
-			p.writeByte('\f', nlimit(n))
+			p.writeByte('\f', p.nlimit(n))
 		}
 	}
 }
@@ -430,11 +546,142 @@ func trimRight(s string) string {
 	return strings.TrimRightFunc(s, unicode.IsSpace)
 }
 
+// expandTabs returns s with each tab replaced by blanks up to the next
+// tab stop, every tabwidth columns. Columns are counted in characters,
+// starting from col for the first one of s.
+func expandTabs(s string, col, tabwidth int) string {
+	if tabwidth <= 0 || strings.IndexByte(s, '\t') < 0 {
+		return s
+	}
+	b := make([]byte, 0, len(s)+tabwidth)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\t':
+			for {
+				b = append(b, ' ')
+				col++
+				if col%tabwidth == 0 {
+					break
+				}
+			}
+		case c&0xC0 != 0x80: // not a continuation byte of a UTF-8 sequence
+			b = append(b, c)
+			col++
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// reflowComment re-wraps the paragraphs of a //-style comment group, given
+// by the texts of its lines without comment marker, so that no line is
+// wider than width, if possible, breaking lines at blanks. Only the
+// paragraphs with a line wider than width change: blank lines, directives,
+// code blocks and lines holding a URL separate paragraphs and are kept as
+// they are. The result is nil if no paragraph is re-wrapped.
+func reflowComment(texts []string, width int) []string {
+	var res []string
+	changed := false
+	for i := 0; i < len(texts); {
+		if !isProse(texts[i]) {
+			res = append(res, texts[i])
+			i++
+			continue
+		}
+		j, wide := i, false
+		for ; j < len(texts) && isProse(texts[j]); j++ {
+			wide = wide || utf8.RuneCountInString(texts[j]) > width
+		}
+		if !wide {
+			res = append(res, texts[i:j]...)
+			i = j
+			continue
+		}
+		changed = true
+		line := ""
+		for _, word := range strings.Fields(strings.Join(texts[i:j], " ")) {
+			if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+				res = append(res, line)
+				line = ""
+			}
+			line += " " + word
+		}
+		res = append(res, line)
+		i = j
+	}
+	if !changed {
+		return nil
+	}
+	return res
+}
+
+// isProse reports whether text, a line of a //-style comment without its
+// marker, may be re-wrapped by reflowComment: it is not blank, neither a
+// directive nor indented code (which don't start with exactly one blank),
+// and holds no URL.
+func isProse(text string) bool {
+	return len(text) > 1 && text[0] == ' ' && text[1] != ' ' && text[1] != '\t' && !strings.Contains(text, "://")
+}
+
+// isDirective reports whether text, a line of a //-style comment without
+// its marker, is a directive to a tool, such as go:generate, lint:ignore
+// or nolint, which must be written exactly as it is: neither reflowed nor
+// with its tabs expanded. Trailing blanks are trimmed as for any comment.
+func isDirective(text string) bool {
+	// "//line " is a line directive, "//extern " is for gccgo,
+	// "//export " for cgo, "//nolint" for golangci-lint
+	for _, prefix := range []string{"line ", "extern ", "export ", "nolint"} {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	// "//[a-z0-9]+:[a-z0-9]"
+	colon := strings.Index(text, ":")
+	if colon <= 0 || colon+1 >= len(text) {
+		return false
+	}
+	for i := 0; i <= colon+1; i++ {
+		if b := text[i]; i != colon && !('a' <= b && b <= 'z' || '0' <= b && b <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// goLiteral returns the Go text of the literal x. A """-delimited string,
+// verbatim text possibly spanning several lines, is written as a raw
+// string, or as an interpreted one if it holds a backquote.
+func goLiteral(x *ast.BasicLit) string {
+	s := x.Value
+	if x.Kind != token.STRING || len(s) < 6 || !strings.HasPrefix(s, `"""`) {
+		return s
+	}
+	text := s[3 : len(s)-3]
+	if strings.Contains(text, "`") {
+		return strconv.Quote(text)
+	}
+	return "`" + text + "`"
+}
+
+// lineFilename returns the filename that the line directive comment, naming
+// filename, sets for the following line. The file set resolves relative
+// names, so its position for the start of the next line is used if the
+// comment is followed by one.
+func (p *printer) lineFilename(comment *ast.Comment, filename string) string {
+	if f := p.fset.File(comment.Pos()); f != nil {
+		if next := comment.End() + 1; int(next) < f.Base()+f.Size() {
+			return p.posFor(next).Filename
+		}
+	}
+	return filename
+}
+
 func (p *printer) writeComment(comment *ast.Comment, prefix string) {
 	text := comment.Text
 	pos := p.posFor(comment.Pos())
 
-	const linePrefix = "//line "
+	const linePrefix = "#line "
 	if strings.HasPrefix(text, linePrefix) && (!pos.IsValid() || pos.Column == 1) {
 		// possibly a line directive
 		ldir := strings.TrimSpace(text[len(linePrefix):])
@@ -444,19 +691,32 @@ func (p *printer) writeComment(comment *ast.Comment, prefix string) {
 				// the Filename and Line number used for subsequent
 				// tokens. We have to update our AST-space position
 				// accordingly and suspend indentation temporarily.
+				// The directive is copied to the output as well, so
+				// the output position changes the same way once the
+				// line is terminated; otherwise atLineBegin would
+				// emit a second, redundant //line comment.
+				filename := p.lineFilename(comment, ldir[:i])
 				indent := p.indent
 				p.indent = 0
 				defer func() {
-					p.pos.Filename = ldir[:i]
-					p.pos.Line = line
+					p.pos.Filename = filename
+					p.pos.Line = line - 1
 					p.pos.Column = 1
+					p.out.Filename = filename
+					p.out.Line = line - 1
 					p.indent = indent
 				}()
 			}
 		}
 	}
 
-	t := trimRight(text[1:])
+	t := text[1:]
+	if p.Mode&KeepCommentSpace == 0 || prefix != "//" {
+		t = trimRight(t)
+	}
+	if p.ExpandCommentTabs && !isDirective(t) {
+		t = expandTabs(t, len(prefix), p.Tabwidth)
+	}
 
 	// shortcut common case of //-style comments
 	var suffix string
@@ -470,6 +730,48 @@ func (p *printer) writeComment(comment *ast.Comment, prefix string) {
 	p.writeString(pos, prefix+t+suffix, true)
 }
 
+// reflowDoc returns the lines of the documentation comment group g, without
+// comment marker, re-wrapped as ReflowDocComments requires, or nil if they
+// are kept as they are.
+func (p *printer) reflowDoc(g *ast.CommentGroup) []string {
+	if !p.docs[g] || p.MaxColumn <= 0 {
+		return nil
+	}
+	texts := make([]string, len(g.List))
+	for i, c := range g.List {
+		if strings.HasPrefix(c.Text, "#line ") {
+			return nil // the line directive must be written by writeComment
+		}
+		texts[i] = c.Text[1:]
+		if p.Mode&KeepCommentSpace == 0 {
+			texts[i] = trimRight(texts[i])
+		}
+		if p.ExpandCommentTabs && !isDirective(texts[i]) {
+			texts[i] = expandTabs(texts[i], len("//"), p.Tabwidth)
+		}
+	}
+	width := p.MaxColumn - (p.Indent+p.indent)*p.Tabwidth - len("//")
+	return reflowComment(texts, width)
+}
+
+// writeDoc writes lines, the re-wrapped text of the documentation comment
+// group g, and returns the last comment of g. The source position is then
+// the end of g, whatever the number of lines written.
+func (p *printer) writeDoc(g *ast.CommentGroup, lines []string) *ast.Comment {
+	pos := p.posFor(g.Pos())
+	for i, line := range lines {
+		if i > 0 {
+			p.writeByte('\f', 1)
+			pos = p.pos
+		}
+		p.writeString(pos, "//"+line, true)
+	}
+	last := g.List[len(g.List)-1]
+	p.pos = p.posFor(last.End())
+	p.last = p.pos
+	return last
+}
+
 // writeCommentSuffix writes a line break after a comment if indicated
 // and processes any leftover indentation information. If a line break
 // is needed, the kind of break (newline vs formfeed) depends on the
@@ -494,6 +796,9 @@ func (p *printer) writeCommentSuffix(needsLinebreak bool) (wroteNewline, dropped
 			} else {
 				if ch == formfeed {
 					droppedFF = true
+					if p.Stats != nil {
+						p.Stats.DroppedFFs++
+					}
 				}
 				p.wsbuf[i] = ignore
 			}
@@ -518,26 +823,41 @@ func (p *printer) writeCommentSuffix(needsLinebreak bool) (wroteNewline, dropped
 //
 func (p *printer) intersperseComments(next token.Position, tok token.Token) (wroteNewline, droppedFF bool) {
 	var last *ast.Comment
+	lineStyle := false // whether last was written as a //-style comment
 	for p.commentBefore(next) {
-		for _, c := range p.comment.List {
-			if tok == token.LPAREN || tok == token.LBRACE {
+		ownLine := p.commentOwnLine()
+		for i, c := range p.comment.List {
+			lineStyle = tok != token.LPAREN && tok != token.LBRACE || ownLine
+			if !lineStyle {
 				p.writeComment(c, "/*")
 			} else {
 				p.writeCommentPrefix(p.posFor(c.Pos()), next, last, c, tok)
+				if i == 0 {
+					if lines := p.reflowDoc(p.comment); lines != nil {
+						// the whole group is written at once
+						last = p.writeDoc(p.comment, lines)
+						break
+					}
+				}
 				p.writeComment(c, "//")
 			}
 			last = c
 		}
+		if p.Stats != nil {
+			p.Stats.Comments += len(p.comment.List)
+		}
 		p.nextComment()
 	}
 
 	if last != nil {
 		// ensure that there is a line break after a //-style comment,
-		// before a closing '}' ')' unless explicitly disabled, or at eof
-		needsLinebreak :=
+		// even if the next item is on its line, e.g. in an AST with
+		// made up positions, before a closing '}' ')' unless explicitly
+		// disabled, or at eof
+		needsLinebreak := lineStyle ||
 			tok == token.RBRACE && p.mode&noExtraLinebreak == 0 ||
-				tok == token.RPAREN && p.mode&noExtraLinebreak == 0 ||
-				tok == token.EOF
+			tok == token.RPAREN && p.mode&noExtraLinebreak == 0 ||
+			tok == token.EOF
 		return p.writeCommentSuffix(needsLinebreak)
 	}
 
@@ -549,6 +869,10 @@ func (p *printer) intersperseComments(next token.Position, tok token.Token) (wro
 
 // whiteWhitespace writes the first n whitespace entries.
 func (p *printer) writeWhitespace(n int) {
+	if p.Stats != nil {
+		p.Stats.Whitespace += n
+	}
+
 	// write entries
 	for i := 0; i < n; i++ {
 		switch ch := p.wsbuf[i]; ch {
@@ -556,6 +880,9 @@ func (p *printer) writeWhitespace(n int) {
 			// ignore!
 		case indent:
 			p.indent++
+			if p.Stats != nil && p.indent > p.Stats.MaxIndent {
+				p.Stats.MaxIndent = p.indent
+			}
 		case unindent:
 			p.indent--
 			if p.indent < 0 {
@@ -597,14 +924,26 @@ func (p *printer) writeWhitespace(n int) {
 // ----------------------------------------------------------------------------
 // Printing interface
 
-// nlines limits n to maxNewlines.
-func nlimit(n int) int {
-	if n > maxNewlines {
-		n = maxNewlines
+// nlimit limits n to the maximum number of newlines between source text.
+func (p *printer) nlimit(n int) int {
+	if max := p.maxNewlines(); n > max {
+		n = max
 	}
 	return n
 }
 
+// maxNewlines returns the maximum number of newlines between source text:
+// maxNewlines, unless the blank lines of the source are preserved.
+func (p *printer) maxNewlines() int {
+	switch {
+	case !p.PreserveBlankLines:
+		return maxNewlines
+	case p.MaxBlankLines > 0:
+		return p.MaxBlankLines + 1
+	}
+	return defaultMaxBlankLines + 1
+}
+
 func mayCombine(prev token.Token, next byte) (b bool) {
 	switch prev {
 	case token.INT:
@@ -640,6 +979,7 @@ func (p *printer) print(args ...interface{}) {
 		var data string
 		var isLit bool
 		var impliedSemi bool // value for p.impliedSemi after this arg
+		var end token.Pos    // of the source text, if data is written differently
 
 		switch x := arg.(type) {
 		case pmode:
@@ -676,11 +1016,22 @@ func (p *printer) print(args ...interface{}) {
 
 		case *ast.Ident:
 			data = x.Name
+			if p.RenameFunc != nil {
+				if name := p.RenameFunc(x); name != "" {
+					data = name
+				}
+			}
 			impliedSemi = true
 			p.lastTok = token.IDENT
 
 		case *ast.BasicLit:
-			data = x.Value
+			data = goLiteral(x)
+			if p.FormatNumber != nil && (x.Kind == token.INT || x.Kind == token.FLOAT) {
+				data = p.FormatNumber(x)
+			}
+			if data != x.Value {
+				end = x.End()
+			}
 			isLit = true
 			impliedSemi = true
 			p.lastTok = x.Kind
@@ -723,22 +1074,23 @@ func (p *printer) print(args ...interface{}) {
 			p.lastTok = token.STRING
 
 		default:
-			fmt.Fprintf(os.Stderr, "print: unsupported argument %v (%T)\n", arg, arg)
-			panic("github.com/DAddYE/igo/to_go printer type")
+			// incorrect AST - stop printing, printNode reports it
+			panic(unsupportedArg{p.pos, arg})
 		}
 		// data != ""
 
 		next := p.pos // estimated/accurate position of next item
 		wroteNewline, droppedFF := p.flush(next, p.lastTok)
+		p.declLines = 0 // only for the comments before the declaration
 
 		// intersperse extra newlines if present in the source and
 		// if they don't cause extra semicolons (don't do this in
 		// flush as it will cause extra newlines at the end of a file)
 		if !p.impliedSemi {
-			n := nlimit(next.Line - p.pos.Line)
+			n := p.nlimit(next.Line - p.pos.Line)
 			// don't exceed maxNewlines if we already wrote one
-			if wroteNewline && n == maxNewlines {
-				n = maxNewlines - 1
+			if max := p.maxNewlines(); wroteNewline && n == max {
+				n = max - 1
 			}
 			if n > 0 {
 				ch := byte('\n')
@@ -751,6 +1103,10 @@ func (p *printer) print(args ...interface{}) {
 		}
 
 		p.writeString(next, data, isLit)
+		if end.IsValid() {
+			p.pos = p.posFor(end)
+			p.last = p.pos
+		}
 		p.impliedSemi = impliedSemi
 	}
 }
@@ -763,13 +1119,33 @@ func (p *printer) commentBefore(next token.Position) (result bool) {
 	return p.commentOffset < next.Offset && (!p.impliedSemi || !p.commentNewline)
 }
 
+// deferComments reports whether the pending comments, which precede an
+// opening '{' or the '(' of a declaration group and would be printed as
+// /*-style comments, are to be printed right after it as //-style comments
+// instead. This is safe since iGo comments extend to the end of the line,
+// thus a line break follows them anyway.
+func (p *printer) deferComments(tok token.Token) bool {
+	if !p.Config.NormalizeComments || p.commentOwnLine() {
+		return false
+	}
+	return tok == token.LBRACE || tok == token.LPAREN && p.mode&declGroup != 0
+}
+
+// commentOwnLine reports whether the pending comments start on a line
+// after the one of the last token printed, as those before the '{' of a
+// composite literal element on its own line. These are printed as
+// //-style comments before the next token, whatever it is.
+func (p *printer) commentOwnLine() bool {
+	return p.posFor(p.comment.Pos()).Line > p.last.Line
+}
+
 // flush prints any pending comments and whitespace occurring textually
 // before the position of the next token tok. The flush result indicates
 // if a newline was written or if a formfeed was dropped from the whitespace
 // buffer.
 //
 func (p *printer) flush(next token.Position, tok token.Token) (wroteNewline, droppedFF bool) {
-	if p.commentBefore(next) {
+	if p.commentBefore(next) && !p.deferComments(tok) {
 		// if there are comments before the next item, intersperse them
 		wroteNewline, droppedFF = p.intersperseComments(next, tok)
 	} else {
@@ -800,7 +1176,66 @@ func getDoc(n ast.Node) *ast.CommentGroup {
 	return nil
 }
 
-func (p *printer) printNode(node interface{}) error {
+// collectDocs records in p.docs the documentation comment groups of node
+// and of the nodes it contains.
+func (p *printer) collectDocs(node interface{}) {
+	p.docs = make(map[*ast.CommentGroup]bool)
+	visit := func(n ast.Node) bool {
+		if doc := getDoc(n); doc != nil {
+			p.docs[doc] = true
+		}
+		return true
+	}
+	switch n := node.(type) {
+	case ast.Node:
+		ast.Inspect(n, visit)
+	case []ast.Decl:
+		for _, d := range n {
+			ast.Inspect(d, visit)
+		}
+	case []ast.Stmt:
+		for _, s := range n {
+			ast.Inspect(s, visit)
+		}
+	}
+}
+
+// An unsupportedArg panic is raised by print for an argument it cannot
+// print, at the AST position pos.
+type unsupportedArg struct {
+	pos token.Position
+	arg interface{}
+}
+
+func (e unsupportedArg) Error() string {
+	return fmt.Sprintf("%s: github.com/DAddYE/igo/to_go: unsupported argument %v (%T)", e.pos, e.arg, e.arg)
+}
+
+// A tooDeep panic is raised by expr1 for an expression nested more than
+// max levels deep, at the AST position pos.
+type tooDeep struct {
+	pos token.Position
+	max int
+}
+
+func (e tooDeep) Error() string {
+	return fmt.Sprintf("%s: github.com/DAddYE/igo/to_go: expression nested more than %d levels deep", e.pos, e.max)
+}
+
+func (p *printer) printNode(node interface{}) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch e := e.(type) {
+			case unsupportedArg:
+				err = e
+			case tooDeep:
+				err = e
+			default:
+				panic(e)
+			}
+		}
+	}()
+
 	// unpack *CommentedNode, if any
 	var comments []*ast.CommentGroup
 	if cnode, ok := node.(*CommentedNode); ok {
@@ -824,9 +1259,11 @@ func (p *printer) printNode(node interface{}) error {
 			beg = doc.Pos()
 		}
 		// token.Pos values are global offsets, we can
-		// compare them directly
+		// compare them directly; a comment group ending
+		// right before the node (e.g., a synthetic one)
+		// is its documentation too
 		i := 0
-		for i < len(comments) && comments[i].End() < beg {
+		for i < len(comments) && comments[i].End()+1 < beg {
 			i++
 		}
 		j := i
@@ -837,6 +1274,8 @@ func (p *printer) printNode(node interface{}) error {
 			p.comments = comments[i:j]
 		}
 	} else if n, ok := node.(*ast.File); ok {
+		n, p.joined = p.filterFile(n)
+		node = n
 		// use ast.File comments, if any
 		p.comments = n.Comments
 	}
@@ -844,6 +1283,10 @@ func (p *printer) printNode(node interface{}) error {
 	// if there are no comments, use node comments
 	p.useNodeComments = p.comments == nil
 
+	if p.ReflowDocComments {
+		p.collectDocs(node)
+	}
+
 	// get comments ready for use
 	p.nextComment()
 
@@ -882,7 +1325,11 @@ func (p *printer) printNode(node interface{}) error {
 	return nil
 
 unsupported:
-	return fmt.Errorf("github.com/DAddYE/igo/printer: unsupported node type %T", node)
+	pos := p.pos
+	if n, ok := node.(ast.Node); ok {
+		pos = p.posFor(n.Pos())
+	}
+	return fmt.Errorf("%s: github.com/DAddYE/igo/to_go: unsupported node type %T", pos, node)
 }
 
 // ----------------------------------------------------------------------------
@@ -892,12 +1339,15 @@ unsupported:
 // characters, trailing blanks and tabs, and for converting formfeed
 // and vtab characters into newlines and htabs (in case no tabwriter
 // is used). Text bracketed by tabwriter.Escape characters is passed
-// through unchanged.
+// through unchanged, but for the carriage returns of its "\r\n" line
+// endings if forceLF is set.
 //
 type trimmer struct {
-	output io.Writer
-	state  int
-	space  []byte
+	output  io.Writer
+	state   int
+	space   []byte
+	forceLF bool
+	blanks  bool // write the tabs separating cells as blanks
 }
 
 // trimmer is implemented as a state machine.
@@ -933,6 +1383,9 @@ func (p *trimmer) Write(data []byte) (n int, err error) {
 		if b == '\v' {
 			b = '\t' // convert to htab
 		}
+		if b == '\t' && p.blanks && p.state != inEscape {
+			b = ' '
+		}
 		switch p.state {
 		case inSpace:
 			switch b {
@@ -951,9 +1404,13 @@ func (p *trimmer) Write(data []byte) (n int, err error) {
 				m = n
 			}
 		case inEscape:
-			if b == tabwriter.Escape {
+			switch {
+			case b == tabwriter.Escape:
 				_, err = p.output.Write(data[m:n])
 				p.resetSpace()
+			case b == '\n' && p.forceLF && n > m && data[n-1] == '\r':
+				_, err = p.output.Write(data[m : n-1])
+				m = n
 			}
 		case inText:
 			switch b {
@@ -988,6 +1445,17 @@ func (p *trimmer) Write(data []byte) (n int, err error) {
 	return
 }
 
+// NewTrimmer returns an io.Writer filtering what is written to it, as
+// the printer filters its output, before writing it to w: tabwriter.Escape
+// characters and trailing blanks and tabs are stripped, and formfeed and
+// vtab characters converted into newlines and htabs. Text bracketed by
+// tabwriter.Escape characters is passed through unchanged. Blanks and
+// tabs are held back until the text following them is written, hence
+// those at the end of the last line are never written to w.
+func NewTrimmer(w io.Writer) io.Writer {
+	return &trimmer{output: w}
+}
+
 // ----------------------------------------------------------------------------
 // Public interface
 
@@ -995,10 +1463,12 @@ func (p *trimmer) Write(data []byte) (n int, err error) {
 type Mode uint
 
 const (
-	RawFormat Mode = 1 << iota // do not use a tabwriter; if set, UseSpaces is ignored
-	TabIndent                  // use tabs for indentation independent of UseSpaces
-	UseSpaces                  // use spaces instead of tabs for alignment
-	SourcePos                  // emit //line comments to preserve original source positions
+	RawFormat        Mode = 1 << iota // do not use a tabwriter; with UseSpaces, indent and separate with spaces, unaligned
+	TabIndent                         // use tabs for indentation independent of UseSpaces
+	UseSpaces                         // use spaces instead of tabs for alignment
+	SourcePos                         // emit //line comments to preserve original source positions
+	KeepCommentSpace                  // keep trailing white space in //-style comments
+	SourceLines                       // keep line breaks from the source, only normalize white space
 )
 
 // A Config node controls the output of Fprint.
@@ -1006,14 +1476,238 @@ type Config struct {
 	Mode     Mode // default: 0
 	Tabwidth int  // default: 8
 	Indent   int  // default: 0 (all code is indented at least by this much)
+
+	// In UseSpaces mode without TabIndent, each level of indentation is
+	// expanded to DisplayTabwidth spaces while Tabwidth remains the
+	// width of alignment cells. Default: Tabwidth. With RawFormat too,
+	// there is no alignment and cells are separated by a blank.
+	DisplayTabwidth int
+
+	// If set, comments preceding an opening '{' or the '(' of a declaration
+	// group are printed after it as //-style comments instead of before it
+	// as /*-style ones.
+	NormalizeComments bool
+
+	// If set, tabs in the text of comments are expanded to blanks, with
+	// tab stops every Tabwidth columns from the start of the comment (or
+	// of the line, for the following lines of a /*-style comment).
+	ExpandCommentTabs bool
+
+	// If set, the blank lines between source text are kept as they are,
+	// up to MaxBlankLines (default: 10) in a row, instead of being
+	// collapsed into one.
+	PreserveBlankLines bool
+	MaxBlankLines      int
+
+	// If set, a JSON object mapping the number of each output line to
+	// the source token.Position of its first token is written to
+	// EmitSourceMap after the output. Blank lines are not mapped.
+	EmitSourceMap io.Writer
+
+	// If set, the assignment tokens of consecutive statements assigning
+	// one value to one operand are aligned. A blank line or any other
+	// statement ends the run.
+	AlignAssignments bool
+
+	// If set, no function body is printed on the line of its signature
+	// unless it is empty, so that every statement gets a line of its own.
+	// The semicolons of clauses, e.g. in a for statement header, are not
+	// affected.
+	OneStatementPerLine bool
+
+	// If set, RenameFunc is called for every identifier printed and the
+	// name it returns is printed instead, unless it is empty. Positions
+	// are not updated for the new names, hence a rename is only meant to
+	// be cosmetic: an API migration, or a package renamed.
+	RenameFunc func(*ast.Ident) string
+
+	// If set, the final newline of the output, if any, is dropped, e.g.
+	// to concatenate fragments. Only one newline is removed.
+	NoFinalNewline bool
+
+	// If set together with MaxColumn, the paragraphs of documentation
+	// comments with lines ending past MaxColumn are re-wrapped at blanks
+	// to fit, indentation included. Directives, lines holding a URL and
+	// code blocks, indented further than the text, are kept as they are.
+	ReflowDocComments bool
+	MaxColumn         int
+
+	// If set, only the declarations of a file for which FilterDecls
+	// returns true are printed, with the comments outside the others.
+	// Imports are not passed to FilterDecls: they are pruned as with
+	// PruneUnusedImports, keeping those the declarations printed use.
+	FilterDecls func(ast.Decl) bool
+
+	// If set, the imports of a file whose package name no selector refers
+	// to are dropped, with their comments. The name is guessed from the
+	// import path if not given. Blank and dot imports are kept, as are
+	// those whose name is guessed when it cannot be relied on: it is not
+	// an identifier (e.g. the path ends in go-foo), the path ends in a
+	// version suffix such as v2, or a selector refers to a package name
+	// which is not that of an import, nor declared in the file.
+	PruneUnusedImports bool
+
+	// If set, Mode and Tabwidth are ignored and the output is formatted
+	// exactly as gofmt (and go/format.Source) would: UseSpaces|TabIndent
+	// with a Tabwidth of 8, and the imports of an *ast.File node sorted
+	// in place. Like go/format.Source, no simplification is applied.
+	GofmtCompatible bool
+
+	// If set, the "\r\n" line endings within literals and comments are
+	// written as "\n", as are all the others. This changes the value of
+	// raw string literals. The scanner already drops carriage returns
+	// from those and comments, so only literals of syntax trees built or
+	// rewritten otherwise are affected.
+	ForceLF bool
+
+	// If set, the Go printed for an *ast.File node is parsed again and
+	// its syntax tree compared to the one printed, leaving out comments
+	// and parentheses. If they differ, e.g. because a comment put a line
+	// break where Go then inserts a semicolon, an error locating the
+	// first difference is returned and nothing is written.
+	VerifySemantics bool
+
+	// If set, printing an expression nested more than MaxDepth levels
+	// deep, e.g. a generated a+a+...+a, fails with an error instead of
+	// possibly exhausting the stack.
+	MaxDepth int
+
+	// If set, the first declaration of a file after the package clause
+	// and the imports, with its documentation, is not preceded by a blank
+	// line, even if the source has one; if not, there is one, as gofmt
+	// does, even if the source has none. Ignored in SourceLines mode.
+	NoBlankBeforeFirstDecl bool
+
+	// If set, BeforeDecl and AfterDecl are called for each declaration of
+	// a declaration list, e.g. the top-level ones of a file, and what they
+	// write is printed as it is, on lines of its own indented as the
+	// declaration. The output of BeforeDecl precedes the documentation of
+	// the declaration, after the other comments before it; the output of
+	// AfterDecl follows the comments on the last line of the declaration.
+	BeforeDecl func(ast.Decl, io.Writer)
+	AfterDecl  func(ast.Decl, io.Writer)
+
+	// If set, the output of an *ast.File node starts with this line, e.g.
+	// DefaultGeneratedHeader, and a blank line, written as they are ahead
+	// of everything else, unless a comment before the package clause of
+	// the file marks it as generated already. The line must match
+	// generatedRx for go tools and linters to skip the file.
+	GeneratedHeader string
+
+	// If set, an *ast.File node is simplified in place before printing,
+	// as by gofmt -s: the element types repeated in composite literals,
+	// e.g. []T{T{}} printed as []T{{}}, the high bound s[a:len(s)] of a
+	// slice of s, the blank variables of range clauses and the empty
+	// declaration groups are dropped.
+	Simplify bool
+
+	// If set, a binary expression is printed with blanks around its
+	// operator only where the source has white space, e.g. a*b+c * d as
+	// it is, instead of as the precedence of its operators asks for, e.g.
+	// a*b + c*d. The output is not canonical: it is meant to round-trip
+	// hand-formatted sources. Expressions without positions are printed
+	// as usual.
+	PreserveExprSpacing bool
+
+	// If set, FormatNumber is called for every integer and floating-point
+	// literal printed and the text it returns is printed instead of the
+	// literal's, e.g. to add digit separators or to lowercase hexadecimal
+	// digits. The text must be a valid literal of the same kind and value:
+	// it is not checked.
+	FormatNumber func(*ast.BasicLit) string
+
+	// If set, Stats is filled in with what the printer did by each call
+	// to Fprint, for diagnostics and benchmarks.
+	Stats *PrinterStats
+}
+
+// PrinterStats counts what the printer did while printing a node, see
+// Config.Stats.
+type PrinterStats struct {
+	Comments   int // comments written
+	DroppedFFs int // formfeeds dropped from the whitespace after comments
+	MaxIndent  int // deepest indentation written, in levels
+	Whitespace int // whitespace entries flushed, ignored ones included
 }
 
+// DefaultGeneratedHeader marks the Go files printed as generated by igo.
+const DefaultGeneratedHeader = "// Code generated by igo; DO NOT EDIT."
+
+// generatedRx matches the comments marking Go files as generated, as
+// described by "go help generate".
+var generatedRx = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// isGenerated reports whether a comment before the package clause of
+// file, a # comment written as a // one, marks it as generated.
+func isGenerated(file *ast.File) bool {
+	for _, g := range file.Comments {
+		if g.Pos() >= file.Package {
+			break
+		}
+		for _, c := range g.List {
+			if generatedRx.MatchString("//" + strings.TrimPrefix(c.Text, "#")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gofmtMode is the printer mode used by gofmt.
+const gofmtMode = UseSpaces | TabIndent
+
 // fprint implements Fprint and takes a nodesSizes map for setting up the printer state.
 func (cfg *Config) fprint(output io.Writer, fset *token.FileSet, node interface{}, nodeSizes map[ast.Node]int) (pos *Positions, err error) {
+	if file, ok := node.(*ast.File); ok && cfg.VerifySemantics {
+		unverified := *cfg
+		unverified.VerifySemantics = false
+		var buf bytes.Buffer
+		if pos, err = unverified.fprint(&buf, fset, node, nodeSizes); err != nil {
+			return
+		}
+		filtered, _ := cfg.filterFile(file)
+		if err = verifySemantics(fset, filtered, buf.Bytes()); err != nil {
+			return
+		}
+		_, err = output.Write(buf.Bytes())
+		return
+	}
+
+	if cfg.GofmtCompatible {
+		gofmt := *cfg
+		gofmt.Mode = gofmtMode
+		gofmt.Tabwidth = 8
+		gofmt.GofmtCompatible = false
+		gofmt.NoBlankBeforeFirstDecl = false
+		cfg = &gofmt
+
+		if file, ok := node.(*ast.File); ok {
+			ast.SortImports(fset, file)
+		}
+	}
+
+	if file, ok := node.(*ast.File); ok && cfg.Simplify {
+		simplify(file)
+	}
+
+	var header []byte
+	if file, ok := node.(*ast.File); ok && cfg.GeneratedHeader != "" && !isGenerated(file) {
+		header = []byte(cfg.GeneratedHeader + "\n\n")
+	}
+
+	if cfg.Stats != nil {
+		*cfg.Stats = PrinterStats{}
+	}
+
 	// print node
 	var p printer
 	p.init(cfg, fset, nodeSizes)
 	pos = &p.Positions
+	if header != nil {
+		// the output lines are counted after the header
+		p.out.Line += 2
+		p.mapNewline = 2
+	}
 	if err = p.printNode(node); err != nil {
 		return
 	}
@@ -1021,11 +1715,27 @@ func (cfg *Config) fprint(output io.Writer, fset *token.FileSet, node interface{
 	p.impliedSemi = false // EOF acts like a newline
 	p.flush(token.Position{Offset: infinity, Line: infinity}, token.EOF)
 
+	// hold the output back if its final newline is to be dropped
+	dest := output
+	var held bytes.Buffer
+	if cfg.NoFinalNewline {
+		output = &held
+	}
+
+	// write the header ahead of the trimmer, as it is
+	if _, err = output.Write(header); err != nil {
+		return
+	}
+
 	// redirect output through a trimmer to eliminate trailing whitespace
 	// (Input to a tabwriter must be untrimmed since trailing tabs provide
 	// formatting information. The tabwriter could provide trimming
 	// functionality but no tabwriter is used when RawFormat is set.)
-	output = &trimmer{output: output}
+	output = &trimmer{
+		output:  output,
+		forceLF: cfg.ForceLF,
+		blanks:  cfg.Mode&(RawFormat|TabIndent|UseSpaces) == RawFormat|UseSpaces,
+	}
 
 	// redirect output through a tabwriter if necessary
 	if cfg.Mode&RawFormat == 0 {
@@ -1052,7 +1762,20 @@ func (cfg *Config) fprint(output io.Writer, fset *token.FileSet, node interface{
 
 	// flush tabwriter, if any
 	if tw, _ := output.(*tabwriter.Writer); tw != nil {
-		err = tw.Flush()
+		if err = tw.Flush(); err != nil {
+			return
+		}
+	}
+
+	if cfg.NoFinalNewline {
+		if _, err = dest.Write(bytes.TrimSuffix(held.Bytes(), []byte{'\n'})); err != nil {
+			return
+		}
+	}
+
+	// write the source map, if requested
+	if cfg.EmitSourceMap != nil {
+		err = json.NewEncoder(cfg.EmitSourceMap).Encode(p.sourceMap)
 	}
 
 	return
@@ -1061,6 +1784,11 @@ func (cfg *Config) fprint(output io.Writer, fset *token.FileSet, node interface{
 // A CommentedNode bundles an AST node and corresponding comments.
 // It may be provided as argument to any of the Fprint functions.
 //
+// Only the comments of Comments, sorted, within the node, its Doc
+// included, are printed, or ending at most one byte before it: a comment
+// group built to document a node, positioned there (e.g., its last
+// comment starting at Node.Pos()-1), is printed as its documentation.
+//
 type CommentedNode struct {
 	Node     interface{} // *ast.File, or ast.Expr, ast.Decl, ast.Spec, or ast.Stmt
 	Comments []*ast.CommentGroup
@@ -1075,9 +1803,76 @@ func (cfg *Config) Fprint(output io.Writer, fset *token.FileSet, node interface{
 	return cfg.fprint(output, fset, node, make(map[ast.Node]int))
 }
 
+// FprintWithCache is like Fprint but uses cache to remember the sizes of
+// the nodes it prints, so that formatting many nodes of the same AST one
+// after the other does not compute the same sizes again. The cache must
+// only be shared between calls using the same file set fset and the same
+// cfg: the sizes depend on RenameFunc. A nil cache behaves like Fprint.
+//
+func (cfg *Config) FprintWithCache(output io.Writer, fset *token.FileSet, node interface{}, cache map[ast.Node]int) (*Positions, error) {
+	if cache == nil {
+		cache = make(map[ast.Node]int)
+	}
+	return cfg.fprint(output, fset, node, cache)
+}
+
 // Fprint "pretty-prints" an AST node to output.
 // It calls Config.Fprint with default settings.
 //
 func Fprint(output io.Writer, fset *token.FileSet, node interface{}) (*Positions, error) {
 	return (&Config{Tabwidth: 8}).Fprint(output, fset, node)
 }
+
+// Source formats node like Fprint does and returns the result.
+// It is the equivalent of go/format.Node for iGo ASTs.
+//
+func Source(fset *token.FileSet, node interface{}) ([]byte, error) {
+	return SourceConfig(&Config{Tabwidth: 8}, fset, node)
+}
+
+// SourceConfig is like Source but formats node for the configuration cfg.
+//
+func SourceConfig(cfg *Config, fset *token.FileSet, node interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := cfg.Fprint(&buf, fset, node); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// PackageDoc returns the documentation of the package clause of file as
+// plain text, or "" if there is none: without the comment markers, with
+// the lines of each paragraph joined into one and the paragraphs
+// separated by a blank line. Indented lines, e.g. those of code blocks,
+// are kept as they are.
+func PackageDoc(file *ast.File) string {
+	var blocks []string
+	var lines []string // of the current block
+	code := false      // whether the current block is indented
+	end := func() {
+		if len(lines) > 0 {
+			sep := " "
+			if code {
+				sep = "\n"
+			}
+			blocks = append(blocks, strings.Join(lines, sep))
+			lines = nil
+		}
+	}
+	for _, line := range strings.Split(getDoc(file).Text(), "\n") {
+		indented := line != "" && (line[0] == ' ' || line[0] == '\t')
+		if line == "" || indented != code {
+			end()
+			code = indented
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	end()
+
+	if len(blocks) == 0 {
+		return ""
+	}
+	return strings.Join(blocks, "\n\n") + "\n"
+}
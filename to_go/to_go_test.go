@@ -0,0 +1,1061 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package to_go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	goast "go/ast"
+	"go/format"
+	goparser "go/parser"
+	gotoken "go/token"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/parser"
+	"github.com/DAddYE/igo/token"
+)
+
+// testConfig is the configuration of the igo command, the one the tests
+// print with unless they are about another.
+var testConfig = Config{Mode: UseSpaces | TabIndent, Tabwidth: 8}
+
+// goSource parses src as iGo and returns it printed as Go with cfg.
+func goSource(t *testing.T, cfg *Config, src string) string {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.igo", src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	var buf bytes.Buffer
+	if _, err := cfg.Fprint(&buf, fset, file); err != nil {
+		t.Fatalf("%q: %v", src, err)
+	}
+	return buf.String()
+}
+
+// A printTest is an iGo source and the Go it is printed as.
+type printTest struct {
+	src, out string
+}
+
+// runPrintTests prints the sources of tests with cfg and compares the
+// results to the expected ones.
+func runPrintTests(t *testing.T, cfg *Config, tests []printTest) {
+	for _, test := range tests {
+		if got := goSource(t, cfg, test.src); got != test.out {
+			t.Errorf("%q:\ngot\n%s\nwant\n%s", test.src, got, test.out)
+		}
+	}
+}
+
+var keepCommentSpaceTests = []struct {
+	mode Mode
+	printTest
+}{
+	{0, printTest{"package p\n\n# art  \n# | x |\t\nvar a = 1 # one  \n",
+		"package p\n\n// art\n// | x |\nvar a = 1 // one\n"}},
+	{KeepCommentSpace, printTest{"package p\n\n# art  \n# | x |\t\nvar a = 1 # one  \n",
+		"package p\n\n// art  \n// | x |\t\nvar a = 1 // one  \n"}},
+	// the blanks after the code are not in a comment
+	{KeepCommentSpace, printTest{"package p\n\nvar a = 1   \n",
+		"package p\n\nvar a = 1\n"}},
+}
+
+func TestKeepCommentSpace(t *testing.T) {
+	for _, test := range keepCommentSpaceTests {
+		cfg := testConfig
+		cfg.Mode |= test.mode
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
+
+// full and lines are the outputs without and with SourceLines.
+var sourceLinesTests = []struct {
+	src, full, lines string
+}{
+	{"package p\nvar a = 1\nvar b = 2\nfunc f(): return\nfunc g()\n\treturn\n",
+		"package p\n\nvar a = 1\nvar b = 2\n\nfunc f() { return }\n\nfunc g() {\n\treturn\n}\n",
+		"package p\nvar a = 1\nvar b = 2\nfunc f() { return }\nfunc g() {\n\treturn\n}\n"},
+	{"package p\n\nfunc f(a,\n\tb int)\n\tx := []int{1,\n\t\t2}\n\t_ = x\n",
+		"package p\n\nfunc f(a,\n\tb int) {\n\tx := []int{1,\n\t\t2}\n\t_ = x\n}\n",
+		"package p\n\nfunc f(a,\n\tb int) {\n\tx := []int{1,\n\t\t2}\n\t_ = x\n}\n"},
+	{"package p\n\nvar a = 1\n\n\n\nvar b = 2\n",
+		"package p\n\nvar a = 1\n\nvar b = 2\n",
+		"package p\n\nvar a = 1\n\nvar b = 2\n"},
+}
+
+func TestSourceLines(t *testing.T) {
+	for _, test := range sourceLinesTests {
+		cfg := testConfig
+		cfg.Mode |= SourceLines
+		runPrintTests(t, &testConfig, []printTest{{test.src, test.full}})
+		runPrintTests(t, &cfg, []printTest{{test.src, test.lines}})
+	}
+}
+
+// An identLines collects the name of each identifier of a file, in order,
+// with the filename and line of its position, //line directives applied.
+type identLines struct {
+	fset *token.FileSet
+	list []string
+}
+
+func (v *identLines) Visit(n ast.Node) ast.Visitor {
+	if id, ok := n.(*ast.Ident); ok {
+		pos := v.fset.Position(id.Pos())
+		v.list = append(v.list, fmt.Sprintf("%s %s:%d", id.Name, pos.Filename, pos.Line))
+	}
+	return v
+}
+
+// goIdentLines is identLines for Go files.
+type goIdentLines struct {
+	fset *gotoken.FileSet
+	list []string
+}
+
+func (v *goIdentLines) Visit(n goast.Node) goast.Visitor {
+	if id, ok := n.(*goast.Ident); ok {
+		pos := v.fset.Position(id.Pos())
+		v.list = append(v.list, fmt.Sprintf("%s %s:%d", id.Name, pos.Filename, pos.Line))
+	}
+	return v
+}
+
+var lineDirectiveTests = []string{
+	"package p\n\n#line a.igo:10\nfunc f()\n\treturn\n\n#line b.igo:20\nfunc g()\n\tx := 1\n\n\n\t_ = x\n",
+	"package p\n\nvar a = 1\n#line a.igo:5\nvar b = 2\n#line b.igo:7\nvar c = 3\n#line a.igo:1\nvar d = 4\n",
+	"package p\n\n#line gen/a.igo:100\nfunc f(x int) int\n\tif x > 0\n\t\t#line gen/b.igo:3\n\t\treturn x\n\treturn -x\n",
+}
+
+// TestLineDirectives checks that, with SourcePos, the identifiers of the
+// Go output are at the positions of the iGo ones, even when //line
+// directives change the filename mid-file.
+func TestLineDirectives(t *testing.T) {
+	cfg := testConfig
+	cfg.Mode |= SourcePos
+	for _, src := range lineDirectiveTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.igo", src, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		var buf bytes.Buffer
+		if _, err := cfg.Fprint(&buf, fset, file); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		gofset := gotoken.NewFileSet()
+		gofile, err := goparser.ParseFile(gofset, "test.go", buf.Bytes(), goparser.ParseComments)
+		if err != nil {
+			t.Fatalf("%q: %v\n%s", src, err, buf.Bytes())
+		}
+		want, got := &identLines{fset: fset}, &goIdentLines{fset: gofset}
+		ast.Walk(want, file)
+		goast.Walk(got, gofile)
+		if g, w := strings.Join(got.list, "\n"), strings.Join(want.list, "\n"); g != w {
+			t.Errorf("%q: positions\n%s\nwant\n%s\noutput\n%s", src, g, w, buf.Bytes())
+		}
+	}
+}
+
+var gofmtSamples = []string{
+	"package p\n\nimport\n\t\"os\"\n\t\"fmt\"\n\nvar _ = fmt.Sprint(os.Args)\n",
+	"package p\n\n# T is a type.\ntype T struct\n\ta int # a\n\tbcd string # bcd\n\te, f []map[string]int\n",
+	"package p\n\nconst\n\tA = iota # first\n\tBCD\n\tE = 10 # ten\n\nvar x = []struct: a, b int{{1, 2}, {3, 4}}\n",
+	"package p\n\nfunc f(a, b int) (int, error)\n\tswitch\n\t\tcase a < b:\n\t\t\treturn b - a, nil\n\t\tdefault:\n\t\t\tfor i := 0; i < a; i++: b += i*2 + 1\n\treturn a*b + 1, nil\n",
+	"package p\n\nfunc *T.m(s []int) int\n\tx := s[1:len(s)]\n\ty := map[string]int{\"a\": 1, \"bc\": 2}\n\treturn len(x) + y[\"a\"]\n",
+}
+
+// TestGofmtCompatible checks that, with GofmtCompatible, the Go output is
+// left as it is by go/format, whatever the other settings.
+func TestGofmtCompatible(t *testing.T) {
+	cfg := &Config{Mode: RawFormat, Tabwidth: 4, GofmtCompatible: true}
+	for _, src := range gofmtSamples {
+		out := goSource(t, cfg, src)
+		res, err := format.Source([]byte(out))
+		if err != nil {
+			t.Errorf("%q: %v\n%s", src, err, out)
+			continue
+		}
+		if string(res) != out {
+			t.Errorf("%q:\ngot\n%s\ngofmt\n%s", src, out, res)
+		}
+	}
+}
+
+// off and on are the outputs without and with AlignAssignments.
+var alignAssignmentsTests = []struct {
+	src, off, on string
+}{
+	{"package p\n\nfunc f()\n\tx = 1\n\tlonger = 2\n\ty += 3\n\n\tz = 4\n",
+		"package p\n\nfunc f() {\n\tx = 1\n\tlonger = 2\n\ty += 3\n\n\tz = 4\n}\n",
+		"package p\n\nfunc f() {\n\tx      = 1\n\tlonger = 2\n\ty      += 3\n\n\tz = 4\n}\n"},
+	// other statements end a run
+	{"package p\n\nfunc f()\n\ta = 1\n\tf()\n\tlonger = 2\n\tb, c = 3, 4\n\tshort := 5\n",
+		"package p\n\nfunc f() {\n\ta = 1\n\tf()\n\tlonger = 2\n\tb, c = 3, 4\n\tshort := 5\n}\n",
+		"package p\n\nfunc f() {\n\ta = 1\n\tf()\n\tlonger = 2\n\tb, c = 3, 4\n\tshort := 5\n}\n"},
+	// a block starts a run of its own
+	{"package p\n\nfunc f()\n\ta = 1\n\tif a > 0\n\t\tlonger = 2\n\t\tb = 3\n",
+		"package p\n\nfunc f() {\n\ta = 1\n\tif a > 0 {\n\t\tlonger = 2\n\t\tb = 3\n\t}\n}\n",
+		"package p\n\nfunc f() {\n\ta = 1\n\tif a > 0 {\n\t\tlonger = 2\n\t\tb      = 3\n\t}\n}\n"},
+}
+
+func TestAlignAssignments(t *testing.T) {
+	for _, test := range alignAssignmentsTests {
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{test.src, test.off}})
+		cfg.AlignAssignments = true
+		runPrintTests(t, &cfg, []printTest{{test.src, test.on}})
+	}
+}
+
+// off and on are the outputs without and with OneStatementPerLine.
+var oneStatementPerLineTests = []struct {
+	src, off, on string
+}{
+	{"package p\n\nfunc f() int: return 1\nfunc g(): return\n",
+		"package p\n\nfunc f() int { return 1 }\nfunc g()     { return }\n",
+		"package p\n\nfunc f() int {\n\treturn 1\n}\n\nfunc g() {\n\treturn\n}\n"},
+	{"package p\n\nvar f = func() int: return 1\n",
+		"package p\n\nvar f = func() int { return 1 }\n",
+		"package p\n\nvar f = func() int {\n\treturn 1\n}\n"},
+	// empty bodies and the semicolons of clauses are kept
+	{"package p\n\nfunc f():\n\nfunc g(s []int)\n\tfor i := 0; i < len(s); i++\n\t\tif x := s[i]; x > 0: break\n",
+		"package p\n\nfunc f() {}\n\nfunc g(s []int) {\n\tfor i := 0; i < len(s); i++ {\n\t\tif x := s[i]; x > 0 {\n\t\t\tbreak\n\t\t}\n\t}\n}\n",
+		"package p\n\nfunc f() {}\n\nfunc g(s []int) {\n\tfor i := 0; i < len(s); i++ {\n\t\tif x := s[i]; x > 0 {\n\t\t\tbreak\n\t\t}\n\t}\n}\n"},
+}
+
+func TestOneStatementPerLine(t *testing.T) {
+	for _, test := range oneStatementPerLineTests {
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{test.src, test.off}})
+		cfg.OneStatementPerLine = true
+		runPrintTests(t, &cfg, []printTest{{test.src, test.on}})
+	}
+}
+
+var blankBeforeFirstDeclTests = []struct {
+	src, noBlank, blank string
+}{
+	{"package p\nfunc F():\n",
+		"package p\nfunc F() {}\n",
+		"package p\n\nfunc F() {}\n"},
+	{"package p\n\nimport \"fmt\"\n\nvar x = fmt.Sprint()\n",
+		"package p\n\nimport \"fmt\"\nvar x = fmt.Sprint()\n",
+		"package p\n\nimport \"fmt\"\n\nvar x = fmt.Sprint()\n"},
+	// the blank line goes before the doc comment of the declaration
+	{"package p\n\nimport \"fmt\"\n# F prints.\nfunc F(): fmt.Println()\n",
+		"package p\n\nimport \"fmt\"\n// F prints.\nfunc F() { fmt.Println() }\n",
+		"package p\n\nimport \"fmt\"\n\n// F prints.\nfunc F() { fmt.Println() }\n"},
+}
+
+func TestBlankBeforeFirstDecl(t *testing.T) {
+	for _, test := range blankBeforeFirstDeclTests {
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{test.src, test.blank}})
+		cfg.NoBlankBeforeFirstDecl = true
+		runPrintTests(t, &cfg, []printTest{{test.src, test.noBlank}})
+	}
+}
+
+// blankLinesTests are run with PreserveBlankLines, up to max blank lines
+// in a row, or the default if max is 0.
+var blankLinesTests = []struct {
+	max int
+	printTest
+}{
+	// at the top level, after the package clause too
+	{0, printTest{"package p\n\n\n\nvar a = 1\n\n\n\nvar b = 2\n",
+		"package p\n\n\n\nvar a = 1\n\n\n\nvar b = 2\n"}},
+	{0, printTest{"package p\n\n\n\n# doc\nvar a = 1\n",
+		"package p\n\n\n\n// doc\nvar a = 1\n"}},
+	{0, printTest{"package p\nvar a = 1\n",
+		"package p\nvar a = 1\n"}},
+	{0, printTest{"package p\n\nimport\n\t\"fmt\"\n\n\n\nvar a = fmt.Sprint()\n",
+		"package p\n\nimport (\n\t\"fmt\"\n)\n\n\n\nvar a = fmt.Sprint()\n"}},
+	// inside blocks
+	{0, printTest{"package p\n\nfunc f(a int)\n\tif a > 0\n\t\ta++\n\n\n\t\ta--\n\n\n\n\tf(a)\n",
+		"package p\n\nfunc f(a int) {\n\tif a > 0 {\n\t\ta++\n\n\n\t\ta--\n\t}\n\n\n\n\tf(a)\n}\n"}},
+	// capped by MaxBlankLines
+	{2, printTest{"package p\n\n\n\nvar a = 1\n\n\n\nvar b = 2\n",
+		"package p\n\n\nvar a = 1\n\n\nvar b = 2\n"}},
+	{1, printTest{"package p\n\nfunc f(a int)\n\tif a > 0\n\t\ta++\n\n\n\t\ta--\n\n\n\n\tf(a)\n",
+		"package p\n\nfunc f(a int) {\n\tif a > 0 {\n\t\ta++\n\n\t\ta--\n\t}\n\n\tf(a)\n}\n"}},
+}
+
+func TestPreserveBlankLines(t *testing.T) {
+	for _, test := range blankLinesTests {
+		cfg := testConfig
+		cfg.PreserveBlankLines = true
+		cfg.MaxBlankLines = test.max
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
+
+var normalizeCommentsTests = []struct {
+	src, off, on string
+}{
+	{"package p\n\nfunc f() # c\n\tx := 1\n",
+		"package p\n\nfunc f() /* c  */ {\n\tx := 1\n}\n",
+		"package p\n\nfunc f() { // c\n\tx := 1\n}\n"},
+	{"package p\n\nvar # v\n\ta = 1\n\tb = 2\n",
+		"package p\n\nvar /* v  */ (\n\ta = 1\n\tb = 2\n)\n",
+		"package p\n\nvar ( // v\n\ta = 1\n\tb = 2\n)\n"},
+	{"package p\n\nfunc f(x int)\n\tif x > 0 # positive\n\t\tx--\n",
+		"package p\n\nfunc f(x int) {\n\tif x > 0 /* positive  */ {\n\t\tx--\n\t}\n}\n",
+		"package p\n\nfunc f(x int) {\n\tif x > 0 { // positive\n\t\tx--\n\t}\n}\n"},
+	// comments on their own line are not moved, even before a '{'
+	{"package p\n\nvar x = []T{\n\t{\"a\", \"b\"},\n\t# c\n\t# d\n\t{\"c\", \"d\"},\n}\n",
+		"package p\n\nvar x = []T{\n\t{\"a\", \"b\"},\n\t// c\n\t// d\n\t{\"c\", \"d\"},\n}\n",
+		"package p\n\nvar x = []T{\n\t{\"a\", \"b\"},\n\t// c\n\t// d\n\t{\"c\", \"d\"},\n}\n"},
+}
+
+func TestNormalizeComments(t *testing.T) {
+	for _, test := range normalizeCommentsTests {
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{test.src, test.off}})
+		cfg.NormalizeComments = true
+		runPrintTests(t, &cfg, []printTest{{test.src, test.on}})
+	}
+}
+
+// indentationTests are the outputs of the same source with each of the
+// indentation strategies: tabs and aligned cells, spaces and aligned
+// cells, tabs without a tabwriter, and spaces without alignment.
+var indentationTests = []struct {
+	mode            Mode
+	displayTabwidth int
+	out             string
+}{
+	{UseSpaces | TabIndent, 0, "package p\n\ntype T struct {\n\tx    int    // x\n\tlong string // l\n}\n"},
+	{UseSpaces, 0, "package p\n\ntype T struct {\n        x       int     // x\n        long    string  // l\n}\n"},
+	{UseSpaces, 4, "package p\n\ntype T struct {\n    x    int     // x\n    long string  // l\n}\n"},
+	{RawFormat, 0, "package p\n\ntype T struct {\n\tx\tint\t// x\n\tlong\tstring\t// l\n}\n"},
+	{RawFormat | UseSpaces, 0, "package p\n\ntype T struct {\n        x int // x\n        long string // l\n}\n"},
+	{RawFormat | UseSpaces, 4, "package p\n\ntype T struct {\n    x int // x\n    long string // l\n}\n"},
+}
+
+func TestIndentation(t *testing.T) {
+	const src = "package p\n\ntype T struct\n\tx    int    # x\n\tlong string # l\n"
+	for _, test := range indentationTests {
+		cfg := testConfig
+		cfg.Mode = test.mode
+		cfg.DisplayTabwidth = test.displayTabwidth
+		runPrintTests(t, &cfg, []printTest{{src, test.out}})
+	}
+}
+
+var pruneImportsTests = []printTest{
+	{"package p\n\nimport\n\t\"fmt\"\n\t\"os\"\n\nvar x = fmt.Sprint()\n",
+		"package p\n\nimport (\n\t\"fmt\"\n)\n\nvar x = fmt.Sprint()\n"},
+	{"package p\n\nimport \"os\"\n\nvar x int\n",
+		"package p\n\nvar x int\n"},
+	// blank and dot imports are kept
+	{"package p\n\nimport\n\t_ \"embed\"\n\t. \"strings\"\n\t\"os\"\n\nvar x int\n",
+		"package p\n\nimport (\n\t_ \"embed\"\n\t. \"strings\"\n)\n\nvar x int\n"},
+	// the name is the given one, or else guessed from the path
+	{"package p\n\nimport\n\tf \"fmt\"\n\t\"example.com/m/v2\"\n\t\"example.com/go-foo\"\n\t\"os\"\n\nvar x = f.Sprint(m.X)\n",
+		"package p\n\nimport (\n\tf \"fmt\"\n\t\"example.com/m/v2\"\n\t\"example.com/go-foo\"\n)\n\nvar x = f.Sprint(m.X)\n"},
+	// the comments of those dropped go too
+	{"package p\n\nimport\n\t\"fmt\" # used\n\t\"os\"  # unused\n\nvar x = fmt.Sprint()\n",
+		"package p\n\nimport (\n\t\"fmt\" // used\n)\n\nvar x = fmt.Sprint()\n"},
+	// names guessed from a version suffix, or which a selector to no known
+	// package may be the one of, are not sure
+	{"package p\n\nimport \"k8s.io/api/core/v1\"\n\nvar x v1.Pod\n",
+		"package p\n\nimport \"k8s.io/api/core/v1\"\n\nvar x v1.Pod\n"},
+	{"package p\n\nimport\n\t\"example.com/m/v2\"\n\t\"os\"\n\nvar x int\n",
+		"package p\n\nimport (\n\t\"example.com/m/v2\"\n)\n\nvar x int\n"},
+	{"package p\n\nimport\n\t\"example.com/yaml\"\n\t\"os\"\n\nvar x = goyaml.Marshal\n",
+		"package p\n\nimport (\n\t\"example.com/yaml\"\n\t\"os\"\n)\n\nvar x = goyaml.Marshal\n"},
+	{"package p\n\nimport\n\tf \"fmt\"\n\t\"os\"\n\ntype T struct\n\tn int\n\nvar t T\n\nvar x = t.n\n",
+		"package p\n\ntype T struct {\n\tn int\n}\n\nvar t T\n\nvar x = t.n\n"},
+}
+
+func TestPruneUnusedImports(t *testing.T) {
+	cfg := testConfig
+	cfg.PruneUnusedImports = true
+	runPrintTests(t, &cfg, pruneImportsTests)
+}
+
+// funcBanner writes a banner before a function declaration.
+func funcBanner(d ast.Decl, w io.Writer) {
+	if f, ok := d.(*ast.FuncDecl); ok {
+		fmt.Fprintf(w, "// --- %s ---", f.Name.Name)
+	}
+}
+
+// funcEnd writes a comment after a function declaration.
+func funcEnd(d ast.Decl, w io.Writer) {
+	if f, ok := d.(*ast.FuncDecl); ok {
+		fmt.Fprintf(w, "// end of %s", f.Name.Name)
+	}
+}
+
+var declHookTests = []struct {
+	before, after bool
+	printTest
+}{
+	{false, false, printTest{"package p\n\nvar x int\n\nfunc F():\n\n# G does.\nfunc G() int: return 1 # one\n",
+		"package p\n\nvar x int\n\nfunc F() {}\n\n// G does.\nfunc G() int { return 1 } // one\n"}},
+	// the banner precedes the documentation
+	{true, false, printTest{"package p\n\nvar x int\n\nfunc F():\n\n# G does.\nfunc G() int: return 1 # one\n",
+		"package p\n\nvar x int\n\n// --- F ---\nfunc F() {}\n\n// --- G ---\n// G does.\nfunc G() int { return 1 } // one\n"}},
+	// the comment follows the one on the last line
+	{false, true, printTest{"package p\n\nvar x int\n\nfunc F():\n\n# G does.\nfunc G() int: return 1 # one\n",
+		"package p\n\nvar x int\n\nfunc F() {}\n// end of F\n\n// G does.\nfunc G() int { return 1 } // one\n// end of G\n"}},
+	{true, true, printTest{"package p\n\nvar x int\n\nfunc F():\n\n# G does.\nfunc G() int: return 1 # one\n",
+		"package p\n\nvar x int\n\n// --- F ---\nfunc F() {}\n// end of F\n\n// --- G ---\n// G does.\nfunc G() int { return 1 } // one\n// end of G\n"}},
+}
+
+func TestDeclHooks(t *testing.T) {
+	for _, test := range declHookTests {
+		cfg := testConfig
+		if test.before {
+			cfg.BeforeDecl = funcBanner
+		}
+		if test.after {
+			cfg.AfterDecl = funcEnd
+		}
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
+
+var generatedHeaderTests = []printTest{
+	{"package p\n\nvar x int\n",
+		"// Code generated by igo; DO NOT EDIT.\n\npackage p\n\nvar x int\n"},
+	{"# Package p does.\npackage p\n",
+		"// Code generated by igo; DO NOT EDIT.\n\n// Package p does.\npackage p\n"},
+	// a file marked already keeps its mark only
+	{"# Code generated by stringer; DO NOT EDIT.\n\npackage p\n",
+		"// Code generated by stringer; DO NOT EDIT.\n\npackage p\n"},
+	// a comment after the package clause does not mark it
+	{"package p\n\n# Code generated by stringer; DO NOT EDIT.\nvar x int\n",
+		"// Code generated by igo; DO NOT EDIT.\n\npackage p\n\n// Code generated by stringer; DO NOT EDIT.\nvar x int\n"},
+}
+
+func TestGeneratedHeader(t *testing.T) {
+	if !generatedRx.MatchString(DefaultGeneratedHeader) {
+		t.Errorf("%q does not mark a file as generated", DefaultGeneratedHeader)
+	}
+	cfg := testConfig
+	cfg.GeneratedHeader = DefaultGeneratedHeader
+	runPrintTests(t, &cfg, generatedHeaderTests)
+
+	// the output positions count the lines of the header
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.igo", "package p\n\nvar x int\n", parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	without, err := testConfig.Fprint(ioutil.Discard, fset, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with, err := cfg.Fprint(ioutil.Discard, fset, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for in, out := range *without {
+		if got := (*with)[in]; got.Line != out.Line+2 || got.Column != out.Column {
+			t.Errorf("%v printed at %v; want %d:%d", in, got, out.Line+2, out.Column)
+		}
+	}
+}
+
+var simplifyTests = []printTest{
+	// composite literals
+	{"package p\n\nvar a = []T{T{1}, T{2}}\n",
+		"package p\n\nvar a = []T{{1}, {2}}\n"},
+	{"package p\n\nvar m = map[K]V{K{1}: V{2}}\n",
+		"package p\n\nvar m = map[K]V{{1}: {2}}\n"},
+	{"package p\n\nvar a = []*T{&T{1}, &U{2}}\n",
+		"package p\n\nvar a = []*T{{1}, &U{2}}\n"},
+	{"package p\n\nvar a = [][]int{[]int{1}, []int8{2}}\n",
+		"package p\n\nvar a = [][]int{{1}, []int8{2}}\n"},
+	// slice expressions
+	{"package p\n\nfunc f(s, t []int)\n\t_ = s[1:len(s)]\n\t_ = s[1:len(t)]\n\t_ = s[0:2]\n",
+		"package p\n\nfunc f(s, t []int) {\n\t_ = s[1:]\n\t_ = s[1:len(t)]\n\t_ = s[0:2]\n}\n"},
+	{"package p\n\nfunc len(s []int) int: return 0\n\nfunc f(s []int): _ = s[1:len(s)]\n",
+		"package p\n\nfunc len(s []int) int { return 0 }\n\nfunc f(s []int) { _ = s[1:len(s)] }\n"},
+	// range clauses
+	{"package p\n\nfunc f(s []int)\n\tfor i, _ := range s\n\t\t_ = i\n\tfor _ = range s\n\t\tbreak\n",
+		"package p\n\nfunc f(s []int) {\n\tfor i := range s {\n\t\t_ = i\n\t}\n\tfor range s {\n\t\tbreak\n\t}\n}\n"},
+}
+
+func TestSimplify(t *testing.T) {
+	cfg := testConfig
+	cfg.Simplify = true
+	runPrintTests(t, &cfg, simplifyTests)
+}
+
+var packageDocTests = []struct {
+	comments []string // of the doc comment, built as such
+	doc      string
+}{
+	{nil, ""},
+	{[]string{"# Package p does", "# things.", "#", "# More."}, "Package p does things.\n\nMore.\n"},
+	{[]string{"// Package p does", "// things."}, "Package p does things.\n"},
+	{[]string{"/*\nPackage p does\nthings.\n\nMore.\n*/"}, "Package p does things.\n\nMore.\n"},
+	// code blocks are kept
+	{[]string{"# Package p:", "#", "#\tx := 1", "#\ty := 2", "# Done."}, "Package p:\n\n\tx := 1\n\ty := 2\n\nDone.\n"},
+}
+
+func TestPackageDoc(t *testing.T) {
+	for _, test := range packageDocTests {
+		file := &ast.File{Name: ast.NewIdent("p")}
+		if test.comments != nil {
+			file.Doc = new(ast.CommentGroup)
+			for _, text := range test.comments {
+				file.Doc.List = append(file.Doc.List, &ast.Comment{Text: text})
+			}
+		}
+		if doc := PackageDoc(file); doc != test.doc {
+			t.Errorf("%q: got %q; want %q", test.comments, doc, test.doc)
+		}
+	}
+
+	// a comment after the package clause documents no package
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.igo", "package p\n\n# F does.\nfunc F():\n", parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc := PackageDoc(file); doc != "" {
+		t.Errorf("got %q; want none", doc)
+	}
+}
+
+// exprSpacingTests are expressions as printed canonically and with
+// PreserveExprSpacing, from the source expr.
+var exprSpacingTests = []struct {
+	expr, off, on string
+}{
+	{"a*b + c*d", "a*b + c*d", "a*b + c*d"},
+	{"a*b+c * d", "a*b + c*d", "a*b+c * d"},
+	{"a * b + c * d", "a*b + c*d", "a * b + c * d"},
+	{"a+ b-c", "a + b - c", "a+ b-c"},
+	{"(a+b) * c", "(a + b) * c", "(a+b) * c"},
+}
+
+func TestPreserveExprSpacing(t *testing.T) {
+	for _, test := range exprSpacingTests {
+		src := "package p\n\nvar x = " + test.expr + "\n"
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{src, "package p\n\nvar x = " + test.off + "\n"}})
+		cfg.PreserveExprSpacing = true
+		runPrintTests(t, &cfg, []printTest{{src, "package p\n\nvar x = " + test.on + "\n"}})
+	}
+
+	// an expression without positions is printed as usual
+	x := &ast.BinaryExpr{
+		X:  &ast.BinaryExpr{X: ast.NewIdent("a"), Op: token.MUL, Y: ast.NewIdent("b")},
+		Op: token.ADD,
+		Y:  ast.NewIdent("c"),
+	}
+	cfg := testConfig
+	cfg.PreserveExprSpacing = true
+	var buf bytes.Buffer
+	if _, err := cfg.Fprint(&buf, token.NewFileSet(), x); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "a*b + c" {
+		t.Errorf("got %q; want %q", buf.String(), "a*b + c")
+	}
+}
+
+// groupDigits adds digit separators to the decimal integer literals of
+// more than four digits.
+func groupDigits(lit *ast.BasicLit) string {
+	s := lit.Value
+	if lit.Kind != token.INT || len(s) <= 4 || s[0] == '0' {
+		return s
+	}
+	n := len(s) % 3
+	if n == 0 {
+		n = 3
+	}
+	res := s[:n]
+	for i := n; i < len(s); i += 3 {
+		res += "_" + s[i:i+3]
+	}
+	return res
+}
+
+var formatNumberTests = []struct {
+	src, off, on string
+}{
+	{"package p\n\nvar\n\ta = 1000000\n\tb = 1234\n\tc = 12345\n\td = 0x1000000\n\tf = 1000000.5\n\tg = \"1000000\"\n",
+		"package p\n\nvar (\n\ta = 1000000\n\tb = 1234\n\tc = 12345\n\td = 0x1000000\n\tf = 1000000.5\n\tg = \"1000000\"\n)\n",
+		"package p\n\nvar (\n\ta = 1_000_000\n\tb = 1234\n\tc = 12_345\n\td = 0x1000000\n\tf = 1000000.5\n\tg = \"1000000\"\n)\n"},
+}
+
+func TestFormatNumber(t *testing.T) {
+	for _, test := range formatNumberTests {
+		cfg := testConfig
+		runPrintTests(t, &cfg, []printTest{{test.src, test.off}})
+		cfg.FormatNumber = groupDigits
+		runPrintTests(t, &cfg, []printTest{{test.src, test.on}})
+	}
+}
+
+var statsTests = []struct {
+	src   string
+	stats PrinterStats
+}{
+	{"package p\n\nvar x int\n", PrinterStats{Comments: 0, DroppedFFs: 0, MaxIndent: 0, Whitespace: 6}},
+	{"# Package p.\npackage p\n\n# F does.\nfunc F(x int) # f\n\tif x > 0 # positive\n\t\tfor\n\t\t\tx-- # down\n", PrinterStats{Comments: 5, DroppedFFs: 0, MaxIndent: 3, Whitespace: 24}},
+	{"package p\n\nfunc f()\n\tx := 1 # c\n\t_ = x\n\t# end\n", PrinterStats{Comments: 2, DroppedFFs: 0, MaxIndent: 1, Whitespace: 15}},
+}
+
+func TestStats(t *testing.T) {
+	for _, test := range statsTests {
+		var stats PrinterStats
+		cfg := testConfig
+		cfg.Stats = &stats
+		for i := 0; i < 2; i++ { // the counts are reset by each call
+			goSource(t, &cfg, test.src)
+			if stats != test.stats {
+				t.Errorf("%q, call %d: got %+v; want %+v", test.src, i, stats, test.stats)
+			}
+		}
+	}
+}
+
+// emptyBodyTests are statements and groups without a body.
+var emptyBodyTests = []printTest{
+	{"package p\n\nfunc f(x bool, s []int)\n\tif x:\n\telse:\n\tfor range s:\n",
+		"package p\n\nfunc f(x bool, s []int) {\n\tif x {\n\t} else {\n\t}\n\tfor range s {\n\t}\n}\n"},
+	{"package p\n\nfunc f(i int)\n\tfor i = 0; i < 1;:\n",
+		"package p\n\nfunc f(i int) {\n\tfor i = 0; i < 1; {\n\t}\n}\n"},
+	{"package p\n\nfunc f(x int)\n\tswitch x\n\tf(x)\n",
+		"package p\n\nfunc f(x int) {\n\tswitch x {\n\t}\n\tf(x)\n}\n"},
+	// a comment after the colon stays on the line of the opening brace
+	{"package p\n\nfunc f(x bool)\n\tfor: # c\n\n\tf(x)\n",
+		"package p\n\nfunc f(x bool) {\n\tfor { // c\n\t}\n\n\tf(x)\n}\n"},
+	{"package p\n\nimport\n\nvar x = 1\n",
+		"package p\n\nimport ()\n\nvar x = 1\n"},
+}
+
+func TestEmptyBodies(t *testing.T) {
+	runPrintTests(t, &testConfig, emptyBodyTests)
+}
+
+// numberLitTests are the number literals of Go 1.13.
+var numberLitTests = []printTest{
+	{"package p\n\nvar x = []float64{0b1010, 0B1, 0o17, 017, 1_000, 0x_1F, 0x1p-2, 0X.8P1, .5, 1e3, 1i}\n",
+		"package p\n\nvar x = []float64{0b1010, 0B1, 0o17, 017, 1_000, 0x_1F, 0x1p-2, 0X.8P1, .5, 1e3, 1i}\n"},
+}
+
+func TestNumberLits(t *testing.T) {
+	runPrintTests(t, &testConfig, numberLitTests)
+}
+
+// oneLineTypeTests are structs and interfaces written on one line.
+var oneLineTypeTests = []printTest{
+	{"package p\n\ntype T struct: A\n\ntype U struct: x int # c\n\nvar x interface: M() # c\n",
+		"package p\n\ntype T struct{ A }\n\ntype U struct{ x int } // c\n\nvar x interface{ M() } // c\n"},
+	// a tag does not fit on one line
+	{"package p\n\ntype T struct: x int `json:\"x\"` # c\n",
+		"package p\n\ntype T struct {\n\tx int `json:\"x\"`\n} // c\n"},
+}
+
+func TestOneLineTypes(t *testing.T) {
+	runPrintTests(t, &testConfig, oneLineTypeTests)
+}
+
+// TestDeterministic prints the same tree, that of a large source, 100
+// times and checks that the output is the same each time.
+func TestDeterministic(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "nodes.igo", nil, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var first []byte
+	for i := 0; i < 100; i++ {
+		var buf bytes.Buffer
+		if _, err := testConfig.Fprint(&buf, fset, file); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = buf.Bytes()
+		} else if !bytes.Equal(buf.Bytes(), first) {
+			t.Fatalf("output %d differs from the first one", i)
+		}
+	}
+}
+
+var reflowDocTests = []struct {
+	maxColumn int
+	printTest
+}{
+	{40, printTest{"package p\n\n# F does something with a rather long description that goes past the limit.\nfunc F():\n",
+		"package p\n\n// F does something with a rather long\n// description that goes past the limit.\nfunc F() {}\n"}},
+	{0, printTest{"package p\n\n# F does something with a rather long description that goes past the limit.\nfunc F():\n",
+		"package p\n\n// F does something with a rather long description that goes past the limit.\nfunc F() {}\n"}},
+	// the indentation counts
+	{40, printTest{"package p\n\ntype T struct\n\t# X is a field with a documentation that is too long.\n\tX int\n",
+		"package p\n\ntype T struct {\n\t// X is a field with a\n\t// documentation that is too\n\t// long.\n\tX int\n}\n"}},
+	// directives, URLs and code blocks are kept
+	{40, printTest{"package p\n\n# F does something with a rather long description that goes past the limit.\n#go:noinline\nfunc F():\n",
+		"package p\n\n// F does something with a rather long\n// description that goes past the limit.\n//go:noinline\nfunc F() {}\n"}},
+	{40, printTest{"package p\n\n# See https://example.com/a/very/long/path/that/does/not/fit for details.\n# Code:\n#\tx := a very long code line that does not fit either\nfunc F():\n",
+		"package p\n\n// See https://example.com/a/very/long/path/that/does/not/fit for details.\n// Code:\n//\tx := a very long code line that does not fit either\nfunc F() {}\n"}},
+	// only documentation comments are re-wrapped
+	{40, printTest{"package p\n\nvar a = 1 # a rather long inline comment that goes past the limit\n\n# A comment that is not a doc comment because of the blank line after it\n\nvar b = 2\n",
+		"package p\n\nvar a = 1 // a rather long inline comment that goes past the limit\n\n// A comment that is not a doc comment because of the blank line after it\n\nvar b = 2\n"}},
+}
+
+func TestReflowDocComments(t *testing.T) {
+	for _, test := range reflowDocTests {
+		cfg := testConfig
+		cfg.ReflowDocComments = true
+		cfg.MaxColumn = test.maxColumn
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
+
+// exportedFunc reports whether d is the declaration of an exported
+// function or method.
+func exportedFunc(d ast.Decl) bool {
+	f, ok := d.(*ast.FuncDecl)
+	return ok && f.Name.IsExported()
+}
+
+var filterDeclsTests = []printTest{
+	{"package p\n\nimport\n\t\"fmt\"\n\t\"os\"\n\t_ \"net/http/pprof\"\n\n\t\"github.com/x/y/v2\"\n\t\"github.com/x/z\"\n\tstr \"strings\"\n\n# A is exported.\nfunc A()\n\tfmt.Println(y.V)\n\n# b is not.\nfunc b()\n\tos.Exit(str.Count(\"\", z.S))\n\ntype T int\n\nvar V = 1 # a var\n\n# M is a method.\nfunc T.M() string: return str.TrimSpace(\"\")\n",
+		"package p\n\nimport (\n\t\"fmt\"\n\t_ \"net/http/pprof\"\n\n\t\"github.com/x/y/v2\"\n\tstr \"strings\"\n)\n\n// A is exported.\nfunc A() {\n\tfmt.Println(y.V)\n}\n\n// M is a method.\nfunc (self T) M() string { return str.TrimSpace(\"\") }\n"},
+	// the dropped imports leave no empty line, but the sections stay
+	{"package p\n\nimport\n\t\"fmt\"\n\n\t\"os\" # os\n\n\t\"github.com/x/y/v2\"\n\nfunc A()\n\tfmt.Println(y.V)\n",
+		"package p\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/x/y/v2\"\n)\n\nfunc A() {\n\tfmt.Println(y.V)\n}\n"},
+	{"package p\n\nimport \"os\"\n\nfunc b()\n\tos.Exit(1)\n",
+		"package p\n"},
+	// v1 may be the name of any import whose name is guessed
+	{"package p\n\nimport\n\t\"k8s.io/api/core/v1\"\n\t\"os\"\n\nfunc A() v1.Pod\n\tvar x v1.Pod\n\treturn x\n\nfunc b(): os.Exit(1)\n",
+		"package p\n\nimport (\n\t\"k8s.io/api/core/v1\"\n\t\"os\"\n)\n\nfunc A() v1.Pod {\n\tvar x v1.Pod\n\treturn x\n}\n"},
+}
+
+func TestFilterDecls(t *testing.T) {
+	cfg := testConfig
+	cfg.FilterDecls = exportedFunc
+	runPrintTests(t, &cfg, filterDeclsTests)
+}
+
+// directiveTests are printed with ExpandCommentTabs and ReflowDocComments,
+// and a MaxColumn of 40.
+var directiveTests = []printTest{
+	{"package p\n\nfunc f()\n\tx := someFunctionWithAVeryLongName(argumentOne, argumentTwo) #nolint:gosec\n\ty := g() #nolint:all // a reason\n\t_, _ = x, y\n",
+		"package p\n\nfunc f() {\n\tx := someFunctionWithAVeryLongName(argumentOne, argumentTwo) //nolint:gosec\n\ty := g()                                                     //nolint:all // a reason\n\t_, _ = x, y\n}\n"},
+	{"package p\n\n# a\tb\n#go:generate\tstringer -type=Foo\n#nolint:gocyclo // a reason long enough to pass the maximum column\ntype Foo int\n",
+		"package p\n\n// a    b\n//go:generate\tstringer -type=Foo\n//nolint:gocyclo // a reason long enough to pass the maximum column\ntype Foo int\n"},
+}
+
+func TestDirectives(t *testing.T) {
+	cfg := testConfig
+	cfg.ExpandCommentTabs = true
+	cfg.ReflowDocComments = true
+	cfg.MaxColumn = 40
+	runPrintTests(t, &cfg, directiveTests)
+}
+
+var forceLFTests = []struct {
+	lit     string // value given to the literal of "var s = `x`"
+	forceLF bool
+	out     string
+}{
+	{"`a\r\nb`", false, "package p\n\nvar s = `a\r\nb`\n"},
+	{"`a\r\nb`", true, "package p\n\nvar s = `a\nb`\n"},
+	{"`a\rb\r\n\r\n`", true, "package p\n\nvar s = `a\rb\n\n`\n"},
+	{"\"a\\r\\nb\"", true, "package p\n\nvar s = \"a\\r\\nb\"\n"}, // escapes are not line endings
+}
+
+// TestForceLF prints literals with "\r\n" line endings, which the scanner
+// never gives, set in the tree after parsing.
+func TestForceLF(t *testing.T) {
+	for _, test := range forceLFTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.igo", "package p\n\nvar s = `x`\n", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0].(*ast.BasicLit).Value = test.lit
+		cfg := testConfig
+		cfg.ForceLF = test.forceLF
+		var buf bytes.Buffer
+		if _, err := cfg.Fprint(&buf, fset, file); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.out {
+			t.Errorf("%q, ForceLF %v: got %q; want %q", test.lit, test.forceLF, buf.String(), test.out)
+		}
+	}
+}
+
+// trimmerForceLFTests are written at once, as the tabwriter writes escaped
+// text, to a trimmer with forceLF set.
+var trimmerForceLFTests = []struct {
+	in, out string
+}{
+	{"a \xffb\r\nc\xff\n", "a b\nc\n"},
+	{"\xff\r\n\r\n\xff\r\n", "\n\n\r\n"}, // only escaped text is changed
+	{"\xffa\rb\r\xff", "a\rb\r"},
+}
+
+func TestTrimmerForceLF(t *testing.T) {
+	for _, test := range trimmerForceLFTests {
+		var buf bytes.Buffer
+		w := &trimmer{output: &buf, forceLF: true}
+		if _, err := w.Write([]byte(test.in)); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != test.out {
+			t.Errorf("%q: got %q; want %q", test.in, buf.String(), test.out)
+		}
+	}
+}
+
+var verifySemanticsTests = []struct {
+	src string
+	err string // substring of the error, if any
+}{
+	{"package p\n\nfunc f(a, b int) int\n\tx := a +\n\t\t# between the operands\n\t\tb\n\tswitch x\n\t\tcase 1:\n\t\t\tx++\n\t\tdefault:\n\t\t\tx--\n\n\treturn g(x, # a\n\t\tb)\n", ""},
+	// the comment before ')' is printed before the comma
+	{"package p\n\nfunc f(a int) int\n\treturn g(a,\n\t\t# c\n\t\t)\n", "printed Go does not parse"},
+}
+
+func TestVerifySemantics(t *testing.T) {
+	for _, test := range verifySemanticsTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.igo", test.src, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("%q: %v", test.src, err)
+		}
+		cfg := testConfig
+		cfg.VerifySemantics = true
+		var buf bytes.Buffer
+		_, err = cfg.Fprint(&buf, fset, file)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%q: got error %v; want %q", test.src, err, test.err)
+			}
+			if buf.Len() > 0 {
+				t.Errorf("%q: got output %q; want none", test.src, buf.Bytes())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+		} else if want := goSource(t, &testConfig, test.src); buf.String() != want {
+			t.Errorf("%q: got %q; want %q as without VerifySemantics", test.src, buf.String(), want)
+		}
+	}
+}
+
+// TestVerifySemanticsTree checks that a tree that the printed Go does not
+// give back, a literal set to "1 + 2", parsed as a sum, is reported.
+func TestVerifySemanticsTree(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.igo", "package p\n\nvar x = 3\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec).Values[0].(*ast.BasicLit).Value = "1 + 2"
+	cfg := testConfig
+	cfg.VerifySemantics = true
+	var buf bytes.Buffer
+	if _, err := cfg.Fprint(&buf, fset, file); err == nil || !strings.Contains(err.Error(), "printed Go has BinaryExpr + at 3:9 instead of BasicLit INT 1 + 2") {
+		t.Errorf("got %q, error %v; want a difference reported", buf.Bytes(), err)
+	}
+}
+
+var maxDepthTests = []struct {
+	n, maxDepth int // terms of a+a+...+a, MaxDepth
+	err         bool
+}{
+	{10, 0, false},
+	{10, 100, false},
+	{5000, 1000, true},
+	{5000, 10000, false},
+}
+
+// TestMaxDepth prints the generated sums a+a+...+a, left-nested as deep
+// as they have terms.
+func TestMaxDepth(t *testing.T) {
+	for _, test := range maxDepthTests {
+		src := "package p\n\nvar x = " + strings.Repeat("a+", test.n-1) + "a\n"
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.igo", src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := testConfig
+		cfg.MaxDepth = test.maxDepth
+		_, err = cfg.Fprint(ioutil.Discard, fset, file)
+		if test.err {
+			if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("nested more than %d levels deep", test.maxDepth)) {
+				t.Errorf("%d terms, MaxDepth %d: got error %v; want too deep", test.n, test.maxDepth, err)
+			}
+		} else if err != nil {
+			t.Errorf("%d terms, MaxDepth %d: %v", test.n, test.maxDepth, err)
+		}
+	}
+}
+
+var commentedNodeTests = []struct {
+	end  int  // of the synthetic comment, relative to the position of the node
+	want bool // whether it is printed as its documentation
+}{
+	{-1, true},
+	{len("# F returns one.") - 1, true}, // starting at Pos()-1
+	{-2, false},
+	{-10, false},
+}
+
+// TestCommentedNode prints a declaration with a comment group built for
+// it, positioned before it, on a line of blanks, as a CommentedNode.
+func TestCommentedNode(t *testing.T) {
+	const doc = "# F returns one."
+	for _, test := range commentedNodeTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.igo", "package p\n\n"+strings.Repeat(" ", 30)+"\nfunc F() int: return 1\n", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decl := file.Decls[0]
+		slash := decl.Pos() + token.Pos(test.end-len(doc))
+		g := &ast.CommentGroup{List: []*ast.Comment{{Slash: slash, Text: doc}}}
+		var buf bytes.Buffer
+		if _, err := testConfig.Fprint(&buf, fset, &CommentedNode{Node: decl, Comments: []*ast.CommentGroup{g}}); err != nil {
+			t.Fatal(err)
+		}
+		want := "func F() int { return 1 }"
+		if test.want {
+			want = "// F returns one.\n" + want
+		}
+		if buf.String() != want {
+			t.Errorf("comment ending at %d: got %q; want %q", test.end, buf.String(), want)
+		}
+	}
+}
+
+var noFinalNewlineTests = []struct {
+	mode Mode
+	printTest
+}{
+	{0, printTest{"package p\n\nvar a = 1\n", "package p\n\nvar a = 1"}},
+	{RawFormat, printTest{"package p\n\nvar a = 1\n", "package p\n\nvar a = 1"}},
+	{0, printTest{"package p\n\nvar a = 1 # one\n", "package p\n\nvar a = 1 // one"}},
+	{RawFormat, printTest{"package p\n\nvar a = 1 # one\n", "package p\n\nvar a = 1\t// one"}}, // unaligned
+	{0, printTest{"package p\n\nvar a = 1\n\n# the end\n", "package p\n\nvar a = 1\n\n// the end"}},
+	{0, printTest{"package p\n\ntype T struct\n\tx    int # x\n\tlong int # l\n",
+		"package p\n\ntype T struct {\n\tx    int // x\n\tlong int // l\n}"}},
+}
+
+func TestNoFinalNewline(t *testing.T) {
+	for _, test := range noFinalNewlineTests {
+		cfg := testConfig
+		cfg.Mode |= test.mode
+		cfg.NoFinalNewline = true
+		runPrintTests(t, &cfg, []printTest{test.printTest})
+	}
+}
+
+var sourceMapTests = []struct {
+	header string
+	want   map[int]string // source line:column by output line
+}{
+	{"", map[int]string{1: "1:1", 3: "3:1", 4: "4:2", 5: "5:3", 6: "5:11", 8: "7:2", 9: "7:11", 11: "9:1"}},
+	// the header lines are counted, not mapped
+	{DefaultGeneratedHeader, map[int]string{3: "1:1", 5: "3:1", 6: "4:2", 7: "5:3", 8: "5:11", 10: "7:2", 11: "7:11", 13: "9:1"}},
+}
+
+// TestEmitSourceMap checks the source map of a small file. The closing
+// braces, which iGo has not, map to the end of the last token of their
+// block.
+func TestEmitSourceMap(t *testing.T) {
+	const src = "package p\n\nfunc f(x int) int\n\tif x > 0\n\t\treturn x\n\n\treturn -x\n\nvar a = 1\n"
+	for _, test := range sourceMapTests {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "test.igo", src, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out, m bytes.Buffer
+		cfg := testConfig
+		cfg.GeneratedHeader = test.header
+		cfg.EmitSourceMap = &m
+		if _, err := cfg.Fprint(&out, fset, file); err != nil {
+			t.Fatal(err)
+		}
+		var sourceMap map[int]token.Position
+		if err := json.Unmarshal(m.Bytes(), &sourceMap); err != nil {
+			t.Fatalf("header %q: %v", test.header, err)
+		}
+		got := make(map[int]string)
+		for line, pos := range sourceMap {
+			got[line] = fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+			if pos.Filename != "test.igo" {
+				t.Errorf("header %q, line %d: mapped to %s", test.header, line, pos.Filename)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("header %q: got %v; want %v\n%s", test.header, got, test.want, out.String())
+		}
+	}
+}
+
+// longName renames every identifier but the package name to one too long
+// for a function to fit on one line.
+func longName(id *ast.Ident) string {
+	if id.Name == "p" {
+		return ""
+	}
+	return id.Name + strings.Repeat("x", 100)
+}
+
+// TestFprintWithCache prints the declarations of a file one by one with a
+// cache per Config, their sizes differing with RenameFunc, and compares
+// them with what Fprint prints.
+func TestFprintWithCache(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "test.igo", "package p\n\nfunc f() int: return a\n\nfunc g(x int): h(x)\n", parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := testConfig
+	renamed.RenameFunc = longName
+	for _, cfg := range []*Config{&testConfig, &renamed} {
+		cache := make(map[ast.Node]int)
+		for i := 0; i < 2; i++ { // the second time from the cache
+			for _, decl := range file.Decls {
+				var want, got bytes.Buffer
+				if _, err := cfg.Fprint(&want, fset, decl); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := cfg.FprintWithCache(&got, fset, decl, cache); err != nil {
+					t.Fatal(err)
+				}
+				if got.String() != want.String() {
+					t.Errorf("got\n%s\nwant\n%s", got.String(), want.String())
+				}
+			}
+		}
+	}
+
+	// the renamed functions do not fit on one line
+	var buf bytes.Buffer
+	if _, err := renamed.Fprint(&buf, fset, file); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(buf.String(), "\n"); n != 9 {
+		t.Errorf("renamed file printed on %d lines; want 9:\n%s", n, buf.String())
+	}
+}
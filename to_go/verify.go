@@ -0,0 +1,140 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package to_go
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"reflect"
+
+	"github.com/DAddYE/igo/ast"
+	"github.com/DAddYE/igo/token"
+)
+
+// A shapeItem is a node of a syntax tree, as compared by verifySemantics.
+type shapeItem struct {
+	depth int
+	desc  string // type of the node, with its names, literals and tokens
+	pos   int64
+}
+
+// verifySemantics parses src, the Go printed for file, and returns an
+// error if it doesn't parse or if its syntax tree differs from the one
+// of file, e.g. because a comment put a line break where a semicolon is
+// then inserted. The trees are compared node by node, in depth-first
+// order, leaving out comments and parentheses.
+func verifySemantics(fset *token.FileSet, file *ast.File, src []byte) error {
+	filename := fset.Position(file.Pos()).Filename
+	gofset := gotoken.NewFileSet()
+	gofile, err := goparser.ParseFile(gofset, "", src, 0)
+	if err != nil {
+		return fmt.Errorf("%s: github.com/DAddYE/igo/to_go: printed Go does not parse: %v", filename, err)
+	}
+
+	in, out := newShaper(), newShaper()
+	ast.Inspect(file, func(n ast.Node) bool {
+		// the body of a clause, or the statements below a label, are
+		// printed without the block wrapping them
+		switch x := n.(type) {
+		case *ast.CaseClause:
+			in.unwrap(x.Body)
+		case *ast.CommClause:
+			in.unwrap(x.Body)
+		case *ast.LabeledStmt:
+			in.unwrap([]ast.Stmt{x.Stmt})
+		}
+		return in.node(n)
+	})
+	goast.Inspect(gofile, func(n goast.Node) bool {
+		return out.node(n)
+	})
+
+	for i, x := range in.items {
+		if i >= len(out.items) {
+			return fmt.Errorf("%s: github.com/DAddYE/igo/to_go: printed Go lacks %s", fset.Position(token.Pos(x.pos)), x.desc)
+		}
+		if y := out.items[i]; x.depth != y.depth || x.desc != y.desc {
+			return fmt.Errorf("%s: github.com/DAddYE/igo/to_go: printed Go has %s at %s instead of %s", fset.Position(token.Pos(x.pos)), y.desc, gofset.Position(gotoken.Pos(y.pos)), x.desc)
+		}
+	}
+	if len(out.items) > len(in.items) {
+		y := out.items[len(in.items)]
+		return fmt.Errorf("%s: github.com/DAddYE/igo/to_go: printed Go has an extra %s", gofset.Position(gotoken.Pos(y.pos)), y.desc)
+	}
+	return nil
+}
+
+// A shaper lists the nodes of a syntax tree of either language.
+type shaper struct {
+	items []shapeItem
+	depth int
+	stack []bool               // whether each node entered is counted in depth
+	skip  map[interface{}]bool // nodes left out, but not their children
+}
+
+func newShaper() *shaper {
+	return &shaper{skip: make(map[interface{}]bool)}
+}
+
+// unwrap leaves out the block wrapping the statements of list, if any,
+// as clauseBody does.
+func (s *shaper) unwrap(list []ast.Stmt) {
+	if len(list) == 1 {
+		if b, ok := list[0].(*ast.BlockStmt); ok {
+			s.skip[b] = true
+		}
+	}
+}
+
+// node appends n, or ends the node entered last if n is nil, as an
+// ast.Inspect function.
+func (s *shaper) node(n interface{}) bool {
+	if n == nil || reflect.ValueOf(n).IsNil() {
+		if last := len(s.stack) - 1; last >= 0 {
+			if s.stack[last] {
+				s.depth--
+			}
+			s.stack = s.stack[:last]
+		}
+		return true
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(n))
+	name := v.Type().Name()
+	switch {
+	case name == "Comment" || name == "CommentGroup":
+		return false
+	case name == "ParenExpr" || s.skip[n]:
+		s.stack = append(s.stack, false)
+		return true
+	}
+
+	desc := name
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Field(i)
+		switch {
+		case f.Type().Name() == "Token" || f.Type().Name() == "ChanDir":
+			desc += fmt.Sprintf(" %v", f.Interface())
+		case f.Kind() == reflect.String && v.Type().Field(i).Name != "GoVersion":
+			str := f.String()
+			if lit, ok := n.(*ast.BasicLit); ok {
+				str = goLiteral(lit)
+			}
+			desc += " " + str
+		}
+	}
+	pos := reflect.ValueOf(n).MethodByName("Pos").Call(nil)[0].Int()
+	s.items = append(s.items, shapeItem{s.depth, desc, pos})
+	// in Go, only the first statement after a label is its child: the
+	// labeled statement is compared as the statements following it
+	counted := name != "LabeledStmt"
+	if counted {
+		s.depth++
+	}
+	s.stack = append(s.stack, counted)
+	return true
+}
@@ -91,6 +91,7 @@ const (
 	RBRACE    // }
 	SEMICOLON // ;
 	COLON     // :
+	TILDE     // ~
 	operator_end
 
 	keyword_beg
@@ -198,6 +199,7 @@ var tokens = [...]string{
 	RBRACE:    "}",
 	SEMICOLON: ";",
 	COLON:     ":",
+	TILDE:     "~",
 
 	BREAK:    "break",
 	CASE:     "case",